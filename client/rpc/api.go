@@ -239,6 +239,10 @@ func (api *HttpApi) Routing() iface.RoutingAPI {
 	return (*RoutingAPI)(api)
 }
 
+func (api *HttpApi) Sds() iface.SdsAPI {
+	return (*SdsAPI)(api)
+}
+
 func (api *HttpApi) loadRemoteVersion() (*semver.Version, error) {
 	api.versionMu.Lock()
 	defer api.versionMu.Unlock()
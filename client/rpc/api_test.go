@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/kubo/config"
 	iface "github.com/ipfs/kubo/core/coreiface"
 	"github.com/ipfs/kubo/core/coreiface/tests"
 	"github.com/ipfs/kubo/test/cli/harness"
@@ -22,6 +23,21 @@ import (
 type NodeProvider struct{}
 
 func (np NodeProvider) MakeAPISwarm(t *testing.T, ctx context.Context, fullIdentity, online bool, n int) ([]iface.CoreAPI, error) {
+	return np.makeAPISwarm(t, ctx, online, n, func(c *config.Config) {})
+}
+
+func (np NodeProvider) MakeSdsAPI(t *testing.T, ctx context.Context, ppURL string) (iface.CoreAPI, error) {
+	apis, err := np.makeAPISwarm(t, ctx, false, 1, func(c *config.Config) {
+		c.Sds.Enabled = true
+		c.Sds.RpcURLs = []string{ppURL}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apis[0], nil
+}
+
+func (NodeProvider) makeAPISwarm(t *testing.T, ctx context.Context, online bool, n int, configure func(*config.Config)) ([]iface.CoreAPI, error) {
 	h := harness.NewT(t)
 
 	apis := make([]iface.CoreAPI, n)
@@ -45,6 +61,7 @@ func (np NodeProvider) MakeAPISwarm(t *testing.T, ctx context.Context, fullIdent
 
 				c := n.ReadConfig()
 				c.Experimental.FilestoreEnabled = true
+				configure(c)
 				n.WriteConfig(c)
 				n.StartDaemon("--enable-pubsub-experiment", "--offline="+strconv.FormatBool(!online))
 
@@ -0,0 +1,424 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	caopts "github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/ipfs/kubo/core/coreiface/sdserr"
+)
+
+type SdsAPI HttpApi
+
+// sdsRemoteError is an error of the sds api of the node, it unwraps to the
+// sds error of its code
+type sdsRemoteError struct {
+	err  *Error
+	kind error
+}
+
+func (e *sdsRemoteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sdsRemoteError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+func sdsError(resp *Response) error {
+	if kind := sdserr.FromCode(uint(resp.Error.Code)); kind != nil {
+		return &sdsRemoteError{err: resp.Error, kind: kind}
+	}
+	return resp.Error
+}
+
+// exec is Exec returning the sds errors answered by the node
+func (api *SdsAPI) exec(ctx context.Context, req RequestBuilder, res interface{}) error {
+	resp, err := req.Send(ctx)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return sdsError(resp)
+	}
+	if res == nil {
+		return resp.Close()
+	}
+	return resp.decode(res)
+}
+
+func (api *SdsAPI) Upload(ctx context.Context, p path.Path, opts ...caopts.SdsUploadOption) (string, error) {
+	options, err := caopts.SdsUploadOptions(opts...)
+	if err != nil {
+		return "", err
+	}
+
+	var out struct {
+		FileHash string
+	}
+	req := api.core().Request("sds/upload", p.String())
+	if options.FileName != "" {
+		req = req.Option("name", options.FileName)
+	}
+	if options.ContentType != "" {
+		req = req.Option("content-type", options.ContentType)
+	}
+	if err := api.exec(ctx, req, &out); err != nil {
+		return "", err
+	}
+	return out.FileHash, nil
+}
+
+func (api *SdsAPI) Link(ctx context.Context, c cid.Cid, fileHash string, opts ...caopts.SdsShareOption) (files.File, error) {
+	options, err := caopts.SdsShareOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if options.Private {
+		return nil, fmt.Errorf("the share link of a mapping file cannot have a password")
+	}
+
+	req := api.core().Request("sds/link", c.String(), fileHash)
+	if options.Expire > 0 {
+		req = req.Option("expire", options.Expire.String())
+	}
+	resp, err := req.Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, sdsError(resp)
+	}
+	defer resp.Close()
+
+	b, err := io.ReadAll(resp.Output)
+	if err != nil {
+		return nil, err
+	}
+	return files.NewBytesFile(b), nil
+}
+
+// sdsShare is the output of the sds share commands
+type sdsShare struct {
+	ShareId   string
+	ShareLink string
+	Password  string
+	Private   bool
+	FileHash  string
+	FileName  string
+	FileSize  uint64
+	Cid       string
+	Created   time.Time
+	Expires   time.Time
+	Revoked   time.Time
+	Recorded  bool
+}
+
+func (s sdsShare) toSdsShare() (iface.SdsShare, error) {
+	out := iface.SdsShare{
+		ShareId:   s.ShareId,
+		ShareLink: s.ShareLink,
+		Password:  s.Password,
+		Private:   s.Private,
+		FileHash:  s.FileHash,
+		FileName:  s.FileName,
+		FileSize:  s.FileSize,
+		Created:   s.Created,
+		Expires:   s.Expires,
+		Revoked:   s.Revoked,
+		Recorded:  s.Recorded,
+	}
+	if s.Cid != "" {
+		c, err := cid.Decode(s.Cid)
+		if err != nil {
+			return iface.SdsShare{}, err
+		}
+		out.Cid = c
+	}
+	return out, nil
+}
+
+func (api *SdsAPI) Share(ctx context.Context, fileHash string, c cid.Cid, opts ...caopts.SdsShareOption) (iface.SdsShare, error) {
+	options, err := caopts.SdsShareOptions(opts...)
+	if err != nil {
+		return iface.SdsShare{}, err
+	}
+
+	var out sdsShare
+	req := api.core().Request("sds/share/create", c.String()).
+		Option("file-hash", fileHash).
+		Option("password", options.Private)
+	if options.Expire > 0 {
+		req = req.Option("expire", options.Expire.String())
+	}
+	if err := api.exec(ctx, req, &out); err != nil {
+		return iface.SdsShare{}, err
+	}
+	return out.toSdsShare()
+}
+
+func (api *SdsAPI) Shares(ctx context.Context, opts ...caopts.SdsSharesOption) ([]iface.SdsShare, error) {
+	options, err := caopts.SdsSharesOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var out struct {
+		Shares []sdsShare
+	}
+	req := api.core().Request("sds/share/ls").Option("recorded", options.Recorded)
+	if err := api.exec(ctx, req, &out); err != nil {
+		return nil, err
+	}
+
+	shares := make([]iface.SdsShare, 0, len(out.Shares))
+	for _, s := range out.Shares {
+		share, err := s.toSdsShare()
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+func (api *SdsAPI) Unshare(ctx context.Context, shareId string) error {
+	return api.exec(ctx, api.core().Request("sds/share/rm", shareId), nil)
+}
+
+func (api *SdsAPI) Parse(ctx context.Context, file files.File) (path.ImmutablePath, error) {
+	var out struct {
+		Path string
+	}
+	err := api.exec(ctx, api.core().Request("sds/parse").FileBody(file), &out)
+	if err != nil {
+		return path.ImmutablePath{}, err
+	}
+
+	p, err := path.NewPath(out.Path)
+	if err != nil {
+		return path.ImmutablePath{}, err
+	}
+	return path.NewImmutablePath(p)
+}
+
+func (api *SdsAPI) Resolve(ctx context.Context, p path.Path) (iface.SdsLink, error) {
+	var out struct {
+		Version   int
+		Cid       string
+		FileHash  string
+		CarSize   int64
+		ShareLink string
+		Wallet    string
+	}
+	if err := api.exec(ctx, api.core().Request("sds/resolve", p.String()), &out); err != nil {
+		return iface.SdsLink{}, err
+	}
+
+	c, err := cid.Decode(out.Cid)
+	if err != nil {
+		return iface.SdsLink{}, err
+	}
+	return iface.SdsLink{
+		Version:   out.Version,
+		Cid:       c,
+		FileHash:  out.FileHash,
+		CarSize:   out.CarSize,
+		ShareLink: out.ShareLink,
+		Wallet:    out.Wallet,
+	}, nil
+}
+
+func (api *SdsAPI) Download(ctx context.Context, link string) (files.File, error) {
+	resp, err := api.core().Request("sds/download", link).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, sdsError(resp)
+	}
+	return files.NewReaderFile(resp.Output), nil
+}
+
+func (api *SdsAPI) Status(ctx context.Context) (iface.SdsStatus, error) {
+	var out iface.SdsStatus
+	if err := api.exec(ctx, api.core().Request("sds/status"), &out); err != nil {
+		return iface.SdsStatus{}, err
+	}
+	return out, nil
+}
+
+func (api *SdsAPI) Uploads(ctx context.Context) ([]iface.SdsUpload, error) {
+	var out struct {
+		Uploads []struct {
+			FileHash    string
+			Cid         string
+			Name        string
+			ContentType string
+			Size        int64
+			OffsetStart uint64
+			OffsetEnd   uint64
+			Status      string
+			Error       string
+			Started     time.Time
+			Updated     time.Time
+		}
+	}
+	if err := api.exec(ctx, api.core().Request("sds/uploads"), &out); err != nil {
+		return nil, err
+	}
+
+	uploads := make([]iface.SdsUpload, 0, len(out.Uploads))
+	for _, u := range out.Uploads {
+		upload := iface.SdsUpload{
+			FileHash:    u.FileHash,
+			Name:        u.Name,
+			ContentType: u.ContentType,
+			Size:        u.Size,
+			OffsetStart: u.OffsetStart,
+			OffsetEnd:   u.OffsetEnd,
+			Status:      u.Status,
+			Error:       u.Error,
+			Started:     u.Started,
+			Updated:     u.Updated,
+		}
+		if u.Cid != "" {
+			c, err := cid.Decode(u.Cid)
+			if err != nil {
+				return nil, err
+			}
+			upload.Cid = c
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// sdsQueued is the output of the sds queue commands
+type sdsQueued struct {
+	Cid         string
+	Name        string
+	Status      string
+	Attempts    int64
+	NextAttempt time.Time
+	FileHash    string
+	Link        string
+	Error       string
+	Added       time.Time
+	Updated     time.Time
+}
+
+func (q sdsQueued) toSdsQueued() (iface.SdsQueued, error) {
+	c, err := cid.Decode(q.Cid)
+	if err != nil {
+		return iface.SdsQueued{}, err
+	}
+	out := iface.SdsQueued{
+		Cid:         c,
+		Name:        q.Name,
+		Status:      q.Status,
+		Attempts:    q.Attempts,
+		NextAttempt: q.NextAttempt,
+		FileHash:    q.FileHash,
+		Error:       q.Error,
+		Added:       q.Added,
+		Updated:     q.Updated,
+	}
+	if q.Link != "" {
+		out.Link, err = cid.Decode(q.Link)
+		if err != nil {
+			return iface.SdsQueued{}, err
+		}
+	}
+	return out, nil
+}
+
+func (api *SdsAPI) Enqueue(ctx context.Context, p path.Path, opts ...caopts.SdsEnqueueOption) (iface.SdsQueued, error) {
+	options, err := caopts.SdsEnqueueOptions(opts...)
+	if err != nil {
+		return iface.SdsQueued{}, err
+	}
+
+	var out sdsQueued
+	req := api.core().Request("sds/queue/add", p.String())
+	if options.Name != "" {
+		req = req.Option("name", options.Name)
+	}
+	if err := api.exec(ctx, req, &out); err != nil {
+		return iface.SdsQueued{}, err
+	}
+	return out.toSdsQueued()
+}
+
+func (api *SdsAPI) Queue(ctx context.Context) ([]iface.SdsQueued, error) {
+	var out struct {
+		Uploads []sdsQueued
+	}
+	if err := api.exec(ctx, api.core().Request("sds/queue/ls"), &out); err != nil {
+		return nil, err
+	}
+
+	uploads := make([]iface.SdsQueued, 0, len(out.Uploads))
+	for _, u := range out.Uploads {
+		q, err := u.toSdsQueued()
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, q)
+	}
+	return uploads, nil
+}
+
+func (api *SdsAPI) QueueRetry(ctx context.Context, c cid.Cid) (iface.SdsQueued, error) {
+	var out sdsQueued
+	if err := api.exec(ctx, api.core().Request("sds/queue/retry", c.String()), &out); err != nil {
+		return iface.SdsQueued{}, err
+	}
+	return out.toSdsQueued()
+}
+
+func (api *SdsAPI) QueueCancel(ctx context.Context, c cid.Cid) error {
+	return api.exec(ctx, api.core().Request("sds/queue/cancel", c.String()), nil)
+}
+
+func (api *SdsAPI) CacheStat(ctx context.Context) (iface.SdsCacheStat, error) {
+	var out iface.SdsCacheStat
+	if err := api.exec(ctx, api.core().Request("sds/cache/stat"), &out); err != nil {
+		return iface.SdsCacheStat{}, err
+	}
+	return out, nil
+}
+
+func (api *SdsAPI) CacheList(ctx context.Context) ([]iface.SdsCacheEntry, error) {
+	var out struct {
+		Entries []iface.SdsCacheEntry
+	}
+	if err := api.exec(ctx, api.core().Request("sds/cache/ls"), &out); err != nil {
+		return nil, err
+	}
+	return out.Entries, nil
+}
+
+func (api *SdsAPI) CacheClear(ctx context.Context) error {
+	return api.exec(ctx, api.core().Request("sds/cache/clear"), nil)
+}
+
+func (api *SdsAPI) CacheVerify(ctx context.Context) ([]iface.SdsCacheEntry, error) {
+	var out struct {
+		Entries []iface.SdsCacheEntry
+	}
+	if err := api.exec(ctx, api.core().Request("sds/cache/verify"), &out); err != nil {
+		return nil, err
+	}
+	return out.Entries, nil
+}
+
+func (api *SdsAPI) core() *HttpApi {
+	return (*HttpApi)(api)
+}
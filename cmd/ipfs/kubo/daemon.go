@@ -501,6 +501,12 @@ take effect.
 	}
 	node.Process.AddChild(goprocess.WithTeardown(cctx.Plugins.Close))
 
+	// The api and its sds fetcher are constructed once for the node, before
+	// the http handlers take their copies of cctx.
+	if _, err := cctx.GetAPI(); err != nil {
+		return err
+	}
+
 	// construct api endpoint - every time
 	apiErrc, err := serveHTTPApi(req, cctx)
 	if err != nil {
@@ -573,7 +579,16 @@ take effect.
 	prometheus.MustRegister(&corehttp.IpfsNodeCollector{Node: node})
 
 	// start MFS pinning thread
-	startPinMFS(daemonConfigPollInterval, cctx, &ipfsPinMFSNode{node})
+	api, err := cctx.GetAPI()
+	if err != nil {
+		return err
+	}
+	startPinMFS(daemonConfigPollInterval, cctx, &ipfsPinMFSNode{node, api})
+
+	// start the workers uploading the DAGs queued by the async adds
+	if err := startSdsQueue(cctx); err != nil {
+		return err
+	}
 
 	// The daemon is *finally* ready.
 	fmt.Printf("Daemon is ready\n")
@@ -631,6 +646,29 @@ take effect.
 	return errs
 }
 
+// startSdsQueue starts the workers of the sds upload queue, they are stopped
+// with the node
+func startSdsQueue(cctx *oldcmds.Context) error {
+	fetcher, err := cctx.SdsFetcher()
+	if err != nil || fetcher == nil {
+		return err
+	}
+	api, err := cctx.GetAPI()
+	if err != nil {
+		return err
+	}
+
+	fetcher.Queue().Start(coreapi.SdsQueueUploader(api))
+	return nil
+}
+
+// serveSdsPinning reports if the sds Pinning Services API is served on the
+// listener, "api" or "gateway"
+func serveSdsPinning(cfg *config.Config, listener string) bool {
+	return cfg.Sds.Enabled && cfg.Sds.PinningService.Enabled.WithDefault(false) &&
+		cfg.Sds.PinningService.Listener.WithDefault(config.DefaultSdsPinningServiceListener) == listener
+}
+
 // serveHTTPApi collects options, creates listener, prints status message and starts serving requests.
 func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error) {
 	cfg, err := cctx.GetConfig()
@@ -693,9 +731,9 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 	// only the webui objects are allowed.
 	// if you know what you're doing, go ahead and pass --unrestricted-api.
 	unrestricted, _ := req.Options[unrestrictedAPIAccessKwd].(bool)
-	gatewayOpt := corehttp.GatewayOption(corehttp.WebUIPaths...)
+	gatewayOpt := corehttp.GatewayOption(cctx, corehttp.WebUIPaths...)
 	if unrestricted {
-		gatewayOpt = corehttp.GatewayOption("/ipfs", "/ipns")
+		gatewayOpt = corehttp.GatewayOption(cctx, "/ipfs", "/ipns")
 	}
 
 	opts := []corehttp.ServeOption{
@@ -720,6 +758,13 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
 
+	if serveSdsPinning(cfg, config.SdsListenerAPI) {
+		opts = append(opts, corehttp.SdsPinningOption(cctx))
+		for _, listener := range listeners {
+			fmt.Printf("SDS Pinning Services API exposed at http://%s%s\n", listener.Addr(), corehttp.SdsPinningPath)
+		}
+	}
+
 	node, err := cctx.ConstructNode()
 	if err != nil {
 		return nil, fmt.Errorf("serveHTTPApi: ConstructNode() failed: %s", err)
@@ -846,8 +891,8 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 
 	opts := []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("gateway"),
-		corehttp.HostnameOption(),
-		corehttp.GatewayOption("/ipfs", "/ipns"),
+		corehttp.HostnameOption(cctx),
+		corehttp.GatewayOption(cctx, "/ipfs", "/ipns"),
 		corehttp.VersionOption(),
 		corehttp.CheckVersionOption(),
 	}
@@ -864,6 +909,13 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
 
+	if serveSdsPinning(cfg, config.SdsListenerGateway) {
+		opts = append(opts, corehttp.SdsPinningOption(cctx))
+		for _, listener := range listeners {
+			fmt.Printf("SDS Pinning Services API exposed at http://%s%s\n", listener.Addr(), corehttp.SdsPinningPath)
+		}
+	}
+
 	node, err := cctx.ConstructNode()
 	if err != nil {
 		return nil, fmt.Errorf("serveHTTPGateway: ConstructNode() failed: %s", err)
@@ -9,6 +9,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 
+	"github.com/ipfs/boxo/path"
 	pinclient "github.com/ipfs/boxo/pinning/remote/client"
 	cid "github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
@@ -16,6 +17,9 @@ import (
 
 	config "github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core"
+	coreiface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/ipfs/kubo/sds"
 )
 
 // mfslog is the logger for remote mfs pinning.
@@ -56,10 +60,20 @@ type pinMFSNode interface {
 	RootNode() (ipld.Node, error)
 	Identity() peer.ID
 	PeerHost() host.Host
+	Sds() pinMFSSds
+}
+
+// pinMFSSds is the part of the sds api pinning the MFS root to the built-in
+// sds pinning service
+type pinMFSSds interface {
+	Enqueue(context.Context, path.Path, ...options.SdsEnqueueOption) (coreiface.SdsQueued, error)
+	Queue(context.Context) ([]coreiface.SdsQueued, error)
+	QueueCancel(context.Context, cid.Cid) error
 }
 
 type ipfsPinMFSNode struct {
 	node *core.IpfsNode
+	api  coreiface.CoreAPI
 }
 
 func (x *ipfsPinMFSNode) RootNode() (ipld.Node, error) {
@@ -74,6 +88,10 @@ func (x *ipfsPinMFSNode) PeerHost() host.Host {
 	return x.node.PeerHost
 }
 
+func (x *ipfsPinMFSNode) Sds() pinMFSSds {
+	return x.api.Sds()
+}
+
 func startPinMFS(configPollInterval time.Duration, cctx pinMFSContext, node pinMFSNode) {
 	errCh := make(chan error)
 	go pinMFSOnChange(configPollInterval, cctx, node, errCh)
@@ -214,13 +232,13 @@ func pinMFS(
 	svcName string,
 	svcConfig config.RemotePinningService,
 ) (lastPin, error) {
-	c := pinclient.NewClient(svcConfig.API.Endpoint, svcConfig.API.Key)
-
-	pinName := svcConfig.Policies.MFS.PinName
-	if pinName == "" {
-		pinName = fmt.Sprintf("policy/%s/mfs", node.Identity().String())
+	if svcName == config.SdsRemoteServiceName {
+		return pinMFSToSds(ctx, node, cid, svcName, svcConfig)
 	}
 
+	c := pinclient.NewClient(svcConfig.API.Endpoint, svcConfig.API.Key)
+	pinName := mfsPinName(node, svcConfig)
+
 	// check if MFS pin exists (across all possible states) and inspect its CID
 	pinStatuses := []pinclient.Status{pinclient.StatusQueued, pinclient.StatusPinning, pinclient.StatusPinned, pinclient.StatusFailed}
 	lsPinCh, lsErrCh := c.Ls(ctx, pinclient.PinOpts.FilterName(pinName), pinclient.PinOpts.FilterStatus(pinStatuses...))
@@ -283,3 +301,56 @@ func pinMFS(
 	}
 	return lastPin{Time: pinTime, ServiceName: svcName, ServiceConfig: svcConfig, CID: cid}, nil
 }
+
+func mfsPinName(node pinMFSNode, svcConfig config.RemotePinningService) string {
+	if svcConfig.Policies.MFS.PinName != "" {
+		return svcConfig.Policies.MFS.PinName
+	}
+	return fmt.Sprintf("policy/%s/mfs", node.Identity().String())
+}
+
+// pinMFSToSds queues the sds upload of the MFS root under the MFS pin name,
+// the uploads queued for the previous roots are removed from the queue
+func pinMFSToSds(
+	ctx context.Context,
+	node pinMFSNode,
+	cid cid.Cid,
+	svcName string,
+	svcConfig config.RemotePinningService,
+) (lastPin, error) {
+	pinName := mfsPinName(node, svcConfig)
+
+	uploads, err := node.Sds().Queue(ctx)
+	if err != nil {
+		return lastPin{}, fmt.Errorf("error while listing remote pins: %v", err)
+	}
+	var previous []coreiface.SdsQueued
+	for _, u := range uploads {
+		if u.Name != pinName {
+			continue
+		}
+		if !u.Cid.Equals(cid) {
+			previous = append(previous, u)
+			continue
+		}
+		// CID of the current MFS root is already being pinned, nothing to do
+		if u.Status != string(sds.QueueFailed) {
+			mfslog.Debugf("pinning MFS to %q: pin for %q exists since %s, skipping", svcName, cid, u.Added.UTC().String())
+			return lastPin{Time: u.Added.UTC(), ServiceName: svcName, ServiceConfig: svcConfig, CID: cid}, nil
+		}
+		mfslog.Errorf("pinning to %q: received pre-existing %q status for %q", svcName, pinclient.StatusFailed, cid)
+	}
+
+	mfslog.Debugf("pinning to %q: queuing the MFS root %q", svcName, cid)
+	pinTime := time.Now().UTC()
+	if _, err := node.Sds().Enqueue(ctx, path.FromCid(cid), options.Sds.Name(pinName)); err != nil {
+		return lastPin{}, err
+	}
+	for _, u := range previous {
+		mfslog.Debugf("pinning to %q: removing the previous MFS root %q", svcName, u.Cid)
+		if err := node.Sds().QueueCancel(ctx, u.Cid); err != nil {
+			return lastPin{}, err
+		}
+	}
+	return lastPin{Time: pinTime, ServiceName: svcName, ServiceConfig: svcConfig, CID: cid}, nil
+}
@@ -8,8 +8,12 @@ import (
 	"time"
 
 	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/path"
+	cid "github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
 	config "github.com/ipfs/kubo/config"
+	coreiface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
 	"github.com/libp2p/go-libp2p/core/host"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 )
@@ -30,6 +34,7 @@ func (x *testPinMFSContext) GetConfig() (*config.Config, error) {
 
 type testPinMFSNode struct {
 	err error
+	sds *testPinMFSSds
 }
 
 func (x *testPinMFSNode) RootNode() (ipld.Node, error) {
@@ -44,6 +49,47 @@ func (x *testPinMFSNode) PeerHost() host.Host {
 	return nil
 }
 
+func (x *testPinMFSNode) Sds() pinMFSSds {
+	return x.sds
+}
+
+// testPinMFSSds is an sds upload queue which never uploads
+type testPinMFSSds struct {
+	queue []coreiface.SdsQueued
+}
+
+func (x *testPinMFSSds) Enqueue(ctx context.Context, p path.Path, opts ...options.SdsEnqueueOption) (coreiface.SdsQueued, error) {
+	settings, err := options.SdsEnqueueOptions(opts...)
+	if err != nil {
+		return coreiface.SdsQueued{}, err
+	}
+	c := p.(path.ImmutablePath).RootCid()
+	for i, u := range x.queue {
+		if u.Cid.Equals(c) {
+			x.queue[i].Name = settings.Name
+			x.queue[i].Status = "queued"
+			return x.queue[i], nil
+		}
+	}
+	u := coreiface.SdsQueued{Cid: c, Name: settings.Name, Status: "queued", Added: time.Now()}
+	x.queue = append(x.queue, u)
+	return u, nil
+}
+
+func (x *testPinMFSSds) Queue(ctx context.Context) ([]coreiface.SdsQueued, error) {
+	return append([]coreiface.SdsQueued(nil), x.queue...), nil
+}
+
+func (x *testPinMFSSds) QueueCancel(ctx context.Context, c cid.Cid) error {
+	for i, u := range x.queue {
+		if u.Cid.Equals(c) {
+			x.queue = append(x.queue[:i], x.queue[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("upload of %s is not queued", c)
+}
+
 var testConfigPollInterval = time.Second
 
 func isErrorSimilar(e1, e2 error) bool {
@@ -177,3 +223,43 @@ func testPinMFSServiceWithError(t *testing.T, cfg *config.Config, expectedErrorP
 		t.Errorf("expecting error containing %q", expectedErrorPrefix)
 	}
 }
+
+func TestPinMFSSds(t *testing.T) {
+	ctx := context.Background()
+	svcConfig := config.RemotePinningService{
+		Policies: config.RemotePinningServicePolicies{
+			MFS: config.RemotePinningServiceMFSPolicy{
+				Enable: true,
+			},
+		},
+	}
+	queued := &testPinMFSSds{}
+	node := &testPinMFSNode{sds: queued}
+	pinName := "policy/" + node.Identity().String() + "/mfs"
+
+	root := merkledag.NewRawNode([]byte{0x01}).Cid()
+	last, err := pinMFS(ctx, node, root, config.SdsRemoteServiceName, svcConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.CID != root || len(queued.queue) != 1 || queued.queue[0].Name != pinName {
+		t.Fatalf("MFS root was not queued under %q: %v", pinName, queued.queue)
+	}
+
+	// the same root is not queued twice
+	if _, err := pinMFS(ctx, node, root, config.SdsRemoteServiceName, svcConfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(queued.queue) != 1 {
+		t.Fatalf("MFS root was queued twice: %v", queued.queue)
+	}
+
+	// a new root replaces the previous one
+	newRoot := merkledag.NewRawNode([]byte{0x02}).Cid()
+	if _, err := pinMFS(ctx, node, newRoot, config.SdsRemoteServiceName, svcConfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(queued.queue) != 1 || queued.queue[0].Cid != newRoot {
+		t.Fatalf("MFS root was not replaced: %v", queued.queue)
+	}
+}
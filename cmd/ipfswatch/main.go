@@ -94,10 +94,11 @@ func run(ipfsPath, watchPath string) error {
 
 	if *http {
 		addr := "/ip4/127.0.0.1/tcp/5001"
+		cctx := cmdCtx(node, ipfsPath)
 		opts := []corehttp.ServeOption{
-			corehttp.GatewayOption("/ipfs", "/ipns"),
+			corehttp.GatewayOption(&cctx, "/ipfs", "/ipns"),
 			corehttp.WebUIOption,
-			corehttp.CommandsOption(cmdCtx(node, ipfsPath)),
+			corehttp.CommandsOption(cctx),
 		}
 		proc.Go(func(p process.Process) {
 			if err := corehttp.ListenAndServe(node, addr, opts...); err != nil {
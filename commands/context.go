@@ -29,6 +29,7 @@ type Context struct {
 
 	Gateway       bool
 	api           coreiface.CoreAPI
+	sdsFetcher    *sds.Fetcher
 	node          *core.IpfsNode
 	ConstructNode func() (*core.IpfsNode, error)
 }
@@ -50,6 +51,14 @@ func (c *Context) GetNode() (*core.IpfsNode, error) {
 			return nil, errors.New("nil ConstructNode function")
 		}
 		c.node, err = c.ConstructNode()
+		if err != nil {
+			return c.node, err
+		}
+		// the deprecated wallet key in clear in the config is moved to the
+		// keystore before sds reads the config
+		if err := sds.MigratePrivateKey(c.node.Repo); err != nil {
+			log.Errorf("could not move Sds.PrivateKey into the keystore: %s", err)
+		}
 	}
 	return c.node, err
 }
@@ -75,11 +84,16 @@ func (c *Context) GetAPI() (coreiface.CoreAPI, error) {
 
 		// sds embedding
 		if cfg.Sds.Enabled {
-			fetcher, err := sds.NewFetcher(&cfg.Sds)
+			fetcher, err := sds.NewFetcher(&cfg.Sds, c.ConfigRoot, n.Repo.Datastore(), n.Repo.Keystore())
 			if err != nil {
 				return nil, err
 			}
+			go func() {
+				<-n.Context().Done()
+				fetcher.Close()
+			}()
 			opts = append(opts, options.Api.SdsFetcher(fetcher))
+			c.sdsFetcher = fetcher
 		}
 
 		opts = append(opts, options.Api.FetchBlocks(fetchBlocks))
@@ -92,6 +106,15 @@ func (c *Context) GetAPI() (coreiface.CoreAPI, error) {
 	return c.api, nil
 }
 
+// SdsFetcher returns the sds fetcher of the api, nil when sds is not
+// enabled. It may construct the api.
+func (c *Context) SdsFetcher() (*sds.Fetcher, error) {
+	if _, err := c.GetAPI(); err != nil {
+		return nil, err
+	}
+	return c.sdsFetcher, nil
+}
+
 // Context returns the node's context.
 func (c *Context) Context() context.Context {
 	n, err := c.GetNode()
@@ -127,6 +150,10 @@ func (c *Context) Close() {
 	// let's not forget teardown. If a node was initialized, we must close it.
 	// Note that this means the underlying req.Context().Node variable is exposed.
 	// this is gross, and should be changed when we extract out the exec Context.
+	// The sds fetcher saves its state in the repo, it is closed first.
+	if c.sdsFetcher != nil {
+		c.sdsFetcher.Close()
+	}
 	if c.node != nil {
 		log.Info("Shutting down node...")
 		c.node.Close()
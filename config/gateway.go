@@ -5,6 +5,14 @@ const (
 	DefaultDeserializedResponses = true
 	DefaultDisableHTMLErrors     = false
 	DefaultExposeRoutingAPI      = false
+	DefaultSdsFallback           = SdsFallbackAuthenticated
+)
+
+// Gateway.SdsFallback values
+const (
+	SdsFallbackOff           = "off"
+	SdsFallbackAuthenticated = "authenticated"
+	SdsFallbackPublic        = "public"
 )
 
 type GatewaySpec struct {
@@ -73,4 +81,10 @@ type Gateway struct {
 	// ExposeRoutingAPI configures the gateway port to expose
 	// routing system as HTTP API at /routing/v1 (https://specs.ipfs.tech/routing/http-routing-v1/).
 	ExposeRoutingAPI Flag
+
+	// SdsFallback configures which clients could make the gateway download
+	// from sds, the content missing in ipfs and the /sds/ namespace: "off"
+	// for none, "authenticated" for the clients of Sds.Fallback.AllowedClients
+	// or with an API.Authorizations token allowed the path, "public" for all.
+	SdsFallback *OptionalString `json:",omitempty"`
 }
@@ -5,6 +5,11 @@ var (
 	PinningConcealSelector = []string{"Pinning", "RemoteServices", "*", "API", "Key"}
 )
 
+// SdsRemoteServiceName is the name of the built-in remote pinning service
+// pinning to sds through the upload queue. A RemoteServices entry of this
+// name only sets its Policies, its API is not used.
+const SdsRemoteServiceName = "sds"
+
 type Pinning struct {
 	RemoteServices map[string]RemotePinningService
 }
@@ -1,29 +1,330 @@
 package config
 
-import (
-	"encoding/hex"
+import "time"
 
-	fwsecp256k1 "github.com/stratosnet/sds/framework/crypto/secp256k1"
+const (
+	SdsTag                = "Sds"
+	SdsPrivateKeyTag      = "PrivateKey"
+	SdsPrivateKeySelector = SdsTag + "." + SdsPrivateKeyTag
 )
 
 type Sds struct {
 	// Enabled is used to switch on/off sds uploading and downloading part
 	Enabled bool
+	// Wallet is the name of the keystore key signing the sds requests, an
+	// sds-secp256k1 key. It is only used by the "keystore" signer. When
+	// neither Wallet nor PrivateKey is set, the DefaultSdsWallet key is
+	// used, generated on the first sds operation.
+	Wallet string `json:",omitempty"`
+	// Signer is where the sds requests are signed, so the wallet key could
+	// be kept off this host
+	Signer SdsSigner
 	// PrivateKey is the secret that will be used to sign uploading file to SDS (hex value, 0x not required)
-	PrivateKey string
+	//
+	// Deprecated: the key is in clear in the config, use Wallet. PrivateKey
+	// is only used when Wallet is empty
+	PrivateKey string `json:",omitempty"`
 	// RpcURL for pp node (where it will be uploaded/dowloaded)
+	//
+	// Deprecated: use RpcURLs, RpcURL is only used when RpcURLs is empty
 	RpcURL string
-	// CacheFolder to store downloads and use for the cache
+	// RpcURLs lists the pp nodes, in the order they are tried on failure
+	RpcURLs []string `json:",omitempty"`
+	// RpcSelection is how the pp serving a download is picked among the
+	// healthy ones: "failover" uses the first one in the RpcURLs order,
+	// "round-robin" rotates through them and "least-latency" picks the
+	// fastest one. Uploads always use the failover order.
+	RpcSelection *OptionalString `json:",omitempty"`
+	// RpcTimeout of a single request to a pp node
+	RpcTimeout *OptionalDuration `json:",omitempty"`
+	// HealthCheckInterval is how often the pp nodes are probed, 0 disables
+	// the active checks and pp nodes are only marked down on failures
+	HealthCheckInterval *OptionalDuration `json:",omitempty"`
+	// CacheFolder to store downloads and use for the cache, the cached files
+	// are kept in its sds-files folder and the CARs exported for the uploads
+	// are spooled in its sds-spool folder. Relative paths are resolved from
+	// the repo root, the sds-cache folder of the repo is used when empty or
+	// set to the former /tmp default
 	CacheFolder string
+	// CacheMaxSize caps the cache size, the least recently used files are
+	// evicted above it (in B, kB, kiB, MB, ...)
+	CacheMaxSize *OptionalString `json:",omitempty"`
+	// Retry is the policy of the requests retried when the pp nodes are
+	// unavailable
+	Retry SdsRetry
+	// Budget caps the ozone spent by the uploads and downloads
+	Budget SdsBudget
+	// UploadMode is "sync" to upload the added DAGs during ipfs add, or
+	// "async" to complete the add right away and queue the upload for the
+	// daemon
+	UploadMode *OptionalString `json:",omitempty"`
+	// Queue is how the daemon uploads the queued DAGs
+	Queue SdsQueue
+	// PinningService serves the Pinning Services API backed by sds
+	PinningService SdsPinningService
+	// Fallback limits the sds downloads started by the gateway requests,
+	// who could start them is set by Gateway.SdsFallback
+	Fallback SdsFallback
+	// TrustedWallets are the wallets, besides the one of the node, whose
+	// mapping files are followed to the DAGs they link to. The mapping
+	// files signed by other wallets are served as they are.
+	TrustedWallets []string `json:",omitempty"`
+	// FollowUnsignedLinks follows the unsigned mapping files of the older
+	// nodes, which anyone could write, to the DAGs they link to
+	FollowUnsignedLinks Flag `json:",omitempty"`
+}
+
+// SdsFallback limits the sds downloads of the content missing in ipfs and of
+// the /sds/ namespace, started by the gateway requests. Zero limits are
+// unlimited.
+type SdsFallback struct {
+	// RateLimit is the number of downloads a gateway client could start per
+	// minute, by client ip address
+	RateLimit *OptionalInteger `json:",omitempty"`
+	// MaxConcurrent caps the downloads in flight for the gateway requests
+	MaxConcurrent *OptionalInteger `json:",omitempty"`
+	// NegativeCacheTTL is how long a share link unknown to sds is not asked
+	// to the pp again
+	NegativeCacheTTL *OptionalDuration `json:",omitempty"`
+	// MaxSize stops the downloads of the files above it (in B, kB, kiB,
+	// MB, ...)
+	MaxSize *OptionalString `json:",omitempty"`
+	// AllowedClients are the ip addresses or networks, in CIDR notation,
+	// of the clients allowed the downloads when Gateway.SdsFallback is
+	// "authenticated"
+	AllowedClients []string `json:",omitempty"`
+	// TrustedProxies are the ip addresses or networks, in CIDR notation,
+	// of the reverse proxies of the gateway, the client of the requests
+	// they forward is taken from X-Forwarded-For
+	TrustedProxies []string `json:",omitempty"`
+	// LookupTimeout bounds the ipfs lookup of a root block missing from
+	// the node, it is downloaded from sds past it
+	LookupTimeout *OptionalDuration `json:",omitempty"`
+}
+
+// SdsPinningService serves the Pinning Services API under /pinning/v1, the
+// DAGs pinned through it are uploaded by the upload queue. The requests are
+// authorized by the API.Authorizations whose AllowedPaths include the
+// /pinning/v1 path.
+type SdsPinningService struct {
+	Enabled Flag `json:",omitempty"`
+	// Listener is "api" to serve it on the RPC API listener or "gateway" to
+	// serve it on the gateway listener
+	Listener *OptionalString `json:",omitempty"`
+}
+
+// SdsQueue configures the daemon workers uploading the DAGs queued by the
+// async adds
+type SdsQueue struct {
+	// Workers uploading the queued DAGs in parallel
+	Workers *OptionalInteger `json:",omitempty"`
+	// Retry is how a failed upload is attempted again, the upload is left
+	// failed after its last attempt
+	Retry SdsRetryPolicy
+}
+
+// SdsBudget caps the ozone spent by the uploads and downloads. The cost of an
+// operation is counted as OzonePerByte ozone units per byte uploaded or
+// downloaded. The days are UTC days, unset or zero limits are unlimited.
+type SdsBudget struct {
+	// OzonePerByte is the price of a byte uploaded or downloaded, the one
+	// the pp checks the balance against before an upload
+	OzonePerByte *OptionalInteger `json:",omitempty"`
+	// Daily caps the ozone spent per day by all the operations
+	Daily *OptionalInteger `json:",omitempty"`
+	// PerOperation caps the ozone spent by a single upload or download
+	PerOperation *OptionalInteger `json:",omitempty"`
+	// PerClient caps the ozone spent per day by the downloads of a single
+	// gateway client, by client ip address
+	PerClient *OptionalInteger `json:",omitempty"`
+	// MinBalance refuses the operations which would bring the ozone balance
+	// of the wallet below it
+	MinBalance *OptionalInteger `json:",omitempty"`
+}
+
+// SdsSigner selects the signer of the sds requests. The "keystore" signer
+// uses the Sds.Wallet key, the "remote" signer asks a signing service over
+// http or a unix socket and only gets the public key of the wallet. The other
+// types are provided by plugins.
+type SdsSigner struct {
+	Type *OptionalString `json:",omitempty"`
+	// URL of the remote signer, http(s)://host:port/path or
+	// unix:///path/to/socket
+	URL string `json:",omitempty"`
+	// Address of the wallet expected from the signer, which is rejected on
+	// first use when it holds another key. The wallet address is known
+	// without asking the signer when set.
+	Address string `json:",omitempty"`
+	// Headers added to the remote signer requests, e.g. an Authorization
+	Headers map[string]string `json:",omitempty"`
+	// Timeout of a remote signer request
+	Timeout *OptionalDuration `json:",omitempty"`
+	// Config is passed as is to the plugin signers
+	Config map[string]interface{} `json:",omitempty"`
+}
+
+// SdsRetry is the retry policy of the sds operations which are safe to
+// retry: "ozone" (ozone balance requests), "download" (download chunk
+// requests) and "upload" (resuming an upload from the last acknowledged
+// chunk, the ozone request of the upload included). The other requests are
+// never retried.
+type SdsRetry struct {
+	SdsRetryPolicy
+	// Operations overrides the policy of some operations
+	Operations map[string]SdsRetryPolicy `json:",omitempty"`
+}
+
+// SdsRetryPolicy is how an operation is retried, the delay between two
+// attempts doubles from BaseDelay up to MaxDelay
+type SdsRetryPolicy struct {
+	// MaxAttempts of the operation including the first one, 1 disables the
+	// retries
+	MaxAttempts *OptionalInteger  `json:",omitempty"`
+	BaseDelay   *OptionalDuration `json:",omitempty"`
+	MaxDelay    *OptionalDuration `json:",omitempty"`
+	// Jitter is the percentage of each delay which is randomized, from 0
+	// to 100
+	Jitter *OptionalInteger `json:",omitempty"`
+}
+
+// Policy returns the policy of the operation, the fields it does not set
+// are the ones of the default policy
+func (r *SdsRetry) Policy(operation string) SdsRetryPolicy {
+	p := r.SdsRetryPolicy
+	o, ok := r.Operations[operation]
+	if !ok {
+		return p
+	}
+	if o.MaxAttempts != nil {
+		p.MaxAttempts = o.MaxAttempts
+	}
+	if o.BaseDelay != nil {
+		p.BaseDelay = o.BaseDelay
+	}
+	if o.MaxDelay != nil {
+		p.MaxDelay = o.MaxDelay
+	}
+	if o.Jitter != nil {
+		p.Jitter = o.Jitter
+	}
+	return p
+}
+
+const (
+	// DefaultSdsWallet is the name of the wallet key generated on first use
+	// when Sds.Wallet is unset
+	DefaultSdsWallet = "sds-wallet"
+	// DefaultSdsCacheMaxSize is the default value of Sds.CacheMaxSize
+	DefaultSdsCacheMaxSize = "10GB"
+	// DefaultSdsRpcSelection is the default value of Sds.RpcSelection
+	DefaultSdsRpcSelection = SdsSelectionFailover
+	// DefaultSdsRpcTimeout is the default value of Sds.RpcTimeout
+	DefaultSdsRpcTimeout = 10 * time.Second
+	// DefaultSdsHealthCheckInterval is the default value of
+	// Sds.HealthCheckInterval
+	DefaultSdsHealthCheckInterval = 30 * time.Second
+	// DefaultSdsSignerType is the default value of Sds.Signer.Type
+	DefaultSdsSignerType = SdsSignerKeystore
+	// DefaultSdsSignerTimeout is the default value of Sds.Signer.Timeout
+	DefaultSdsSignerTimeout = 10 * time.Second
+
+	// DefaultSdsRetryMaxAttempts is the default value of Sds.Retry.MaxAttempts
+	DefaultSdsRetryMaxAttempts = 4
+	// DefaultSdsRetryBaseDelay is the default value of Sds.Retry.BaseDelay
+	DefaultSdsRetryBaseDelay = 500 * time.Millisecond
+	// DefaultSdsRetryMaxDelay is the default value of Sds.Retry.MaxDelay
+	DefaultSdsRetryMaxDelay = 10 * time.Second
+	// DefaultSdsRetryJitter is the default value of Sds.Retry.Jitter
+	DefaultSdsRetryJitter = 50
+
+	// DefaultSdsUploadMode is the default value of Sds.UploadMode
+	DefaultSdsUploadMode = SdsUploadSync
+	// DefaultSdsQueueWorkers is the default value of Sds.Queue.Workers
+	DefaultSdsQueueWorkers = 2
+	// DefaultSdsQueueMaxAttempts is the default value of
+	// Sds.Queue.Retry.MaxAttempts
+	DefaultSdsQueueMaxAttempts = 10
+	// DefaultSdsQueueBaseDelay is the default value of
+	// Sds.Queue.Retry.BaseDelay
+	DefaultSdsQueueBaseDelay = 30 * time.Second
+	// DefaultSdsQueueMaxDelay is the default value of
+	// Sds.Queue.Retry.MaxDelay
+	DefaultSdsQueueMaxDelay = time.Hour
+
+	// DefaultSdsPinningServiceListener is the default value of
+	// Sds.PinningService.Listener
+	DefaultSdsPinningServiceListener = SdsListenerAPI
+
+	// DefaultSdsBudgetOzonePerByte is the default value of
+	// Sds.Budget.OzonePerByte
+	DefaultSdsBudgetOzonePerByte = 1
+
+	// DefaultSdsFallbackRateLimit is the default value of
+	// Sds.Fallback.RateLimit
+	DefaultSdsFallbackRateLimit = 60
+	// DefaultSdsFallbackMaxConcurrent is the default value of
+	// Sds.Fallback.MaxConcurrent
+	DefaultSdsFallbackMaxConcurrent = 32
+	// DefaultSdsFallbackNegativeCacheTTL is the default value of
+	// Sds.Fallback.NegativeCacheTTL
+	DefaultSdsFallbackNegativeCacheTTL = 10 * time.Minute
+	// DefaultSdsFallbackLookupTimeout is the default value of
+	// Sds.Fallback.LookupTimeout
+	DefaultSdsFallbackLookupTimeout = 10 * time.Second
+)
+
+// Sds.RpcSelection values
+const (
+	SdsSelectionFailover     = "failover"
+	SdsSelectionRoundRobin   = "round-robin"
+	SdsSelectionLeastLatency = "least-latency"
+)
+
+// Sds.UploadMode values
+const (
+	SdsUploadSync  = "sync"
+	SdsUploadAsync = "async"
+)
+
+// Sds.PinningService.Listener values
+const (
+	SdsListenerAPI     = "api"
+	SdsListenerGateway = "gateway"
+)
+
+// Sds.Signer.Type values, plugins add their own
+const (
+	SdsSignerKeystore = "keystore"
+	SdsSignerRemote   = "remote"
+)
+
+// PPs returns the pp nodes urls, RpcURLs or the legacy RpcURL
+func (s *Sds) PPs() []string {
+	if len(s.RpcURLs) > 0 {
+		return s.RpcURLs
+	}
+	if s.RpcURL != "" {
+		return []string{s.RpcURL}
+	}
+	return nil
+}
+
+// WalletName returns the name of the keystore key of the wallet, Wallet or
+// DefaultSdsWallet. It is empty when the deprecated PrivateKey is used.
+func (s *Sds) WalletName() string {
+	if s.Wallet == "" && s.PrivateKey != "" {
+		return ""
+	}
+	if s.Wallet == "" {
+		return DefaultSdsWallet
+	}
+	return s.Wallet
 }
 
 func sdsConfig() Sds {
-	w, _ := fwsecp256k1.GenerateKey()
-	pkStr := "0x" + hex.EncodeToString(w.Bytes())
 	return Sds{
 		Enabled:     false,
-		PrivateKey:  pkStr,
-		RpcURL:      "http://127.0.0.1:18281",
-		CacheFolder: "/tmp",
+		RpcURLs:     []string{"http://127.0.0.1:18281"},
+		CacheFolder: "",
 	}
 }
@@ -10,7 +10,6 @@ import (
 
 	"github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core/commands/cmdenv"
-	"github.com/ipfs/kubo/sds"
 
 	"github.com/cheggaaa/pb"
 	"github.com/ipfs/boxo/files"
@@ -51,6 +50,8 @@ const (
 	inlineOptionName      = "inline"
 	inlineLimitOptionName = "inline-limit"
 	toFilesOptionName     = "to-files"
+	sdsAsyncOptionName    = "sds-async"
+	sdsFileNameOptionName = "sds-name"
 )
 
 const adderOutChanSize = 8
@@ -127,6 +128,14 @@ You can now check what blocks have been created by:
   QmerURi9k4XzKCaaPbsK6BL5pMEjF7PGphjDvkkjDtsVf3 868
   QmQB28iwSriSUSMqG2nXDTLtdPHgWb4rebBrU7Q1j4vxPv 338
 
+When SDS is enabled, the added DAG is uploaded to SDS as a CAR and a mapping
+file linking the CID to the SDS file is added as well, its CID is the one
+printed. Passing '--sds-async', or setting Sds.UploadMode to "async", completes
+the add right away and queues the upload, the daemon uploads the DAG and adds
+its mapping file in the background. See 'ipfs sds queue --help'. The CAR is
+stored in SDS under the name of the added file, or '--sds-name', along with
+the content type detected from its extension and the CID of the DAG.
+
 Finally, a note on hash (CID) determinism and 'ipfs add' command.
 
 Almost all the flags provided by this command will change the final CID, and
@@ -167,6 +176,8 @@ See 'dag export' and 'dag import' for more information.
 		cmds.IntOption(inlineLimitOptionName, "Maximum block size to inline. (experimental)").WithDefault(32),
 		cmds.BoolOption(pinOptionName, "Pin locally to protect added files from garbage collection.").WithDefault(true),
 		cmds.StringOption(toFilesOptionName, "Add reference to Files API (MFS) at the provided path."),
+		cmds.BoolOption(sdsAsyncOptionName, "Queue the SDS upload for the daemon instead of waiting for it. Default: Sds.UploadMode."),
+		cmds.StringOption(sdsFileNameOptionName, "The name of the file in SDS. Default: the name of the added file."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
 		quiet, _ := req.Options[quietOptionName].(bool)
@@ -218,6 +229,8 @@ See 'dag export' and 'dag import' for more information.
 		inline, _ := req.Options[inlineOptionName].(bool)
 		inlineLimit, _ := req.Options[inlineLimitOptionName].(int)
 		toFilesStr, toFilesSet := req.Options[toFilesOptionName].(string)
+		sdsAsync, sdsAsyncSet := req.Options[sdsAsyncOptionName].(bool)
+		sdsName, sdsNameSet := req.Options[sdsFileNameOptionName].(string)
 
 		if chunker == "" {
 			chunker = cfg.Import.UnixFSChunker.WithDefault(config.DefaultUnixFSChunker)
@@ -237,6 +250,10 @@ See 'dag export' and 'dag import' for more information.
 			rawblks = cfg.Import.UnixFSRawLeaves.WithDefault(config.DefaultUnixFSRawLeaves)
 		}
 
+		if !sdsAsyncSet {
+			sdsAsync = cfg.Sds.UploadMode.WithDefault(config.DefaultSdsUploadMode) == config.SdsUploadAsync
+		}
+
 		if onlyHash && toFilesSet {
 			return fmt.Errorf("%s and %s options are not compatible", onlyHashOptionName, toFilesOptionName)
 		}
@@ -301,6 +318,10 @@ See 'dag export' and 'dag import' for more information.
 			errCh := make(chan error, 1)
 			events := make(chan interface{}, adderOutChanSize)
 			sdsEvents := make(chan interface{}, adderOutChanSize)
+			name := addit.Name()
+			if sdsNameSet {
+				name = sdsName
+			}
 
 			go func() {
 				opts[len(opts)-1] = options.Unixfs.Events(events)
@@ -309,36 +330,34 @@ See 'dag export' and 'dag import' for more information.
 				defer close(events)
 				defer close(sdsEvents)
 				pathAdded, err := api.Unixfs().Add(req.Context, addit.Node(), opts...)
-				fmt.Println("ipfs add unixfs add err", err)
 				if err != nil {
 					errCh <- err
 					return
 				}
 
-				if cfg.Sds.Enabled {
-					f, err := sds.NewDagParser(req.Context, api.Dag(), nil, nil).Export(pathAdded.RootCid())
-					if err != nil {
+				if cfg.Sds.Enabled && sdsAsync {
+					// the add does not wait for the pp, the daemon uploads
+					// the DAG and adds its mapping file
+					if _, err := api.Sds().Enqueue(req.Context, pathAdded, options.Sds.Name(name)); err != nil {
 						errCh <- err
 						return
 					}
-
-					sdsFileHash, err := api.Sds().Upload(req.Context, f, opts...)
-					fmt.Println("ipfs add sds add err", err)
+				} else if cfg.Sds.Enabled {
+					sdsFileHash, err := api.Sds().Upload(req.Context, pathAdded, options.Sds.FileName(name))
 					if err != nil {
-						errCh <- err
+						errCh <- sdsError(req, err)
 						return
 					}
 
 					mapFile, err := api.Sds().Link(req.Context, pathAdded.RootCid(), sdsFileHash)
 					if err != nil {
-						errCh <- err
+						errCh <- sdsError(req, err)
 						return
 					}
 
 					opts[len(opts)-1] = options.Unixfs.Events(sdsEvents)
 
 					_, err = api.Unixfs().Add(req.Context, mapFile, opts...)
-					fmt.Println("ipfs add sds link err", err)
 					if err != nil {
 						errCh <- err
 						return
@@ -402,7 +421,7 @@ See 'dag export' and 'dag import' for more information.
 			}()
 
 			rEvts := events
-			if cfg.Sds.Enabled {
+			if cfg.Sds.Enabled && !sdsAsync {
 				rEvts = sdsEvents
 			}
 
@@ -139,6 +139,9 @@ func cat(nd *core.IpfsNode, cfg *config.Config, ctx context.Context, api iface.C
 	if max == 0 {
 		return nil, 0, nil
 	}
+	// streamed are the files from the first one whose size is not known yet,
+	// still being downloaded from sds
+	var streamed []files.File
 	for _, pString := range paths {
 		p, err := cmdutils.PathOrCidPath(pString)
 		if err != nil {
@@ -160,6 +163,11 @@ func cat(nd *core.IpfsNode, cfg *config.Config, ctx context.Context, api iface.C
 			return nil, 0, iface.ErrNotSupported
 		}
 
+		if _, known := knownSize(file); len(streamed) > 0 || !known {
+			streamed = append(streamed, file)
+			continue
+		}
+
 		fsize, err := file.Size()
 		if err != nil {
 			return nil, 0, err
@@ -190,9 +198,72 @@ func cat(nd *core.IpfsNode, cfg *config.Config, ctx context.Context, api iface.C
 				length = uint64(max)
 			}
 			readers = append(readers, r)
-			break
+			return readers, length, nil
 		}
 		readers = append(readers, file)
 	}
+
+	if len(streamed) > 0 {
+		// the offset and length are applied to the streamed files as they are
+		// read, so the output starts as soon as the data at the offset arrives
+		var r io.Reader = &catReader{files: streamed, offset: offset}
+		if max > 0 {
+			r = io.LimitReader(r, max-int64(length))
+		}
+		readers = append(readers, r)
+		length = 0
+	}
 	return readers, length, nil
 }
+
+// knownSize returns the size of the file without waiting for the download of
+// the files streamed from sds
+func knownSize(file files.File) (int64, bool) {
+	if sf, ok := file.(interface{ KnownSize() (int64, bool) }); ok {
+		return sf.KnownSize()
+	}
+	size, err := file.Size()
+	return size, err == nil
+}
+
+// catReader reads files one after the other from offset. A file is skipped by
+// its size when the offset is past its end, which is known once reading at
+// the offset reaches the end of its download.
+type catReader struct {
+	files  []files.File
+	offset int64
+}
+
+func (r *catReader) Read(p []byte) (int, error) {
+	for len(r.files) > 0 {
+		file := r.files[0]
+		if r.offset > 0 {
+			if size, known := knownSize(file); known && r.offset >= size {
+				r.offset -= size
+				r.files = r.files[1:]
+				continue
+			}
+			if _, err := file.Seek(r.offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := file.Read(p)
+		if n > 0 {
+			r.offset = 0
+			return n, nil
+		}
+		if err != io.EOF {
+			return 0, err
+		}
+		if r.offset > 0 {
+			size, err := file.Size()
+			if err != nil {
+				return 0, err
+			}
+			r.offset -= size
+		}
+		r.files = r.files[1:]
+	}
+	return 0, io.EOF
+}
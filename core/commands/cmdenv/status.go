@@ -0,0 +1,25 @@
+package cmdenv
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type errorStatusKey struct{}
+
+// WithErrorStatus returns a context in which a command could pick the HTTP
+// status answered by the API when it fails, see SetErrorStatus. The returned
+// value holds the status, zero when unset.
+func WithErrorStatus(ctx context.Context) (context.Context, *atomic.Int32) {
+	status := new(atomic.Int32)
+	return context.WithValue(ctx, errorStatusKey{}, status), status
+}
+
+// SetErrorStatus sets the HTTP status answered by the API when the command
+// fails, instead of the generic 500. It does nothing when the command is not
+// run by the HTTP API.
+func SetErrorStatus(ctx context.Context, status int) {
+	if s, ok := ctx.Value(errorStatusKey{}).(*atomic.Int32); ok {
+		s.Store(int32(status))
+	}
+}
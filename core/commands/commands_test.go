@@ -176,6 +176,33 @@ func TestCommands(t *testing.T) {
 		"/repo/ls",
 		"/resolve",
 		"/shutdown",
+		"/sds",
+		"/sds/cache",
+		"/sds/cache/clear",
+		"/sds/cache/ls",
+		"/sds/cache/stat",
+		"/sds/cache/verify",
+		"/sds/download",
+		"/sds/link",
+		"/sds/parse",
+		"/sds/queue",
+		"/sds/queue/add",
+		"/sds/queue/cancel",
+		"/sds/queue/ls",
+		"/sds/queue/retry",
+		"/sds/resolve",
+		"/sds/share",
+		"/sds/share/create",
+		"/sds/share/ls",
+		"/sds/share/rm",
+		"/sds/status",
+		"/sds/upload",
+		"/sds/uploads",
+		"/sds/uploads/resume",
+		"/sds/wallet",
+		"/sds/wallet/address",
+		"/sds/wallet/balance",
+		"/sds/wallet/rotate",
 		"/stats",
 		"/stats/bitswap",
 		"/stats/bw",
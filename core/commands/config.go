@@ -81,6 +81,8 @@ Set the value of the 'Datastore.Path' key:
 		switch strings.ToLower(key) {
 		case "identity", "identity.privkey":
 			return errors.New("cannot show or change private key through API")
+		case "sds.privatekey":
+			return errors.New("cannot show or change the sds wallet key through API, import it with 'ipfs key import --format=sds-hex'")
 		default:
 		}
 
@@ -117,6 +119,11 @@ Set the value of the 'Datastore.Path' key:
 			}
 		} else {
 			output, err = getConfig(r, key)
+			if err == nil && strings.EqualFold(key, config.SdsTag) {
+				if m, ok := output.Value.(map[string]interface{}); ok {
+					output.Value, err = scrubOptionalValue(m, []string{config.SdsPrivateKeyTag})
+				}
+			}
 		}
 
 		if err != nil {
@@ -218,6 +225,11 @@ NOTE: For security reasons, this command will omit your private key and remote s
 			return err
 		}
 
+		cfg, err = scrubOptionalValue(cfg, []string{config.SdsTag, config.SdsPrivateKeyTag})
+		if err != nil {
+			return err
+		}
+
 		return cmds.EmitOnce(res, &cfg)
 	},
 	Encoders: cmds.EncoderMap{
@@ -436,6 +448,11 @@ func scrubPrivKey(cfg *config.Config) (map[string]interface{}, error) {
 		return nil, err
 	}
 
+	cfgMap, err = scrubOptionalValue(cfgMap, []string{config.SdsTag, config.SdsPrivateKeyTag})
+	if err != nil {
+		return nil, err
+	}
+
 	return cfgMap, nil
 }
 
@@ -536,6 +553,18 @@ func replaceConfig(r repo.Repo, file io.Reader) error {
 
 	newCfg.Identity.PrivKey = pkstr
 
+	// Handle Sds.PrivateKey (secret, deprecated)
+
+	if newCfg.Sds.PrivateKey != "" {
+		return errors.New("setting the sds wallet key with API is not supported")
+	}
+
+	oldCfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	newCfg.Sds.PrivateKey = oldCfg.Sds.PrivateKey
+
 	// Handle Pinning.RemoteServices (API.Key of each service is a secret)
 
 	newServices := newCfg.Pinning.RemoteServices
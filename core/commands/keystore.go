@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -86,7 +87,7 @@ var keyGenCmd = &cmds.Command{
 		Tagline: "Create a new keypair",
 	},
 	Options: []cmds.Option{
-		cmds.StringOption(keyStoreTypeOptionName, "t", "type of the key to create: rsa, ed25519").WithDefault(keyStoreAlgorithmDefault),
+		cmds.StringOption(keyStoreTypeOptionName, "t", "type of the key to create: rsa, ed25519, sds-secp256k1").WithDefault(keyStoreAlgorithmDefault),
 		cmds.IntOption(keyStoreSizeOptionName, "s", "size of the key to generate"),
 		ke.OptionIPNSBase,
 	},
@@ -144,6 +145,7 @@ const (
 	keyFormatOptionName            = "format"
 	keyFormatPemCleartextOption    = "pem-pkcs8-cleartext"
 	keyFormatLibp2pCleartextOption = "libp2p-protobuf-cleartext"
+	keyFormatSdsHexOption          = "sds-hex"
 	keyAllowAnyTypeOptionName      = "allow-any-key-type"
 )
 
@@ -162,6 +164,9 @@ elsewhere. For example, using openssl to get a PEM with public key:
 
   $ ipfs key export testkey --format=pem-pkcs8-cleartext -o privkey.pem
   $ openssl pkey -in privkey.pem -pubout > pubkey.pem
+
+SDS wallet keys could be exported as the hex private key used by SDS wallets
+with '--format=sds-hex'.
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -169,7 +174,7 @@ elsewhere. For example, using openssl to get a PEM with public key:
 	},
 	Options: []cmds.Option{
 		cmds.StringOption(outputOptionName, "o", "The path where the output should be stored."),
-		cmds.StringOption(keyFormatOptionName, "f", "The format of the exported private key, libp2p-protobuf-cleartext or pem-pkcs8-cleartext.").WithDefault(keyFormatLibp2pCleartextOption),
+		cmds.StringOption(keyFormatOptionName, "f", "The format of the exported private key, libp2p-protobuf-cleartext, pem-pkcs8-cleartext or sds-hex.").WithDefault(keyFormatLibp2pCleartextOption),
 	},
 	NoRemote: true,
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -232,6 +237,15 @@ elsewhere. For example, using openssl to get a PEM with public key:
 			if err != nil {
 				return err
 			}
+		case keyFormatSdsHexOption:
+			if _, ok := sk.(*crypto.Secp256k1PrivateKey); !ok {
+				return fmt.Errorf("format=%s only supports sds-secp256k1 keys", keyFormatSdsHexOption)
+			}
+			raw, err := sk.Raw()
+			if err != nil {
+				return err
+			}
+			formattedKey = []byte("0x" + hex.EncodeToString(raw) + "\n")
 		default:
 			return fmt.Errorf("unrecognized export format: %s", exportFormat)
 		}
@@ -261,6 +275,8 @@ elsewhere. For example, using openssl to get a PEM with public key:
 					fileExtension = "pem"
 				case keyFormatLibp2pCleartextOption:
 					fileExtension = "key"
+				case keyFormatSdsHexOption:
+					fileExtension = "hex"
 				}
 				trimmed := strings.TrimRight(fmt.Sprintf("%s.%s", req.Arguments[0], fileExtension), "/")
 				_, outPath = filepath.Split(trimmed)
@@ -289,7 +305,7 @@ elsewhere. For example, using openssl to get a PEM with public key:
 					return fmt.Errorf("encoding PEM block: %w", err)
 				}
 
-			case keyFormatLibp2pCleartextOption:
+			case keyFormatLibp2pCleartextOption, keyFormatSdsHexOption:
 				_, err = io.Copy(file, outReader)
 				if err != nil {
 					return err
@@ -315,11 +331,17 @@ The PEM format allows for key generation outside of the IPFS node:
 
   $ openssl genpkey -algorithm ED25519 > ed25519.pem
   $ ipfs key import test-openssl -f pem-pkcs8-cleartext ed25519.pem
+
+An existing SDS wallet is imported from its hex private key with
+'--format=sds-hex', it could then be used by setting Sds.Wallet to its name:
+
+  $ ipfs key import my-wallet -f sds-hex wallet.hex
+  $ ipfs config Sds.Wallet my-wallet
 `,
 	},
 	Options: []cmds.Option{
 		ke.OptionIPNSBase,
-		cmds.StringOption(keyFormatOptionName, "f", "The format of the private key to import, libp2p-protobuf-cleartext or pem-pkcs8-cleartext.").WithDefault(keyFormatLibp2pCleartextOption),
+		cmds.StringOption(keyFormatOptionName, "f", "The format of the private key to import, libp2p-protobuf-cleartext, pem-pkcs8-cleartext or sds-hex.").WithDefault(keyFormatLibp2pCleartextOption),
 		cmds.BoolOption(keyAllowAnyTypeOptionName, "Allow importing any key type.").WithDefault(false),
 	},
 	Arguments: []cmds.Argument{
@@ -387,6 +409,15 @@ The PEM format allows for key generation outside of the IPFS node:
 				}
 				return fmt.Errorf("unable to unmarshall format=%s: %w", keyFormatLibp2pCleartextOption, err)
 			}
+		case keyFormatSdsHexOption:
+			raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+			if err != nil {
+				return fmt.Errorf("unable to decode format=%s: %w", keyFormatSdsHexOption, err)
+			}
+			sk, err = crypto.UnmarshalSecp256k1PrivateKey(raw)
+			if err != nil {
+				return fmt.Errorf("unable to unmarshall format=%s: %w", keyFormatSdsHexOption, err)
+			}
 
 		default:
 			return fmt.Errorf("unrecognized import format: %s", importFormat)
@@ -398,9 +429,9 @@ The PEM format allows for key generation outside of the IPFS node:
 		allowAnyKeyType, _ := req.Options[keyAllowAnyTypeOptionName].(bool)
 		if !allowAnyKeyType {
 			switch t := sk.(type) {
-			case *crypto.RsaPrivateKey, *crypto.Ed25519PrivateKey:
+			case *crypto.RsaPrivateKey, *crypto.Ed25519PrivateKey, *crypto.Secp256k1PrivateKey:
 			default:
-				return fmt.Errorf("key type %T is not allowed to be imported, only RSA or Ed25519;"+
+				return fmt.Errorf("key type %T is not allowed to be imported, only RSA, Ed25519 or Secp256k1;"+
 					" use flag --%s if you are sure of what you're doing",
 					t, keyAllowAnyTypeOptionName)
 			}
@@ -127,6 +127,11 @@ NOTE: a comma-separated notation is supported in CLI for convenience:
 
   $ ipfs pin remote ls --service=mysrv --cid=bafkqaaa --status=queued,pinning,pinned,failed
 
+When SDS is enabled, the built-in 'sds' service uploads the DAG to SDS through
+the upload queue of the daemon (see 'ipfs sds queue --help'):
+
+  $ ipfs pin remote add --service=sds --name=mypin bafkqaaa
+
 `,
 	},
 
@@ -140,6 +145,10 @@ NOTE: a comma-separated notation is supported in CLI for convenience:
 	},
 	Type: RemotePinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if isSdsRemotePinService(req) {
+			return addSdsRemotePin(req, res, env)
+		}
+
 		ctx, cancel := context.WithCancel(req.Context)
 		defer cancel()
 
@@ -278,6 +287,10 @@ Pass '--status=queued,pinning,pinned,failed' to list pins in all states.
 		cmds.DelimitedStringsOption(",", pinStatusOptionName, "Return pins with the specified statuses (queued,pinning,pinned,failed).").WithDefault([]string{"pinned"}),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if isSdsRemotePinService(req) {
+			return listSdsRemotePins(req, res, env)
+		}
+
 		ctx, cancel := context.WithCancel(req.Context)
 		defer cancel()
 
@@ -374,6 +387,10 @@ To list and then remove all pending pin requests, pass an explicit status list:
   $ ipfs pin remote ls --service=mysrv --status=queued,pinning,failed
   $ ipfs pin remote rm --service=mysrv --status=queued,pinning,failed --force
 
+Removing a pin from the built-in 'sds' service removes the upload from the
+SDS queue, an upload in progress is stopped. The file already uploaded to SDS
+is left as is.
+
 `,
 	},
 
@@ -386,6 +403,10 @@ To list and then remove all pending pin requests, pass an explicit status list:
 		cmds.BoolOption(pinForceOptionName, "Allow removal of multiple pins matching the query without additional confirmation.").WithDefault(false),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if isSdsRemotePinService(req) {
+			return rmSdsRemotePins(req, env)
+		}
+
 		ctx, cancel := context.WithCancel(req.Context)
 		defer cancel()
 
@@ -467,6 +488,9 @@ TIP:
 		}
 
 		name := req.Arguments[0]
+		if name == config.SdsRemoteServiceName {
+			return fmt.Errorf("%q is the built-in SDS pinning service, enable it with Sds.Enabled", name)
+		}
 		endpoint, err := normalizeEndpoint(req.Arguments[1])
 		if err != nil {
 			return err
@@ -573,13 +597,20 @@ TIP: pass '--enc=json' for more useful JSON output.
 		if err != nil {
 			return err
 		}
-		if cfg.Pinning.RemoteServices == nil {
-			return cmds.EmitOnce(res, &PinServicesList{make([]ServiceDetails, 0)})
+		services := make(map[string]config.RemotePinningService, len(cfg.Pinning.RemoteServices)+1)
+		for svcName, svcConfig := range cfg.Pinning.RemoteServices {
+			services[svcName] = svcConfig
+		}
+		if cfg.Sds.Enabled {
+			// the built-in service is listed without a config entry
+			services[config.SdsRemoteServiceName] = services[config.SdsRemoteServiceName]
 		}
-		services := cfg.Pinning.RemoteServices
 		result := PinServicesList{make([]ServiceDetails, 0, len(services))}
 		for svcName, svcConfig := range services {
 			svcDetails := ServiceDetails{svcName, svcConfig.API.Endpoint, nil}
+			if svcName == config.SdsRemoteServiceName {
+				svcDetails.ApiEndpoint = sdsRemotePinEndpoint(cfg)
+			}
 
 			// if --pin-count is passed, we try to fetch pin numbers from remote service
 			if req.Options[pinServiceStatOptionName].(bool) {
@@ -629,7 +660,12 @@ TIP: pass '--enc=json' for more useful JSON output.
 					return pc, nil
 				}
 
-				pinCount, err := lsRemotePinCount(ctx, env, svcName)
+				var pinCount *PinCount
+				if svcName == config.SdsRemoteServiceName {
+					pinCount, err = lsSdsRemotePinCount(ctx, req, env)
+				} else {
+					pinCount, err = lsRemotePinCount(ctx, env, svcName)
+				}
 
 				// PinCount is present only if we were able to fetch counts.
 				// We don't want to break listing of services so this is best-effort.
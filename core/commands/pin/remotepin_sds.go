@@ -0,0 +1,254 @@
+package pin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	pinclient "github.com/ipfs/boxo/pinning/remote/client"
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/commands/cmdutils"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/ipfs/kubo/sds"
+)
+
+// The built-in sds service pins through the sds upload queue: a pin is a
+// queued upload, its request id is the root cid of the DAG.
+
+// isSdsRemotePinService reports if the request is for the built-in sds
+// pinning service
+func isSdsRemotePinService(req *cmds.Request) bool {
+	service, _ := req.Options[pinServiceNameOptionName].(string)
+	return service == config.SdsRemoteServiceName
+}
+
+// sdsPinStatus maps the status of a queued upload to a pin status
+func sdsPinStatus(status string) pinclient.Status {
+	return pinclient.Status(sds.QueueStatus(status).PinStatus())
+}
+
+func toSdsRemotePinOutput(u iface.SdsQueued) RemotePinOutput {
+	return RemotePinOutput{
+		Name:   u.Name,
+		Status: sdsPinStatus(u.Status).String(),
+		Cid:    u.Cid.String(),
+	}
+}
+
+// sdsRemotePinEndpoint is the endpoint listed for the sds service, its pp
+// nodes
+func sdsRemotePinEndpoint(cfg *config.Config) string {
+	return strings.Join(cfg.Sds.PPs(), ",")
+}
+
+// getSdsRemotePin returns the queued upload of the cid, or false when it is
+// not queued
+func getSdsRemotePin(ctx context.Context, api iface.CoreAPI, c cid.Cid) (iface.SdsQueued, bool, error) {
+	uploads, err := api.Sds().Queue(ctx)
+	if err != nil {
+		return iface.SdsQueued{}, false, err
+	}
+	for _, u := range uploads {
+		if u.Cid.Equals(c) {
+			return u, true, nil
+		}
+	}
+	return iface.SdsQueued{}, false, nil
+}
+
+func addSdsRemotePin(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+	ctx, cancel := context.WithCancel(req.Context)
+	defer cancel()
+
+	if len(req.Arguments) != 1 {
+		return fmt.Errorf("expecting one CID argument")
+	}
+	api, err := cmdenv.GetApi(env, req)
+	if err != nil {
+		return err
+	}
+	p, err := cmdutils.PathOrCidPath(req.Arguments[0])
+	if err != nil {
+		return err
+	}
+	name, _ := req.Options[pinNameOptionName].(string)
+
+	// Only the daemon runs the queue workers, the pin would never leave the
+	// queue without it
+	background := req.Options[pinBackgroundOptionName].(bool)
+	if !background {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !node.IsDaemon {
+			return fmt.Errorf("pins to %q are uploaded by the daemon, start it or pass --%s to only queue the pin", config.SdsRemoteServiceName, pinBackgroundOptionName)
+		}
+	}
+
+	u, err := api.Sds().Enqueue(ctx, p, options.Sds.Name(name))
+	if err != nil {
+		return err
+	}
+
+	// Block unless --background=true is passed
+	c := u.Cid
+	for !background {
+		s := sdsPinStatus(u.Status)
+		if s == pinclient.StatusPinned {
+			break
+		}
+		if s == pinclient.StatusFailed {
+			return fmt.Errorf("sds failed to pin %s: %s", c, u.Error)
+		}
+		tmr := time.NewTimer(time.Second / 2)
+		select {
+		case <-tmr.C:
+		case <-ctx.Done():
+			tmr.Stop()
+			return fmt.Errorf("waiting for pin interrupted, %s remains queued for sds", c)
+		}
+
+		var found bool
+		u, found, err = getSdsRemotePin(ctx, api, c)
+		if err != nil {
+			return fmt.Errorf("failed to check pin status of %s due to error: %v", c, err)
+		}
+		if !found {
+			return fmt.Errorf("pin of %s was removed from the sds queue", c)
+		}
+	}
+
+	return res.Emit(toSdsRemotePinOutput(u))
+}
+
+// lsSdsRemote returns the queued uploads matching the filters of the request
+func lsSdsRemote(ctx context.Context, req *cmds.Request, api iface.CoreAPI) ([]iface.SdsQueued, error) {
+	name, nameFound := req.Options[pinNameOptionName].(string)
+
+	var cids []cid.Cid
+	if cidsRaw, cidsFound := req.Options[pinCIDsOptionName]; cidsFound {
+		for _, rawCID := range cidsRaw.([]string) {
+			parsedCID, err := cid.Decode(rawCID)
+			if err != nil {
+				return nil, fmt.Errorf("CID %q cannot be parsed: %v", rawCID, err)
+			}
+			cids = append(cids, parsedCID)
+		}
+	}
+
+	statuses := map[pinclient.Status]bool{}
+	if statusRaw, statusFound := req.Options[pinStatusOptionName]; statusFound {
+		for _, rawStatus := range statusRaw.([]string) {
+			s := pinclient.Status(rawStatus)
+			if s.String() == string(pinclient.StatusUnknown) {
+				return nil, fmt.Errorf("status %q is not valid", rawStatus)
+			}
+			statuses[s] = true
+		}
+	}
+
+	uploads, err := api.Sds().Queue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []iface.SdsQueued
+	for _, u := range uploads {
+		if nameFound && u.Name != name {
+			continue
+		}
+		if len(statuses) > 0 && !statuses[sdsPinStatus(u.Status)] {
+			continue
+		}
+		if len(cids) > 0 && !containsCid(cids, u.Cid) {
+			continue
+		}
+		matching = append(matching, u)
+	}
+	return matching, nil
+}
+
+func containsCid(cids []cid.Cid, c cid.Cid) bool {
+	for _, x := range cids {
+		if x.Equals(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func listSdsRemotePins(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+	api, err := cmdenv.GetApi(env, req)
+	if err != nil {
+		return err
+	}
+
+	uploads, err := lsSdsRemote(req.Context, req, api)
+	if err != nil {
+		return err
+	}
+	for _, u := range uploads {
+		if err := res.Emit(toSdsRemotePinOutput(u)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rmSdsRemotePins(req *cmds.Request, env cmds.Environment) error {
+	if len(req.Arguments) != 0 {
+		return fmt.Errorf("unexpected argument %q", req.Arguments[0])
+	}
+	api, err := cmdenv.GetApi(env, req)
+	if err != nil {
+		return err
+	}
+
+	uploads, err := lsSdsRemote(req.Context, req, api)
+	if err != nil {
+		return fmt.Errorf("error while listing remote pins: %v", err)
+	}
+	if len(uploads) > 1 && !req.Options[pinForceOptionName].(bool) {
+		return fmt.Errorf("multiple remote pins are matching this query, add --force to confirm the bulk removal")
+	}
+
+	for _, u := range uploads {
+		if err := api.Sds().QueueCancel(req.Context, u.Cid); err != nil {
+			return fmt.Errorf("removing pin of %s failed: %v", u.Cid, err)
+		}
+	}
+	return nil
+}
+
+// lsSdsRemotePinCount counts the queued uploads by pin status
+func lsSdsRemotePinCount(ctx context.Context, req *cmds.Request, env cmds.Environment) (*PinCount, error) {
+	api, err := cmdenv.GetApi(env, req)
+	if err != nil {
+		return nil, err
+	}
+	uploads, err := api.Sds().Queue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &PinCount{}
+	for _, u := range uploads {
+		switch sdsPinStatus(u.Status) {
+		case pinclient.StatusQueued:
+			pc.Queued++
+		case pinclient.StatusPinning:
+			pc.Pinning++
+		case pinclient.StatusPinned:
+			pc.Pinned++
+		case pinclient.StatusFailed:
+			pc.Failed++
+		}
+	}
+	return pc, nil
+}
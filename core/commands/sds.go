@@ -2,15 +2,35 @@ package commands
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/commands/cmdutils"
+	"github.com/ipfs/kubo/repo/fsrepo"
 	"github.com/ipfs/kubo/sds"
 
+	humanize "github.com/dustin/go-humanize"
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/path"
+	cid "github.com/ipfs/go-cid"
+	cidenc "github.com/ipfs/go-cidutil/cidenc"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	ipld "github.com/ipfs/go-ipld-format"
 	iface "github.com/ipfs/kubo/core/coreiface"
 	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/ipfs/kubo/core/coreiface/sdserr"
+	"github.com/libp2p/go-libp2p/core/crypto"
 )
 
 func getCarOrResolve(nd *core.IpfsNode, cfg *config.Config, ctx context.Context, api iface.CoreAPI, p path.Path) (files.Node, error) {
@@ -25,7 +45,7 @@ func getCarOrResolve(nd *core.IpfsNode, cfg *config.Config, ctx context.Context,
 			return nil, err
 		}
 
-		sf, err := api.Sds().Download(ctx, p)
+		sf, err := api.Sds().Download(ctx, p.Segments()[1])
 		if err != nil {
 			return nil, err
 		}
@@ -34,8 +54,8 @@ func getCarOrResolve(nd *core.IpfsNode, cfg *config.Config, ctx context.Context,
 		// in this case we should pin to store into local block tree
 		doPinRoots = true
 	} else {
-		// in case file found on ipfs, check if it is a mapping file and get original car file
-		// NOTE: Risk of broke API with mailware map file?
+		// in case file found on ipfs, check if it is a mapping file and get original car file.
+		// Parse only follows the mapping files of the trusted wallets, the others are read as they are.
 		mFile, ok := f.(files.File)
 		if ok {
 			p, err := api.Sds().Parse(ctx, mFile)
@@ -44,6 +64,8 @@ func getCarOrResolve(nd *core.IpfsNode, cfg *config.Config, ctx context.Context,
 				if err != nil {
 					return nil, err
 				}
+			} else if _, err := mFile.Seek(0, io.SeekStart); err != nil {
+				return nil, err
 			}
 		}
 	}
@@ -57,21 +79,1542 @@ func getCarOrResolve(nd *core.IpfsNode, cfg *config.Config, ctx context.Context,
 			return nil, err
 		}
 
-		sdsP, err := sds.NewDagParser(ctx, api.Dag(), nd.Blockstore, nd.Pinning).Import(f.(files.File), doPinRoots)
+		// the content is read as the blocks are imported, so the output
+		// starts before the whole CAR is downloaded
+		imported, err := sds.NewDagParser(ctx, api.Dag(), nd.Blockstore, nd.Pinning).StartImport(f.(files.File), doPinRoots)
 		if err != nil {
 			return nil, err
 		}
 
-		sdsP, err = sds.ModifySdsCARPath(sdsP, p)
+		sdsP, err := sds.ModifySdsCARPath(imported.Root, p)
 		if err != nil {
 			return nil, err
 		}
 
-		f, err = api.Unixfs().Get(ctx, sdsP)
-		if err != nil {
+		return getImported(ctx, imported, func() (files.Node, error) {
+			return api.Unixfs().Get(ctx, sdsP)
+		})
+	}
+
+	return f, nil
+}
+
+// importPollInterval is how often the content of a DAG still being imported
+// is opened again when it reaches a block which is not imported yet
+const importPollInterval = 100 * time.Millisecond
+
+// getImported opens the content of a DAG being imported with open. A file is
+// read as the import goes, a directory is returned once the import is over.
+func getImported(ctx context.Context, imported *sds.CARImport, open func() (files.Node, error)) (files.Node, error) {
+	over := false
+	for {
+		nd, err := open()
+		switch {
+		case err == nil:
+			if f, ok := nd.(files.File); ok {
+				return &importedFile{File: f, ctx: ctx, imported: imported, open: open, over: over}, nil
+			}
+			if over {
+				return nd, nil
+			}
+			nd.Close()
+			for !over {
+				if over, err = waitImport(ctx, imported); err != nil {
+					return nil, err
+				}
+			}
+		case ipld.IsNotFound(err) && !over:
+			if over, err = waitImport(ctx, imported); err != nil {
+				return nil, err
+			}
+		default:
 			return nil, err
 		}
 	}
+}
 
-	return f, nil
+// waitImport waits for more blocks to be imported, it reports whether the
+// import is over
+func waitImport(ctx context.Context, imported *sds.CARImport) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-imported.Done():
+		return true, imported.Wait()
+	case <-time.After(importPollInterval):
+		return false, nil
+	}
+}
+
+// importedFile is a file of a DAG being imported. Reading up to a block which
+// is not imported yet waits for the import to get further, then goes on from
+// the same offset in the file opened again.
+type importedFile struct {
+	files.File
+	ctx      context.Context
+	imported *sds.CARImport
+	open     func() (files.Node, error)
+	// over is set once the import is over, a missing block is then an error
+	over bool
+	// offset is where the next read starts, the file is opened again and
+	// seeked there when stale is set
+	offset int64
+	stale  bool
+}
+
+func (f *importedFile) Read(p []byte) (int, error) {
+	for {
+		var err error
+		if f.stale {
+			err = f.reopen()
+		}
+		if err == nil {
+			var n int
+			n, err = f.File.Read(p)
+			f.offset += int64(n)
+			if !ipld.IsNotFound(err) {
+				return n, err
+			}
+			f.stale = true
+			if n > 0 {
+				return n, nil
+			}
+		}
+		if !ipld.IsNotFound(err) || f.over {
+			return 0, err
+		}
+		if f.over, err = waitImport(f.ctx, f.imported); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// reopen opens the file again at the read offset
+func (f *importedFile) reopen() error {
+	nd, err := f.open()
+	if err != nil {
+		return err
+	}
+	file, ok := nd.(files.File)
+	if !ok {
+		nd.Close()
+		return iface.ErrNotFile
+	}
+	f.File.Close()
+	f.File = file
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		return err
+	}
+	f.stale = false
+	return nil
+}
+
+// Seek moves the offset of the next read, the file is seeked when it is read
+func (f *importedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		size, err := f.Size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	f.offset = offset
+	f.stale = true
+	return offset, nil
+}
+
+var SdsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Interact with the SDS storage layer.",
+		ShortDescription: `
+'ipfs sds' is a set of commands to operate the SDS integration directly,
+without going through 'ipfs add', 'ipfs get' or 'ipfs cat'.
+
+  > ipfs sds status
+  > ipfs sds upload QmSomeHash
+  > ipfs sds share create --expire=7d --password QmSomeHash
+  > ipfs sds share ls
+  > ipfs sds queue ls
+  > ipfs sds download sds://QmSomeHash
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"status":   sdsStatusCmd,
+		"upload":   sdsUploadCmd,
+		"download": sdsDownloadCmd,
+		"share":    sdsShareCmd,
+		"link":     sdsLinkCmd,
+		"resolve":  sdsResolveCmd,
+		"parse":    sdsParseCmd,
+		"uploads":  sdsUploadsCmd,
+		"queue":    sdsQueueCmd,
+		"cache":    sdsCacheCmd,
+		"wallet":   sdsWalletCmd,
+	},
+}
+
+type SdsUploadOutput struct {
+	Cid      string
+	FileHash string
+}
+
+type SdsShareOutput struct {
+	Cid       string `json:",omitempty"`
+	ShareLink string
+	ShareId   string
+	Password  string `json:",omitempty"`
+	Private   bool
+	FileHash  string
+	FileName  string `json:",omitempty"`
+	FileSize  uint64 `json:",omitempty"`
+	Created   time.Time
+	Expires   time.Time `json:",omitempty"`
+	Revoked   time.Time `json:",omitempty"`
+	Recorded  bool
+}
+
+type SdsSharesOutput struct {
+	Shares []SdsShareOutput
+}
+
+type SdsResolveOutput struct {
+	Version   int
+	Cid       string
+	FileHash  string
+	CarSize   int64  `json:",omitempty"`
+	ShareLink string `json:",omitempty"`
+	Wallet    string `json:",omitempty"`
+}
+
+type SdsParseOutput struct {
+	Path string
+}
+
+type SdsUploadSession struct {
+	FileHash    string
+	Cid         string `json:",omitempty"`
+	Name        string `json:",omitempty"`
+	ContentType string `json:",omitempty"`
+	Size        int64
+	OffsetStart uint64
+	OffsetEnd   uint64
+	Status      string
+	Error       string `json:",omitempty"`
+	Started     time.Time
+	Updated     time.Time
+}
+
+type SdsUploadsOutput struct {
+	Uploads []SdsUploadSession
+}
+
+type SdsQueuedUpload struct {
+	Cid         string
+	Name        string `json:",omitempty"`
+	Status      string
+	Attempts    int64
+	NextAttempt time.Time
+	FileHash    string `json:",omitempty"`
+	Link        string `json:",omitempty"`
+	Error       string `json:",omitempty"`
+	Added       time.Time
+	Updated     time.Time
+}
+
+type SdsQueueOutput struct {
+	Uploads []SdsQueuedUpload
+}
+
+type SdsCacheOutput struct {
+	Entries []iface.SdsCacheEntry
+}
+
+type SdsWalletOutput struct {
+	// Name of the wallet key in the keystore, empty for the deprecated
+	// Sds.PrivateKey
+	Name    string
+	Address string
+	Ozone   string `json:",omitempty"`
+}
+
+const (
+	sdsFileHashOptionName    = "file-hash"
+	sdsOldKeyOptionName      = "oldkey"
+	sdsNameOptionName        = "name"
+	sdsExpireOptionName      = "expire"
+	sdsPasswordOptionName    = "password"
+	sdsRecordedOptionName    = "recorded"
+	sdsContentTypeOptionName = "content-type"
+)
+
+// sdsError makes the HTTP API answer the sds errors with their own status
+// and code, so clients could tell them apart without parsing the message.
+// The code tells apart the errors sharing a status.
+func sdsError(req *cmds.Request, err error) error {
+	if status := sds.ErrorStatus(err); status != 0 {
+		if status == http.StatusNotFound {
+			// the api clients take a 404 for an unknown command, the code
+			// still tells the error apart
+			status = http.StatusBadRequest
+		}
+		cmdenv.SetErrorStatus(req.Context, status)
+	}
+	if code := sdserr.Code(err); code != 0 {
+		return cmds.Errorf(cmds.ErrorType(code), "%s", err)
+	}
+	return err
+}
+
+var sdsStatusCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the state of the SDS integration.",
+		ShortDescription: `
+Prints whether SDS is enabled, the PP nodes with their health and latency,
+the wallet address signing the requests, its ozone balance and the ozone
+spent today against Sds.Budget.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		status, err := api.Sds().Status(req.Context)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &status)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *iface.SdsStatus) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			fmt.Fprintf(tw, "Enabled:\t%t\n", out.Enabled)
+			if !out.Enabled {
+				for _, pp := range out.PPs {
+					fmt.Fprintf(tw, "PP:\t%s\n", pp.URL)
+				}
+				return tw.Flush()
+			}
+			for _, pp := range out.PPs {
+				state := "up"
+				if !pp.Healthy {
+					state = "down"
+				}
+				fmt.Fprintf(tw, "PP:\t%s\t%s\t%s", pp.URL, state, pp.Latency.Round(time.Millisecond))
+				if pp.Error != "" {
+					fmt.Fprintf(tw, "\t%s", pp.Error)
+				}
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintf(tw, "Reachable:\t%t\n", out.Reachable)
+			if out.Error != "" {
+				fmt.Fprintf(tw, "Error:\t%s\n", out.Error)
+			}
+			fmt.Fprintf(tw, "Wallet:\t%s\n", out.WalletAddress)
+			if out.Reachable {
+				fmt.Fprintf(tw, "Ozone:\t%s\n", out.Ozone)
+			}
+			if out.Spent != nil {
+				fmt.Fprintf(tw, "Spent today:\t%d", out.Spent.Total)
+				if out.Spent.Daily > 0 {
+					fmt.Fprintf(tw, " of %d", out.Spent.Daily)
+				}
+				fmt.Fprintln(tw)
+				ops := make([]string, 0, len(out.Spent.Operations))
+				for op := range out.Spent.Operations {
+					ops = append(ops, op)
+				}
+				sort.Strings(ops)
+				for _, op := range ops {
+					fmt.Fprintf(tw, "Spent on %s:\t%d\n", op, out.Spent.Operations[op])
+				}
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: iface.SdsStatus{},
+}
+
+var sdsUploadCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Upload a DAG to SDS.",
+		ShortDescription: `
+Exports the DAG referenced by the path as a CAR and stores it in SDS.
+Prints the SDS file hash of the uploaded CAR.
+
+The file is stored under --name, or the root CID of the DAG, along with its
+content type and root CID, so it could be told apart in the SDS listings.
+The content type is detected from the extension of the name unless
+--content-type is given.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, false, "The path of the DAG to upload."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(sdsNameOptionName, "The name of the file in SDS. Default: the last segment of the path, or the root CID."),
+		cmds.StringOption(sdsContentTypeOptionName, "The content type of the file."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		p, err := cmdutils.PathOrCidPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		rp, _, err := api.ResolvePath(req.Context, p)
+		if err != nil {
+			return err
+		}
+
+		name, ok := req.Options[sdsNameOptionName].(string)
+		if !ok {
+			name = sdsPathName(p)
+		}
+		contentType, _ := req.Options[sdsContentTypeOptionName].(string)
+		fileHash, err := api.Sds().Upload(req.Context, rp, options.Sds.FileName(name), options.Sds.ContentType(contentType))
+		if err != nil {
+			return sdsError(req, err)
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &SdsUploadOutput{
+			Cid:      enc.Encode(rp.RootCid()),
+			FileHash: fileHash,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsUploadOutput) error {
+			_, err := fmt.Fprintln(w, out.FileHash)
+			return err
+		}),
+	},
+	Type: SdsUploadOutput{},
+}
+
+var sdsDownloadCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Download a file shared through SDS.",
+		ShortDescription: `
+Outputs the raw content of the file behind an SDS share link. The link
+could be a full 'sds://' link, a bare share id or a CID shared through SDS.
+The data is not imported into IPFS.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("share-link", true, false, "The share link of the file to download."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		file, err := api.Sds().Download(req.Context, req.Arguments[0])
+		if err != nil {
+			return sdsError(req, err)
+		}
+		go func() {
+			// the file can not be closed from the response emitter, do it
+			// once the request is over
+			<-req.Context.Done()
+			file.Close()
+		}()
+
+		return res.Emit(file)
+	},
+}
+
+var sdsShareCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create and manage SDS share links.",
+		ShortDescription: `
+The share links created by the node are recorded in the repo, along with
+their password, expiry and revocation, to audit what was exposed.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"create": sdsShareCreateCmd,
+		"ls":     sdsShareLsCmd,
+		"rm":     sdsShareRmCmd,
+	},
+}
+
+var sdsShareCreateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create an SDS share link for an uploaded DAG.",
+		ShortDescription: `
+Shares the SDS file holding the CAR of the DAG referenced by the CID.
+Unless --file-hash is given, the SDS file hash is computed by exporting
+the DAG locally.
+
+The share does not expire unless --expire is given, as a duration like
+"12h" or "7d". A share created with --password is private: SDS generates
+its password, which has to be given along with the link, as
+sds://<link>/<password>.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, false, "The root CID of the uploaded DAG."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(sdsFileHashOptionName, "The SDS file hash of the uploaded CAR."),
+		cmds.StringOption(sdsExpireOptionName, "How long the share link is valid, e.g. \"12h\" or \"7d\"."),
+		cmds.BoolOption(sdsPasswordOptionName, "Protect the share link with a password generated by SDS."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		c, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		fileHash, _ := req.Options[sdsFileHashOptionName].(string)
+		private, _ := req.Options[sdsPasswordOptionName].(bool)
+
+		var expire time.Duration
+		if s, ok := req.Options[sdsExpireOptionName].(string); ok {
+			expire, err = parseShareExpire(s)
+			if err != nil {
+				return err
+			}
+		}
+
+		share, err := api.Sds().Share(req.Context, fileHash, c, options.Sds.Expire(expire), options.Sds.Private(private))
+		if err != nil {
+			return sdsError(req, err)
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		out := toSdsShareOutput(enc, share)
+		return cmds.EmitOnce(res, &out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsShareOutput) error {
+			_, err := fmt.Fprintln(w, sds.FormatShareLink(strings.TrimPrefix(out.ShareLink, "sds://"), out.Password))
+			return err
+		}),
+	},
+	Type: SdsShareOutput{},
+}
+
+var sdsShareLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the SDS share links.",
+		ShortDescription: `
+Lists the active share links of the wallet, as known by SDS, with their
+share id and expiry. The password, CID and creation of the share links
+created by the node come from its records.
+
+With --recorded, lists the records of the repo only, most recent first,
+including the share links which were revoked or expired.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(sdsRecordedOptionName, "List the share links recorded in the repo, without asking SDS."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		recorded, _ := req.Options[sdsRecordedOptionName].(bool)
+		shares, err := api.Sds().Shares(req.Context, options.Sds.Recorded(recorded))
+		if err != nil {
+			return sdsError(req, err)
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		out := &SdsSharesOutput{Shares: make([]SdsShareOutput, 0, len(shares))}
+		for _, share := range shares {
+			out.Shares = append(out.Shares, toSdsShareOutput(enc, share))
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsSharesOutput) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			for _, share := range out.Shares {
+				link := sds.FormatShareLink(strings.TrimPrefix(share.ShareLink, "sds://"), share.Password)
+				target := share.Cid
+				if target == "" {
+					target = share.FileHash
+				}
+
+				var state string
+				switch {
+				case !share.Revoked.IsZero():
+					state = "revoked " + share.Revoked.Format(time.RFC3339)
+				case share.Expires.IsZero():
+					state = "never expires"
+				case share.Expires.Before(time.Now()):
+					state = "expired " + share.Expires.Format(time.RFC3339)
+				default:
+					state = "expires " + share.Expires.Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", share.ShareId, link, target, state)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: SdsSharesOutput{},
+}
+
+var sdsShareRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Revoke SDS share links.",
+		ShortDescription: `
+Stops the share links with the share ids listed by 'ipfs sds share ls'. The
+share links created by the node stay in its records, as revoked.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("share-id", true, true, "The share ids of the share links."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		for _, shareId := range req.Arguments {
+			if err := api.Sds().Unshare(req.Context, shareId); err != nil {
+				return sdsError(req, fmt.Errorf("revoking share %s: %w", shareId, err))
+			}
+		}
+		return nil
+	},
+}
+
+// sdsPathName returns the last segment of a path below the root of its DAG,
+// empty for the root
+func sdsPathName(p path.Path) string {
+	segments := p.Segments()
+	if len(segments) <= 2 {
+		return ""
+	}
+	return segments[len(segments)-1]
+}
+
+// parseShareExpire parses a share duration, a go duration or a number of
+// days like "7d"
+func parseShareExpire(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid --%s %q: %w", sdsExpireOptionName, s, err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --%s %q: %w", sdsExpireOptionName, s, err)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("invalid --%s %q: the share link would expire right away", sdsExpireOptionName, s)
+	}
+	return d, nil
+}
+
+func toSdsShareOutput(enc cidenc.Encoder, share iface.SdsShare) SdsShareOutput {
+	out := SdsShareOutput{
+		ShareLink: share.ShareLink,
+		ShareId:   share.ShareId,
+		Password:  share.Password,
+		Private:   share.Private,
+		FileHash:  share.FileHash,
+		FileName:  share.FileName,
+		FileSize:  share.FileSize,
+		Created:   share.Created,
+		Expires:   share.Expires,
+		Revoked:   share.Revoked,
+		Recorded:  share.Recorded,
+	}
+	if share.Cid.Defined() {
+		out.Cid = enc.Encode(share.Cid)
+	}
+	return out
+}
+
+var sdsResolveCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Print the content of an SDS mapping file.",
+		ShortDescription: `
+Reads the mapping file added by 'ipfs add' when SDS is enabled and prints
+the original DAG root CID and the SDS file hash of its CAR.
+
+Mapping files from version 2 on also hold the size of the CAR, the link it is
+shared under and the wallet which uploaded it. They are signed by that wallet
+and the signature is verified before anything is printed. Version 1 mapping
+files are unsigned.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, false, "The path of the mapping file."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		p, err := cmdutils.PathOrCidPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		link, err := api.Sds().Resolve(req.Context, p)
+		if err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &SdsResolveOutput{
+			Version:   link.Version,
+			Cid:       enc.Encode(link.Cid),
+			FileHash:  link.FileHash,
+			CarSize:   link.CarSize,
+			ShareLink: link.ShareLink,
+			Wallet:    link.Wallet,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsResolveOutput) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			fmt.Fprintf(tw, "Version:\t%d\n", out.Version)
+			fmt.Fprintf(tw, "Cid:\t%s\n", out.Cid)
+			fmt.Fprintf(tw, "FileHash:\t%s\n", out.FileHash)
+			if out.Version > 1 {
+				fmt.Fprintf(tw, "CarSize:\t%d\n", out.CarSize)
+				fmt.Fprintf(tw, "ShareLink:\t%s\n", out.ShareLink)
+				fmt.Fprintf(tw, "Wallet:\t%s\n", out.Wallet)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: SdsResolveOutput{},
+}
+
+var sdsLinkCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create the mapping file of an uploaded DAG.",
+		ShortDescription: `
+Shares the SDS file holding the CAR of the DAG and outputs the mapping file
+linking the CID to the SDS file hash, the same file 'ipfs add' adds to IPFS
+when SDS is enabled.
+
+The share link does not expire unless --expire is given.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, false, "The root CID of the uploaded DAG."),
+		cmds.StringArg("file-hash", true, false, "The SDS file hash of the uploaded CAR."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(sdsExpireOptionName, "How long the share link is valid, e.g. \"12h\" or \"7d\"."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		c, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		var expire time.Duration
+		if s, ok := req.Options[sdsExpireOptionName].(string); ok {
+			expire, err = parseShareExpire(s)
+			if err != nil {
+				return err
+			}
+		}
+
+		mapFile, err := api.Sds().Link(req.Context, c, req.Arguments[1], options.Sds.Expire(expire))
+		if err != nil {
+			return sdsError(req, err)
+		}
+
+		return res.Emit(mapFile)
+	},
+}
+
+var sdsParseCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Parse an SDS mapping file.",
+		ShortDescription: `
+Reads a mapping file from the input and prints the path of the DAG it
+links to. The mapping file must be signed by the wallet of the node or one of
+Sds.TrustedWallets, the unsigned mapping files of older nodes are only
+followed when Sds.FollowUnsignedLinks is set.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("file", true, false, "The mapping file to parse.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		file, err := cmdenv.GetFileArg(req.Files.Entries())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		p, err := api.Sds().Parse(req.Context, file)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &SdsParseOutput{Path: p.String()})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsParseOutput) error {
+			_, err := fmt.Fprintln(w, out.Path)
+			return err
+		}),
+	},
+	Type: SdsParseOutput{},
+}
+
+var sdsUploadsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the unfinished SDS uploads.",
+		ShortDescription: `
+Lists the uploads in progress, and the ones interrupted or failed which
+could be resumed with 'ipfs sds uploads resume'. The progress of every
+upload is saved in the repo, an upload resumed after a failure or a daemon
+restart continues where the PP left off instead of starting over.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"resume": sdsUploadsResumeCmd,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		uploads, err := api.Sds().Uploads(req.Context)
+		if err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		out := &SdsUploadsOutput{Uploads: make([]SdsUploadSession, 0, len(uploads))}
+		for _, u := range uploads {
+			session := SdsUploadSession{
+				FileHash:    u.FileHash,
+				Name:        u.Name,
+				ContentType: u.ContentType,
+				Size:        u.Size,
+				OffsetStart: u.OffsetStart,
+				OffsetEnd:   u.OffsetEnd,
+				Status:      u.Status,
+				Error:       u.Error,
+				Started:     u.Started,
+				Updated:     u.Updated,
+			}
+			if u.Cid.Defined() {
+				session.Cid = enc.Encode(u.Cid)
+			}
+			out.Uploads = append(out.Uploads, session)
+		}
+
+		return cmds.EmitOnce(res, out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsUploadsOutput) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			for _, u := range out.Uploads {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d/%d", u.FileHash, u.Cid, u.Name, u.Status, u.OffsetEnd, u.Size)
+				if u.Error != "" {
+					fmt.Fprintf(tw, "\t%s", u.Error)
+				}
+				fmt.Fprintln(tw)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: SdsUploadsOutput{},
+}
+
+var sdsUploadsResumeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Resume unfinished SDS uploads.",
+		ShortDescription: `
+Exports again the DAG of the uploads and sends the chunks the PP still
+misses. Prints the SDS file hash of every finished upload.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("file-hash", true, true, "The SDS file hash of the uploads to resume."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		uploads, err := api.Sds().Uploads(req.Context)
+		if err != nil {
+			return err
+		}
+		sources := make(map[string]cid.Cid, len(uploads))
+		for _, u := range uploads {
+			sources[u.FileHash] = u.Cid
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		for _, fileHash := range req.Arguments {
+			source, ok := sources[fileHash]
+			if !ok {
+				return fmt.Errorf("no unfinished upload of %s", fileHash)
+			}
+			if !source.Defined() {
+				return fmt.Errorf("upload of %s is not a DAG and could not be exported again", fileHash)
+			}
+
+			uploaded, err := api.Sds().Upload(req.Context, path.FromCid(source))
+			if err != nil {
+				return sdsError(req, err)
+			}
+			if uploaded != fileHash {
+				return fmt.Errorf("DAG %s exported to %s instead of %s", source, uploaded, fileHash)
+			}
+
+			if err := res.Emit(&SdsUploadOutput{
+				Cid:      enc.Encode(source),
+				FileHash: uploaded,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsUploadOutput) error {
+			_, err := fmt.Fprintln(w, out.FileHash)
+			return err
+		}),
+	},
+	Type: SdsUploadOutput{},
+}
+
+var sdsQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the SDS uploads queued by the async adds.",
+		ShortDescription: `
+With 'ipfs add --sds-async' or Sds.UploadMode set to "async", 'ipfs add'
+completes as soon as the content is added to IPFS and the upload to SDS is
+queued in the repo. The daemon uploads the queued DAGs with Sds.Queue.Workers
+workers and adds their mapping file. A failed upload is attempted again
+following Sds.Queue.Retry, and is left failed after its last attempt.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add":    sdsQueueAddCmd,
+		"ls":     sdsQueueLsCmd,
+		"retry":  sdsQueueRetryCmd,
+		"cancel": sdsQueueCancelCmd,
+	},
+}
+
+var sdsQueueAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Queue the SDS upload of a DAG.",
+		ShortDescription: `
+Queues the upload of the DAG referenced by the path, as 'ipfs add --sds-async'
+does for the added content. The queued uploads are the pins of the built-in
+"sds" remote pinning service, see 'ipfs pin remote --help'.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, false, "The path of the DAG to upload."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(sdsNameOptionName, "A name for the upload, the name of its remote pin."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		p, err := cmdutils.PathOrCidPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		name, _ := req.Options[sdsNameOptionName].(string)
+		u, err := api.Sds().Enqueue(req.Context, p, options.Sds.Name(name))
+		if err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		queued := toSdsQueuedUpload(enc, u)
+		return cmds.EmitOnce(res, &queued)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(encodeSdsQueuedUpload),
+	},
+	Type: SdsQueuedUpload{},
+}
+
+var sdsQueueLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the queued SDS uploads.",
+		ShortDescription: `
+Lists the queued uploads, oldest first, with their status: queued,
+uploading, failed or done. The mapping file of the done uploads is listed
+along with their SDS file hash.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		uploads, err := api.Sds().Queue(req.Context)
+		if err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		out := &SdsQueueOutput{Uploads: make([]SdsQueuedUpload, 0, len(uploads))}
+		for _, u := range uploads {
+			out.Uploads = append(out.Uploads, toSdsQueuedUpload(enc, u))
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsQueueOutput) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			for _, u := range out.Uploads {
+				fmt.Fprintf(tw, "%s\t%s\t%d", u.Cid, u.Status, u.Attempts)
+				switch {
+				case u.Link != "":
+					fmt.Fprintf(tw, "\t%s\t%s", u.Link, u.FileHash)
+				case u.Error != "":
+					fmt.Fprintf(tw, "\t%s", u.Error)
+				}
+				fmt.Fprintln(tw)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: SdsQueueOutput{},
+}
+
+var sdsQueueRetryCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Attempt queued SDS uploads again.",
+		ShortDescription: `
+Queues the failed uploads again with all their attempts, an upload waiting
+for its next attempt is attempted right away.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, true, "The root CID of the queued DAGs."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		for _, arg := range req.Arguments {
+			c, err := cid.Decode(arg)
+			if err != nil {
+				return err
+			}
+
+			u, err := api.Sds().QueueRetry(req.Context, c)
+			if err != nil {
+				return err
+			}
+
+			queued := toSdsQueuedUpload(enc, u)
+			if err := res.Emit(&queued); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(encodeSdsQueuedUpload),
+	},
+	Type: SdsQueuedUpload{},
+}
+
+var sdsQueueCancelCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove uploads from the SDS queue.",
+		ShortDescription: `
+Removes the uploads from the queue, an upload in progress is stopped. The
+progress of a stopped upload is kept, see 'ipfs sds uploads'.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, true, "The root CID of the queued DAGs."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		for _, arg := range req.Arguments {
+			c, err := cid.Decode(arg)
+			if err != nil {
+				return err
+			}
+			if err := api.Sds().QueueCancel(req.Context, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func encodeSdsQueuedUpload(req *cmds.Request, w io.Writer, out *SdsQueuedUpload) error {
+	_, err := fmt.Fprintln(w, out.Cid, out.Status)
+	return err
+}
+
+func toSdsQueuedUpload(enc cidenc.Encoder, u iface.SdsQueued) SdsQueuedUpload {
+	out := SdsQueuedUpload{
+		Cid:         enc.Encode(u.Cid),
+		Name:        u.Name,
+		Status:      u.Status,
+		Attempts:    u.Attempts,
+		NextAttempt: u.NextAttempt,
+		FileHash:    u.FileHash,
+		Error:       u.Error,
+		Added:       u.Added,
+		Updated:     u.Updated,
+	}
+	if u.Link.Defined() {
+		out.Link = enc.Encode(u.Link)
+	}
+	return out
+}
+
+var sdsCacheCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the cache of SDS downloads.",
+		ShortDescription: `
+Files downloaded from SDS are kept in a cache folder, the sds-cache folder of
+the repo unless Sds.CacheFolder is set. The cache size is capped by
+Sds.CacheMaxSize, the least recently used files are evicted above it.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"stat":   sdsCacheStatCmd,
+		"ls":     sdsCacheLsCmd,
+		"clear":  sdsCacheClearCmd,
+		"verify": sdsCacheVerifyCmd,
+	},
+}
+
+var sdsCacheStatCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the SDS cache usage.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		stat, err := api.Sds().CacheStat(req.Context)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &stat)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *iface.SdsCacheStat) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			fmt.Fprintf(tw, "Folder:\t%s\n", out.Dir)
+			fmt.Fprintf(tw, "Files:\t%d\n", out.Files)
+			fmt.Fprintf(tw, "Size:\t%s\n", humanize.Bytes(uint64(out.Size)))
+			fmt.Fprintf(tw, "MaxSize:\t%s\n", humanize.Bytes(uint64(out.MaxSize)))
+			return tw.Flush()
+		}),
+	},
+	Type: iface.SdsCacheStat{},
+}
+
+var sdsCacheLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the files in the SDS cache.",
+		ShortDescription: `
+Lists the cached files with their size, most recently used first.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		entries, err := api.Sds().CacheList(req.Context)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &SdsCacheOutput{Entries: entries})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(encodeSdsCacheEntries),
+	},
+	Type: SdsCacheOutput{},
+}
+
+var sdsCacheClearCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove all the files from the SDS cache.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		return api.Sds().CacheClear(req.Context)
+	},
+}
+
+var sdsCacheVerifyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Verify the integrity of the SDS cache.",
+		ShortDescription: `
+Checks every cached file against its SDS file hash. Corrupted files are
+removed from the cache and listed.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		corrupted, err := api.Sds().CacheVerify(req.Context)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &SdsCacheOutput{Entries: corrupted})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(encodeSdsCacheEntries),
+	},
+	Type: SdsCacheOutput{},
+}
+
+func encodeSdsCacheEntries(req *cmds.Request, w io.Writer, out *SdsCacheOutput) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	for _, e := range out.Entries {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", e.FileHash, e.Size, e.LastUsed.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+var sdsWalletCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the SDS wallet.",
+		ShortDescription: `
+The SDS requests are signed by the wallet whose key is named by Sds.Wallet in
+the keystore. When Sds.Wallet is unset, the 'sds-wallet' key is used, generated
+on the first SDS operation. Other wallets are created or imported with
+'ipfs key':
+
+  > ipfs key gen --type=sds-secp256k1 my-wallet
+  > ipfs key import my-wallet --format=sds-hex wallet.hex
+  > ipfs config Sds.Wallet my-wallet
+
+The key could also be kept off this host by a signing service, the daemon then
+only gets the public key of the wallet:
+
+  > ipfs config Sds.Signer.Type remote
+  > ipfs config Sds.Signer.URL unix:///run/sds-signer.sock
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"address": sdsWalletAddressCmd,
+		"balance": sdsWalletBalanceCmd,
+		"rotate":  sdsWalletRotateCmd,
+	},
+}
+
+var sdsWalletAddressCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the address of the SDS wallet.",
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", false, false, "The name of a wallet key of the keystore, the wallet of Sds.Signer by default."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := nd.Repo.Config()
+		if err != nil {
+			return err
+		}
+
+		sdsCfg := cfg.Sds
+		if len(req.Arguments) > 0 {
+			sdsCfg.Wallet = req.Arguments[0]
+			sdsCfg.Signer = config.SdsSigner{}
+		}
+		wallet, err := sds.LoadWallet(&sdsCfg, nd.Repo.Keystore())
+		if err != nil {
+			return err
+		}
+		address, err := wallet.GetAddress(req.Context)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &SdsWalletOutput{
+			Name:    sdsCfg.WalletName(),
+			Address: address,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsWalletOutput) error {
+			_, err := fmt.Fprintln(w, out.Address)
+			return err
+		}),
+	},
+	Type: SdsWalletOutput{},
+}
+
+var sdsWalletBalanceCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the ozone balance of the SDS wallet.",
+		ShortDescription: `
+Asks the PP nodes for the ozone balance of the wallet signing the SDS
+requests.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		cfg, err := nd.Repo.Config()
+		if err != nil {
+			return err
+		}
+
+		status, err := api.Sds().Status(req.Context)
+		if err != nil {
+			return err
+		}
+		if !status.Enabled {
+			return fmt.Errorf("sds is not enabled, set Sds.Enabled in the config")
+		}
+		if !status.Reachable {
+			return sdsError(req, fmt.Errorf("%w: %s", sds.ErrPPUnavailable, status.Error))
+		}
+
+		return cmds.EmitOnce(res, &SdsWalletOutput{
+			Name:    cfg.Sds.WalletName(),
+			Address: status.WalletAddress,
+			Ozone:   status.Ozone,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsWalletOutput) error {
+			_, err := fmt.Fprintln(w, out.Ozone)
+			return err
+		}),
+	},
+	Type: SdsWalletOutput{},
+}
+
+var sdsWalletRotateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Replace the SDS wallet by a new one.",
+		ShortDescription: `
+Generates a new wallet key under the name of Sds.Wallet, 'sds-wallet' when it
+is unset. The previous wallet
+key is kept in the keystore under the name given by --oldkey, the ozone left
+on it could still be spent by pointing Sds.Wallet to it. Its address is added
+to Sds.TrustedWallets, so the mapping files it signed are still followed.
+The daemon must not be running when calling this command.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(sdsOldKeyOptionName, "o", "Keystore name to use for backing up the current wallet key."),
+	},
+	NoRemote: true,
+	PreRun:   DaemonNotRunning,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		oldKey, ok := req.Options[sdsOldKeyOptionName].(string)
+		if !ok {
+			return fmt.Errorf("keystore name for backing up the current wallet key must be provided")
+		}
+		if oldKey == "self" {
+			return fmt.Errorf("keystore name for back up cannot be named 'self'")
+		}
+
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+		r, err := fsrepo.Open(cfgRoot)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		cfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+		if typ := cfg.Sds.Signer.Type.WithDefault(config.DefaultSdsSignerType); typ != config.SdsSignerKeystore {
+			return fmt.Errorf("the sds wallet is held by the %q signer, rotate it there", typ)
+		}
+		name := cfg.Sds.WalletName()
+		if name == "" {
+			return fmt.Errorf("no sds wallet to rotate, the deprecated Sds.PrivateKey is used")
+		}
+
+		ks := r.Keystore()
+		current, err := ks.Get(name)
+		if err != nil {
+			return fmt.Errorf("loading sds wallet %q from the keystore: %w", name, err)
+		}
+		if has, err := ks.Has(oldKey); err != nil || has {
+			if err == nil {
+				err = fmt.Errorf("key with name '%s' already exists", oldKey)
+			}
+			return err
+		}
+
+		previous, err := sds.NewSdsWalletFromKey(current)
+		if err != nil {
+			return err
+		}
+		previousAddress, err := previous.GetAddress(req.Context)
+		if err != nil {
+			return err
+		}
+		sk, _, err := crypto.GenerateSecp256k1Key(rand.Reader)
+		if err != nil {
+			return err
+		}
+		wallet, err := sds.NewSdsWalletFromKey(sk)
+		if err != nil {
+			return err
+		}
+		address, err := wallet.GetAddress(req.Context)
+		if err != nil {
+			return err
+		}
+
+		if err := ks.Put(oldKey, current); err != nil {
+			return fmt.Errorf("saving current wallet key in keystore (%w)", err)
+		}
+		if err := ks.Delete(name); err != nil {
+			return err
+		}
+		if err := ks.Put(name, sk); err != nil {
+			return err
+		}
+
+		// the mapping files signed by the previous wallet are still followed
+		if !slices.Contains(cfg.Sds.TrustedWallets, previousAddress) {
+			cfg.Sds.TrustedWallets = append(cfg.Sds.TrustedWallets, previousAddress)
+			if err := r.SetConfig(cfg); err != nil {
+				return fmt.Errorf("trusting the previous wallet: %w", err)
+			}
+		}
+
+		return cmds.EmitOnce(res, &SdsWalletOutput{
+			Name:    name,
+			Address: address,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *SdsWalletOutput) error {
+			_, err := fmt.Fprintln(w, out.Address)
+			return err
+		}),
+	},
+	Type: SdsWalletOutput{},
 }
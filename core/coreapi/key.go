@@ -95,6 +95,14 @@ func (api *KeyAPI) Generate(ctx context.Context, name string, opts ...caopts.Key
 			return nil, err
 		}
 
+		sk = priv
+		pk = pub
+	case caopts.SdsSecp256k1Key:
+		priv, pub, err := crypto.GenerateSecp256k1Key(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
 		sk = priv
 		pk = pub
 	default:
@@ -2,81 +2,589 @@ package coreapi
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	gopath "path"
+	"strings"
 
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/path"
 	cid "github.com/ipfs/go-cid"
+	coreiface "github.com/ipfs/kubo/core/coreiface"
 	options "github.com/ipfs/kubo/core/coreiface/options"
 	"github.com/ipfs/kubo/sds"
 	fwtypes "github.com/stratosnet/sds/framework/types"
 )
 
+// maxSdsLinkSize caps the amount of data read when resolving a mapping file
+const maxSdsLinkSize = 1024 * 1024
+
+var errSdsNotEnabled = errors.New("sds is not enabled, set Sds.Enabled in the config")
+
 type SdsAPI CoreAPI
 
+func (api *SdsAPI) fetcher() (*sds.Fetcher, error) {
+	if api.sdsFetcher == nil {
+		return nil, errSdsNotEnabled
+	}
+	return api.sdsFetcher, nil
+}
+
 // Link a path with sds as share link
-func (api *SdsAPI) Link(ctx context.Context, cid cid.Cid, fileHash string, opts ...options.UnixfsAddOption) (files.File, error) {
-	mapFile, err := sds.NewSdsFile(cid, fileHash)
+func (api *SdsAPI) Link(ctx context.Context, cid cid.Cid, fileHash string, opts ...options.SdsShareOption) (files.File, error) {
+	settings, err := options.SdsShareOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
-	if _, err = api.sdsFetcher.CreateShareLink(fileHash, cid.String()); err != nil {
+	if settings.Private {
+		return nil, fmt.Errorf("the share link of a mapping file cannot have a password")
+	}
+	fetcher, err := api.fetcher()
+	if err != nil {
 		return nil, err
 	}
-	f, ok := mapFile.(files.File)
-	if !ok {
-		return nil, fmt.Errorf("not a file")
+	share, err := api.Share(ctx, fileHash, cid, opts...)
+	if err != nil {
+		return nil, err
+	}
+	carSize, err := sds.NewDagParser(ctx, api.dag, nil, nil).CARSize(cid)
+	if err != nil {
+		return nil, err
+	}
+	return fetcher.NewLink(ctx, sds.Link{
+		Cid:       cid,
+		FileHash:  fileHash,
+		CarSize:   carSize,
+		ShareLink: share.ShareLink,
+	})
+}
+
+// Share creates a share link for an uploaded sds file holding the DAG of the
+// cid. When the file hash is empty it is computed from the exported DAG.
+func (api *SdsAPI) Share(ctx context.Context, fileHash string, cid cid.Cid, opts ...options.SdsShareOption) (coreiface.SdsShare, error) {
+	settings, err := options.SdsShareOptions(opts...)
+	if err != nil {
+		return coreiface.SdsShare{}, err
+	}
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return coreiface.SdsShare{}, err
+	}
+
+	if fileHash == "" {
+		f, err := sds.NewDagParser(ctx, api.dag, nil, nil).Export(cid, fetcher.Cache().SpoolDir())
+		if err != nil {
+			return coreiface.SdsShare{}, err
+		}
+		fileHash, err = sds.CreateFileHashFromReader(f)
+		f.Close()
+		if err != nil {
+			return coreiface.SdsShare{}, err
+		}
+	}
+
+	share, err := fetcher.CreateShareLink(ctx, fileHash, cid.String(), sds.ShareOptions{
+		Duration: settings.Expire,
+		Private:  settings.Private,
+	})
+	if err != nil {
+		return coreiface.SdsShare{}, err
+	}
+	return toSdsShare(nil, share), nil
+}
+
+// Shares lists the share links of the wallet known by the pp, or the ones
+// recorded in the repo
+func (api *SdsAPI) Shares(ctx context.Context, opts ...options.SdsSharesOption) ([]coreiface.SdsShare, error) {
+	settings, err := options.SdsSharesOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return nil, err
+	}
+
+	recorded, err := fetcher.Shares().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Recorded {
+		shares := make([]coreiface.SdsShare, 0, len(recorded))
+		for _, share := range recorded {
+			shares = append(shares, toSdsShare(nil, share))
+		}
+		return shares, nil
+	}
+
+	byId := make(map[string]*sds.Share, len(recorded))
+	for _, share := range recorded {
+		byId[share.ShareId] = share
+	}
+	shared, err := fetcher.ListShareLinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]coreiface.SdsShare, 0, len(shared))
+	for _, f := range shared {
+		shares = append(shares, toSdsShare(f, byId[f.ShareId]))
+	}
+	return shares, nil
+}
+
+// toSdsShare merges a share listed by the pp and its record in the repo,
+// either could be nil
+func toSdsShare(f *sds.SharedFile, share *sds.Share) coreiface.SdsShare {
+	var out coreiface.SdsShare
+	if share != nil {
+		out = coreiface.SdsShare{
+			ShareId:   share.ShareId,
+			ShareLink: share.ShareLink,
+			Password:  share.Password,
+			Private:   share.Private,
+			FileHash:  share.FileHash,
+			Created:   share.Created,
+			Expires:   share.Expires,
+			Revoked:   share.Revoked,
+			Recorded:  true,
+		}
+		if c, err := cid.Decode(share.Cid); err == nil {
+			out.Cid = c
+		}
+	}
+	if f != nil {
+		out.ShareId = f.ShareId
+		out.ShareLink = f.ShareLink
+		out.FileHash = f.FileHash
+		out.FileName = f.FileName
+		out.FileSize = f.FileSize
+		if !f.Created.IsZero() {
+			out.Created = f.Created
+		}
+		if !f.Expires.IsZero() {
+			out.Expires = f.Expires
+		}
+	}
+	return out
+}
+
+// Unshare revokes the share link of the share id
+func (api *SdsAPI) Unshare(ctx context.Context, shareId string) error {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return err
 	}
-	return f, nil
+	return fetcher.StopShareLink(ctx, shareId)
 }
 
-// Add imports the data from the reader into sds store chunks
-func (api *SdsAPI) Upload(ctx context.Context, file_ files.File, opts ...options.UnixfsAddOption) (string, error) {
-	fileData, err := io.ReadAll(file_)
+// Upload exports the DAG under the path as a CAR into sds store chunks
+func (api *SdsAPI) Upload(ctx context.Context, p path.Path, opts ...options.SdsUploadOption) (string, error) {
+	settings, err := options.SdsUploadOptions(opts...)
+	if err != nil {
+		return "", err
+	}
+	fetcher, err := api.fetcher()
 	if err != nil {
 		return "", err
 	}
 
-	return api.sdsFetcher.Upload(fileData)
+	rp, _, err := api.core().ResolvePath(ctx, p)
+	if err != nil {
+		return "", err
+	}
+
+	// the CAR is spooled on disk, chunks are read from there at the offsets
+	// asked by pp
+	f, err := sds.NewDagParser(ctx, api.dag, nil, nil).Export(rp.RootCid(), fetcher.Cache().SpoolDir())
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	size, err := f.Size()
+	if err != nil {
+		return "", err
+	}
+
+	contentType := settings.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(gopath.Ext(settings.FileName))
+	}
+	return fetcher.Upload(ctx, f, size, sds.UploadMeta{
+		Name:        settings.FileName,
+		ContentType: contentType,
+		Cid:         rp.RootCid(),
+	})
 }
 
 func (api *SdsAPI) Parse(ctx context.Context, file_ files.File) (path.ImmutablePath, error) {
-	fsize, err := file_.Size()
+	link, err := readSdsLink(file_)
 	if err != nil {
 		return path.ImmutablePath{}, err
 	}
-
-	fileData := make([]byte, fsize)
-	_, err = io.ReadFull(file_, fileData)
+	trust, err := api.linkTrust()
 	if err != nil {
 		return path.ImmutablePath{}, err
 	}
-
-	originalCid, err := sds.ParseLink(fileData)
-	fmt.Println("Parse originalCid", originalCid)
-	if err != nil {
+	if err := trust.Check(ctx, link); err != nil {
 		return path.ImmutablePath{}, err
 	}
 
-	ip, err := path.NewPath("/ipfs/" + originalCid.String())
+	ip, err := path.NewPath("/ipfs/" + link.Cid.String())
 	if err != nil {
 		return path.ImmutablePath{}, err
 	}
 	return path.NewImmutablePath(ip)
 }
 
-func (api *SdsAPI) Download(ctx context.Context, p path.Path) (files.File, error) {
-	fmt.Println("p.Segments()", p.Segments())
-	fmt.Println("p.Segments()[1]", p.Segments()[1])
-	shareLink := fwtypes.SetShareLink(p.Segments()[1], "")
-	fmt.Println("shareLink", shareLink)
-	fileData, err := api.sdsFetcher.DownloadFromShare(shareLink.String())
+// Resolve reads the mapping file at the path
+func (api *SdsAPI) Resolve(ctx context.Context, p path.Path) (coreiface.SdsLink, error) {
+	node, err := api.core().Unixfs().Get(ctx, p)
+	if err != nil {
+		return coreiface.SdsLink{}, err
+	}
+	defer node.Close()
+
+	f, ok := node.(files.File)
+	if !ok {
+		return coreiface.SdsLink{}, coreiface.ErrNotFile
+	}
+
+	link, err := readSdsLink(f)
+	if err != nil {
+		return coreiface.SdsLink{}, fmt.Errorf("%s is not an sds mapping file: %w", p, err)
+	}
+	return coreiface.SdsLink{
+		Version:   link.Version,
+		Cid:       link.Cid,
+		FileHash:  link.FileHash,
+		CarSize:   link.CarSize,
+		ShareLink: link.ShareLink,
+		Wallet:    link.Wallet,
+	}, nil
+}
+
+// readSdsLink decodes a mapping file, without reading more than a mapping
+// file could weigh
+func readSdsLink(f files.File) (*sds.Link, error) {
+	fileData, err := io.ReadAll(io.LimitReader(f, maxSdsLinkSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(fileData) > maxSdsLinkSize {
+		return nil, fmt.Errorf("file is too big")
+	}
+	return sds.DecodeLink(fileData)
+}
+
+// linkTrust returns the trust of the mapping files Parse follows, the wallet
+// of the node is only trusted when sds is enabled
+func (api *SdsAPI) linkTrust() (*sds.LinkTrust, error) {
+	if api.sdsFetcher != nil {
+		return api.sdsFetcher.LinkTrust(), nil
+	}
+	cfg, err := api.repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	return sds.NewLinkTrust(&cfg.Sds, ""), nil
+}
+
+func (api *SdsAPI) Download(ctx context.Context, link string) (files.File, error) {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return nil, err
+	}
+
+	shareLink, err := parseShareLink(link)
+	if err != nil {
+		return nil, err
+	}
+	return fetcher.DownloadFromShare(ctx, sds.FormatShareLink(shareLink.Link, shareLink.Password))
+}
+
+// parseShareLink accepts a full sds:// share link as well as a bare share id
+// or an ipfs cid shared through sds, followed by the password of a private
+// share
+func parseShareLink(link string) (*fwtypes.ShareDataMeshId, error) {
+	if strings.HasPrefix(link, fwtypes.SHARED_DATA_MESH_PROTOCOL) {
+		return fwtypes.ParseShareLink(link)
+	}
+	link = strings.TrimPrefix(link, "/ipfs/")
+	if strings.Contains(link, "/") {
+		return fwtypes.ParseShareLink(fwtypes.SHARED_DATA_MESH_PROTOCOL + link)
+	}
+	if len(link) == fwtypes.NormalShareLinkLength {
+		return &fwtypes.ShareDataMeshId{Link: link}, nil
+	}
+	return fwtypes.SetShareLink(link, ""), nil
+}
+
+// Status reports the sds configuration and the pp node state
+func (api *SdsAPI) Status(ctx context.Context) (coreiface.SdsStatus, error) {
+	cfg, err := api.repo.Config()
+	if err != nil {
+		return coreiface.SdsStatus{}, err
+	}
+
+	status := coreiface.SdsStatus{
+		Enabled: cfg.Sds.Enabled,
+	}
+	if api.sdsFetcher == nil {
+		for _, u := range cfg.Sds.PPs() {
+			status.PPs = append(status.PPs, coreiface.SdsPP{URL: u})
+		}
+		return status, nil
+	}
+
+	// an unreachable remote signer is reported like an unreachable pp
+	status.WalletAddress, err = api.sdsFetcher.WalletAddress(ctx)
+	if err != nil {
+		status.Error = err.Error()
+	} else if oz, err := api.sdsFetcher.GetOzone(ctx); err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Reachable = true
+		status.Ozone = oz.Ozone
+	}
+
+	spend, err := api.sdsFetcher.Budget().Spend(ctx)
+	if err != nil {
+		return coreiface.SdsStatus{}, err
+	}
+	status.Spent = &coreiface.SdsSpend{
+		Day:        spend.Day,
+		Total:      spend.Total,
+		Daily:      cfg.Sds.Budget.Daily.WithDefault(0),
+		Operations: spend.Operations,
+	}
+
+	for _, pp := range api.sdsFetcher.PPs() {
+		s := coreiface.SdsPP{
+			URL:     pp.URL,
+			Healthy: pp.Healthy,
+			Latency: pp.Latency,
+		}
+		if pp.Error != nil {
+			s.Error = pp.Error.Error()
+		}
+		status.PPs = append(status.PPs, s)
+	}
+	return status, nil
+}
+
+// Uploads lists the saved upload sessions
+func (api *SdsAPI) Uploads(ctx context.Context) ([]coreiface.SdsUpload, error) {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := fetcher.Uploads(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make([]coreiface.SdsUpload, 0, len(sessions))
+	for _, session := range sessions {
+		upload := coreiface.SdsUpload{
+			FileHash:    session.FileHash,
+			Name:        session.Name,
+			ContentType: session.ContentType,
+			Size:        session.Size,
+			OffsetStart: session.OffsetStart,
+			OffsetEnd:   session.OffsetEnd,
+			Status:      string(session.Status),
+			Error:       session.Error,
+			Started:     session.Started,
+			Updated:     session.Updated,
+		}
+		if session.Cid != "" {
+			upload.Cid, err = cid.Decode(session.Cid)
+			if err != nil {
+				return nil, err
+			}
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// Enqueue queues the upload of the DAG under the path for the daemon
+func (api *SdsAPI) Enqueue(ctx context.Context, p path.Path, opts ...options.SdsEnqueueOption) (coreiface.SdsQueued, error) {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return coreiface.SdsQueued{}, err
+	}
+
+	settings, err := options.SdsEnqueueOptions(opts...)
+	if err != nil {
+		return coreiface.SdsQueued{}, err
+	}
+
+	rp, _, err := api.core().ResolvePath(ctx, p)
+	if err != nil {
+		return coreiface.SdsQueued{}, err
+	}
+
+	u, err := fetcher.Queue().Enqueue(ctx, rp.RootCid(), settings.Name)
+	if err != nil {
+		return coreiface.SdsQueued{}, err
+	}
+	return toSdsQueued(u)
+}
+
+// Queue lists the queued uploads
+func (api *SdsAPI) Queue(ctx context.Context) ([]coreiface.SdsQueued, error) {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return nil, err
+	}
+
+	uploads, err := fetcher.Queue().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]coreiface.SdsQueued, 0, len(uploads))
+	for _, u := range uploads {
+		q, err := toSdsQueued(u)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, nil
+}
+
+func (api *SdsAPI) QueueRetry(ctx context.Context, c cid.Cid) (coreiface.SdsQueued, error) {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return coreiface.SdsQueued{}, err
+	}
+
+	u, err := fetcher.Queue().Retry(ctx, c)
+	if err != nil {
+		return coreiface.SdsQueued{}, err
+	}
+	return toSdsQueued(u)
+}
+
+func (api *SdsAPI) QueueCancel(ctx context.Context, c cid.Cid) error {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return err
+	}
+	return fetcher.Queue().Cancel(ctx, c)
+}
+
+func toSdsQueued(u *sds.QueuedUpload) (coreiface.SdsQueued, error) {
+	c, err := cid.Decode(u.Cid)
+	if err != nil {
+		return coreiface.SdsQueued{}, err
+	}
+	q := coreiface.SdsQueued{
+		Cid:         c,
+		Name:        u.Name,
+		Status:      string(u.Status),
+		Attempts:    u.Attempts,
+		NextAttempt: u.NextAttempt,
+		FileHash:    u.FileHash,
+		Error:       u.Error,
+		Added:       u.Added,
+		Updated:     u.Updated,
+	}
+	if u.Link != "" {
+		q.Link, err = cid.Decode(u.Link)
+		if err != nil {
+			return coreiface.SdsQueued{}, err
+		}
+	}
+	return q, nil
+}
+
+// SdsQueueUploader uploads the DAGs queued by the async adds the way ipfs add
+// does in sync mode: the DAG is uploaded, shared, and its mapping file is
+// added and pinned
+func SdsQueueUploader(api coreiface.CoreAPI) sds.QueueUploader {
+	return func(ctx context.Context, c cid.Cid, name string) (string, cid.Cid, error) {
+		fileHash, err := api.Sds().Upload(ctx, path.FromCid(c), options.Sds.FileName(name))
+		if err != nil {
+			return "", cid.Undef, err
+		}
+
+		mapFile, err := api.Sds().Link(ctx, c, fileHash)
+		if err != nil {
+			return "", cid.Undef, err
+		}
+		defer mapFile.Close()
+
+		p, err := api.Unixfs().Add(ctx, mapFile, options.Unixfs.Pin(true))
+		if err != nil {
+			return "", cid.Undef, err
+		}
+		return fileHash, p.RootCid(), nil
+	}
+}
+
+func (api *SdsAPI) CacheStat(ctx context.Context) (coreiface.SdsCacheStat, error) {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return coreiface.SdsCacheStat{}, err
+	}
+
+	stat := fetcher.Cache().Stat()
+	return coreiface.SdsCacheStat{
+		Dir:     stat.Dir,
+		Size:    stat.Size,
+		MaxSize: stat.MaxSize,
+		Files:   stat.Files,
+	}, nil
+}
+
+func (api *SdsAPI) CacheList(ctx context.Context) ([]coreiface.SdsCacheEntry, error) {
+	fetcher, err := api.fetcher()
 	if err != nil {
 		return nil, err
 	}
+	return toSdsCacheEntries(fetcher.Cache().List()), nil
+}
 
-	rfc := files.NewBytesFile(fileData)
+func (api *SdsAPI) CacheClear(ctx context.Context) error {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return err
+	}
+	return fetcher.Cache().Clear()
+}
+
+func (api *SdsAPI) CacheVerify(ctx context.Context) ([]coreiface.SdsCacheEntry, error) {
+	fetcher, err := api.fetcher()
+	if err != nil {
+		return nil, err
+	}
+
+	corrupted, err := fetcher.Cache().Verify()
+	if err != nil {
+		return nil, err
+	}
+	return toSdsCacheEntries(corrupted), nil
+}
+
+func toSdsCacheEntries(entries []sds.CacheEntry) []coreiface.SdsCacheEntry {
+	out := make([]coreiface.SdsCacheEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, coreiface.SdsCacheEntry{
+			FileHash: e.FileHash,
+			Size:     e.Size,
+			LastUsed: e.LastUsed,
+		})
+	}
+	return out
+}
 
-	return rfc, nil
+func (api *SdsAPI) core() *CoreAPI {
+	return (*CoreAPI)(api)
 }
@@ -16,11 +16,13 @@ import (
 	mock "github.com/ipfs/kubo/core/mock"
 	"github.com/ipfs/kubo/core/node/libp2p"
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/sds"
 
 	"github.com/ipfs/go-datastore"
 	syncds "github.com/ipfs/go-datastore/sync"
 	"github.com/ipfs/kubo/config"
 	coreiface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
 	"github.com/ipfs/kubo/core/coreiface/tests"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -31,7 +33,19 @@ const testPeerID = "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe"
 
 type NodeProvider struct{}
 
-func (NodeProvider) MakeAPISwarm(t *testing.T, ctx context.Context, fullIdentity bool, online bool, n int) ([]coreiface.CoreAPI, error) {
+func (np NodeProvider) MakeAPISwarm(t *testing.T, ctx context.Context, fullIdentity bool, online bool, n int) ([]coreiface.CoreAPI, error) {
+	return np.makeAPISwarm(t, ctx, fullIdentity, online, n, config.Sds{})
+}
+
+func (np NodeProvider) MakeSdsAPI(t *testing.T, ctx context.Context, ppURL string) (coreiface.CoreAPI, error) {
+	apis, err := np.makeAPISwarm(t, ctx, false, false, 1, config.Sds{Enabled: true, RpcURLs: []string{ppURL}})
+	if err != nil {
+		return nil, err
+	}
+	return apis[0], nil
+}
+
+func (NodeProvider) makeAPISwarm(t *testing.T, ctx context.Context, fullIdentity bool, online bool, n int, sdsCfg config.Sds) ([]coreiface.CoreAPI, error) {
 	mn := mocknet.New()
 
 	nodes := make([]*core.IpfsNode, n)
@@ -69,6 +83,7 @@ func (NodeProvider) MakeAPISwarm(t *testing.T, ctx context.Context, fullIdentity
 		c.Addresses.Swarm = []string{fmt.Sprintf("/ip4/18.0.%d.1/tcp/4001", i)}
 		c.Identity = ident
 		c.Experimental.FilestoreEnabled = true
+		c.Sds = sdsCfg
 
 		ds := syncds.MutexWrap(datastore.NewMapDatastore())
 		r := &repo.Mock{
@@ -91,7 +106,17 @@ func (NodeProvider) MakeAPISwarm(t *testing.T, ctx context.Context, fullIdentity
 			return nil, err
 		}
 		nodes[i] = node
-		apis[i], err = coreapi.NewCoreAPI(node)
+
+		var opts []options.ApiOption
+		if c.Sds.Enabled {
+			fetcher, err := sds.NewFetcher(&c.Sds, t.TempDir(), r.D, r.K)
+			if err != nil {
+				return nil, err
+			}
+			t.Cleanup(fetcher.Close)
+			opts = append(opts, options.Api.SdsFetcher(fetcher))
+		}
+		apis[i], err = coreapi.NewCoreAPI(node, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	cmdsHttp "github.com/ipfs/go-ipfs-cmds/http"
@@ -16,6 +17,7 @@ import (
 	config "github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core"
 	corecommands "github.com/ipfs/kubo/core/commands"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
@@ -139,7 +141,7 @@ func commandsOption(cctx oldcmds.Context, command *cmds.Command) ServeOption {
 		addCORSDefaults(cfg)
 		patchCORSVars(cfg, l.Addr())
 
-		cmdHandler := cmdsHttp.NewHandler(&cctx, command, cfg)
+		cmdHandler := withErrorStatus(cmdsHttp.NewHandler(&cctx, command, cfg))
 
 		if len(rcfg.API.Authorizations) > 0 {
 			authorizations := convertAuthorizationsMap(rcfg.API.Authorizations)
@@ -152,6 +154,37 @@ func commandsOption(cctx oldcmds.Context, command *cmds.Command) ServeOption {
 	}
 }
 
+// errorStatusWriter replaces the status of an error response with the one
+// set by the command
+type errorStatusWriter struct {
+	http.ResponseWriter
+	status *atomic.Int32
+}
+
+func (w *errorStatusWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest {
+		if status := w.status.Load(); status != 0 {
+			code = int(status)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorStatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withErrorStatus lets the commands answer their errors with a status other
+// than 500, see cmdenv.SetErrorStatus
+func withErrorStatus(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, status := cmdenv.WithErrorStatus(r.Context())
+		next.ServeHTTP(&errorStatusWriter{ResponseWriter: w, status: status}, r.WithContext(ctx))
+	})
+}
+
 type rpcAuthScopeWithUser struct {
 	config.RPCAuthScope
 	User string
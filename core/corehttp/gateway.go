@@ -7,6 +7,8 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/ipfs/boxo/blockservice"
@@ -18,6 +20,7 @@ import (
 	offlineroute "github.com/ipfs/boxo/routing/offline"
 	"github.com/ipfs/go-cid"
 	version "github.com/ipfs/kubo"
+	oldcmds "github.com/ipfs/kubo/commands"
 	"github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core"
 	iface "github.com/ipfs/kubo/core/coreiface"
@@ -27,38 +30,57 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-func GatewayOption(paths ...string) ServeOption {
+// GatewayOption serves the gateway on the paths, the content missing in ipfs
+// is downloaded from sds by the fetcher of cctx when sds is enabled
+func GatewayOption(cctx *oldcmds.Context, paths ...string) ServeOption {
 	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
 		config, headers, err := getGatewayConfig(n)
 		if err != nil {
 			return nil, err
 		}
 
-		backend, err := newGatewayBackend(n)
+		backend, sdsBackend, err := newGatewayBackend(n, cctx)
 		if err != nil {
 			return nil, err
 		}
 
-		handler := gateway.NewHandler(config, backend)
-		handler = gateway.NewHeaders(headers).ApplyCors().Wrap(handler)
-		handler = otelhttp.NewHandler(handler, "Gateway")
+		clients, err := getGatewayClients(n)
+		if err != nil {
+			return nil, err
+		}
+
+		ipfsHandler := gateway.NewHandler(config, backend)
+		handler := wrapGatewayHandler(ipfsHandler, headers, clients, "Gateway")
 
 		for _, p := range paths {
 			mux.Handle(p+"/", handler)
 		}
 
+		// the sds namespace serves any content, like /ipfs/
+		if sdsBackend != nil && slices.Contains(paths, "/ipfs") {
+			sdsHandler := &sdsGatewayHandler{backend: sdsBackend, ipfs: ipfsHandler, config: config}
+			mux.Handle(sdsGatewayPrefix, wrapGatewayHandler(sdsHandler, headers, clients, "SdsGateway"))
+		}
+
 		return mux, nil
 	}
 }
 
-func HostnameOption() ServeOption {
+// HostnameOption serves the subdomain and DNSLink gateways, like
+// GatewayOption
+func HostnameOption(cctx *oldcmds.Context) ServeOption {
 	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
 		config, headers, err := getGatewayConfig(n)
 		if err != nil {
 			return nil, err
 		}
 
-		backend, err := newGatewayBackend(n)
+		backend, sdsBackend, err := newGatewayBackend(n, cctx)
+		if err != nil {
+			return nil, err
+		}
+
+		clients, err := getGatewayClients(n)
 		if err != nil {
 			return nil, err
 		}
@@ -67,14 +89,67 @@ func HostnameOption() ServeOption {
 
 		var handler http.Handler
 		handler = gateway.NewHostnameHandler(config, backend, childMux)
-		handler = gateway.NewHeaders(headers).ApplyCors().Wrap(handler)
-		handler = otelhttp.NewHandler(handler, "HostnameGateway")
+		if sdsBackend != nil {
+			handler = withSdsSubdomains(config.PublicGateways, childMux, handler)
+		}
+		handler = wrapGatewayHandler(handler, headers, clients, "HostnameGateway")
 
 		mux.Handle("/", handler)
 		return childMux, nil
 	}
 }
 
+// wrapGatewayHandler adds the headers, the sds client and the tracing of the
+// gateway requests
+func wrapGatewayHandler(handler http.Handler, headers map[string][]string, clients *gatewayClients, operation string) http.Handler {
+	handler = gateway.NewHeaders(headers).ApplyCors().Wrap(handler)
+	handler = withSdsClient(clients, handler)
+	return otelhttp.NewHandler(handler, operation)
+}
+
+// gatewayClients tells the clients of the gateway requests apart for the sds
+// fallback
+type gatewayClients struct {
+	// authorizations are the API.Authorizations, their tokens allow the sds
+	// fallback when Gateway.SdsFallback is "authenticated"
+	authorizations map[string]rpcAuthScopeWithUser
+	proxies        sds.TrustedProxies
+}
+
+func getGatewayClients(n *core.IpfsNode) (*gatewayClients, error) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := sds.NewTrustedProxies(&cfg.Sds.Fallback)
+	if err != nil {
+		return nil, err
+	}
+	return &gatewayClients{
+		authorizations: convertAuthorizationsMap(cfg.API.Authorizations),
+		proxies:        proxies,
+	}, nil
+}
+
+// withSdsClient tags the requests with the address of their client, the
+// ozone spent by the sds downloads is budgeted per client. The requests with
+// an authorization token allowed their path are authorized the sds fallback.
+func withSdsClient(clients *gatewayClients, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := sds.WithClient(r.Context(), clients.proxies.Client(r))
+
+		if auth, ok := clients.authorizations[r.Header.Get("Authorization")]; ok {
+			for _, prefix := range auth.AllowedPaths {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					ctx = sds.WithAuthorized(ctx)
+					break
+				}
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func VersionOption() ServeOption {
 	return func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
 		mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
@@ -114,10 +189,13 @@ func Libp2pGatewayOption() ServeOption {
 	}
 }
 
-func newGatewayBackend(n *core.IpfsNode) (gateway.IPFSBackend, error) {
+// newGatewayBackend returns the backend of the gateway, with the sds
+// backend it wraps when sds is enabled. The sds downloads are made by the
+// fetcher of cctx, shared by the gateways of the node with their limits.
+func newGatewayBackend(n *core.IpfsNode, cctx *oldcmds.Context) (gateway.IPFSBackend, *sds.SdsBlocksBackend, error) {
 	cfg, err := n.Repo.Config()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	bserv := n.Blocks
@@ -133,7 +211,7 @@ func newGatewayBackend(n *core.IpfsNode) (gateway.IPFSBackend, error) {
 			cs = node.DefaultIpnsCacheSize
 		}
 		if cs < 0 {
-			return nil, fmt.Errorf("cannot specify negative resolve cache size")
+			return nil, nil, fmt.Errorf("cannot specify negative resolve cache size")
 		}
 
 		nsOptions := []namesys.Option{
@@ -146,7 +224,7 @@ func newGatewayBackend(n *core.IpfsNode) (gateway.IPFSBackend, error) {
 		vsRouting = offlineroute.NewOfflineRouter(n.Repo.Datastore(), n.RecordValidator)
 		nsys, err = namesys.NewNameSystem(vsRouting, nsOptions...)
 		if err != nil {
-			return nil, fmt.Errorf("error constructing namesys: %w", err)
+			return nil, nil, fmt.Errorf("error constructing namesys: %w", err)
 		}
 
 		// Gateway.NoFetch=true requires offline path resolver
@@ -160,15 +238,27 @@ func newGatewayBackend(n *core.IpfsNode) (gateway.IPFSBackend, error) {
 		gateway.WithResolver(pathResolver),
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// sds
-	sdsBackend, err := sds.NewSdsBlockBackend(backend, &cfg.Sds, n.DAG, n.Blockstore, n.Pinning)
+	fetcher, err := cctx.SdsFetcher()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	sdsBackend, err := sds.NewSdsBlockBackend(backend, &cfg.Sds, cfg.Gateway.SdsFallback.WithDefault(config.DefaultSdsFallback), fetcher, n.DAG, n.Blockstore, n.Pinning)
+	if err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		<-n.Context().Done()
+		sdsBackend.Close()
+	}()
+	wrapped := &offlineGatewayErrWrapper{gwimpl: sdsBackend}
+	if !cfg.Sds.Enabled {
+		return wrapped, nil, nil
 	}
-	return &offlineGatewayErrWrapper{gwimpl: sdsBackend}, nil
+	return wrapped, sdsBackend, nil
 }
 
 type offlineGatewayErrWrapper struct {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/ipfs/boxo/namesys"
 	version "github.com/ipfs/kubo"
+	oldcmds "github.com/ipfs/kubo/commands"
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
 	"github.com/ipfs/kubo/repo"
@@ -126,10 +127,11 @@ func newTestServerAndNode(t *testing.T, ns mockNamesys) (*httptest.Server, iface
 	ts := httptest.NewServer(dh)
 	t.Cleanup(func() { ts.Close() })
 
+	cctx := &oldcmds.Context{ConstructNode: func() (*core.IpfsNode, error) { return n, nil }}
 	dh.Handler, err = MakeHandler(n,
 		ts.Listener,
-		HostnameOption(),
-		GatewayOption("/ipfs", "/ipns"),
+		HostnameOption(cctx),
+		GatewayOption(cctx, "/ipfs", "/ipns"),
 		VersionOption(),
 	)
 	if err != nil {
@@ -0,0 +1,187 @@
+package corehttp
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/gateway"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/sds"
+	mc "github.com/multiformats/go-multicodec"
+	mh "github.com/multiformats/go-multihash"
+	fwtypes "github.com/stratosnet/sds/framework/types"
+)
+
+// sdsGatewayPrefix is the path prefix of the sds gateway namespace
+const sdsGatewayPrefix = "/" + sds.Namespace + "/"
+
+// sdsSharePasswordHeader is the request header of the password of a private
+// share link, which could also be given by the password query parameter
+const sdsSharePasswordHeader = "Sds-Share-Password"
+
+// sdsGatewayHandler serves the sds namespace, /sds/<share link>/sub/path and
+// /sds/<wallet>/<file hash>/sub/path. The DAG of a CAR file is served by the
+// ipfs gateway handler, like the /ipfs/ path of its root, other files are
+// served as they are. The DAG of a private share is served from its CAR by a
+// gateway handler of its own, it is not imported.
+type sdsGatewayHandler struct {
+	backend *sds.SdsBlocksBackend
+	// ipfs is the gateway handler of the /ipfs/ paths
+	ipfs http.Handler
+	// config is the config of the gateway handlers of the private DAGs
+	config gateway.Config
+}
+
+func (h *sdsGatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gp, err := sds.ParseGatewayPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if gp.ShareLink != "" {
+		gp.Password = r.Header.Get(sdsSharePasswordHeader)
+		if gp.Password == "" {
+			gp.Password = r.URL.Query().Get("password")
+		}
+	}
+
+	p, file, err := h.backend.Open(r.Context(), gp)
+	if err != nil {
+		status := sds.ErrorStatus(err)
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// the request URI is kept, the redirects and the links of the directory
+	// listings stay in the sds namespace
+	ipfsRequest := func() *http.Request {
+		r := r.Clone(r.Context())
+		r.URL.Path = p.String()
+		r.URL.RawPath = ""
+		return r
+	}
+
+	if file != nil && p.RootCid().Defined() {
+		defer file.Close()
+		backend, err := sds.CARBackend(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		gateway.NewHandler(h.config, backend).ServeHTTP(w, ipfsRequest())
+		return
+	}
+
+	if file != nil {
+		defer file.Close()
+		if gp.Rest != "" && gp.Rest != "/" {
+			http.Error(w, "the sds file is not a directory", http.StatusNotFound)
+			return
+		}
+		rs, ok := file.(io.ReadSeeker)
+		if !ok {
+			http.Error(w, "the sds file is not seekable", http.StatusInternalServerError)
+			return
+		}
+		// the content type is sniffed from the first bytes of the file
+		http.ServeContent(w, r, "", time.Time{}, rs)
+		return
+	}
+
+	h.ipfs.ServeHTTP(w, ipfsRequest())
+}
+
+// withSdsSubdomains serves the sds namespace on the subdomains of the
+// gateways using subdomains, <share>.sds.example.com is served by childMux
+// as /sds/<share>. The /sds/ paths of these gateways are redirected to their
+// subdomain.
+func withSdsSubdomains(gateways map[string]*gateway.PublicGateway, childMux http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		for gwHost, gw := range gateways {
+			if gw == nil || !gw.UseSubdomains {
+				continue
+			}
+
+			if host == gwHost {
+				rest, ok := strings.CutPrefix(r.URL.Path, sdsGatewayPrefix)
+				if !ok {
+					break
+				}
+				link, rest, _ := strings.Cut(rest, "/")
+				label, ok := sdsSubdomainLabel(link)
+				if !ok {
+					break
+				}
+				u := *r.URL
+				u.Scheme = "http"
+				if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+					u.Scheme = "https"
+				}
+				u.Host = label + "." + sds.Namespace + "." + r.Host
+				u.Path = "/" + rest
+				u.RawPath = ""
+				http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+				return
+			}
+
+			label, ok := strings.CutSuffix(host, "."+sds.Namespace+"."+gwHost)
+			if !ok || label == "" || strings.Contains(label, ".") {
+				continue
+			}
+			r = r.Clone(r.Context())
+			r.URL.Path = sdsGatewayPrefix + sdsShareLinkFromLabel(label) + r.URL.Path
+			r.URL.RawPath = ""
+			childMux.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sdsSubdomainLabel returns the subdomain label of a share link. The links of
+// the shares of ipfs DAGs are CIDv0, case sensitive, their label is the
+// CIDv1 in base32.
+func sdsSubdomainLabel(link string) (string, bool) {
+	if len(link) == fwtypes.NormalShareLinkLength {
+		return link, link == strings.ToLower(link)
+	}
+	if !fwtypes.CheckIpfsCid(link) {
+		return "", false
+	}
+	c, err := cid.Decode(link)
+	if err != nil {
+		return "", false
+	}
+	return cid.NewCidV1(c.Type(), c.Hash()).String(), true
+}
+
+// sdsShareLinkFromLabel returns the share link of a subdomain label
+func sdsShareLinkFromLabel(label string) string {
+	c, err := cid.Decode(label)
+	if err != nil || c.Version() != 1 || c.Type() != uint64(mc.DagPb) {
+		return label
+	}
+	decoded, err := mh.Decode(c.Hash())
+	if err != nil || decoded.Code != mh.SHA2_256 {
+		return label
+	}
+	return cid.NewCidV0(c.Hash()).String()
+}
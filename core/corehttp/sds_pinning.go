@@ -0,0 +1,442 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	oldcmds "github.com/ipfs/kubo/commands"
+	"github.com/ipfs/kubo/core"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/sds"
+	"github.com/libp2p/go-libp2p/core/host"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// SdsPinningPath is the endpoint of the Pinning Services API backed by sds,
+// the one to give to the pinning clients
+const SdsPinningPath = "/pinning/v1"
+
+const (
+	// sdsPinsDefaultLimit and sdsPinsMaxLimit bound the pins listed at once
+	sdsPinsDefaultLimit = 10
+	sdsPinsMaxLimit     = 1000
+	// sdsPinsMaxCids is the number of cids a listing could be filtered by
+	sdsPinsMaxCids = 10
+	// sdsPinOriginsTimeout bounds the connections to the origins of a pin
+	sdsPinOriginsTimeout = time.Minute
+)
+
+// SdsPinningOption serves the Pinning Services API, the pinned DAGs are
+// uploaded to sds by the upload queue of the daemon. The requests are
+// authorized by the API.Authorizations tokens allowed the SdsPinningPath.
+func SdsPinningOption(cctx *oldcmds.Context) ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		fetcher, err := cctx.SdsFetcher()
+		if err != nil {
+			return nil, err
+		}
+		if fetcher == nil {
+			return nil, errors.New("the sds pinning service needs sds, set Sds.Enabled in the config")
+		}
+		api, err := cctx.GetAPI()
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		h := &sdsPinningHandler{pins: fetcher.Pins(), api: api, node: n}
+		pinsMux := http.NewServeMux()
+		pinsMux.HandleFunc("GET "+SdsPinningPath+"/pins", h.listPins)
+		pinsMux.HandleFunc("POST "+SdsPinningPath+"/pins", h.addPin)
+		pinsMux.HandleFunc("GET "+SdsPinningPath+"/pins/{requestid}", h.getPin)
+		pinsMux.HandleFunc("POST "+SdsPinningPath+"/pins/{requestid}", h.replacePin)
+		pinsMux.HandleFunc("DELETE "+SdsPinningPath+"/pins/{requestid}", h.removePin)
+
+		handler := withSdsPinningAuth(convertAuthorizationsMap(cfg.API.Authorizations), pinsMux)
+		handler = otelhttp.NewHandler(handler, "corehttp.sdsPinningHandler")
+		mux.Handle(SdsPinningPath+"/", handler)
+		return mux, nil
+	}
+}
+
+// withSdsPinningAuth only lets through the requests with the authorization
+// of an API.Authorizations entry allowed the path. Unlike the RPC API, the
+// pinning service is never open: without authorizations every request is
+// denied.
+func withSdsPinningAuth(authorizations map[string]rpcAuthScopeWithUser, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth, ok := authorizations[r.Header.Get("Authorization")]; ok {
+			for _, prefix := range auth.AllowedPaths {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		writeSdsPinningError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized), "provide a token of API.Authorizations allowed "+SdsPinningPath)
+	})
+}
+
+type sdsPinningHandler struct {
+	pins *sds.Pins
+	api  iface.CoreAPI
+	node *core.IpfsNode
+}
+
+// sdsPinJSON is the Pin object of the Pinning Services API
+type sdsPinJSON struct {
+	Cid     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// sdsPinStatusJSON is the PinStatus object of the Pinning Services API
+type sdsPinStatusJSON struct {
+	RequestID string            `json:"requestid"`
+	Status    sds.PinStatus     `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       sdsPinJSON        `json:"pin"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info,omitempty"`
+}
+
+type sdsPinResultsJSON struct {
+	Count   int                 `json:"count"`
+	Results []*sdsPinStatusJSON `json:"results"`
+}
+
+func writeSdsPinningJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("writing pinning service response: %s", err)
+	}
+}
+
+// writeSdsPinningError answers the Failure object of the Pinning Services
+// API
+func writeSdsPinningError(w http.ResponseWriter, status int, reason, details string) {
+	type failure struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details,omitempty"`
+	}
+	writeSdsPinningJSON(w, status, struct {
+		Error failure `json:"error"`
+	}{failure{reason, details}})
+}
+
+// writeSdsPinsError answers an error of the pins, with the status of the
+// sds error it wraps
+func writeSdsPinsError(w http.ResponseWriter, err error) {
+	status := sds.ErrorStatus(err)
+	switch {
+	case errors.Is(err, sds.ErrPinNotFound):
+		status = http.StatusNotFound
+	case status == 0:
+		status = http.StatusInternalServerError
+	}
+	writeSdsPinningError(w, status, http.StatusText(status), err.Error())
+}
+
+// delegates are the addresses of the node, the pinning clients could connect
+// to it to provide the DAG
+func (h *sdsPinningHandler) delegates() []string {
+	delegates := []string{}
+	if h.node.PeerHost == nil {
+		return delegates
+	}
+	addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(h.node.PeerHost))
+	if err != nil {
+		return delegates
+	}
+	for _, a := range addrs {
+		delegates = append(delegates, a.String())
+	}
+	return delegates
+}
+
+func (h *sdsPinningHandler) toPinStatusJSON(s *sds.PinState, delegates []string) *sdsPinStatusJSON {
+	out := &sdsPinStatusJSON{
+		RequestID: s.RequestID,
+		Status:    s.Status,
+		Created:   s.Created,
+		Pin: sdsPinJSON{
+			Cid:     s.Cid,
+			Name:    s.Name,
+			Origins: s.Origins,
+			Meta:    s.Meta,
+		},
+		Delegates: delegates,
+	}
+	switch {
+	case s.Upload == nil:
+		out.Info = map[string]string{"error": "the upload was removed from the sds queue"}
+	case s.Status == sds.PinPinned:
+		out.Info = map[string]string{"filehash": s.Upload.FileHash, "link": s.Upload.Link}
+	case s.Upload.Error != "":
+		out.Info = map[string]string{"error": s.Upload.Error}
+	}
+	return out
+}
+
+// readPin decodes the Pin object of a request
+func readPin(r *http.Request) (*sds.Pin, error) {
+	var in sdsPinJSON
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		return nil, fmt.Errorf("decoding the pin: %w", err)
+	}
+	if _, err := cid.Decode(in.Cid); err != nil {
+		return nil, fmt.Errorf("invalid cid %q: %w", in.Cid, err)
+	}
+	for _, o := range in.Origins {
+		if _, err := ma.NewMultiaddr(o); err != nil {
+			return nil, fmt.Errorf("invalid origin %q: %w", o, err)
+		}
+	}
+	return &sds.Pin{
+		Cid:     in.Cid,
+		Name:    in.Name,
+		Origins: in.Origins,
+		Meta:    in.Meta,
+	}, nil
+}
+
+// connectOrigins connects to the origins of the pin in the background, they
+// are hints of the providers of the DAG the upload exports
+func (h *sdsPinningHandler) connectOrigins(pin *sds.Pin) {
+	if len(pin.Origins) == 0 {
+		return
+	}
+	var addrs []ma.Multiaddr
+	for _, o := range pin.Origins {
+		if a, err := ma.NewMultiaddr(o); err == nil {
+			addrs = append(addrs, a)
+		}
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		log.Debugf("pin %s has invalid origins: %s", pin.RequestID, err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sdsPinOriginsTimeout)
+		defer cancel()
+		for _, info := range infos {
+			if err := h.api.Swarm().Connect(ctx, info); err != nil {
+				log.Debugf("connecting to the origin %s of pin %s: %s", info.ID, pin.RequestID, err)
+			}
+		}
+	}()
+}
+
+func (h *sdsPinningHandler) addPin(w http.ResponseWriter, r *http.Request) {
+	pin, err := readPin(r)
+	if err != nil {
+		writeSdsPinningError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest), err.Error())
+		return
+	}
+
+	s, err := h.pins.Add(r.Context(), pin)
+	if err != nil {
+		writeSdsPinsError(w, err)
+		return
+	}
+	h.connectOrigins(pin)
+	writeSdsPinningJSON(w, http.StatusAccepted, h.toPinStatusJSON(s, h.delegates()))
+}
+
+func (h *sdsPinningHandler) getPin(w http.ResponseWriter, r *http.Request) {
+	s, err := h.pins.Get(r.Context(), r.PathValue("requestid"))
+	if err != nil {
+		writeSdsPinsError(w, err)
+		return
+	}
+	writeSdsPinningJSON(w, http.StatusOK, h.toPinStatusJSON(s, h.delegates()))
+}
+
+func (h *sdsPinningHandler) replacePin(w http.ResponseWriter, r *http.Request) {
+	pin, err := readPin(r)
+	if err != nil {
+		writeSdsPinningError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest), err.Error())
+		return
+	}
+
+	s, err := h.pins.Replace(r.Context(), r.PathValue("requestid"), pin)
+	if err != nil {
+		writeSdsPinsError(w, err)
+		return
+	}
+	h.connectOrigins(pin)
+	writeSdsPinningJSON(w, http.StatusAccepted, h.toPinStatusJSON(s, h.delegates()))
+}
+
+func (h *sdsPinningHandler) removePin(w http.ResponseWriter, r *http.Request) {
+	if err := h.pins.Remove(r.Context(), r.PathValue("requestid")); err != nil {
+		writeSdsPinsError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sdsPinsFilter is the query of a pins listing
+type sdsPinsFilter struct {
+	cids     map[string]bool
+	name     string
+	match    string
+	statuses map[sds.PinStatus]bool
+	before   time.Time
+	after    time.Time
+	meta     map[string]string
+	limit    int
+}
+
+func parseSdsPinsFilter(r *http.Request) (*sdsPinsFilter, error) {
+	q := r.URL.Query()
+	f := &sdsPinsFilter{
+		name:     q.Get("name"),
+		match:    q.Get("match"),
+		statuses: map[sds.PinStatus]bool{},
+		limit:    sdsPinsDefaultLimit,
+	}
+
+	if v := q.Get("cid"); v != "" {
+		cids := strings.Split(v, ",")
+		if len(cids) > sdsPinsMaxCids {
+			return nil, fmt.Errorf("at most %d cids could be listed at once", sdsPinsMaxCids)
+		}
+		f.cids = make(map[string]bool, len(cids))
+		for _, c := range cids {
+			f.cids[c] = true
+		}
+	}
+
+	switch f.match {
+	case "":
+		f.match = "exact"
+	case "exact", "iexact", "partial", "ipartial":
+	default:
+		return nil, fmt.Errorf("invalid match %q", f.match)
+	}
+
+	statuses := []string{string(sds.PinPinned)}
+	if v := q.Get("status"); v != "" {
+		statuses = strings.Split(v, ",")
+	}
+	for _, s := range statuses {
+		switch status := sds.PinStatus(s); status {
+		case sds.PinQueued, sds.PinPinning, sds.PinPinned, sds.PinFailed:
+			f.statuses[status] = true
+		default:
+			return nil, fmt.Errorf("invalid status %q", s)
+		}
+	}
+
+	var err error
+	if v := q.Get("before"); v != "" {
+		if f.before, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid before: %w", err)
+		}
+	}
+	if v := q.Get("after"); v != "" {
+		if f.after, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid after: %w", err)
+		}
+	}
+	if v := q.Get("meta"); v != "" {
+		if err := json.Unmarshal([]byte(v), &f.meta); err != nil {
+			return nil, fmt.Errorf("invalid meta: %w", err)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.limit, err = strconv.Atoi(v); err != nil || f.limit < 1 || f.limit > sdsPinsMaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", sdsPinsMaxLimit)
+		}
+	}
+	return f, nil
+}
+
+func (f *sdsPinsFilter) matches(s *sds.PinState) bool {
+	if f.cids != nil && !f.cids[s.Cid] {
+		return false
+	}
+	if !f.statuses[s.Status] {
+		return false
+	}
+	if f.name != "" {
+		name := s.Name
+		switch f.match {
+		case "exact":
+			if name != f.name {
+				return false
+			}
+		case "iexact":
+			if !strings.EqualFold(name, f.name) {
+				return false
+			}
+		case "partial":
+			if !strings.Contains(name, f.name) {
+				return false
+			}
+		case "ipartial":
+			if !strings.Contains(strings.ToLower(name), strings.ToLower(f.name)) {
+				return false
+			}
+		}
+	}
+	if !f.before.IsZero() && !s.Created.Before(f.before) {
+		return false
+	}
+	if !f.after.IsZero() && !s.Created.After(f.after) {
+		return false
+	}
+	for k, v := range f.meta {
+		if got, ok := s.Meta[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *sdsPinningHandler) listPins(w http.ResponseWriter, r *http.Request) {
+	f, err := parseSdsPinsFilter(r)
+	if err != nil {
+		writeSdsPinningError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest), err.Error())
+		return
+	}
+
+	states, err := h.pins.List(r.Context())
+	if err != nil {
+		writeSdsPinsError(w, err)
+		return
+	}
+
+	// count is the number of matching pins, the results are the most
+	// recent ones up to the limit
+	out := sdsPinResultsJSON{Results: []*sdsPinStatusJSON{}}
+	delegates := h.delegates()
+	for _, s := range states {
+		if !f.matches(s) {
+			continue
+		}
+		out.Count++
+		if len(out.Results) < f.limit {
+			out.Results = append(out.Results, h.toPinStatusJSON(s, delegates))
+		}
+	}
+	writeSdsPinningJSON(w, http.StatusOK, out)
+}
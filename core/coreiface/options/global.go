@@ -1,5 +1,11 @@
 package options
 
+import (
+	"context"
+
+	"github.com/ipfs/boxo/files"
+)
+
 type ApiSettings struct {
 	Offline     bool
 	FetchBlocks bool
@@ -49,8 +55,7 @@ func (apiOpts) FetchBlocks(fetch bool) ApiOption {
 }
 
 type SdsFetcher interface {
-	Download(fileHash string) ([]byte, error)
-	Upload(fileData []byte) (string, error)
+	Download(ctx context.Context, fileHash string) (files.File, error)
 }
 
 // sds
@@ -3,6 +3,8 @@ package options
 const (
 	RSAKey     = "rsa"
 	Ed25519Key = "ed25519"
+	// SdsSecp256k1Key is a secp256k1 key used as an sds wallet
+	SdsSecp256k1Key = "sds-secp256k1"
 
 	DefaultRSALen = 2048
 )
@@ -60,6 +62,7 @@ var Key keyOpts
 // Supported key types:
 // * options.RSAKey
 // * options.Ed25519Key
+// * options.SdsSecp256k1Key
 func (keyOpts) Type(algorithm string) KeyGenerateOption {
 	return func(settings *KeyGenerateSettings) error {
 		settings.Algorithm = algorithm
@@ -0,0 +1,153 @@
+package options
+
+import "time"
+
+// SdsEnqueueSettings represent the settings for SdsAPI.Enqueue
+type SdsEnqueueSettings struct {
+	Name string
+}
+
+// SdsEnqueueOption is the signature of an option for SdsAPI.Enqueue
+type SdsEnqueueOption func(*SdsEnqueueSettings) error
+
+// SdsEnqueueOptions compile a series of SdsEnqueueOption into a ready to use
+// SdsEnqueueSettings and set the default values.
+func SdsEnqueueOptions(opts ...SdsEnqueueOption) (*SdsEnqueueSettings, error) {
+	options := &SdsEnqueueSettings{}
+
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, err
+		}
+	}
+
+	return options, nil
+}
+
+// SdsUploadSettings represent the settings for SdsAPI.Upload
+type SdsUploadSettings struct {
+	FileName    string
+	ContentType string
+}
+
+// SdsUploadOption is the signature of an option for SdsAPI.Upload
+type SdsUploadOption func(*SdsUploadSettings) error
+
+// SdsUploadOptions compile a series of SdsUploadOption into a ready to use
+// SdsUploadSettings and set the default values.
+func SdsUploadOptions(opts ...SdsUploadOption) (*SdsUploadSettings, error) {
+	options := &SdsUploadSettings{}
+
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, err
+		}
+	}
+
+	return options, nil
+}
+
+// SdsShareSettings represent the settings for SdsAPI.Share
+type SdsShareSettings struct {
+	Expire  time.Duration
+	Private bool
+}
+
+// SdsShareOption is the signature of an option for SdsAPI.Share
+type SdsShareOption func(*SdsShareSettings) error
+
+// SdsShareOptions compile a series of SdsShareOption into a ready to use
+// SdsShareSettings and set the default values.
+func SdsShareOptions(opts ...SdsShareOption) (*SdsShareSettings, error) {
+	options := &SdsShareSettings{}
+
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, err
+		}
+	}
+
+	return options, nil
+}
+
+// SdsSharesSettings represent the settings for SdsAPI.Shares
+type SdsSharesSettings struct {
+	Recorded bool
+}
+
+// SdsSharesOption is the signature of an option for SdsAPI.Shares
+type SdsSharesOption func(*SdsSharesSettings) error
+
+// SdsSharesOptions compile a series of SdsSharesOption into a ready to use
+// SdsSharesSettings and set the default values.
+func SdsSharesOptions(opts ...SdsSharesOption) (*SdsSharesSettings, error) {
+	options := &SdsSharesSettings{}
+
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, err
+		}
+	}
+
+	return options, nil
+}
+
+type sdsOpts struct{}
+
+// Sds provide an access to all the options for the Sds API.
+var Sds sdsOpts
+
+// Name is an option for Sds.Enqueue which names the queued upload, the name
+// of the remote pin when the upload is queued by 'pin remote add'. An empty
+// name keeps the name of an upload already queued.
+func (sdsOpts) Name(name string) SdsEnqueueOption {
+	return func(settings *SdsEnqueueSettings) error {
+		settings.Name = name
+		return nil
+	}
+}
+
+// FileName is an option for Sds.Upload which sets the name the file is
+// stored under in sds. The root cid of the DAG is used when it is empty.
+func (sdsOpts) FileName(name string) SdsUploadOption {
+	return func(settings *SdsUploadSettings) error {
+		settings.FileName = name
+		return nil
+	}
+}
+
+// ContentType is an option for Sds.Upload which sets the content type of the
+// uploaded DAG, detected from the extension of the file name when empty
+func (sdsOpts) ContentType(contentType string) SdsUploadOption {
+	return func(settings *SdsUploadSettings) error {
+		settings.ContentType = contentType
+		return nil
+	}
+}
+
+// Expire is an option for Sds.Share which sets how long the share link is
+// valid, zero for the default duration of sds
+func (sdsOpts) Expire(d time.Duration) SdsShareOption {
+	return func(settings *SdsShareSettings) error {
+		settings.Expire = d
+		return nil
+	}
+}
+
+// Private is an option for Sds.Share which protects the share link with a
+// password generated by sds
+func (sdsOpts) Private(private bool) SdsShareOption {
+	return func(settings *SdsShareSettings) error {
+		settings.Private = private
+		return nil
+	}
+}
+
+// Recorded is an option for Sds.Shares which lists the shares recorded in the
+// repo only, including the revoked and expired ones, without asking sds
+func (sdsOpts) Recorded(recorded bool) SdsSharesOption {
+	return func(settings *SdsSharesSettings) error {
+		settings.Recorded = recorded
+		return nil
+	}
+}
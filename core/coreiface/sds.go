@@ -2,6 +2,7 @@ package iface
 
 import (
 	"context"
+	"time"
 
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/path"
@@ -9,17 +10,206 @@ import (
 	"github.com/ipfs/kubo/core/coreiface/options"
 )
 
+// SdsLink is the content of a mapping file linking an ipfs DAG to the sds
+// file holding its CAR
+type SdsLink struct {
+	// Version of the mapping file, 1 for the unsigned files of older nodes
+	Version int
+	// Cid is the root of the original DAG
+	Cid cid.Cid
+	// FileHash is the sds file hash of the DAG CAR
+	FileHash string
+	// CarSize is the size of the DAG CAR, zero for a v1 mapping file
+	CarSize int64
+	// ShareLink is the sds:// link the CAR is shared under
+	ShareLink string
+	// Wallet is the wallet which uploaded the CAR and signed the mapping
+	// file, empty for a v1 mapping file
+	Wallet string
+}
+
+// SdsShare is a share link of a file stored in sds
+type SdsShare struct {
+	ShareId string
+	// ShareLink is the sds:// link of the share, without its password
+	ShareLink string
+	// Password of a private share, empty when it is not known
+	Password string
+	// Private reports if the share is protected by a password, only known
+	// for the shares recorded by the node
+	Private  bool
+	FileHash string
+	// FileName and FileSize are listed by the pp
+	FileName string
+	FileSize uint64
+	// Cid is the root of the shared DAG, undefined when it is not known
+	Cid     cid.Cid
+	Created time.Time
+	// Expires is zero for a share which does not expire
+	Expires time.Time
+	// Revoked is when the share was removed, zero while it is active
+	Revoked time.Time
+	// Recorded reports if the share was created by the node, and is kept
+	// in its repo
+	Recorded bool
+}
+
+// SdsStatus describes the state of the sds integration
+type SdsStatus struct {
+	// Enabled reports if sds is switched on in the config
+	Enabled bool
+	// PPs are the pp nodes of the config and their observed state
+	PPs []SdsPP
+	// Reachable reports if a pp node answered
+	Reachable bool
+	// Error returned by the pp nodes when none is reachable
+	Error string
+	// WalletAddress used to sign sds requests
+	WalletAddress string
+	// Ozone balance of the wallet
+	Ozone string
+	// Spent is the ozone spent today by the sds operations
+	Spent *SdsSpend `json:",omitempty"`
+}
+
+// SdsSpend is the ozone spent during a day
+type SdsSpend struct {
+	// Day is the UTC day, as 2006-01-02
+	Day   string
+	Total int64
+	// Daily budget, zero when unlimited
+	Daily int64
+	// Operations is the ozone spent by upload and download
+	Operations map[string]int64 `json:",omitempty"`
+}
+
+// SdsPP is a pp node and its observed state
+type SdsPP struct {
+	URL string
+	// Healthy is unset once a request or a health check failed, until the
+	// pp node answers again
+	Healthy bool
+	// Latency is the moving average of the request durations
+	Latency time.Duration
+	// Error of the last failed request
+	Error string `json:",omitempty"`
+}
+
+// SdsUpload is the saved progress of an upload to sds
+type SdsUpload struct {
+	FileHash string
+	// Cid is the root of the uploaded DAG, undefined when the uploaded file
+	// is not a DAG
+	Cid cid.Cid
+	// Name and ContentType the file is uploaded with
+	Name        string
+	ContentType string
+	Size        int64
+	// OffsetStart and OffsetEnd are the last range acknowledged by the pp
+	OffsetStart uint64
+	OffsetEnd   uint64
+	// Status is uploading, interrupted or failed
+	Status  string
+	Error   string
+	Started time.Time
+	Updated time.Time
+}
+
+// SdsQueued is a DAG added in async mode, queued to be uploaded to sds by
+// the daemon
+type SdsQueued struct {
+	// Cid is the root of the DAG
+	Cid cid.Cid
+	// Name of the upload, the pin name when it is queued as a remote pin
+	Name string
+	// Status is queued, uploading, failed or done
+	Status string
+	// Attempts of the upload so far
+	Attempts int64
+	// NextAttempt is when an upload which failed is attempted again
+	NextAttempt time.Time
+	// FileHash is the sds file hash of the DAG CAR once uploaded
+	FileHash string
+	// Link is the mapping file added once the DAG is uploaded
+	Link    cid.Cid
+	Error   string
+	Added   time.Time
+	Updated time.Time
+}
+
+// SdsCacheEntry is a downloaded file kept in the sds cache
+type SdsCacheEntry struct {
+	FileHash string
+	Size     int64
+	LastUsed time.Time
+}
+
+// SdsCacheStat describes the sds cache usage
+type SdsCacheStat struct {
+	// Dir is the cache folder
+	Dir string
+	// Size of the cached files
+	Size int64
+	// MaxSize above which the least recently used files are evicted
+	MaxSize int64
+	// Files is the number of cached files
+	Files int
+}
+
 // SdsAPI specifies the interface to the sds layer.
 type SdsAPI interface {
-	// Add imports the data from the reader into sds store chunks
-	Upload(context.Context, files.File, ...options.UnixfsAddOption) (string, error)
-	// Link a path with sds as share link
-	Link(context.Context, cid.Cid, string, ...options.UnixfsAddOption) (files.File, error)
-	// Parse file to get sds file hash
+	// Upload exports the DAG referenced by the path as a CAR into sds store
+	// chunks and returns the sds file hash. The file is stored under its
+	// name, along with its content type and root cid.
+	Upload(context.Context, path.Path, ...options.SdsUploadOption) (string, error)
+	// Link a path with sds as share link. The options set the expiry of the
+	// share link, which is public for the mapping file to be followed
+	Link(context.Context, cid.Cid, string, ...options.SdsShareOption) (files.File, error)
+	// Share creates a share link for an uploaded sds file holding the DAG
+	// of the cid. An empty file hash is computed from the exported DAG. The
+	// share is recorded in the repo
+	Share(context.Context, string, cid.Cid, ...options.SdsShareOption) (SdsShare, error)
+	// Shares lists the share links of the wallet, along with what the node
+	// recorded about the ones it created
+	Shares(context.Context, ...options.SdsSharesOption) ([]SdsShare, error)
+	// Unshare revokes the share link of the share id
+	Unshare(context.Context, string) error
+	// Parse returns the path of the DAG a mapping file links to, the file
+	// must be signed by the node wallet or one of Sds.TrustedWallets, or be
+	// an unsigned one when Sds.FollowUnsignedLinks is set
 	Parse(context.Context, files.File) (path.ImmutablePath, error)
-	// Get returns a read-only handle to a file tree referenced by a file hash
+	// Resolve reads the mapping file at the path
+	Resolve(context.Context, path.Path) (SdsLink, error)
+	// Download returns a read-only handle to a file referenced by a share
+	// link, which could be a bare share id, an sds:// link or an ipfs cid
 	//
 	// Note that some implementations of this API may apply the specified context
 	// to operations performed on the returned file
-	Download(context.Context, path.Path) (files.File, error)
+	Download(context.Context, string) (files.File, error)
+	// Status reports the sds configuration and the pp node state
+	Status(context.Context) (SdsStatus, error)
+	// Uploads lists the unfinished uploads, the ones in progress as well as
+	// the interrupted or failed ones which could be resumed by uploading the
+	// same DAG again
+	Uploads(context.Context) ([]SdsUpload, error)
+	// Enqueue queues the upload of the DAG referenced by the path, the
+	// daemon uploads it and adds its mapping file in the background
+	Enqueue(context.Context, path.Path, ...options.SdsEnqueueOption) (SdsQueued, error)
+	// Queue lists the uploads queued by Enqueue, oldest first
+	Queue(context.Context) ([]SdsQueued, error)
+	// QueueRetry queues a failed upload again, or makes an upload waiting
+	// for its next attempt due right away
+	QueueRetry(context.Context, cid.Cid) (SdsQueued, error)
+	// QueueCancel removes an upload from the queue, stopping it when it is
+	// in progress
+	QueueCancel(context.Context, cid.Cid) error
+	// CacheStat reports the usage of the cache of downloaded files
+	CacheStat(context.Context) (SdsCacheStat, error)
+	// CacheList lists the cached files, most recently used first
+	CacheList(context.Context) ([]SdsCacheEntry, error)
+	// CacheClear removes all the cached files
+	CacheClear(context.Context) error
+	// CacheVerify checks the cached files against their file hash, the
+	// corrupted ones are removed and returned
+	CacheVerify(context.Context) ([]SdsCacheEntry, error)
 }
@@ -0,0 +1,79 @@
+// Package sdserr holds the sds errors answered by the api of the node, along
+// with the codes telling them apart in the error bodies. It has no
+// dependencies so the api clients could match the errors without the sds
+// implementation.
+package sdserr
+
+import "errors"
+
+var (
+	// ErrAlreadyExists is returned when uploading a file sds already stores
+	ErrAlreadyExists = errors.New("sds: file already exists")
+	// ErrInsufficientOzone is returned when the wallet cannot pay for the
+	// operation, the wallet needs to be topped up
+	ErrInsufficientOzone = errors.New("sds: insufficient ozone")
+	// ErrShareNotFound is returned when a share link does not exist, or is
+	// not known to have existed
+	ErrShareNotFound = errors.New("sds: share not found")
+	// ErrShareExpired is returned when a share link created by the node
+	// expired or was revoked
+	ErrShareExpired = errors.New("sds: share expired or revoked")
+	// ErrPPUnavailable is returned when no pp node could serve the request,
+	// the request could be retried later
+	ErrPPUnavailable = errors.New("sds: pp unavailable")
+	// ErrBudgetExceeded is returned when an operation would spend more ozone
+	// than the budgets of Sds.Budget allow
+	ErrBudgetExceeded = errors.New("sds: ozone budget exceeded")
+	// ErrRateLimited is returned when the gateway client started more
+	// downloads than Sds.Fallback.RateLimit allows
+	ErrRateLimited = errors.New("sds: too many downloads, retry later")
+	// ErrFallbackDenied is returned when Gateway.SdsFallback does not allow
+	// the gateway client to download from sds
+	ErrFallbackDenied = errors.New("sds: fallback not allowed for the client")
+	// ErrFileTooLarge is returned when a download goes over
+	// Sds.Fallback.MaxSize
+	ErrFileTooLarge = errors.New("sds: file too large")
+	// ErrFallbackBusy is returned when Sds.Fallback.MaxConcurrent downloads
+	// are already in flight
+	ErrFallbackBusy = errors.New("sds: too many downloads in flight")
+)
+
+// codes are the codes of the sds errors in the error bodies answered by the
+// api. Several errors are answered with the same http status, the codes tell
+// them apart. They start above the codes of the command errors.
+var codes = []struct {
+	code uint
+	err  error
+}{
+	{100, ErrAlreadyExists},
+	{101, ErrInsufficientOzone},
+	{102, ErrShareNotFound},
+	{103, ErrPPUnavailable},
+	{104, ErrBudgetExceeded},
+	{105, ErrRateLimited},
+	{106, ErrFallbackDenied},
+	{107, ErrFileTooLarge},
+	{108, ErrFallbackBusy},
+	{109, ErrShareExpired},
+}
+
+// Code returns the code answered for err, zero when err is not an sds error
+func Code(err error) uint {
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return 0
+}
+
+// FromCode returns the sds error answered with the code, nil when the code
+// is not one of an sds error
+func FromCode(code uint) error {
+	for _, c := range codes {
+		if c.code == code {
+			return c.err
+		}
+	}
+	return nil
+}
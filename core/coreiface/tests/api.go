@@ -82,6 +82,7 @@ func TestApi(p Provider) func(t *testing.T) {
 		t.Run("Pin", tp.TestPin)
 		t.Run("PubSub", tp.TestPubSub)
 		t.Run("Routing", tp.TestRouting)
+		t.Run("Sds", tp.TestSds)
 		t.Run("Unixfs", tp.TestUnixfs)
 
 		apis <- -1
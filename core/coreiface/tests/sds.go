@@ -0,0 +1,325 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/ipfs/kubo/sds"
+	sdsmock "github.com/ipfs/kubo/sds/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testSdsFileHash = "v05j1m517ljekhi1c4ce82pb62c5p1vdjvrbph2g"
+
+func (tp *TestSuite) TestSds(t *testing.T) {
+	tp.hasApi(t, func(api iface.CoreAPI) error {
+		if api.Sds() == nil {
+			return errAPINotImplemented
+		}
+		return nil
+	})
+
+	t.Run("TestSdsStatusDisabled", tp.TestSdsStatusDisabled)
+	t.Run("TestSdsUploadDisabled", tp.TestSdsUploadDisabled)
+	t.Run("TestSdsUploadsDisabled", tp.TestSdsUploadsDisabled)
+	t.Run("TestSdsQueueDisabled", tp.TestSdsQueueDisabled)
+	t.Run("TestSdsParse", tp.TestSdsParse)
+	t.Run("TestSdsResolve", tp.TestSdsResolve)
+	t.Run("TestSdsUpload", tp.TestSdsUpload)
+	t.Run("TestSdsLink", tp.TestSdsLink)
+	t.Run("TestSdsShare", tp.TestSdsShare)
+	t.Run("TestSdsDownload", tp.TestSdsDownload)
+	t.Run("TestSdsEnqueue", tp.TestSdsEnqueue)
+}
+
+// SdsProvider is a Provider which also creates nodes with sds enabled, the
+// sds cases are skipped for the providers which do not implement it
+type SdsProvider interface {
+	// MakeSdsAPI creates a node using the pp at ppURL
+	MakeSdsAPI(t *testing.T, ctx context.Context, ppURL string) (iface.CoreAPI, error)
+}
+
+// makeSdsAPI starts a mock pp and creates a node with sds enabled using it
+func (tp *TestSuite) makeSdsAPI(t *testing.T, ctx context.Context) (iface.CoreAPI, *sdsmock.PP) {
+	provider, ok := tp.Provider.(SdsProvider)
+	if !ok {
+		t.Skip("the provider does not enable sds")
+	}
+
+	pp := sdsmock.NewPP()
+	t.Cleanup(pp.Close)
+	api, err := provider.MakeSdsAPI(t, ctx, pp.URL())
+	require.NoError(t, err)
+	return api, pp
+}
+
+// uploadSds adds and uploads helloStr, it returns its path and file hash
+func uploadSds(t *testing.T, ctx context.Context, api iface.CoreAPI) (path.ImmutablePath, string) {
+	p, err := api.Unixfs().Add(ctx, strFile(helloStr)())
+	require.NoError(t, err)
+	fileHash, err := api.Sds().Upload(ctx, p, options.Sds.FileName("hello.txt"))
+	require.NoError(t, err)
+	return p, fileHash
+}
+
+func (tp *TestSuite) TestSdsStatusDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := tp.makeAPI(t, ctx)
+	require.NoError(t, err)
+
+	status, err := api.Sds().Status(ctx)
+	require.NoError(t, err)
+	require.False(t, status.Enabled)
+	require.False(t, status.Reachable)
+}
+
+func (tp *TestSuite) TestSdsUploadDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := tp.makeAPI(t, ctx)
+	require.NoError(t, err)
+
+	p, err := api.Unixfs().Add(ctx, strFile(helloStr)())
+	require.NoError(t, err)
+
+	_, err = api.Sds().Upload(ctx, p)
+	require.ErrorContains(t, err, "sds is not enabled")
+}
+
+func (tp *TestSuite) TestSdsUploadsDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := tp.makeAPI(t, ctx)
+	require.NoError(t, err)
+
+	_, err = api.Sds().Uploads(ctx)
+	require.ErrorContains(t, err, "sds is not enabled")
+}
+
+func (tp *TestSuite) TestSdsQueueDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := tp.makeAPI(t, ctx)
+	require.NoError(t, err)
+
+	p, err := api.Unixfs().Add(ctx, strFile(helloStr)())
+	require.NoError(t, err)
+
+	_, err = api.Sds().Enqueue(ctx, p)
+	require.ErrorContains(t, err, "sds is not enabled")
+	_, err = api.Sds().Queue(ctx)
+	require.ErrorContains(t, err, "sds is not enabled")
+}
+
+func (tp *TestSuite) TestSdsParse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := tp.makeAPI(t, ctx)
+	require.NoError(t, err)
+
+	p, err := api.Unixfs().Add(ctx, strFile(helloStr)())
+	require.NoError(t, err)
+
+	// the unsigned mapping files are not followed by default
+	mapData := sdsMappingFile(t, p)
+	_, err = api.Sds().Parse(ctx, files.NewBytesFile(mapData))
+	require.ErrorContains(t, err, "untrusted mapping file")
+
+	_, err = api.Sds().Parse(ctx, files.NewBytesFile([]byte(helloStr)))
+	require.Error(t, err)
+}
+
+func (tp *TestSuite) TestSdsResolve(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := tp.makeAPI(t, ctx)
+	require.NoError(t, err)
+
+	p, err := api.Unixfs().Add(ctx, strFile(helloStr)())
+	require.NoError(t, err)
+
+	mapPath, err := api.Unixfs().Add(ctx, files.NewBytesFile(sdsMappingFile(t, p)))
+	require.NoError(t, err)
+
+	link, err := api.Sds().Resolve(ctx, mapPath)
+	require.NoError(t, err)
+	require.Equal(t, p.RootCid(), link.Cid)
+	require.Equal(t, testSdsFileHash, link.FileHash)
+
+	_, err = api.Sds().Resolve(ctx, p)
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "is not an sds mapping file"))
+}
+
+func (tp *TestSuite) TestSdsUpload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, pp := tp.makeSdsAPI(t, ctx)
+
+	p, fileHash := uploadSds(t, ctx, api)
+	meta, ok := pp.FileMeta(fileHash)
+	require.True(t, ok)
+	require.Equal(t, sdsmock.FileMeta{Name: "hello.txt", ContentType: "text/plain; charset=utf-8", Cid: p.RootCid().String()}, meta)
+
+	// the session of a finished upload is not kept
+	uploads, err := api.Sds().Uploads(ctx)
+	require.NoError(t, err)
+	require.Empty(t, uploads)
+
+	// uploading the DAG again gives the same file
+	again, err := api.Sds().Upload(ctx, p, options.Sds.FileName("hello.txt"))
+	require.NoError(t, err)
+	require.Equal(t, fileHash, again)
+}
+
+func (tp *TestSuite) TestSdsLink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, _ := tp.makeSdsAPI(t, ctx)
+
+	p, fileHash := uploadSds(t, ctx, api)
+	mapFile, err := api.Sds().Link(ctx, p.RootCid(), fileHash, options.Sds.Expire(time.Hour))
+	require.NoError(t, err)
+	// the mapping file is put as a block, adding it would upload it as well
+	mapBlock, err := api.Block().Put(ctx, mapFile)
+	require.NoError(t, err)
+
+	link, err := api.Sds().Resolve(ctx, mapBlock.Path())
+	require.NoError(t, err)
+	require.Equal(t, sds.LinkVersion, link.Version)
+
+	// the mapping files of the node wallet are followed
+	mapData, err := api.Block().Get(ctx, mapBlock.Path())
+	require.NoError(t, err)
+	parsed, err := api.Sds().Parse(ctx, files.NewReaderFile(mapData))
+	require.NoError(t, err)
+	require.Equal(t, p.RootCid(), parsed.RootCid())
+	require.Equal(t, p.RootCid(), link.Cid)
+	require.Equal(t, fileHash, link.FileHash)
+	require.Positive(t, link.CarSize)
+	require.True(t, strings.HasPrefix(link.ShareLink, "sds://"), link.ShareLink)
+
+	status, err := api.Sds().Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, status.WalletAddress, link.Wallet)
+
+	// the share link of the mapping file expires with the option
+	shares, err := api.Sds().Shares(ctx, options.Sds.Recorded(true))
+	require.NoError(t, err)
+	share := findSdsShare(shares, func(s iface.SdsShare) bool { return s.ShareLink == link.ShareLink })
+	require.NotNil(t, share)
+	require.False(t, share.Expires.IsZero())
+
+	_, err = api.Sds().Link(ctx, p.RootCid(), fileHash, options.Sds.Private(true))
+	require.ErrorContains(t, err, "cannot have a password")
+}
+
+func (tp *TestSuite) TestSdsShare(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, _ := tp.makeSdsAPI(t, ctx)
+
+	p, fileHash := uploadSds(t, ctx, api)
+	share, err := api.Sds().Share(ctx, fileHash, p.RootCid(), options.Sds.Private(true))
+	require.NoError(t, err)
+	require.True(t, share.Private)
+	require.NotEmpty(t, share.Password)
+	require.Equal(t, fileHash, share.FileHash)
+
+	byId := func(s iface.SdsShare) bool { return s.ShareId == share.ShareId }
+	shares, err := api.Sds().Shares(ctx)
+	require.NoError(t, err)
+	listed := findSdsShare(shares, byId)
+	require.NotNil(t, listed)
+	require.True(t, listed.Recorded)
+	require.Equal(t, p.RootCid(), listed.Cid)
+
+	require.NoError(t, api.Sds().Unshare(ctx, share.ShareId))
+	shares, err = api.Sds().Shares(ctx)
+	require.NoError(t, err)
+	require.Nil(t, findSdsShare(shares, byId))
+
+	// the revoked share stays recorded
+	shares, err = api.Sds().Shares(ctx, options.Sds.Recorded(true))
+	require.NoError(t, err)
+	listed = findSdsShare(shares, byId)
+	require.NotNil(t, listed)
+	require.False(t, listed.Revoked.IsZero())
+
+	err = api.Sds().Unshare(ctx, share.ShareId)
+	require.ErrorIs(t, err, sds.ErrShareExpired)
+}
+
+func (tp *TestSuite) TestSdsDownload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, pp := tp.makeSdsAPI(t, ctx)
+
+	p, fileHash := uploadSds(t, ctx, api)
+	share, err := api.Sds().Share(ctx, fileHash, p.RootCid())
+	require.NoError(t, err)
+
+	f, err := api.Sds().Download(ctx, share.ShareLink)
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	stored, ok := pp.File(fileHash)
+	require.True(t, ok)
+	require.Equal(t, stored, data)
+
+	require.NoError(t, api.Sds().Unshare(ctx, share.ShareId))
+	_, err = api.Sds().Download(ctx, share.ShareLink)
+	require.ErrorIs(t, err, sds.ErrShareExpired)
+}
+
+func (tp *TestSuite) TestSdsEnqueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, _ := tp.makeSdsAPI(t, ctx)
+
+	p, err := api.Unixfs().Add(ctx, strFile(helloStr)())
+	require.NoError(t, err)
+
+	queued, err := api.Sds().Enqueue(ctx, p, options.Sds.Name("hello.txt"))
+	require.NoError(t, err)
+	require.Equal(t, p.RootCid(), queued.Cid)
+	require.Equal(t, "hello.txt", queued.Name)
+
+	uploads, err := api.Sds().Queue(ctx)
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	require.Equal(t, p.RootCid(), uploads[0].Cid)
+
+	require.NoError(t, api.Sds().QueueCancel(ctx, p.RootCid()))
+	uploads, err = api.Sds().Queue(ctx)
+	require.NoError(t, err)
+	require.Empty(t, uploads)
+}
+
+func findSdsShare(shares []iface.SdsShare, match func(iface.SdsShare) bool) *iface.SdsShare {
+	for i := range shares {
+		if match(shares[i]) {
+			return &shares[i]
+		}
+	}
+	return nil
+}
+
+func sdsMappingFile(t *testing.T, p path.ImmutablePath) []byte {
+	mapFile, err := sds.NewSdsFileV1(p.RootCid(), testSdsFileHash)
+	require.NoError(t, err)
+	defer mapFile.Close()
+
+	data, err := io.ReadAll(mapFile)
+	require.NoError(t, err)
+	return data
+}
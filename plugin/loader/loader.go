@@ -16,6 +16,7 @@ import (
 	"github.com/ipfs/kubo/core/coreapi"
 	plugin "github.com/ipfs/kubo/plugin"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
+	"github.com/ipfs/kubo/sds"
 
 	logging "github.com/ipfs/go-log"
 	opentracing "github.com/opentracing/opentracing-go"
@@ -299,6 +300,13 @@ func (loader *PluginLoader) Inject() error {
 				return err
 			}
 		}
+		if pl, ok := pl.(plugin.PluginSdsSigner); ok {
+			err := injectSdsSignerPlugin(pl)
+			if err != nil {
+				loader.state = loaderFailed
+				return err
+			}
+		}
 	}
 
 	return loader.transition(loaderInjecting, loaderInjected)
@@ -389,3 +397,7 @@ func injectFxPlugin(pl plugin.PluginFx) error {
 	core.RegisterFXOptionFunc(pl.Options)
 	return nil
 }
+
+func injectSdsSignerPlugin(pl plugin.PluginSdsSigner) error {
+	return sds.RegisterSigner(pl.SdsSignerType(), pl.NewSdsSigner)
+}
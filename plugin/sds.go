@@ -0,0 +1,15 @@
+package plugin
+
+import (
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/sds"
+)
+
+// PluginSdsSigner is an interface that can be implemented to add a signer of
+// the sds requests, selected with Sds.Signer.Type.
+type PluginSdsSigner interface {
+	Plugin
+
+	SdsSignerType() string
+	NewSdsSigner(cfg *config.SdsSigner) (sds.Signer, error)
+}
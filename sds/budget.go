@@ -0,0 +1,296 @@
+package sds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/kubo/config"
+)
+
+// budgetKey is the datastore key of the ozone spent today
+var budgetKey = datastore.NewKey("/sds/budget")
+
+// budgetFlushInterval is how often the spend of the day is saved in the
+// datastore while it changes
+const budgetFlushInterval = 10 * time.Second
+
+// maxBudgetClients caps the gateway clients whose spend is tracked in a day,
+// the clients coming after share the spend of otherClients
+var maxBudgetClients = 10000
+
+const otherClients = "other"
+
+// the operations charged to the budget
+const (
+	opUpload   = "upload"
+	opDownload = "download"
+)
+
+type clientKey struct{}
+
+// WithClient returns a context of the requests of a gateway client, the
+// ozone spent by its downloads is counted against Sds.Budget.PerClient
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// clientFrom returns the gateway client of the context, empty when the
+// request did not come from a gateway
+func clientFrom(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// BudgetSpend is the ozone spent during a day
+type BudgetSpend struct {
+	// Day is the UTC day, as 2006-01-02
+	Day   string
+	Total int64
+	// Operations is the ozone spent by upload and download
+	Operations map[string]int64 `json:",omitempty"`
+	// Clients is the ozone spent by gateway client, the clients over the
+	// cap of the tracked clients are counted together as "other"
+	Clients map[string]int64 `json:",omitempty"`
+}
+
+func (s *BudgetSpend) copy() *BudgetSpend {
+	out := &BudgetSpend{Day: s.Day, Total: s.Total}
+	if s.Operations != nil {
+		out.Operations = maps.Clone(s.Operations)
+	}
+	if s.Clients != nil {
+		out.Clients = maps.Clone(s.Clients)
+	}
+	return out
+}
+
+// Budget tracks the ozone spent by the operations and refuses the ones going
+// over the limits of Sds.Budget. The spend of the day is loaded once from the
+// repo datastore and kept in memory, it is saved every budgetFlushInterval
+// while it changes so restarting the daemon does not reset it.
+type Budget struct {
+	cfg *config.SdsBudget
+	ds  datastore.Datastore
+	now func() time.Time
+
+	mu    sync.Mutex
+	spend *BudgetSpend
+	dirty bool
+
+	close sync.Once
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewBudget returns the budget of the spend saved in ds, it has to be closed
+// for the spend to be saved
+func NewBudget(cfg *config.SdsBudget, ds datastore.Datastore) *Budget {
+	b := &Budget{
+		cfg:  cfg,
+		ds:   ds,
+		now:  time.Now,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go b.flushLoop()
+	return b
+}
+
+// Close stops saving the spend periodically and saves it a last time
+func (b *Budget) Close() {
+	b.close.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+}
+
+func (b *Budget) flushLoop() {
+	defer close(b.done)
+	ticker := time.NewTicker(budgetFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.flush()
+		case <-b.stop:
+			b.flush()
+			return
+		}
+	}
+}
+
+// flush saves the spend when it changed since it was last saved
+func (b *Budget) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.dirty {
+		return
+	}
+	data, err := json.Marshal(b.spend)
+	if err == nil {
+		err = b.ds.Put(context.Background(), budgetKey, data)
+	}
+	if err != nil {
+		logger.Errorf("failed to save the ozone spent today: %s", err)
+		return
+	}
+	b.dirty = false
+}
+
+// today returns the spend of the day, loaded from the datastore the first
+// time. The caller holds b.mu.
+func (b *Budget) today(ctx context.Context) (*BudgetSpend, error) {
+	today := b.now().UTC().Format(time.DateOnly)
+	if b.spend == nil {
+		data, err := b.ds.Get(ctx, budgetKey)
+		switch {
+		case errors.Is(err, datastore.ErrNotFound):
+			b.spend = &BudgetSpend{}
+		case err != nil:
+			return nil, err
+		default:
+			var spend BudgetSpend
+			if err := json.Unmarshal(data, &spend); err != nil {
+				return nil, err
+			}
+			b.spend = &spend
+		}
+	}
+	if b.spend.Day != today {
+		b.spend = &BudgetSpend{Day: today}
+	}
+	return b.spend, nil
+}
+
+// Cost returns the ozone an operation moving bytes costs
+func (b *Budget) Cost(bytes int64) int64 {
+	return bytes * b.cfg.OzonePerByte.WithDefault(config.DefaultSdsBudgetOzonePerByte)
+}
+
+// Spend returns the ozone spent today
+func (b *Budget) Spend(ctx context.Context) (*BudgetSpend, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spend, err := b.today(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return spend.copy(), nil
+}
+
+// Check refuses up front an operation of client costing cost when it would
+// go over a daily budget or over Sds.Budget.PerOperation, or bring the ozone
+// balance below Sds.Budget.MinBalance. The cost of a download is unknown
+// before it starts, it is given as zero and only refused when a budget is
+// used up.
+func (b *Budget) Check(ctx context.Context, op, client string, cost int64, balance string) error {
+	if limit := b.cfg.PerOperation.WithDefault(0); overLimit(0, cost, limit) {
+		return fmt.Errorf("%w: %s of %d ozone is over Sds.Budget.PerOperation %d", ErrBudgetExceeded, op, cost, limit)
+	}
+	if minBalance := b.cfg.MinBalance.WithDefault(0); minBalance > 0 {
+		ozone, err := strconv.ParseInt(balance, 10, 64)
+		if err != nil {
+			logger.Warnf("could not check Sds.Budget.MinBalance against the ozone balance %q: %s", balance, err)
+		} else if ozone-cost < minBalance {
+			return fmt.Errorf("%w: %s of %d ozone would bring the balance %d below Sds.Budget.MinBalance %d", ErrInsufficientOzone, op, cost, ozone, minBalance)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spend, err := b.today(ctx)
+	if err != nil {
+		return err
+	}
+	// an operation costs at least one ozone, so a used up budget also
+	// refuses the downloads
+	return b.exceeded(spend, op, client, max(cost, 1))
+}
+
+// Charge records ozone spent by an operation of client. It fails once a
+// daily budget is exceeded, the operation should then be stopped.
+func (b *Budget) Charge(ctx context.Context, op, client string, cost int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	spend, err := b.today(ctx)
+	if err != nil {
+		return err
+	}
+	spend.Total += cost
+	if spend.Operations == nil {
+		spend.Operations = make(map[string]int64)
+	}
+	spend.Operations[op] += cost
+	if client != "" {
+		if spend.Clients == nil {
+			spend.Clients = make(map[string]int64)
+		}
+		spend.Clients[trackedClient(spend, client)] += cost
+	}
+	b.dirty = true
+	ozoneSpent.WithLabelValues(op).Add(float64(cost))
+
+	return b.exceeded(spend, op, client, 0)
+}
+
+// trackedClient returns the key of the spend of client, otherClients once
+// the tracked clients are capped
+func trackedClient(spend *BudgetSpend, client string) string {
+	if _, ok := spend.Clients[client]; ok || len(spend.Clients) < maxBudgetClients {
+		return client
+	}
+	return otherClients
+}
+
+// exceeded returns an error when adding cost to spend goes over a daily
+// budget
+func (b *Budget) exceeded(spend *BudgetSpend, op, client string, cost int64) error {
+	if limit := b.cfg.Daily.WithDefault(0); overLimit(spend.Total, cost, limit) {
+		return fmt.Errorf("%w: %s is over Sds.Budget.Daily %d, %d ozone spent today", ErrBudgetExceeded, op, limit, spend.Total)
+	}
+	if limit := b.cfg.PerClient.WithDefault(0); client != "" {
+		if spent := spend.Clients[trackedClient(spend, client)]; overLimit(spent, cost, limit) {
+			return fmt.Errorf("%w: %s is over Sds.Budget.PerClient %d, %d ozone spent today by %s", ErrBudgetExceeded, op, limit, spent, client)
+		}
+	}
+	return nil
+}
+
+// overLimit reports if spending cost after spent goes over limit, zero
+// limits are unlimited
+func overLimit(spent, cost, limit int64) bool {
+	return limit > 0 && spent+cost > limit
+}
+
+// operationSpend charges the ozone spent by one operation to the budget
+type operationSpend struct {
+	budget *Budget
+	op     string
+	client string
+	spent  int64
+}
+
+func (b *Budget) operation(op, client string) *operationSpend {
+	return &operationSpend{budget: b, op: op, client: client}
+}
+
+// charge records cost spent by the operation, it fails once the operation
+// or a daily budget went over its limit
+func (o *operationSpend) charge(ctx context.Context, cost int64) error {
+	o.spent += cost
+	if err := o.budget.Charge(ctx, o.op, o.client, cost); err != nil {
+		return err
+	}
+	if limit := o.budget.cfg.PerOperation.WithDefault(0); overLimit(o.spent, 0, limit) {
+		return fmt.Errorf("%w: %s went over Sds.Budget.PerOperation %d", ErrBudgetExceeded, o.op, limit)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package sds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudget(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	cfg := &config.SdsBudget{
+		Daily:        config.NewOptionalInteger(1000),
+		PerOperation: config.NewOptionalInteger(600),
+		PerClient:    config.NewOptionalInteger(300),
+		MinBalance:   config.NewOptionalInteger(100),
+	}
+	now := time.Date(2024, 10, 1, 23, 0, 0, 0, time.UTC)
+	b := NewBudget(cfg, ds)
+	defer b.Close()
+	b.now = func() time.Time { return now }
+
+	t.Run("checks the operations up front", func(t *testing.T) {
+		assert.NoError(t, b.Check(ctx, opUpload, "", 600, "10000"))
+		assert.ErrorIs(t, b.Check(ctx, opUpload, "", 601, "10000"), ErrBudgetExceeded)
+		assert.ErrorIs(t, b.Check(ctx, opUpload, "", 500, "599"), ErrInsufficientOzone)
+		assert.NoError(t, b.Check(ctx, opDownload, "", 0, "101"))
+		assert.ErrorIs(t, b.Check(ctx, opDownload, "", 0, "99"), ErrInsufficientOzone)
+		// the balance could not be checked
+		assert.NoError(t, b.Check(ctx, opDownload, "", 0, "1e+12"))
+	})
+
+	t.Run("charges the operations", func(t *testing.T) {
+		upload := b.operation(opUpload, "")
+		require.NoError(t, upload.charge(ctx, 400))
+		assert.ErrorIs(t, upload.charge(ctx, 201), ErrBudgetExceeded)
+
+		download := b.operation(opDownload, "10.0.0.1")
+		require.NoError(t, download.charge(ctx, 200))
+		assert.NoError(t, b.Check(ctx, opDownload, "10.0.0.1", 0, "10000"))
+		assert.ErrorIs(t, download.charge(ctx, 101), ErrBudgetExceeded)
+		assert.ErrorIs(t, b.Check(ctx, opDownload, "10.0.0.1", 0, "10000"), ErrBudgetExceeded)
+		assert.NoError(t, b.Check(ctx, opDownload, "10.0.0.2", 0, "10000"))
+
+		spend, err := b.Spend(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, &BudgetSpend{
+			Day:        "2024-10-01",
+			Total:      902,
+			Operations: map[string]int64{opUpload: 601, opDownload: 301},
+			Clients:    map[string]int64{"10.0.0.1": 301},
+		}, spend)
+	})
+
+	t.Run("resets every day", func(t *testing.T) {
+		now = now.Add(2 * time.Hour)
+		spend, err := b.Spend(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, &BudgetSpend{Day: "2024-10-02"}, spend)
+		assert.NoError(t, b.Check(ctx, opDownload, "10.0.0.1", 0, "10000"))
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		b := NewBudget(&config.SdsBudget{}, dssync.MutexWrap(datastore.NewMapDatastore()))
+		defer b.Close()
+		require.NoError(t, b.operation(opUpload, "").charge(ctx, 1<<40))
+		assert.NoError(t, b.Check(ctx, opUpload, "", 1<<40, "0"))
+	})
+
+	t.Run("prices the bytes", func(t *testing.T) {
+		b := NewBudget(&config.SdsBudget{}, dssync.MutexWrap(datastore.NewMapDatastore()))
+		defer b.Close()
+		assert.Equal(t, int64(600), b.Cost(600))
+		b = NewBudget(&config.SdsBudget{OzonePerByte: config.NewOptionalInteger(3)}, dssync.MutexWrap(datastore.NewMapDatastore()))
+		defer b.Close()
+		assert.Equal(t, int64(1800), b.Cost(600))
+	})
+}
+
+func TestBudgetSaved(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	cfg := &config.SdsBudget{}
+
+	b := NewBudget(cfg, ds)
+	require.NoError(t, b.Charge(ctx, opUpload, "", 100))
+	require.NoError(t, b.Charge(ctx, opDownload, "10.0.0.1", 50))
+
+	// the charges are kept in memory
+	has, err := ds.Has(ctx, budgetKey)
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	// and saved when the budget is closed
+	b.Close()
+	b.Close()
+	b = NewBudget(cfg, ds)
+	defer b.Close()
+	spend, err := b.Spend(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(150), spend.Total)
+	assert.Equal(t, map[string]int64{"10.0.0.1": 50}, spend.Clients)
+}
+
+func TestBudgetClients(t *testing.T) {
+	defer func(max int) { maxBudgetClients = max }(maxBudgetClients)
+	maxBudgetClients = 2
+
+	ctx := context.Background()
+	b := NewBudget(&config.SdsBudget{PerClient: config.NewOptionalInteger(100)}, dssync.MutexWrap(datastore.NewMapDatastore()))
+	defer b.Close()
+
+	require.NoError(t, b.Charge(ctx, opDownload, "10.0.0.1", 10))
+	require.NoError(t, b.Charge(ctx, opDownload, "10.0.0.2", 20))
+	// the clients over the cap share a budget
+	require.NoError(t, b.Charge(ctx, opDownload, "10.0.0.3", 30))
+	require.NoError(t, b.Charge(ctx, opDownload, "10.0.0.4", 40))
+	require.NoError(t, b.Charge(ctx, opDownload, "10.0.0.1", 10))
+	assert.ErrorIs(t, b.Check(ctx, opDownload, "10.0.0.5", 31, "10000"), ErrBudgetExceeded)
+	assert.NoError(t, b.Check(ctx, opDownload, "10.0.0.2", 31, "10000"))
+
+	spend, err := b.Spend(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"10.0.0.1": 20, "10.0.0.2": 20, otherClients: 70}, spend.Clients)
+}
@@ -0,0 +1,411 @@
+package sds
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ipfs/kubo/config"
+	"github.com/stratosnet/sds/framework/crypto"
+)
+
+// DefaultCacheFolder is the cache folder in the repo used when none is set
+const DefaultCacheFolder = "sds-cache"
+
+// legacyCacheFolder is the default cache folder of the older configs, shared
+// with the other programs, it is taken as unset
+const legacyCacheFolder = "/tmp"
+
+// filesFolder is the folder of the cache holding the cached files and the
+// downloads in progress. The cache folder itself could be shared, only the
+// folders the cache owns are listed and cleared.
+const filesFolder = "sds-files"
+
+// partSuffix marks the files of downloads in progress
+const partSuffix = ".part"
+
+// spoolFolder is the folder of the cache holding the CARs exported for the
+// uploads and shares, they are removed once read
+const spoolFolder = "sds-spool"
+
+// CacheDir returns the cache folder of the config, relative folders are
+// resolved from the repo root. The legacy /tmp default is replaced by the
+// folder of the repo.
+func CacheDir(cfg *config.Sds, repoPath string) string {
+	dir := cfg.CacheFolder
+	if dir == "" || filepath.Clean(dir) == legacyCacheFolder {
+		dir = DefaultCacheFolder
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repoPath, dir)
+	}
+	return dir
+}
+
+// CacheEntry is a file stored in the cache
+type CacheEntry struct {
+	FileHash string
+	Size     int64
+	LastUsed time.Time
+	// verified is set once the content is checked against the file hash
+	verified bool
+}
+
+// CacheStat describes the cache usage
+type CacheStat struct {
+	Dir     string
+	Size    int64
+	MaxSize int64
+	Files   int
+}
+
+// Cache keeps downloaded sds files on disk, named by file hash. The total
+// size is capped, the least recently used files are evicted first. Files are
+// written to a temporary file and renamed once complete, and their content is
+// verified against the file hash before being reused.
+type Cache struct {
+	dir string
+	// files is the folder of the cached files, within dir
+	files   string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+	// parts are the temporary files of the downloads in progress
+	parts map[string]struct{}
+}
+
+// OpenCache returns the cache of the folder configured in cfg, creating the
+// folder if needed
+func OpenCache(cfg *config.Sds, repoPath string) (*Cache, error) {
+	maxSize, err := humanize.ParseBytes(cfg.CacheMaxSize.WithDefault(config.DefaultSdsCacheMaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sds.CacheMaxSize: %w", err)
+	}
+	return newCache(CacheDir(cfg, repoPath), int64(maxSize))
+}
+
+func newCache(dir string, maxSize int64) (*Cache, error) {
+	files := filepath.Join(dir, filesFolder)
+	if err := os.MkdirAll(files, 0o755); err != nil {
+		return nil, err
+	}
+	// spooled files left by a previous run are never read again
+	spoolDir := filepath.Join(dir, spoolFolder)
+	if err := os.RemoveAll(spoolDir); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(spoolDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		dir:     dir,
+		files:   files,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		parts:   make(map[string]struct{}),
+	}
+
+	dirEntries, err := os.ReadDir(files)
+	if err != nil {
+		return nil, err
+	}
+	var found []*CacheEntry
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() || !isFileHash(de.Name()) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, &CacheEntry{
+			FileHash: de.Name(),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+
+	// the modification time is bumped on every use, so the lru order
+	// survives restarts
+	sort.Slice(found, func(i, j int) bool { return found[i].LastUsed.After(found[j].LastUsed) })
+	for _, e := range found {
+		c.entries[e.FileHash] = c.lru.PushBack(e)
+		c.size += e.Size
+	}
+	c.evict()
+	return c, nil
+}
+
+// isFileHash filters out the files of the cache folder which are not cached
+// files, downloads in progress or anything else stored there
+func isFileHash(name string) bool {
+	return crypto.ValidateHash(name)
+}
+
+// SpoolDir returns the folder where the exported CARs are spooled
+func (c *Cache) SpoolDir() string {
+	return filepath.Join(c.dir, spoolFolder)
+}
+
+func (c *Cache) path(fileHash string) string {
+	return filepath.Join(c.files, fileHash)
+}
+
+// Open returns the cached file, or nil when it is not cached. A file is
+// hashed before its first reuse, and dropped if its content does not match.
+func (c *Cache) Open(fileHash string) (*os.File, int64, error) {
+	c.mu.Lock()
+	el, ok := c.entries[fileHash]
+	if !ok {
+		c.mu.Unlock()
+		return nil, 0, nil
+	}
+	e := el.Value.(*CacheEntry)
+	c.lru.MoveToFront(el)
+	e.LastUsed = time.Now()
+	verified := e.verified
+	c.mu.Unlock()
+
+	f, err := os.Open(c.path(fileHash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.drop(fileHash)
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	if !verified {
+		valid, err := verifyFile(f, fileHash)
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		if !valid {
+			f.Close()
+			logger.Warnf("cached file %s does not match its hash, dropping it", fileHash)
+			return nil, 0, c.Remove(fileHash)
+		}
+		c.mu.Lock()
+		e.verified = true
+		c.mu.Unlock()
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(f.Name(), now, now); err != nil {
+		logger.Debugf("failed to touch cached file %s: %s", fileHash, err)
+	}
+	return f, e.Size, nil
+}
+
+// verifyFile checks the content of f against the file hash, and seeks f back
+// to its start
+func verifyFile(f *os.File, fileHash string) (bool, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	hash, err := CreateFileHashFromReader(f)
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return hash == fileHash, nil
+}
+
+// Has tells if the file is in the cache, without checking its content
+func (c *Cache) Has(fileHash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[fileHash]
+	return ok
+}
+
+// Create returns a temporary file to download the file into, to be passed
+// to Commit once complete or to Abort
+func (c *Cache) Create(fileHash string) (*os.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tmp, err := os.CreateTemp(c.files, fileHash+".*"+partSuffix)
+	if err != nil {
+		return nil, err
+	}
+	c.parts[tmp.Name()] = struct{}{}
+	return tmp, nil
+}
+
+// Abort removes the temporary file of a failed download
+func (c *Cache) Abort(tmp *os.File) {
+	c.mu.Lock()
+	delete(c.parts, tmp.Name())
+	c.mu.Unlock()
+	os.Remove(tmp.Name())
+}
+
+// Commit checks the downloaded content against the file hash and moves it
+// into the cache. The temporary file is removed on failure.
+func (c *Cache) Commit(tmp *os.File, fileHash string, size int64) error {
+	valid, err := verifyFile(tmp, fileHash)
+	if err == nil && !valid {
+		err = fmt.Errorf("downloaded file does not match the file hash %s", fileHash)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(fileHash))
+	}
+	if err != nil {
+		c.Abort(tmp)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.parts, tmp.Name())
+	c.remove(fileHash)
+	c.entries[fileHash] = c.lru.PushFront(&CacheEntry{
+		FileHash: fileHash,
+		Size:     size,
+		LastUsed: time.Now(),
+		verified: true,
+	})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used files until the cache fits
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		e := c.lru.Back().Value.(*CacheEntry)
+		logger.Debugf("evicting %s from the cache", e.FileHash)
+		c.remove(e.FileHash)
+		cacheEvictions.Inc()
+		if err := os.Remove(c.path(e.FileHash)); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("failed to evict %s from the cache: %s", e.FileHash, err)
+		}
+	}
+}
+
+// remove forgets the entry, the caller holds the lock
+func (c *Cache) remove(fileHash string) {
+	el, ok := c.entries[fileHash]
+	if !ok {
+		return
+	}
+	c.size -= el.Value.(*CacheEntry).Size
+	c.lru.Remove(el)
+	delete(c.entries, fileHash)
+}
+
+// drop forgets an entry whose file is gone
+func (c *Cache) drop(fileHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(fileHash)
+}
+
+// Remove deletes the file from the cache
+func (c *Cache) Remove(fileHash string) error {
+	c.drop(fileHash)
+	if err := os.Remove(c.path(fileHash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the cached files, most recently used first
+func (c *Cache) List() []CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]CacheEntry, 0, c.lru.Len())
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		entries = append(entries, *el.Value.(*CacheEntry))
+	}
+	return entries
+}
+
+func (c *Cache) Stat() CacheStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStat{
+		Dir:     c.dir,
+		Size:    c.size,
+		MaxSize: c.maxSize,
+		Files:   c.lru.Len(),
+	}
+}
+
+// Clear deletes all the cached files, along with the leftovers of
+// interrupted downloads. The downloads in progress are kept.
+func (c *Cache) Clear() error {
+	for _, e := range c.List() {
+		if err := c.Remove(e.FileHash); err != nil {
+			return err
+		}
+	}
+
+	// the lock is held so no download starts between the listing and the
+	// removal
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parts, err := filepath.Glob(filepath.Join(c.files, "*"+partSuffix))
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, ok := c.parts[p]; ok {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify hashes every cached file and removes the corrupted ones, which are
+// returned
+func (c *Cache) Verify() ([]CacheEntry, error) {
+	var corrupted []CacheEntry
+	for _, e := range c.List() {
+		f, err := os.Open(c.path(e.FileHash))
+		if err != nil {
+			if os.IsNotExist(err) {
+				c.drop(e.FileHash)
+				corrupted = append(corrupted, e)
+				continue
+			}
+			return nil, err
+		}
+		valid, err := verifyFile(f, e.FileHash)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if !valid {
+			if err := c.Remove(e.FileHash); err != nil {
+				return nil, err
+			}
+			corrupted = append(corrupted, e)
+			continue
+		}
+		c.mu.Lock()
+		if el, ok := c.entries[e.FileHash]; ok {
+			el.Value.(*CacheEntry).verified = true
+		}
+		c.mu.Unlock()
+	}
+	return corrupted, nil
+}
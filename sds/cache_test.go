@@ -0,0 +1,191 @@
+package sds
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commitData(t *testing.T, c *Cache, data []byte) string {
+	fileHash := CreateFileHash(data)
+	tmp, err := c.Create(fileHash)
+	require.NoError(t, err)
+	defer tmp.Close()
+	_, err = tmp.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, c.Commit(tmp, fileHash, int64(len(data))))
+	return fileHash
+}
+
+// cacheFiles returns the names of the files of the folder of the cached
+// files
+func cacheFiles(t *testing.T, c *Cache) []string {
+	entries, err := os.ReadDir(c.files)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestCacheDir(t *testing.T) {
+	assert.Equal(t, filepath.Join("/repo", DefaultCacheFolder), CacheDir(&config.Sds{}, "/repo"))
+	assert.Equal(t, filepath.Join("/repo", "cache"), CacheDir(&config.Sds{CacheFolder: "cache"}, "/repo"))
+	assert.Equal(t, "/var/cache/sds", CacheDir(&config.Sds{CacheFolder: "/var/cache/sds"}, "/repo"))
+	// the legacy default is not used
+	assert.Equal(t, filepath.Join("/repo", DefaultCacheFolder), CacheDir(&config.Sds{CacheFolder: "/tmp/"}, "/repo"))
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := newCache(t.TempDir(), 25)
+	require.NoError(t, err)
+
+	first := commitData(t, c, []byte("first file"))
+	second := commitData(t, c, []byte("second file"))
+
+	// using the first file makes the second one the least recently used
+	f, _, err := c.Open(first)
+	require.NoError(t, err)
+	require.NotNil(t, f)
+	f.Close()
+
+	third := commitData(t, c, []byte("third file"))
+
+	f, _, err = c.Open(second)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	_, err = os.Stat(c.path(second))
+	assert.True(t, os.IsNotExist(err))
+
+	stat := c.Stat()
+	assert.Equal(t, 2, stat.Files)
+	assert.Equal(t, int64(20), stat.Size)
+
+	var hashes []string
+	for _, e := range c.List() {
+		hashes = append(hashes, e.FileHash)
+	}
+	assert.Equal(t, []string{third, first}, hashes)
+
+	// the cache content is found again after a restart
+	reopened, err := newCache(c.dir, 25)
+	require.NoError(t, err)
+	assert.Equal(t, stat.Files, reopened.Stat().Files)
+	assert.Equal(t, stat.Size, reopened.Stat().Size)
+}
+
+func TestCacheRejectsCorruptedFiles(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newCache(dir, 1024)
+	require.NoError(t, err)
+
+	// a download not matching its hash is not committed
+	tmp, err := c.Create(CreateFileHash([]byte("expected")))
+	require.NoError(t, err)
+	_, err = tmp.Write([]byte("received"))
+	require.NoError(t, err)
+	assert.Error(t, c.Commit(tmp, CreateFileHash([]byte("expected")), 8))
+	tmp.Close()
+	assert.Zero(t, c.Stat().Files)
+
+	fileHash := commitData(t, c, []byte("hello sds"))
+	require.NoError(t, os.WriteFile(c.path(fileHash), []byte("hello sdz"), 0o644))
+
+	corrupted, err := c.Verify()
+	require.NoError(t, err)
+	require.Len(t, corrupted, 1)
+	assert.Equal(t, fileHash, corrupted[0].FileHash)
+	assert.Zero(t, c.Stat().Files)
+
+	// files found on disk are checked before their first use
+	fileHash = commitData(t, c, []byte("hello sds"))
+	require.NoError(t, os.WriteFile(c.path(fileHash), []byte("hello sdz"), 0o644))
+	reopened, err := newCache(dir, 1024)
+	require.NoError(t, err)
+	f, _, err := reopened.Open(fileHash)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+}
+
+func TestCacheClear(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newCache(dir, 1024)
+	require.NoError(t, err)
+
+	commitData(t, c, []byte("hello sds"))
+	// left by a download interrupted before a restart
+	leftover := filepath.Join(c.files, "leftover.1"+partSuffix)
+	require.NoError(t, os.WriteFile(leftover, []byte("hello"), 0o644))
+	// a download in progress keeps its part
+	data := []byte("in progress")
+	part, err := c.Create(CreateFileHash(data))
+	require.NoError(t, err)
+	defer part.Close()
+
+	require.NoError(t, c.Clear())
+	assert.Zero(t, c.Stat().Files)
+	assert.Equal(t, []string{filepath.Base(part.Name())}, cacheFiles(t, c))
+
+	_, err = part.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, c.Commit(part, CreateFileHash(data), int64(len(data))))
+	assert.Equal(t, 1, c.Stat().Files)
+
+	// once done, the part is not kept anymore
+	require.NoError(t, c.Clear())
+	assert.Empty(t, cacheFiles(t, c))
+}
+
+func TestCacheSharedFolder(t *testing.T) {
+	dir := t.TempDir()
+	// the files of the other programs sharing the folder
+	others := []string{"notes", "spool", CreateFileHash([]byte("other"))}
+	for _, name := range others {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("not cached"), 0o644))
+	}
+	c, err := newCache(dir, 1)
+	require.NoError(t, err)
+	// only the file hashes are cached files
+	require.NoError(t, os.WriteFile(filepath.Join(c.files, "notes"), []byte("not cached"), 0o644))
+
+	commitData(t, c, []byte("hello sds"))
+	assert.Zero(t, c.Stat().Files)
+	require.NoError(t, c.Clear())
+	_, err = c.Verify()
+	require.NoError(t, err)
+	reopened, err := newCache(dir, 1024)
+	require.NoError(t, err)
+	assert.Zero(t, reopened.Stat().Files)
+
+	for _, name := range others {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+	}
+	assert.Equal(t, []string{"notes"}, cacheFiles(t, c))
+}
+
+func TestCacheSpoolDir(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newCache(dir, 1024)
+	require.NoError(t, err)
+
+	tf, err := SpoolFile(c.SpoolDir(), bytes.NewReader([]byte("hello sds")))
+	require.NoError(t, err)
+	// spooled files are not cached files
+	assert.Zero(t, c.Stat().Files)
+
+	// the files left by a previous run are removed on open
+	_, err = newCache(dir, 1024)
+	require.NoError(t, err)
+	_, err = os.Stat(tf.Name())
+	assert.True(t, os.IsNotExist(err))
+	tf.File.Close()
+}
@@ -1,7 +1,6 @@
 package sds
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -14,9 +13,13 @@ import (
 	cid "github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
 	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	"github.com/ipfs/kubo/tracing"
 	gocar "github.com/ipld/go-car"
 	gocarv2 "github.com/ipld/go-car/v2"
 	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -49,7 +52,105 @@ func (dp *DagParser) Get(_ context.Context, c cid.Cid) (blocks.Block, error) {
 	return dp.dag.Get(dp.ctx, c)
 }
 
+// countingGetter counts the blocks written into a CAR
+type countingGetter struct {
+	dp     *DagParser
+	blocks int64
+}
+
+func (cg *countingGetter) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	b, err := cg.dp.Get(ctx, c)
+	if err == nil {
+		cg.blocks++
+	}
+	return b, err
+}
+
 func (dp *DagParser) Import(file files.File, doPinRoots bool) (path.Path, error) {
+	car, err := gocarv2.NewBlockReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dp.importBlocks(car, doPinRoots); err != nil {
+		return nil, err
+	}
+
+	return rootPath(car)
+}
+
+// ImportAsync reads the CAR header and returns the root path right away,
+// while the blocks are imported in the background as they are read from the
+// file. The import result is sent to the returned channel once it is over.
+//
+// This allows to start serving the content of a CAR which is still being
+// downloaded: blocks added by the import are delivered to pending requests.
+func (dp *DagParser) ImportAsync(file files.File, doPinRoots bool) (path.Path, <-chan error, error) {
+	car, err := gocarv2.NewBlockReader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p, err := rootPath(car)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- dp.importBlocks(car, doPinRoots)
+	}()
+	return p, done, nil
+}
+
+// CARImport is a CAR import running in the background
+type CARImport struct {
+	// Root is the path of the root of the imported DAG
+	Root path.Path
+	done chan struct{}
+	err  error
+}
+
+// StartImport starts importing the CAR of file in the background like
+// ImportAsync, file is closed once the import is over
+func (dp *DagParser) StartImport(file files.File, doPinRoots bool) (*CARImport, error) {
+	p, done, err := dp.ImportAsync(file, doPinRoots)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	ci := &CARImport{Root: p, done: make(chan struct{})}
+	go func() {
+		defer close(ci.done)
+		defer file.Close()
+		ci.err = <-done
+	}()
+	return ci, nil
+}
+
+// Done is closed once the import is over
+func (ci *CARImport) Done() <-chan struct{} {
+	return ci.done
+}
+
+// Wait waits for the import to be over and returns its error
+func (ci *CARImport) Wait() error {
+	<-ci.done
+	return ci.err
+}
+
+func rootPath(car *gocarv2.BlockReader) (path.Path, error) {
+	if len(car.Roots) == 0 {
+		return nil, fmt.Errorf("car file has no roots")
+	}
+	return path.NewPath("/ipfs/" + car.Roots[0].String())
+}
+
+func (dp *DagParser) importBlocks(car *gocarv2.BlockReader, doPinRoots bool) (err error) {
+	_, span := tracing.Span(dp.ctx, "Sds.DagParser", "Import", trace.WithAttributes(attribute.Bool("pin", doPinRoots)))
+	defer span.End()
+
 	blockDecoder := ipldlegacy.NewDecoder()
 
 	// grab a pinlock ( which doubles as a GC lock ) so that regardless of the
@@ -70,6 +171,12 @@ func (dp *DagParser) Import(file files.File, doPinRoots bool) (path.Path, error)
 
 	roots := cid.NewSet()
 	var blockCount, blockBytesCount uint64
+	defer func() {
+		span.SetAttributes(attribute.Int64("blocks", int64(blockCount)), attribute.Int64("bytes", int64(blockBytesCount)))
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}()
 
 	// remember last valid block and provide a meaningful error message
 	// when a truncated/mangled CAR is being imported
@@ -85,39 +192,30 @@ func (dp *DagParser) Import(file files.File, doPinRoots bool) (path.Path, error)
 
 	var previous blocks.Block
 
-	car, err := gocarv2.NewBlockReader(file)
-	fmt.Println("Import NewBlockReader car err", car, err)
-	if err != nil {
-		return nil, err
-	}
-
 	for _, c := range car.Roots {
 		roots.Add(c)
 	}
 
-	fmt.Println("Import car.Roots", car.Roots)
-
 	for {
 		block, err := car.Next()
 		if err != nil && err != io.EOF {
-			return nil, importError(previous, block, err)
+			return importError(previous, block, err)
 		} else if block == nil {
 			break
 		}
 		if len(block.RawData()) > SoftBlockLimit {
 			err = fmt.Errorf("produced block is over 1MiB: big blocks can't be exchanged with other peers. consider using UnixFS for automatic chunking of bigger files, or pass --allow-big-block to override")
-			return nil, importError(previous, block, err)
+			return importError(previous, block, err)
 		}
 
 		// the double-decode is suboptimal, but we need it for batching
 		nd, err := blockDecoder.DecodeNode(dp.ctx, block)
-		fmt.Println("Import DecodeNode nd err", nd, err)
 		if err != nil {
-			return nil, importError(previous, block, err)
+			return importError(previous, block, err)
 		}
 
 		if err := batch.Add(dp.ctx, nd); err != nil {
-			return nil, importError(previous, block, err)
+			return importError(previous, block, err)
 		}
 		blockCount++
 		blockBytesCount += uint64(len(block.RawData()))
@@ -125,11 +223,11 @@ func (dp *DagParser) Import(file files.File, doPinRoots bool) (path.Path, error)
 	}
 
 	if err := batch.Commit(); err != nil {
-		return nil, err
+		return err
 	}
 
 	if doPinRoots {
-		err = roots.ForEach(func(c cid.Cid) error {
+		return roots.ForEach(func(c cid.Cid) error {
 			// This will trigger a full read of the DAG in the pinner, to make sure we have all blocks.
 			// Ideally we would do colloring of the pinning state while importing the blocks
 			// and ensure the gray bucket is empty at the end (or use the network to download missing blocks).
@@ -150,30 +248,55 @@ func (dp *DagParser) Import(file files.File, doPinRoots bool) (path.Path, error)
 
 			return nil
 		})
-		if err != nil {
-			return nil, err
-		}
 	}
 
-	p, err := path.NewPath("/ipfs/" + car.Roots[0].String())
-	fmt.Println("Import NewPath p err", p, err)
+	return nil
+}
+
+// CARSize returns the size of the CAR Export writes for the DAG under rootCid,
+// the DAG is traversed without writing the CAR
+func (dp *DagParser) CARSize(rootCid cid.Cid) (int64, error) {
+	ctx, span := tracing.Span(dp.ctx, "Sds.DagParser", "CARSize", trace.WithAttributes(attribute.String("root", rootCid.String())))
+	defer span.End()
+
+	dag := gocar.Dag{Root: rootCid, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
+	car := gocar.NewSelectiveCar(ctx, &countingGetter{dp: dp}, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
+	prepared, err := car.Prepare()
 	if err != nil {
-		return nil, err
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
 	}
-
-	return p, nil
+	return int64(prepared.Size()), nil
 }
 
-func (dp *DagParser) Export(rootCid cid.Cid) (files.File, error) {
-	var b bytes.Buffer
+// Export writes the DAG under rootCid as a CAR into a temporary file in
+// spoolDir, so large DAGs do not have to fit in memory. Closing the returned
+// file removes it.
+func (dp *DagParser) Export(rootCid cid.Cid, spoolDir string) (*TempFile, error) {
+	ctx, span := tracing.Span(dp.ctx, "Sds.DagParser", "Export", trace.WithAttributes(attribute.String("root", rootCid.String())))
+	defer span.End()
+
+	pr, pw := io.Pipe()
 
 	dag := gocar.Dag{Root: rootCid, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
 	// TraverseLinksOnlyOnce is safe for an exhaustive selector but won't be when we allow
 	// arbitrary selectors here
-	car := gocar.NewSelectiveCar(dp.ctx, dp, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
-	if err := car.Write(&b); err != nil {
+	cg := &countingGetter{dp: dp}
+	car := gocar.NewSelectiveCar(ctx, cg, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		pw.CloseWithError(car.Write(pw))
+	}()
+
+	tf, err := SpoolFile(spoolDir, pr)
+	// unblock the writer in case spooling stopped early
+	pr.Close()
+	<-written
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
-
-	return files.NewBytesFile(b.Bytes()), nil
+	span.SetAttributes(attribute.Int64("blocks", cg.blocks), attribute.Int64("bytes", tf.size))
+	return tf, nil
 }
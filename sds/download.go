@@ -0,0 +1,332 @@
+package sds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/boxo/files"
+)
+
+// span is a written [start, end) range of a downloading file
+type span struct {
+	start, end int64
+}
+
+// download is a file being fetched from sds. Chunks are written into the
+// backing file at their offsets as soon as they arrive, so readers only wait
+// for the range they need instead of the whole file.
+//
+// A download is cancelled when its last reader is closed before the end.
+type download struct {
+	file *os.File
+	// ctx is the context of the fetch, cancelled by cancel
+	ctx context.Context
+	// cancel stops the fetch of the download, nil when it is complete
+	cancel context.CancelCauseFunc
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	written   []span
+	size      int64
+	done      bool
+	err       error
+	refs      int
+	cancelled bool
+}
+
+// errDownloadAbandoned cancels the downloads which have no reader left
+var errDownloadAbandoned = errors.New("download abandoned by all its readers")
+
+func newDownload(file *os.File) *download {
+	d := &download{file: file}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// newCompleteDownload wraps an already fully downloaded file
+func newCompleteDownload(file *os.File, size int64) *download {
+	d := newDownload(file)
+	d.written = []span{{0, size}}
+	d.size = size
+	d.done = true
+	return d
+}
+
+// write stores a chunk received at offset off
+func (d *download) write(data []byte, off int64) error {
+	if _, err := d.file.WriteAt(data, off); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.written = addSpan(d.written, span{off, off + int64(len(data))})
+	d.cond.Broadcast()
+	return nil
+}
+
+// finish marks the download as over, err is set when it failed
+func (d *download) finish(size int64, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.size = size
+	d.err = err
+	d.done = true
+	d.cond.Broadcast()
+	if d.refs == 0 {
+		d.file.Close()
+	}
+}
+
+// available blocks until the byte at off is downloaded and returns the
+// number of contiguous bytes readable from there
+func (d *download) available(ctx context.Context, off int64) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		for _, s := range d.written {
+			if s.start <= off && off < s.end {
+				return s.end - off, nil
+			}
+		}
+		if d.done {
+			if d.err != nil {
+				return 0, d.err
+			}
+			if off >= d.size {
+				return 0, io.EOF
+			}
+			return 0, fmt.Errorf("missing data at offset %d of downloaded file", off)
+		}
+		d.cond.Wait()
+	}
+}
+
+// waitSize blocks until the download is over and returns the file size
+func (d *download) waitSize(ctx context.Context) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for !d.done {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		d.cond.Wait()
+	}
+	return d.size, d.err
+}
+
+func (d *download) knownSize() (int64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size, d.done && d.err == nil
+}
+
+// newReader returns a reader of the download, which is closed when ctx is
+// done. It returns nil when the download was cancelled.
+func (d *download) newReader(ctx context.Context) *Reader {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancelled {
+		return nil
+	}
+	d.refs++
+
+	r := &Reader{d: d, ctx: ctx, max: maxSizeFrom(ctx)}
+	r.stop = context.AfterFunc(ctx, func() {
+		// wake up the reads waiting for data, they return the ctx error
+		d.mu.Lock()
+		d.cond.Broadcast()
+		d.mu.Unlock()
+		r.Close()
+	})
+	return r
+}
+
+func (d *download) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refs--
+	if d.refs > 0 {
+		return
+	}
+	if d.done {
+		d.file.Close()
+	} else if d.cancel != nil {
+		d.cancelled = true
+		d.cancel(errDownloadAbandoned)
+	}
+}
+
+// addSpan inserts s into the sorted list of written spans merging the
+// overlapping or adjacent ones
+func addSpan(spans []span, s span) []span {
+	if s.start >= s.end {
+		return spans
+	}
+	spans = append(spans, s)
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	merged := spans[:1]
+	for _, cur := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if cur.start <= last.end {
+			if cur.end > last.end {
+				last.end = cur.end
+			}
+			continue
+		}
+		merged = append(merged, cur)
+	}
+	return merged
+}
+
+// Reader reads a file downloaded from sds. It could be used while the
+// download is still in progress: reads block until the requested bytes
+// arrive, and only operations which need the file size wait for the whole
+// download to complete.
+type Reader struct {
+	d   *download
+	ctx context.Context
+	// max is the size past which the reads fail with ErrFileTooLarge, zero
+	// when unlimited, so a download shared with a reader without limit is
+	// still stopped for this one
+	max    int64
+	stop   func() bool
+	offset int64
+	closed atomic.Bool
+}
+
+var _ files.File = (*Reader)(nil)
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.closed.Load() {
+		if err := r.ctx.Err(); err != nil {
+			return 0, err
+		}
+		return 0, os.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := r.d.available(r.ctx, r.offset)
+	if err != nil {
+		return 0, r.stopped(err)
+	}
+	if r.tooLarge(r.offset + n) {
+		return 0, ErrFileTooLarge
+	}
+	if n > int64(len(p)) {
+		n = int64(len(p))
+	}
+
+	m, err := r.d.file.ReadAt(p[:n], r.offset)
+	r.offset += int64(m)
+	if errors.Is(err, io.EOF) && m > 0 {
+		err = nil
+	}
+	return m, err
+}
+
+// ReadAt reads len(p) bytes at off, waiting for them to be downloaded. It
+// does not move the offset of Read.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if r.closed.Load() {
+		if err := r.ctx.Err(); err != nil {
+			return 0, err
+		}
+		return 0, os.ErrClosed
+	}
+
+	read := 0
+	for read < len(p) {
+		n, err := r.d.available(r.ctx, off+int64(read))
+		if err != nil {
+			return read, r.stopped(err)
+		}
+		if r.tooLarge(off + int64(read) + n) {
+			return read, ErrFileTooLarge
+		}
+		n = min(n, int64(len(p)-read))
+		m, err := r.d.file.ReadAt(p[read:read+int(n)], off+int64(read))
+		read += m
+		if err != nil && (!errors.Is(err, io.EOF) || m == 0) {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed.Load() {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		size, err := r.d.waitSize(r.ctx)
+		if err != nil {
+			return 0, r.stopped(err)
+		}
+		offset += size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Size returns the file size, waiting for the download to complete.
+func (r *Reader) Size() (int64, error) {
+	size, err := r.d.waitSize(r.ctx)
+	if err == nil && r.tooLarge(size) {
+		return 0, ErrFileTooLarge
+	}
+	return size, r.stopped(err)
+}
+
+// tooLarge reports if a file of size bytes is over the limit of the reader
+func (r *Reader) tooLarge(size int64) bool {
+	return r.max > 0 && size > r.max
+}
+
+// stopped closes the reader when its context is done, so the download is
+// already released when the caller gets the ctx error. It returns err.
+func (r *Reader) stopped(err error) error {
+	if err != nil && r.ctx.Err() != nil {
+		r.Close()
+	}
+	return err
+}
+
+// KnownSize returns the file size without blocking, the second value
+// reports if the download is already complete and the size is known.
+func (r *Reader) KnownSize() (int64, bool) {
+	return r.d.knownSize()
+}
+
+// Close releases the download, which is cancelled when it has no reader
+// left. It is called when the context of the reader is done.
+func (r *Reader) Close() error {
+	if r.closed.Swap(true) {
+		return nil
+	}
+	r.stop()
+	r.d.release()
+	return nil
+}
@@ -0,0 +1,224 @@
+package sds
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddSpan(t *testing.T) {
+	var spans []span
+	spans = addSpan(spans, span{10, 20})
+	spans = addSpan(spans, span{30, 40})
+	assert.Equal(t, []span{{10, 20}, {30, 40}}, spans)
+
+	spans = addSpan(spans, span{0, 10})
+	assert.Equal(t, []span{{0, 20}, {30, 40}}, spans)
+
+	spans = addSpan(spans, span{15, 35})
+	assert.Equal(t, []span{{0, 40}}, spans)
+}
+
+func TestReaderReadAt(t *testing.T) {
+	fileData := []byte("hello sds, read at any offset")
+	tmp, err := os.CreateTemp(t.TempDir(), "download")
+	require.NoError(t, err)
+
+	d := newDownload(tmp)
+	r := d.newReader(context.Background())
+	defer r.Close()
+
+	// the read spans the chunks, waiting for the missing one
+	require.NoError(t, d.write(fileData[:10], 0))
+	read := make(chan []byte)
+	go func() {
+		buf := make([]byte, 15)
+		n, _ := r.ReadAt(buf, 5)
+		read <- buf[:n]
+	}()
+	select {
+	case <-read:
+		t.Fatal("read returned before the data was downloaded")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, d.write(fileData[10:], 10))
+	d.finish(int64(len(fileData)), nil)
+	assert.Equal(t, fileData[5:20], <-read)
+
+	// the offset of Read is not moved
+	buf := make([]byte, 5)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, fileData[:5], buf[:n])
+
+	buf = make([]byte, 10)
+	n, err = r.ReadAt(buf, int64(len(fileData))-4)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, fileData[len(fileData)-4:], buf[:n])
+}
+
+func TestReaderStreamsWhileDownloading(t *testing.T) {
+	fileData := make([]byte, 300)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+
+	tmp, err := os.CreateTemp(t.TempDir(), "download")
+	require.NoError(t, err)
+
+	d := newDownload(tmp)
+	r := d.newReader(context.Background())
+	defer r.Close()
+
+	_, known := r.KnownSize()
+	assert.False(t, known)
+
+	// chunks could arrive out of order
+	require.NoError(t, d.write(fileData[100:200], 100))
+
+	_, err = r.Seek(150, io.SeekStart)
+	require.NoError(t, err)
+	buf := make([]byte, 100)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, fileData[150:200], buf[:n])
+
+	// reading from the start blocks until the first chunk arrives
+	read := make(chan []byte)
+	go func() {
+		_, err := r.Seek(0, io.SeekStart)
+		if err != nil {
+			close(read)
+			return
+		}
+		data, _ := io.ReadAll(r)
+		read <- data
+	}()
+
+	select {
+	case <-read:
+		t.Fatal("read returned before the data was downloaded")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, d.write(fileData[:100], 0))
+	require.NoError(t, d.write(fileData[200:], 200))
+	d.finish(int64(len(fileData)), nil)
+
+	assert.Equal(t, fileData, <-read)
+
+	size, err := r.Size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(fileData)), size)
+
+	pos, err := r.Seek(-10, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(290), pos)
+}
+
+func TestReaderDownloadFailure(t *testing.T) {
+	tmp, err := os.CreateTemp(t.TempDir(), "download")
+	require.NoError(t, err)
+
+	d := newDownload(tmp)
+	r := d.newReader(context.Background())
+	defer r.Close()
+
+	require.NoError(t, d.write([]byte("hello"), 0))
+
+	failure := errors.New("pp went away")
+	go d.finish(0, failure)
+
+	data, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, failure)
+	assert.Equal(t, []byte("hello"), data)
+
+	_, err = r.Size()
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestReaderContext(t *testing.T) {
+	tmp, err := os.CreateTemp(t.TempDir(), "download")
+	require.NoError(t, err)
+
+	d := newDownload(tmp)
+	dctx, cancel := context.WithCancelCause(context.Background())
+	d.cancel = cancel
+
+	ctx, cancelReader := context.WithCancel(context.Background())
+	r := d.newReader(ctx)
+	other := d.newReader(context.Background())
+
+	read := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 10))
+		read <- err
+	}()
+
+	// the pending read is woken up when the context of the reader is done
+	cancelReader()
+	select {
+	case err := <-read:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read is still blocked after the context was cancelled")
+	}
+	require.NoError(t, dctx.Err(), "the download still has a reader")
+
+	// the download is cancelled once its last reader is gone
+	require.NoError(t, other.Close())
+	assert.ErrorIs(t, context.Cause(dctx), errDownloadAbandoned)
+	assert.Nil(t, d.newReader(context.Background()))
+}
+
+func TestReaderMaxSize(t *testing.T) {
+	fileData := []byte("hello sds, over the limit")
+	ctx := withMaxSize(context.Background(), 10)
+	tmp, err := os.CreateTemp(t.TempDir(), "download")
+	require.NoError(t, err)
+
+	// the download is shared with a reader without limit
+	d := newDownload(tmp)
+	unlimited := d.newReader(context.Background())
+	defer unlimited.Close()
+	r := d.newReader(ctx)
+	defer r.Close()
+
+	require.NoError(t, d.write(fileData[:5], 0))
+	buf := make([]byte, 20)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, fileData[:5], buf[:n])
+
+	// the reader stops past its limit as the data arrives
+	require.NoError(t, d.write(fileData[5:], 5))
+	_, err = r.Read(buf)
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+	_, err = r.ReadAt(buf, 0)
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+	d.finish(int64(len(fileData)), nil)
+	_, err = r.Size()
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+
+	data, err := io.ReadAll(unlimited)
+	require.NoError(t, err)
+	assert.Equal(t, fileData, data)
+
+	// a cached file is refused right away
+	cached := func(size int64) *Reader {
+		f, err := os.Open(tmp.Name())
+		require.NoError(t, err)
+		return newCompleteDownload(f, size).newReader(ctx)
+	}
+	_, err = joined(ctx, cached(int64(len(fileData))), nil)
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+	f, err := joined(ctx, cached(10), nil)
+	require.NoError(t, err)
+	f.Close()
+}
@@ -0,0 +1,187 @@
+package sds
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/kubo/core/coreiface/sdserr"
+	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
+)
+
+// The sds errors are defined along with their codes in sdserr, so the api
+// clients could match them without importing this package.
+var (
+	// ErrAlreadyExists is returned when uploading a file sds already stores
+	ErrAlreadyExists = sdserr.ErrAlreadyExists
+	// ErrInsufficientOzone is returned when the wallet cannot pay for the
+	// operation, the wallet needs to be topped up
+	ErrInsufficientOzone = sdserr.ErrInsufficientOzone
+	// ErrShareNotFound is returned when a share link does not exist, or is
+	// not known to have existed
+	ErrShareNotFound = sdserr.ErrShareNotFound
+	// ErrShareExpired is returned when a share link created by the node
+	// expired or was revoked
+	ErrShareExpired = sdserr.ErrShareExpired
+	// ErrPPUnavailable is returned when no pp node could serve the request,
+	// the request could be retried later
+	ErrPPUnavailable = sdserr.ErrPPUnavailable
+	// ErrBudgetExceeded is returned when an operation would spend more ozone
+	// than the budgets of Sds.Budget allow
+	ErrBudgetExceeded = sdserr.ErrBudgetExceeded
+)
+
+// returnMessages describes the failure codes of rpc_api results
+var returnMessages = map[string]string{
+	rpc_api.GENERIC_ERR:                   "generic error",
+	rpc_api.SIGNATURE_FAILURE:             "signature verification failed",
+	rpc_api.WRONG_FILE_SIZE:               "wrong file size",
+	rpc_api.TIME_OUT:                      "timeout",
+	rpc_api.FILE_REQ_FAILURE:              "file request failed",
+	rpc_api.WRONG_INPUT:                   "wrong input",
+	rpc_api.WRONG_PP_ADDRESS:              "wrong pp address",
+	rpc_api.INTERNAL_DATA_FAILURE:         "internal data failure",
+	rpc_api.INTERNAL_COMM_FAILURE:         "internal communication failure",
+	rpc_api.WRONG_FILE_INFO:               "wrong file info",
+	rpc_api.WRONG_WALLET_ADDRESS:          "wrong wallet address",
+	rpc_api.CONFLICT_WITH_ANOTHER_SESSION: "conflict with another session",
+	rpc_api.SESSION_STOPPED:               "session stopped",
+}
+
+// RPCError is a failure answered by a pp node, either as a JSON-RPC error,
+// an http error or a failure code in the Return of a result. It unwraps to
+// one of the sds errors when the failure is a known condition.
+type RPCError struct {
+	// Method is the JSON-RPC method which failed
+	Method string
+	// Code is the JSON-RPC error code, zero when the failure is reported
+	// otherwise
+	Code int
+	// Return is the Return of the result, a code or a message forwarded by
+	// the pp
+	Return string
+	// HTTPStatus is the status of an http error
+	HTTPStatus int
+	Message    string
+
+	kind error
+}
+
+func (e *RPCError) Error() string {
+	var detail string
+	switch {
+	case e.Code != 0:
+		detail = fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+	case e.HTTPStatus != 0:
+		detail = fmt.Sprintf("http status %d: %s", e.HTTPStatus, e.Message)
+	case e.Message != e.Return:
+		detail = fmt.Sprintf("%s (return %s)", e.Message, e.Return)
+	default:
+		detail = e.Message
+	}
+
+	msg := fmt.Sprintf("pp failed on %s: %s", e.Method, detail)
+	if e.kind != nil {
+		msg = e.kind.Error() + ": " + msg
+	}
+	return msg
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.kind
+}
+
+// isReturnOK reports if the Return of a result is a success or a step of a
+// session
+func isReturnOK(ret string) bool {
+	switch ret {
+	case rpc_api.SUCCESS, rpc_api.UPLOAD_DATA, rpc_api.DOWNLOAD_OK, rpc_api.DL_OK_ASK_INFO, rpc_api.SHARED_DL_START:
+		return true
+	}
+	return false
+}
+
+// checkReturn returns the error reported in the Return of a result of method
+func checkReturn(method, ret string) error {
+	if isReturnOK(ret) {
+		return nil
+	}
+
+	e := &RPCError{Method: method, Return: ret, Message: ret}
+	if msg, ok := returnMessages[ret]; ok {
+		e.Message = msg
+	}
+
+	// the sp messages are forwarded as is by the pp when they have no code,
+	// the pp itself checks the duplicates by this message
+	switch {
+	case strings.Contains(ret, "Same file with the name"):
+		e.kind = ErrAlreadyExists
+	case (method == "user_requestGetShared" || method == "user_requestStopShare") && ret == rpc_api.FILE_REQ_FAILURE:
+		e.kind = ErrShareNotFound
+	case ret == rpc_api.TIME_OUT || ret == rpc_api.INTERNAL_COMM_FAILURE:
+		e.kind = ErrPPUnavailable
+	}
+	return e
+}
+
+// withBalance marks the refusal of an operation costing cost as
+// ErrInsufficientOzone when the ozone balance could not pay for it. The pp
+// has no code for it, it forwards the message of the sp, so the balance
+// taken before the request tells why the operation was refused.
+func withBalance(err error, balance string, cost int64) error {
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.kind != nil || rpcErr.Code != 0 || rpcErr.HTTPStatus != 0 {
+		return err
+	}
+	ozone, perr := strconv.ParseInt(balance, 10, 64)
+	if perr == nil && ozone < max(cost, 1) {
+		rpcErr.kind = ErrInsufficientOzone
+	}
+	return err
+}
+
+// withShare marks the refusal of a share link as ErrShareExpired when the
+// node created the share and it expired or was revoked since. The pp answers
+// the same failure for the links which never existed, only the records of
+// the node tell them apart.
+func withShare(err error, share *Share) error {
+	var rpcErr *RPCError
+	if share == nil || !errors.As(err, &rpcErr) || rpcErr.kind != ErrShareNotFound {
+		return err
+	}
+	if !share.Revoked.IsZero() || (!share.Expires.IsZero() && time.Now().After(share.Expires)) {
+		rpcErr.kind = ErrShareExpired
+	}
+	return err
+}
+
+// ErrorStatus returns the http status answered for err, zero when err is
+// not an sds error
+func ErrorStatus(err error) int {
+	var rpcErr *RPCError
+	switch {
+	case errors.Is(err, ErrAlreadyExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrInsufficientOzone):
+		return http.StatusPaymentRequired
+	case errors.Is(err, ErrShareNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrShareExpired):
+		return http.StatusGone
+	case errors.Is(err, ErrPPUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrBudgetExceeded), errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrFallbackDenied), errors.Is(err, ErrFileTooLarge):
+		return http.StatusForbidden
+	case errors.Is(err, ErrFallbackBusy):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &rpcErr):
+		return http.StatusBadGateway
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package sds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/coreiface/sdserr"
+	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckReturn(t *testing.T) {
+	for _, ret := range []string{rpc_api.SUCCESS, rpc_api.UPLOAD_DATA, rpc_api.DOWNLOAD_OK, rpc_api.DL_OK_ASK_INFO} {
+		assert.NoError(t, checkReturn("user_requestUpload", ret))
+	}
+
+	cases := []struct {
+		method string
+		ret    string
+		kind   error
+		status int
+	}{
+		{"user_requestUpload", "Same file with the name already exists", ErrAlreadyExists, http.StatusConflict},
+		{"user_requestUpload", "Not enough ozone to pay for the upload", nil, http.StatusBadGateway},
+		{"user_requestGetShared", rpc_api.FILE_REQ_FAILURE, ErrShareNotFound, http.StatusNotFound},
+		{"user_requestDownload", rpc_api.TIME_OUT, ErrPPUnavailable, http.StatusServiceUnavailable},
+		{"user_requestDownload", rpc_api.FILE_REQ_FAILURE, nil, http.StatusBadGateway},
+	}
+	for _, c := range cases {
+		err := checkReturn(c.method, c.ret)
+		var rpcErr *RPCError
+		require.ErrorAs(t, err, &rpcErr, c.ret)
+		assert.Equal(t, c.ret, rpcErr.Return)
+		assert.Equal(t, c.kind, errors.Unwrap(err), c.ret)
+		assert.Equal(t, c.status, ErrorStatus(err), c.ret)
+	}
+
+	assert.Equal(t, 0, ErrorStatus(errors.New("other")))
+}
+
+func TestErrorCode(t *testing.T) {
+	codes := make(map[uint]error)
+	for _, err := range []error{
+		ErrAlreadyExists, ErrInsufficientOzone, ErrShareNotFound, ErrPPUnavailable, ErrBudgetExceeded,
+		ErrRateLimited, ErrFallbackDenied, ErrFileTooLarge, ErrFallbackBusy, ErrShareExpired,
+	} {
+		code := sdserr.Code(fmt.Errorf("%w: details", err))
+		require.NotZero(t, code, err)
+		assert.NotContains(t, codes, code, err)
+		codes[code] = err
+		assert.Equal(t, err, sdserr.FromCode(code))
+	}
+
+	// the errors answered with the same status have their own codes
+	assert.Equal(t, ErrorStatus(ErrBudgetExceeded), ErrorStatus(ErrRateLimited))
+	assert.NotEqual(t, sdserr.Code(ErrBudgetExceeded), sdserr.Code(ErrRateLimited))
+	assert.Equal(t, ErrorStatus(ErrPPUnavailable), ErrorStatus(ErrFallbackBusy))
+	assert.NotEqual(t, sdserr.Code(ErrPPUnavailable), sdserr.Code(ErrFallbackBusy))
+
+	assert.Zero(t, sdserr.Code(checkReturn("user_requestDownload", rpc_api.FILE_REQ_FAILURE)))
+	assert.Nil(t, sdserr.FromCode(0))
+	assert.Nil(t, sdserr.FromCode(3))
+}
+
+func TestWithBalance(t *testing.T) {
+	refused := func() error { return checkReturn("user_requestUpload", "sp refused the upload") }
+
+	assert.ErrorIs(t, withBalance(refused(), "599", 600), ErrInsufficientOzone)
+	assert.ErrorIs(t, withBalance(refused(), "0", 0), ErrInsufficientOzone)
+	assert.Nil(t, errors.Unwrap(withBalance(refused(), "600", 600)))
+	assert.Nil(t, errors.Unwrap(withBalance(refused(), "not a balance", 600)))
+
+	// the refusals with a known reason are kept
+	err := withBalance(checkReturn("user_requestUpload", "Same file with the name already exists"), "0", 600)
+	assert.ErrorIs(t, err, ErrAlreadyExists)
+	err = withBalance(&RPCError{Method: "user_requestUpload", HTTPStatus: http.StatusBadRequest}, "0", 600)
+	assert.Nil(t, errors.Unwrap(err))
+	err = withBalance(errors.New("other"), "0", 600)
+	assert.NotErrorIs(t, err, ErrInsufficientOzone)
+}
+
+func TestWithShare(t *testing.T) {
+	refused := func() error { return checkReturn("user_requestGetShared", rpc_api.FILE_REQ_FAILURE) }
+	now := time.Now()
+
+	// the links the node did not create, or still active, are not found
+	assert.ErrorIs(t, withShare(refused(), nil), ErrShareNotFound)
+	assert.ErrorIs(t, withShare(refused(), &Share{}), ErrShareNotFound)
+	assert.ErrorIs(t, withShare(refused(), &Share{Expires: now.Add(time.Hour)}), ErrShareNotFound)
+
+	err := withShare(refused(), &Share{Expires: now.Add(-time.Hour)})
+	assert.ErrorIs(t, err, ErrShareExpired)
+	assert.Equal(t, http.StatusGone, ErrorStatus(err))
+	err = withShare(refused(), &Share{Revoked: now})
+	assert.ErrorIs(t, err, ErrShareExpired)
+	assert.NotErrorIs(t, err, ErrShareNotFound)
+
+	// the other refusals are kept
+	err = withShare(checkReturn("user_requestGetShared", rpc_api.TIME_OUT), &Share{Revoked: now})
+	assert.ErrorIs(t, err, ErrPPUnavailable)
+}
+
+func TestRpcErrorAnswers(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			http.Error(w, "bad request", status)
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"no such method"}}`))
+	}))
+	defer server.Close()
+
+	rpc, err := NewRpc(&config.Sds{RpcURLs: []string{server.URL}})
+	require.NoError(t, err)
+	defer rpc.Close()
+
+	// a JSON-RPC error is answered by a pp which is up
+	var res rpc_api.Result
+	err = rpc.sendRequest(context.Background(), "user_unknown", nil, &res)
+	var rpcErr *RPCError
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, -32601, rpcErr.Code)
+	assert.Equal(t, "no such method", rpcErr.Message)
+	assert.True(t, rpc.PPs()[0].Healthy)
+
+	status = http.StatusBadRequest
+	err = rpc.sendRequest(context.Background(), "user_unknown", nil, &res)
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, http.StatusBadRequest, rpcErr.HTTPStatus)
+
+	status = http.StatusBadGateway
+	err = rpc.sendRequest(context.Background(), "user_unknown", nil, &res)
+	assert.ErrorIs(t, err, ErrPPUnavailable)
+	assert.False(t, rpc.PPs()[0].Healthy)
+}
@@ -0,0 +1,409 @@
+package sds
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/coreiface/sdserr"
+)
+
+var (
+	// ErrFallbackDenied is returned when Gateway.SdsFallback does not allow
+	// the gateway client to download from sds
+	ErrFallbackDenied = sdserr.ErrFallbackDenied
+	// ErrRateLimited is returned when the gateway client started more
+	// downloads than Sds.Fallback.RateLimit allows
+	ErrRateLimited = sdserr.ErrRateLimited
+	// ErrFallbackBusy is returned when Sds.Fallback.MaxConcurrent downloads
+	// are already in flight
+	ErrFallbackBusy = sdserr.ErrFallbackBusy
+	// ErrFileTooLarge is returned when a download goes over
+	// Sds.Fallback.MaxSize
+	ErrFileTooLarge = sdserr.ErrFileTooLarge
+)
+
+// maxGuardEntries bounds the clients and the unknown share links tracked by
+// the guard, so a script flooding the gateway cannot grow them forever
+const maxGuardEntries = 65536
+
+// lru is a map of at most maxGuardEntries entries, the least recently used
+// one is evicted to make room for a new one
+type lru[V any] struct {
+	entries map[string]*list.Element
+	// order has the entries from the most to the least recently used
+	order *list.List
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRU[V any]() *lru[V] {
+	return &lru[V]{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the value of key and marks it used
+func (l *lru[V]) get(key string) (V, bool) {
+	e, ok := l.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*lruEntry[V]).value, true
+}
+
+// put sets the value of key and marks it used
+func (l *lru[V]) put(key string, value V) {
+	if e, ok := l.entries[key]; ok {
+		e.Value.(*lruEntry[V]).value = value
+		l.order.MoveToFront(e)
+		return
+	}
+	if l.order.Len() >= maxGuardEntries {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry[V]{key: key, value: value})
+}
+
+func (l *lru[V]) remove(key string) {
+	if e, ok := l.entries[key]; ok {
+		l.order.Remove(e)
+		delete(l.entries, key)
+	}
+}
+
+type authorizedKey struct{}
+
+// WithAuthorized returns a context of the requests of a gateway client
+// allowed the sds fallback when Gateway.SdsFallback is "authenticated"
+func WithAuthorized(ctx context.Context) context.Context {
+	return context.WithValue(ctx, authorizedKey{}, true)
+}
+
+func authorized(ctx context.Context) bool {
+	ok, _ := ctx.Value(authorizedKey{}).(bool)
+	return ok
+}
+
+type maxSizeKey struct{}
+
+// withMaxSize returns a context whose downloads and readers fail with
+// ErrFileTooLarge past max bytes
+func withMaxSize(ctx context.Context, max int64) context.Context {
+	if max <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, maxSizeKey{}, max)
+}
+
+// maxSizeFrom returns the size the downloads of the context are stopped
+// past, zero when unlimited
+func maxSizeFrom(ctx context.Context) int64 {
+	max, _ := ctx.Value(maxSizeKey{}).(int64)
+	return max
+}
+
+// bucket is the token bucket of a gateway client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refusal is the answer of sds to a share link unknown, expired or revoked,
+// cached until expiry
+type refusal struct {
+	err    error
+	expiry time.Time
+}
+
+// fallbackGuard decides which gateway requests could download from sds,
+// enforcing Gateway.SdsFallback and the limits of Sds.Fallback
+type fallbackGuard struct {
+	mode    string
+	allowed []netip.Prefix
+	// rate is the number of downloads per minute of a client, zero when
+	// unlimited
+	rate    int64
+	ttl     time.Duration
+	maxSize int64
+	// slots holds a token per download in flight, nil when unlimited
+	slots chan struct{}
+	now   func() time.Time
+
+	mu       sync.Mutex
+	buckets  *lru[*bucket]
+	negative *lru[refusal]
+}
+
+func newFallbackGuard(mode string, cfg *config.SdsFallback) (*fallbackGuard, error) {
+	switch mode {
+	case config.SdsFallbackOff, config.SdsFallbackAuthenticated, config.SdsFallbackPublic:
+	default:
+		return nil, fmt.Errorf("invalid Gateway.SdsFallback %q, expected %q, %q or %q", mode, config.SdsFallbackOff, config.SdsFallbackAuthenticated, config.SdsFallbackPublic)
+	}
+
+	g := &fallbackGuard{
+		mode:     mode,
+		rate:     cfg.RateLimit.WithDefault(config.DefaultSdsFallbackRateLimit),
+		ttl:      cfg.NegativeCacheTTL.WithDefault(config.DefaultSdsFallbackNegativeCacheTTL),
+		now:      time.Now,
+		buckets:  newLRU[*bucket](),
+		negative: newLRU[refusal](),
+	}
+
+	for _, c := range cfg.AllowedClients {
+		prefix, err := parseClient(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Sds.Fallback.AllowedClients: %w", err)
+		}
+		g.allowed = append(g.allowed, prefix)
+	}
+
+	if n := cfg.MaxConcurrent.WithDefault(config.DefaultSdsFallbackMaxConcurrent); n > 0 {
+		g.slots = make(chan struct{}, n)
+	}
+
+	if s := cfg.MaxSize.WithDefault(""); s != "" {
+		size, err := humanize.ParseBytes(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Sds.Fallback.MaxSize: %w", err)
+		}
+		g.maxSize = int64(size)
+	}
+	return g, nil
+}
+
+// TrustedProxies are the reverse proxies of Sds.Fallback.TrustedProxies, the
+// client of a request they forward is the one they add to X-Forwarded-For
+type TrustedProxies []netip.Prefix
+
+// NewTrustedProxies parses Sds.Fallback.TrustedProxies
+func NewTrustedProxies(cfg *config.SdsFallback) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, c := range cfg.TrustedProxies {
+		prefix, err := parseClient(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Sds.Fallback.TrustedProxies: %w", err)
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) contains(client string) bool {
+	addr, err := netip.ParseAddr(client)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client returns the ip address of the client of a gateway request. Every
+// proxy appends the address it got the request from to X-Forwarded-For, so
+// the client is the last address not of a trusted proxy, the ones before it
+// could be forged.
+func (p TrustedProxies) Client(r *http.Request) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	if !p.contains(client) {
+		return client
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		client = hop
+		if !p.contains(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// parseClient parses an ip address or a network in CIDR notation
+func parseClient(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// trusted reports if the client of the context is allowed by a token or by
+// Sds.Fallback.AllowedClients
+func (g *fallbackGuard) trusted(ctx context.Context) bool {
+	if authorized(ctx) {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientFrom(ctx))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range g.allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire checks the request could download id from sds, and takes a slot of
+// the downloads in flight. The returned release frees it. The trusted clients
+// are not rate limited.
+func (g *fallbackGuard) acquire(ctx context.Context, id string) (release func(), err error) {
+	trusted := g.trusted(ctx)
+	switch {
+	case g.mode == config.SdsFallbackOff:
+		return nil, ErrFallbackDenied
+	case g.mode == config.SdsFallbackAuthenticated && !trusted:
+		return nil, ErrFallbackDenied
+	}
+
+	if err := g.unknown(id); err != nil {
+		return nil, err
+	}
+	if !trusted && !g.allow(clientFrom(ctx)) {
+		return nil, ErrRateLimited
+	}
+
+	if g.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case g.slots <- struct{}{}:
+	default:
+		return nil, ErrFallbackBusy
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-g.slots }) }, nil
+}
+
+// allow takes a token from the bucket of the client, the buckets refill at
+// Sds.Fallback.RateLimit tokens per minute up to as many
+func (g *fallbackGuard) allow(client string) bool {
+	if g.rate <= 0 {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	perSecond := float64(g.rate) / 60
+	b, ok := g.buckets.get(client)
+	if !ok {
+		// an unknown client has a full bucket, like the evicted ones once
+		// refilled
+		b = &bucket{tokens: float64(g.rate), last: now}
+		g.buckets.put(client, b)
+	}
+
+	b.tokens = min(float64(g.rate), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// unknown returns the error sds answered recently for the share link id,
+// nil when the link was not refused
+func (g *fallbackGuard) unknown(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	r, ok := g.negative.get(id)
+	if !ok {
+		return nil
+	}
+	if g.now().After(r.expiry) {
+		g.negative.remove(id)
+		return nil
+	}
+	return r.err
+}
+
+// forget caches err as the answer of sds to the share link id for
+// Sds.Fallback.NegativeCacheTTL
+func (g *fallbackGuard) forget(id string, err error) {
+	if g.ttl <= 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.negative.put(id, refusal{err: err, expiry: g.now().Add(g.ttl)})
+}
+
+// download downloads id with fn within the limits of the guard. The slot of
+// the downloads in flight is held until the returned file is closed.
+func (g *fallbackGuard) download(ctx context.Context, id string, fn func(ctx context.Context) (files.File, error)) (files.File, error) {
+	release, err := g.acquire(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := fn(withMaxSize(ctx, g.maxSize))
+	if err != nil {
+		release()
+		if errors.Is(err, ErrShareNotFound) || errors.Is(err, ErrShareExpired) {
+			g.forget(id, err)
+		}
+		return nil, err
+	}
+	return &guardedFile{File: file, release: release}, nil
+}
+
+// guardedFile frees its slot of the downloads in flight when closed
+type guardedFile struct {
+	files.File
+	release func()
+}
+
+func (f *guardedFile) Close() error {
+	defer f.release()
+	return f.File.Close()
+}
+
+// ReadAt reads the guarded file at off, the files downloaded from sds
+// support it
+func (f *guardedFile) ReadAt(p []byte, off int64) (int, error) {
+	ra, ok := f.File.(io.ReaderAt)
+	if !ok {
+		return 0, errors.New("sds: the file does not support ReadAt")
+	}
+	return ra.ReadAt(p, off)
+}
@@ -0,0 +1,192 @@
+package sds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/kubo/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackGuard(t *testing.T) {
+	ctx := WithClient(context.Background(), "10.0.0.1")
+	found := func(ctx context.Context) (files.File, error) {
+		return files.NewBytesFile([]byte("content")), nil
+	}
+	notFound := func(ctx context.Context) (files.File, error) {
+		return nil, ErrShareNotFound
+	}
+
+	newGuard := func(t *testing.T, mode string, cfg config.SdsFallback) *fallbackGuard {
+		g, err := newFallbackGuard(mode, &cfg)
+		require.NoError(t, err)
+		return g
+	}
+
+	t.Run("refuses the clients by mode", func(t *testing.T) {
+		cfg := config.SdsFallback{AllowedClients: []string{"192.168.0.0/16", "::1"}}
+
+		g := newGuard(t, config.SdsFallbackOff, cfg)
+		_, err := g.download(WithAuthorized(ctx), "a", found)
+		assert.ErrorIs(t, err, ErrFallbackDenied)
+
+		g = newGuard(t, config.SdsFallbackAuthenticated, cfg)
+		_, err = g.download(ctx, "a", found)
+		assert.ErrorIs(t, err, ErrFallbackDenied)
+		for _, allowed := range []context.Context{
+			WithAuthorized(ctx),
+			WithClient(ctx, "192.168.1.2"),
+			WithClient(ctx, "::1"),
+		} {
+			f, err := g.download(allowed, "a", found)
+			require.NoError(t, err)
+			f.Close()
+		}
+
+		g = newGuard(t, config.SdsFallbackPublic, cfg)
+		f, err := g.download(ctx, "a", found)
+		require.NoError(t, err)
+		f.Close()
+
+		_, err = newFallbackGuard("private", &cfg)
+		assert.Error(t, err)
+		_, err = newFallbackGuard(config.SdsFallbackPublic, &config.SdsFallback{AllowedClients: []string{"localhost"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("rate limits the clients", func(t *testing.T) {
+		g := newGuard(t, config.SdsFallbackPublic, config.SdsFallback{RateLimit: config.NewOptionalInteger(2)})
+		now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+		g.now = func() time.Time { return now }
+
+		for i := 0; i < 2; i++ {
+			f, err := g.download(ctx, "a", found)
+			require.NoError(t, err)
+			f.Close()
+		}
+		_, err := g.download(ctx, "a", found)
+		assert.ErrorIs(t, err, ErrRateLimited)
+
+		// the other clients have their own bucket, the authorized ones none
+		f, err := g.download(WithClient(ctx, "10.0.0.2"), "a", found)
+		require.NoError(t, err)
+		f.Close()
+		f, err = g.download(WithAuthorized(ctx), "a", found)
+		require.NoError(t, err)
+		f.Close()
+
+		// a download per 30s
+		now = now.Add(30 * time.Second)
+		f, err = g.download(ctx, "a", found)
+		require.NoError(t, err)
+		f.Close()
+		_, err = g.download(ctx, "a", found)
+		assert.ErrorIs(t, err, ErrRateLimited)
+	})
+
+	t.Run("evicts the least recently seen clients", func(t *testing.T) {
+		g := newGuard(t, config.SdsFallbackPublic, config.SdsFallback{RateLimit: config.NewOptionalInteger(1)})
+		now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+		g.now = func() time.Time { return now }
+
+		for i := 0; i < maxGuardEntries; i++ {
+			require.True(t, g.allow(fmt.Sprintf("client-%d", i)))
+		}
+		// the first client is seen again, the second one is evicted for a
+		// new client
+		assert.False(t, g.allow("client-0"))
+		require.True(t, g.allow("new"))
+		assert.Equal(t, maxGuardEntries, g.buckets.order.Len())
+		assert.False(t, g.allow("client-0"))
+		assert.True(t, g.allow("client-1"))
+	})
+
+	t.Run("caches the unknown share links", func(t *testing.T) {
+		g := newGuard(t, config.SdsFallbackPublic, config.SdsFallback{})
+		now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+		g.now = func() time.Time { return now }
+
+		called := 0
+		fn := func(ctx context.Context) (files.File, error) {
+			called++
+			return notFound(ctx)
+		}
+		for i := 0; i < 3; i++ {
+			_, err := g.download(ctx, "a", fn)
+			assert.ErrorIs(t, err, ErrShareNotFound)
+		}
+		assert.Equal(t, 1, called)
+
+		now = now.Add(config.DefaultSdsFallbackNegativeCacheTTL + time.Second)
+		_, err := g.download(ctx, "a", fn)
+		assert.ErrorIs(t, err, ErrShareNotFound)
+		assert.Equal(t, 2, called)
+
+		// the expired links are answered as they were refused
+		expired := func(ctx context.Context) (files.File, error) {
+			called++
+			return nil, ErrShareExpired
+		}
+		for i := 0; i < 2; i++ {
+			_, err = g.download(ctx, "b", expired)
+			assert.ErrorIs(t, err, ErrShareExpired)
+		}
+		assert.Equal(t, 3, called)
+	})
+
+	t.Run("caps the downloads in flight", func(t *testing.T) {
+		g := newGuard(t, config.SdsFallbackPublic, config.SdsFallback{MaxConcurrent: config.NewOptionalInteger(1)})
+
+		f, err := g.download(ctx, "a", found)
+		require.NoError(t, err)
+		_, err = g.download(ctx, "b", found)
+		assert.ErrorIs(t, err, ErrFallbackBusy)
+
+		// a failed download frees its slot
+		f.Close()
+		_, err = g.download(ctx, "b", notFound)
+		assert.ErrorIs(t, err, ErrShareNotFound)
+		f, err = g.download(ctx, "c", found)
+		require.NoError(t, err)
+		f.Close()
+	})
+
+	t.Run("limits the size of the downloads", func(t *testing.T) {
+		g := newGuard(t, config.SdsFallbackPublic, config.SdsFallback{MaxSize: config.NewOptionalString("1KiB")})
+		f, err := g.download(ctx, "a", func(ctx context.Context) (files.File, error) {
+			assert.Equal(t, int64(1024), maxSizeFrom(ctx))
+			return found(ctx)
+		})
+		require.NoError(t, err)
+		f.Close()
+	})
+}
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := NewTrustedProxies(&config.SdsFallback{TrustedProxies: []string{"10.0.0.0/8", "::1"}})
+	require.NoError(t, err)
+
+	request := func(remote string, forwarded ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/ipfs/cid", nil)
+		r.RemoteAddr = remote
+		for _, f := range forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		return r
+	}
+
+	assert.Equal(t, "192.168.1.2", proxies.Client(request("192.168.1.2:4001", "1.2.3.4")))
+	assert.Equal(t, "1.2.3.4", proxies.Client(request("10.0.0.1:4001", "1.2.3.4")))
+	assert.Equal(t, "1.2.3.4", proxies.Client(request("[::1]:4001", "5.6.7.8, 1.2.3.4, 10.0.0.2")))
+	assert.Equal(t, "1.2.3.4", proxies.Client(request("10.0.0.1:4001", "5.6.7.8", "1.2.3.4, 10.0.0.2")))
+	assert.Equal(t, "10.0.0.1", proxies.Client(request("10.0.0.1:4001")))
+
+	_, err = NewTrustedProxies(&config.SdsFallback{TrustedProxies: []string{"proxy"}})
+	assert.Error(t, err)
+}
@@ -1,197 +1,697 @@
 package sds
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"path/filepath"
-	"strings"
+	"io"
+	"sync"
+	"time"
 
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/keystore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/tracing"
 	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Fetcher struct {
 	cfg    *config.Sds
 	wallet *SdsWallet
 	rpc    *Rpc
+	cache  *Cache
+	budget *Budget
+	// trust tells the mapping files followed, the node wallet is trusted
+	trust *LinkTrust
+
+	uploads *UploadStore
+	queue   *UploadQueue
+	pins    *Pins
+	shares  *Shares
+
+	mu sync.Mutex
+	// downloads in progress by file hash
+	downloads map[string]*download
+	// uploads in progress by file hash
+	uploading map[string]struct{}
+
+	// guard limits the downloads started by the gateway requests, it is
+	// built by the first gateway of the node
+	guardOnce sync.Once
+	guard     *fallbackGuard
+	guardErr  error
 }
 
-func NewFetcher(cfg *config.Sds) (*Fetcher, error) {
-	wallet, err := NewSdsWallet(cfg.PrivateKey)
+// NewFetcher creates a fetcher saving its upload sessions into ds. The cache
+// folder is resolved from the repo path and the wallet is loaded from ks.
+func NewFetcher(cfg *config.Sds, repoPath string, ds datastore.Datastore, ks keystore.Keystore) (*Fetcher, error) {
+	wallet, err := LoadWallet(cfg, ks)
+	if err != nil {
+		return nil, err
+	}
+	rpc, err := NewRpc(cfg)
 	if err != nil {
 		return nil, err
 	}
-	rpc, err := NewRpc(cfg.RpcURL)
+	cache, err := OpenCache(cfg, repoPath)
 	if err != nil {
 		return nil, err
 	}
 
+	queue := NewUploadQueue(&cfg.Queue, ds)
 	return &Fetcher{
-		cfg:    cfg,
-		wallet: wallet,
-		rpc:    rpc,
+		cfg:       cfg,
+		wallet:    wallet,
+		rpc:       rpc,
+		cache:     cache,
+		budget:    NewBudget(&cfg.Budget, ds),
+		trust:     newNodeLinkTrust(cfg, wallet),
+		uploads:   NewUploadStore(ds),
+		queue:     queue,
+		pins:      NewPins(ds, queue),
+		shares:    NewShares(ds),
+		downloads: make(map[string]*download),
+		uploading: make(map[string]struct{}),
 	}, nil
 }
 
-func isDublErr(ret string) bool {
-	// this is sp error, means that file already exist and uploaded, so we could just link
-	return strings.Contains(ret, "Same file with the name")
-}
-
-func (f *Fetcher) Upload(fileData []byte) (string, error) {
-	fileHash := CreateFileHash(fileData)
+// Upload stores size bytes read from file into sds. The file hash is computed
+// in a streaming pass and every chunk is read on demand at the offsets
+// requested by the pp, so the file is never fully loaded into memory.
+//
+// The progress is saved in an upload session after every acknowledged chunk.
+// Uploading a file with an unfinished session resumes it: the upload is
+// requested again and the pp continues from the offsets it already has.
+// The metadata is sent to the pp and kept in the session, the root of the DAG
+// exported in the file, if any, so the file could be exported again to
+// resume the upload, and the name and content type so the resumed upload is
+// requested with them.
+func (f *Fetcher) Upload(ctx context.Context, file io.ReaderAt, size int64, meta UploadMeta) (string, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Fetcher", "Upload", trace.WithAttributes(attribute.Int64("size", size)))
+	defer span.End()
 
-	oz, err := f.rpc.GetOzone(f.wallet)
+	fileHash, err := CreateFileHashFromReader(io.NewSectionReader(file, 0, size))
 	if err != nil {
 		return "", err
 	}
+	span.SetAttributes(attribute.String("filehash", fileHash))
 
-	// TODO: How to get file name?
-	fileName, err := randomFileName(16, "txt")
+	f.mu.Lock()
+	if _, ok := f.uploading[fileHash]; ok {
+		f.mu.Unlock()
+		return "", fmt.Errorf("upload of %s is already in progress", fileHash)
+	}
+	f.uploading[fileHash] = struct{}{}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.uploading, fileHash)
+		f.mu.Unlock()
+	}()
+
+	session, err := f.uploads.Get(ctx, fileHash)
 	if err != nil {
 		return "", err
 	}
+	now := time.Now()
+	if session == nil {
+		session = &UploadSession{
+			FileHash: fileHash,
+			Size:     size,
+			Started:  now,
+		}
+	} else {
+		logger.Infof("resuming upload of %s, acknowledged up to offset %d", fileHash, session.OffsetEnd)
+	}
+	if meta.Cid.Defined() {
+		session.Cid = meta.Cid.String()
+	}
+	if meta.Name != "" {
+		session.Name = meta.Name
+	}
+	if meta.ContentType != "" {
+		session.ContentType = meta.ContentType
+	}
+	session.Status = UploadInProgress
+	session.Error = ""
+	session.Updated = now
+	if err := f.uploads.Put(ctx, session); err != nil {
+		return "", err
+	}
 
-	res, err := f.rpc.RequestUpload(f.wallet, oz.SequenceNumber, fileName, fileHash, len(fileData))
+	// the pp keeps the chunks it received, so the upload is resumed on the
+	// same pp when it is still up
+	rpc := f.rpc.Session(false)
+	resumed := session.PP != ""
+	if resumed {
+		rpc.Pin(session.PP)
+	}
+	// the upload is resumed from the last acknowledged chunk when the pp
+	// was unavailable
+	spend := f.budget.operation(opUpload, clientFrom(ctx))
+	err = f.rpc.retry(ctx, retryUpload, func() error {
+		err := f.upload(ctx, rpc, file, session, spend)
+		if resumed && errors.Is(err, ErrPPUnavailable) {
+			// the pp of the session could be gone since it was saved, the
+			// upload restarts on the other pp nodes, from the first chunk
+			logger.Warnf("pp %s of the upload of %s is unavailable, restarting the upload: %s", session.PP, fileHash, err)
+			resumed = false
+			rpc.Unpin()
+			session.PP = ""
+			session.OffsetStart, session.OffsetEnd = 0, 0
+			err = f.upload(ctx, rpc, file, session, spend)
+		}
+		return err
+	})
+	observeDuration(uploadDuration, now, err)
 	if err != nil {
-		if isDublErr(err.Error()) {
-			return fileHash, nil
+		span.SetStatus(codes.Error, err.Error())
+		session.Status = UploadFailed
+		session.Error = err.Error()
+		session.Updated = time.Now()
+		if errS := f.uploads.Put(ctx, session); errS != nil {
+			logger.Errorf("failed to save upload session of %s: %s", fileHash, errS)
 		}
 		return "", err
 	}
-	if res.Return != rpc_api.UPLOAD_DATA {
-		if isDublErr(res.Return) {
-			return fileHash, nil
+
+	if err := f.uploads.Delete(ctx, fileHash); err != nil {
+		return "", err
+	}
+	return fileHash, nil
+}
+
+// upload sends the chunks requested by the pp, saving the session progress
+// and charging the acknowledged chunks to the budget
+func (f *Fetcher) upload(ctx context.Context, rpc *Rpc, file io.ReaderAt, session *UploadSession, spend *operationSpend) error {
+	// the chunks acknowledged before a resume are already paid for
+	cost := f.budget.Cost(session.Size - int64(session.OffsetEnd))
+	oz, err := f.preflight(ctx, rpc, opUpload, cost)
+	if err != nil {
+		return err
+	}
+
+	meta := UploadMeta{Name: session.Name, ContentType: session.ContentType}
+	if session.Cid != "" {
+		meta.Cid, err = cid.Decode(session.Cid)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := rpc.RequestUpload(ctx, f.wallet, oz.SequenceNumber, session.FileHash, int(session.Size), meta)
+	if err != nil {
+		// the file is already stored, so it could just be linked
+		if errors.Is(err, ErrAlreadyExists) {
+			return nil
 		}
-		return "", fmt.Errorf("failed sp request upload with error: %s", res.Return)
+		return withBalance(err, oz.Ozone, cost)
+	}
+	if res.Return != rpc_api.UPLOAD_DATA {
+		return &RPCError{Method: "user_requestUpload", Return: res.Return, Message: "unexpected return"}
 	}
+	session.SequenceNumber = oz.SequenceNumber
+	session.PP = rpc.PP()
 
 	for res.Return == rpc_api.UPLOAD_DATA {
-		chunkData := make([]byte, *res.OffsetEnd-*res.OffsetStart)
-		copy(chunkData, fileData[*res.OffsetStart:*res.OffsetEnd])
-		fileChunk := base64.StdEncoding.EncodeToString(chunkData)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start, end := *res.OffsetStart, *res.OffsetEnd
+		chunkData, err := readChunk(file, session.Size, start, end)
 		if err != nil {
-			return "", err
+			return err
 		}
+		fileChunk := base64.StdEncoding.EncodeToString(chunkData)
 
-		res, err = f.rpc.UploadData(f.wallet, oz.SequenceNumber, fileHash, fileChunk)
+		res, err = rpc.UploadData(ctx, f.wallet, oz.SequenceNumber, session.FileHash, fileChunk)
 		if err != nil {
-			if isDublErr(err.Error()) {
-				return fileHash, nil
+			if errors.Is(err, ErrAlreadyExists) {
+				return nil
 			}
-			return "", err
+			return err
+		}
+
+		uploadChunks.Inc()
+		uploadBytes.Add(float64(len(chunkData)))
+
+		session.OffsetStart, session.OffsetEnd = start, end
+		session.Updated = time.Now()
+		if err := f.uploads.Put(ctx, session); err != nil {
+			return err
+		}
+		if err := spend.charge(ctx, f.budget.Cost(int64(len(chunkData)))); err != nil {
+			return err
 		}
 	}
 
 	if res.Return != rpc_api.SUCCESS {
-		if isDublErr(res.Return) {
-			return fileHash, nil
-		}
-		return "", fmt.Errorf("failed sp upload data with error: %s", res.Return)
+		return &RPCError{Method: "user_uploadData", Return: res.Return, Message: "unexpected return"}
 	}
 
-	return fileHash, nil
+	return nil
 }
 
-func (f *Fetcher) download(fileHash string, downloadCallback func() (*rpc_api.Result, error)) ([]byte, error) {
-	var (
-		fileSize uint64 = 0
-	)
-
-	res, err := downloadCallback()
+// Uploads returns the saved upload sessions. The sessions left in progress
+// by a previous process are reported as interrupted.
+func (f *Fetcher) Uploads(ctx context.Context) ([]*UploadSession, error) {
+	sessions, err := f.uploads.List(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if fileHash == "" {
-		fileHash = res.FileHash
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, session := range sessions {
+		if _, ok := f.uploading[session.FileHash]; !ok && session.Status == UploadInProgress {
+			session.Status = UploadInterrupted
+		}
 	}
+	return sessions, nil
+}
 
-	fmt.Printf("download res %+v\n", res)
+// readChunk reads the [start, end) range of the file requested by the pp
+func readChunk(file io.ReaderAt, size int64, start, end uint64) ([]byte, error) {
+	if start > end || end > uint64(size) {
+		return nil, fmt.Errorf("pp requested chunk [%d, %d) out of file bounds %d", start, end, size)
+	}
+	chunkData := make([]byte, end-start)
+	if _, err := io.ReadFull(io.NewSectionReader(file, int64(start), int64(end-start)), chunkData); err != nil {
+		return nil, err
+	}
+	return chunkData, nil
+}
+
+// cached returns a reader of the file if it is already downloaded or being
+// downloaded right now, nil otherwise
+func (f *Fetcher) cached(ctx context.Context, fileHash string) (*Reader, error) {
+	f.mu.Lock()
+	d, ok := f.downloads[fileHash]
+	f.mu.Unlock()
+	if ok {
+		// a cancelled download is replaced by a new one
+		if r := d.newReader(ctx); r != nil {
+			return r, nil
+		}
+	}
 
-	filePath := filepath.Join(f.cfg.CacheFolder, fileHash)
+	ff, size, err := f.cache.Open(fileHash)
+	if err != nil || ff == nil {
+		return nil, err
+	}
+	return newCompleteDownload(ff, size).newReader(ctx), nil
+}
 
-	fileData, err := readFile(filePath)
+// claim returns a reader of the file when it is cached or already being
+// downloaded. Otherwise it registers a new download of the file and returns
+// it along with a reader, so the concurrent requests of the file wait for
+// this download instead of starting their own.
+func (f *Fetcher) claim(ctx context.Context, fileHash string) (*Reader, *download, error) {
+	for {
+		r, err := f.cached(ctx, fileHash)
+		if err != nil || r != nil {
+			return r, nil, err
+		}
+
+		f.mu.Lock()
+		if d, ok := f.downloads[fileHash]; ok {
+			// a cancelled download is replaced by a new one
+			if r := d.newReader(ctx); r != nil {
+				f.mu.Unlock()
+				return r, nil, nil
+			}
+		} else if f.cache.Has(fileHash) {
+			// committed by a download which completed in the meantime
+			f.mu.Unlock()
+			continue
+		}
+		tmp, err := f.cache.Create(fileHash)
+		if err != nil {
+			f.mu.Unlock()
+			return nil, nil, err
+		}
+		d := newDownload(tmp)
+		// the download outlives the request which started it, as long as
+		// there are readers
+		d.ctx, d.cancel = context.WithCancelCause(context.WithoutCancel(ctx))
+		f.downloads[fileHash] = d
+		f.mu.Unlock()
+		return d.newReader(ctx), d, nil
+	}
+}
+
+// end drops the download from the ones in progress and tells its readers it
+// is over
+func (f *Fetcher) end(fileHash string, d *download, size int64, err error) {
+	f.mu.Lock()
+	if f.downloads[fileHash] == d {
+		delete(f.downloads, fileHash)
+	}
+	f.mu.Unlock()
+	d.finish(size, err)
+}
+
+// joined returns the reader of a file found by claim. The file is refused
+// right away when it is known to be over the size limit of ctx, the reader
+// stops past it otherwise.
+func joined(ctx context.Context, r *Reader, err error) (files.File, error) {
 	if err != nil {
 		return nil, err
 	}
-	if fileData != nil {
-		return fileData, err
+	if size, ok := r.KnownSize(); ok && r.tooLarge(size) {
+		r.Close()
+		return nil, ErrFileTooLarge
 	}
+	cacheHits.Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("cached", true))
+	return r, nil
+}
 
-	if fileData == nil {
-		fileData = make([]byte, 0)
+// download starts fetching the file and returns as soon as the pp accepted
+// the request. The data is streamed into the cache folder in the background
+// and could be read from the returned file while it is still arriving.
+//
+// The download is registered before the pp is asked when the file hash is
+// known, so a file requested concurrently is only downloaded once. The file
+// hash of a share link is only known once the pp answered.
+//
+// The download is cancelled once all its readers are closed or their context
+// is done, so a download nobody waits for does not keep spending ozone.
+func (f *Fetcher) download(ctx context.Context, fileHash string, downloadCallback func(ctx context.Context, rpc *Rpc) (*rpc_api.Result, error)) (files.File, error) {
+	start := time.Now()
+	// the download session only exists on the pp which started it
+	rpc := f.rpc.Session(true)
+
+	var (
+		r   *Reader
+		d   *download
+		res *rpc_api.Result
+		err error
+	)
+	if fileHash != "" {
+		if r, d, err = f.claim(ctx, fileHash); err != nil || d == nil {
+			return joined(ctx, r, err)
+		}
+		res, err = downloadCallback(d.ctx, rpc)
+	} else if res, err = downloadCallback(ctx, rpc); err == nil {
+		fileHash = res.FileHash
+		if r, d, err = f.claim(ctx, fileHash); err != nil || d == nil {
+			return joined(ctx, r, err)
+		}
 	}
+	logger.Debugf("download res %+v", res)
+
+	if err == nil && res.Return != rpc_api.DOWNLOAD_OK && res.Return != rpc_api.DL_OK_ASK_INFO {
+		err = &RPCError{Method: "user_requestDownload", Return: res.Return, Message: "unexpected return"}
+	}
+	if err != nil {
+		observeDuration(downloadDuration, start, err)
+		if d != nil {
+			f.cache.Abort(d.file)
+			f.end(fileHash, d, 0, err)
+			d.cancel(nil)
+			r.Close()
+		}
+		return nil, err
+	}
+	cacheMisses.Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("cached", false))
+
+	spend := f.budget.operation(opDownload, clientFrom(ctx))
+	go func() {
+		defer d.cancel(nil)
+		// the span outlives the request, it tells how long the request
+		// waited for the download
+		dctx, span := tracing.Span(d.ctx, "Sds.Fetcher", "Fetch", trace.WithAttributes(attribute.String("filehash", fileHash)))
+		defer span.End()
+
+		size, err := f.fetch(dctx, d, rpc, res, fileHash, spend)
+		if err == nil {
+			err = f.cache.Commit(d.file, fileHash, size)
+		} else {
+			f.cache.Abort(d.file)
+		}
+		observeDuration(downloadDuration, start, err)
+		span.SetAttributes(attribute.Int64("bytes", size))
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		f.end(fileHash, d, size, err)
+	}()
+
+	return r, nil
+}
+
+// fetch pulls the file chunks from the pp into d and returns the file size,
+// the chunks are charged to the budget as they arrive
+func (f *Fetcher) fetch(ctx context.Context, d *download, rpc *Rpc, res *rpc_api.Result, fileHash string, spend *operationSpend) (int64, error) {
+	var (
+		fileSize uint64 = 0
+		err      error
+	)
 
 	// Handle result:1 sending the content
 	for res.Return == rpc_api.DOWNLOAD_OK || res.Return == rpc_api.DL_OK_ASK_INFO {
+		if err := context.Cause(ctx); err != nil {
+			return 0, err
+		}
 		if res.Return == rpc_api.DL_OK_ASK_INFO {
-			res, err = f.rpc.DownloadedFileInfo(f.wallet, res.ReqId, fileHash, fileSize)
+			res, err = rpc.DownloadedFileInfo(ctx, f.wallet, res.ReqId, fileHash, fileSize)
 		} else {
 			start := *res.OffsetStart
 			end := *res.OffsetEnd
+			if max := maxSizeFrom(ctx); max > 0 && end > uint64(max) {
+				return 0, ErrFileTooLarge
+			}
 			fileSize = fileSize + (end - start)
-			decoded, _ := base64.StdEncoding.DecodeString(res.FileData)
-			fileData = append(fileData, decoded...)
-			res, err = f.rpc.DownloadData(f.wallet, res.ReqId, fileHash)
+			decoded, decErr := base64.StdEncoding.DecodeString(res.FileData)
+			if decErr != nil {
+				return 0, decErr
+			}
+			if err = d.write(decoded, int64(start)); err != nil {
+				return 0, err
+			}
+			downloadChunks.Inc()
+			downloadBytes.Add(float64(len(decoded)))
+			if err := spend.charge(ctx, f.budget.Cost(int64(len(decoded)))); err != nil {
+				return 0, err
+			}
+			res, err = rpc.DownloadData(ctx, f.wallet, res.ReqId, fileHash)
 		}
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 	}
 	if res.Return != rpc_api.SUCCESS {
-		return nil, fmt.Errorf("failed sp download with error: %s", res.Return)
+		return 0, &RPCError{Method: "user_downloadData", Return: res.Return, Message: "unexpected return"}
 	}
 
-	if err = writeOnly(filePath, fileData[:]); err != nil {
+	return int64(fileSize), nil
+}
+
+// Download downloads a file uploaded by the wallet of the fetcher
+func (f *Fetcher) Download(ctx context.Context, fileHash string) (files.File, error) {
+	owner, err := f.wallet.GetAddress(ctx)
+	if err != nil {
 		return nil, err
 	}
-
-	return fileData, nil
+	return f.DownloadFrom(ctx, owner, fileHash)
 }
 
-func (f *Fetcher) Download(fileHash string) ([]byte, error) {
-	callback := func() (*rpc_api.Result, error) {
-		oz, err := f.rpc.GetOzone(f.wallet)
+// DownloadFrom downloads a file uploaded by the owner wallet
+func (f *Fetcher) DownloadFrom(ctx context.Context, owner, fileHash string) (files.File, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Fetcher", "Download", trace.WithAttributes(attribute.String("filehash", fileHash)))
+	defer span.End()
+
+	callback := func(ctx context.Context, rpc *Rpc) (*rpc_api.Result, error) {
+		oz, err := f.preflight(ctx, rpc, opDownload, 0)
 		if err != nil {
 			return nil, err
 		}
-		res, err := f.rpc.RequestDownload(f.wallet, oz.SequenceNumber, fileHash)
-		fmt.Println("Fetcher Download RequestDownload res - err", res, err)
+		res, err := rpc.RequestDownload(ctx, f.wallet, oz.SequenceNumber, owner, fileHash)
+		logger.Debugf("request download %s: res %+v err %v", fileHash, res, err)
 		if err != nil {
-			return nil, err
+			return nil, withBalance(err, oz.Ozone, 0)
 		}
 		return res, nil
 	}
-	return f.download(fileHash, callback)
+	return f.download(ctx, fileHash, callback)
 }
 
-func (f *Fetcher) DownloadFromShare(shareLink string) ([]byte, error) {
-	callback := func() (*rpc_api.Result, error) {
-		oz, err := f.rpc.GetOzone(f.wallet)
+func (f *Fetcher) DownloadFromShare(ctx context.Context, shareLink string) (files.File, error) {
+	redacted := redactShareLink(shareLink)
+	ctx, span := tracing.Span(ctx, "Sds.Fetcher", "DownloadFromShare", trace.WithAttributes(attribute.String("sharelink", redacted)))
+	defer span.End()
+
+	callback := func(ctx context.Context, rpc *Rpc) (*rpc_api.Result, error) {
+		oz, err := f.preflight(ctx, rpc, opDownload, 0)
 		if err != nil {
 			return nil, err
 		}
-		res, err := f.rpc.GetShared(f.wallet, oz.SequenceNumber, shareLink)
-		fmt.Println("Fetcher Download DownloadFromShare res - err", res, err)
+		res, err := rpc.GetShared(ctx, f.wallet, oz.SequenceNumber, shareLink)
+		logger.Debugf("get shared %s: res %+v err %v", redacted, res, err)
 		if err != nil {
-			return nil, err
+			share, ferr := f.shares.Find(ctx, shareLink)
+			if ferr != nil {
+				logger.Warnf("could not find the share %s: %s", redacted, ferr)
+			}
+			return nil, withShare(withBalance(err, oz.Ozone, 0), share)
 		}
 		return res, nil
 	}
-	return f.download("", callback)
+	return f.download(ctx, "", callback)
+}
+
+// NewLink returns the mapping file of the link, signed by the wallet of the
+// fetcher
+func (f *Fetcher) NewLink(ctx context.Context, link Link) (files.File, error) {
+	return NewSdsFile(ctx, f.wallet, link)
 }
 
-func (f *Fetcher) CreateShareLink(fileHash, cid string) (bool, error) {
-	res, err := f.rpc.RequestShare(f.wallet, fileHash, &cid)
-	fmt.Println("Fetcher CreateShareLink RequestShare res - err", res, err)
+// CreateShareLink shares the file holding the DAG of the cid and records the
+// share in the repo
+func (f *Fetcher) CreateShareLink(ctx context.Context, fileHash, cid string, opts ShareOptions) (*Share, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Fetcher", "CreateShareLink", trace.WithAttributes(attribute.String("filehash", fileHash)))
+	defer span.End()
+
+	var ipfsCid *string
+	if cid != "" {
+		ipfsCid = &cid
+	}
+	res, err := f.rpc.RequestShare(ctx, f.wallet, fileHash, ipfsCid, int64(opts.Duration/time.Second), opts.Private)
+	logger.Debugf("request share %s: res %+v err %v", fileHash, res, err)
+	if err == nil && res.Return != rpc_api.SUCCESS {
+		err = &RPCError{Method: "user_requestShare", Return: res.Return, Message: "unexpected return"}
+	}
+	sharesCreated.WithLabelValues(outcome(err)).Inc()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if res.Return != rpc_api.SUCCESS {
-		return false, fmt.Errorf("share link creation failed")
+	return f.shares.add(ctx, res.ShareId, res.ShareLink, fileHash, cid, opts)
+}
+
+// ListShareLinks returns the share links of the wallet, listed by the pp
+func (f *Fetcher) ListShareLinks(ctx context.Context) ([]*SharedFile, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Fetcher", "ListShareLinks")
+	defer span.End()
+
+	var shared []*SharedFile
+	for page := uint64(0); ; page++ {
+		res, err := f.rpc.ListShares(ctx, f.wallet, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range res.FileInfo {
+			shared = append(shared, sharedFile(info))
+		}
+		if len(res.FileInfo) == 0 || uint64(len(shared)) >= res.TotalNumber {
+			return shared, nil
+		}
+	}
+}
+
+// StopShareLink revokes the share link of the share id. A share created by
+// the node stays recorded in the repo, as revoked.
+func (f *Fetcher) StopShareLink(ctx context.Context, shareId string) error {
+	ctx, span := tracing.Span(ctx, "Sds.Fetcher", "StopShareLink", trace.WithAttributes(attribute.String("shareid", shareId)))
+	defer span.End()
+
+	if _, err := f.rpc.StopShare(ctx, f.wallet, shareId); err != nil {
+		share, gerr := f.shares.Get(ctx, shareId)
+		if gerr != nil {
+			logger.Warnf("could not get the share %s: %s", shareId, gerr)
+		}
+		return withShare(err, share)
+	}
+	return f.shares.revoke(ctx, shareId)
+}
+
+// preflight gets the ozone balance and the sequence number of a request,
+// and refuses the operation up front when it is over the budget
+func (f *Fetcher) preflight(ctx context.Context, rpc *Rpc, op string, cost int64) (*rpc_api.GetOzoneResult, error) {
+	getOzone := rpc.GetOzone
+	if op == opUpload {
+		// the uploads are retried as a whole, the ozone request is not
+		// retried on its own within them
+		getOzone = rpc.getOzone
+	}
+	oz, err := getOzone(ctx, f.wallet)
+	if err != nil {
+		return nil, err
 	}
+	if err := f.budget.Check(ctx, op, clientFrom(ctx), cost, oz.Ozone); err != nil {
+		return nil, err
+	}
+	return oz, nil
+}
+
+// gatewayGuard returns the guard of the downloads started by the gateway
+// requests, enforcing the fallback mode, one of the Gateway.SdsFallback
+// values, and Sds.Fallback for all the gateways of the node
+func (f *Fetcher) gatewayGuard(mode string) (*fallbackGuard, error) {
+	f.guardOnce.Do(func() {
+		f.guard, f.guardErr = newFallbackGuard(mode, &f.cfg.Fallback)
+	})
+	return f.guard, f.guardErr
+}
+
+// GetOzone returns the ozone balance of the wallet
+func (f *Fetcher) GetOzone(ctx context.Context) (*rpc_api.GetOzoneResult, error) {
+	return f.rpc.GetOzone(ctx, f.wallet)
+}
+
+// Budget returns the ozone budget of the operations
+func (f *Fetcher) Budget() *Budget {
+	return f.budget
+}
+
+// PPs returns the state of the pp nodes
+func (f *Fetcher) PPs() []PPState {
+	return f.rpc.PPs()
+}
+
+// Queue returns the queue of the uploads of the async adds
+func (f *Fetcher) Queue() *UploadQueue {
+	return f.queue
+}
+
+// Pins returns the pin requests of the Pinning Services API
+func (f *Fetcher) Pins() *Pins {
+	return f.pins
+}
+
+// Close stops the upload queue workers and the health checks of the pp
+// nodes, and saves the ozone spent
+func (f *Fetcher) Close() {
+	f.queue.Close()
+	f.rpc.Close()
+	f.budget.Close()
+}
+
+// Shares returns the share links created by the node
+func (f *Fetcher) Shares() *Shares {
+	return f.shares
+}
+
+// Cache returns the cache of the downloaded files
+func (f *Fetcher) Cache() *Cache {
+	return f.cache
+}
+
+// LinkTrust returns the trust of the mapping files followed to their DAGs,
+// the ones of the wallet of the fetcher and of Sds.TrustedWallets
+func (f *Fetcher) LinkTrust() *LinkTrust {
+	return f.trust
+}
 
-	return true, nil
+// WalletAddress returns the address of the wallet signing sds requests
+func (f *Fetcher) WalletAddress(ctx context.Context) (string, error) {
+	return f.wallet.GetAddress(ctx)
 }
@@ -0,0 +1,740 @@
+package sds_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/keystore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/sds"
+	sdsmock "github.com/ipfs/kubo/sds/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testCid = "QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN"
+
+const otherWalletKey = "0x2a1fb1b3bd4c6bbe2de5bc3e8dbd1fd1cae5e6beb8a5bbbdd1e5eb81d7c4a96b"
+
+func newTestFetcher(t *testing.T, pp *sdsmock.PP, key string) *sds.Fetcher {
+	return newTestFetcherConfig(t, &config.Sds{
+		PrivateKey: key,
+		RpcURLs:    []string{pp.URL()},
+	})
+}
+
+func newTestFetcherConfig(t *testing.T, cfg *config.Sds) *sds.Fetcher {
+	return newTestFetcherStore(t, cfg, dssync.MutexWrap(datastore.NewMapDatastore()))
+}
+
+// newTestFetcherStore returns a fetcher keeping its state in ds, like the
+// fetchers of the successive runs of a node
+func newTestFetcherStore(t *testing.T, cfg *config.Sds, ds datastore.Batching) *sds.Fetcher {
+	cfg.Enabled = true
+	cfg.CacheFolder = t.TempDir()
+	ks := keystore.NewMemKeystore()
+	if cfg.PrivateKey != "" {
+		cfg.Wallet = config.DefaultSdsWallet
+		require.NoError(t, ks.Put(cfg.Wallet, testKey(t, cfg.PrivateKey)))
+		cfg.PrivateKey = ""
+	}
+	f, err := sds.NewFetcher(cfg, "", ds, ks)
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// noUploadRetry leaves interrupted uploads to be resumed by the test
+var noUploadRetry = config.SdsRetry{
+	Operations: map[string]config.SdsRetryPolicy{
+		"upload": {MaxAttempts: config.NewOptionalInteger(1)},
+	},
+}
+
+func TestFetcherUploadDownload(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	pp.SetChunkSize(1000)
+	f := newTestFetcher(t, pp, testWalletKey)
+
+	fileData := make([]byte, 4500)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+
+	fileHash, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+	assert.Equal(t, sds.CreateFileHash(fileData), fileHash)
+
+	// uploading the same file again only links to it
+	again, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+	assert.Equal(t, fileHash, again)
+
+	file, err := f.Download(ctx, fileHash)
+	require.NoError(t, err)
+	downloaded, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, fileData, downloaded)
+}
+
+func TestFetcherShare(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	fileData := []byte("hello sds")
+
+	owner := newTestFetcher(t, pp, testWalletKey)
+	fileHash, err := owner.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+
+	share, err := owner.CreateShareLink(ctx, fileHash, testCid, sds.ShareOptions{})
+	require.NoError(t, err)
+	shareLink := share.ShareLink
+	assert.Equal(t, "sds://"+testCid, shareLink)
+
+	// anyone could download a shared file
+	reader := newTestFetcher(t, pp, otherWalletKey)
+	file, err := reader.DownloadFromShare(ctx, shareLink)
+	require.NoError(t, err)
+	defer file.Close()
+
+	size, err := file.Size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(fileData)), size)
+	downloaded, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, fileData, downloaded)
+}
+
+func TestFetcherShareLifecycle(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	fileData := []byte("hello private sds")
+
+	owner := newTestFetcher(t, pp, testWalletKey)
+	fileHash, err := owner.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+
+	share, err := owner.CreateShareLink(ctx, fileHash, "", sds.ShareOptions{Duration: time.Hour, Private: true})
+	require.NoError(t, err)
+	assert.True(t, share.Private)
+	assert.NotEmpty(t, share.Password)
+	assert.NotContains(t, share.ShareLink, share.Password)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), share.Expires, time.Minute)
+
+	// the password is needed to download a private share
+	reader := newTestFetcher(t, pp, otherWalletKey)
+	_, err = reader.DownloadFromShare(ctx, share.ShareLink)
+	assert.Error(t, err)
+	file, err := reader.DownloadFromShare(ctx, sds.FormatShareLink(strings.TrimPrefix(share.ShareLink, "sds://"), share.Password))
+	require.NoError(t, err)
+	downloaded, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, fileData, downloaded)
+
+	listed, err := owner.ListShareLinks(ctx)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, share.ShareId, listed[0].ShareId)
+	assert.Equal(t, fileHash, listed[0].FileHash)
+	assert.Equal(t, share.Expires.Unix(), listed[0].Expires.Unix())
+
+	// the other wallets do not see the shares of the owner
+	listed, err = reader.ListShareLinks(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+	assert.ErrorIs(t, reader.StopShareLink(ctx, share.ShareId), sds.ErrShareNotFound)
+
+	require.NoError(t, owner.StopShareLink(ctx, share.ShareId))
+	listed, err = owner.ListShareLinks(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+	_, err = reader.DownloadFromShare(ctx, sds.FormatShareLink(strings.TrimPrefix(share.ShareLink, "sds://"), share.Password))
+	assert.ErrorIs(t, err, sds.ErrShareNotFound)
+	// the owner knows the share was revoked
+	_, err = owner.DownloadFromShare(ctx, sds.FormatShareLink(strings.TrimPrefix(share.ShareLink, "sds://"), share.Password))
+	assert.ErrorIs(t, err, sds.ErrShareExpired)
+	assert.ErrorIs(t, owner.StopShareLink(ctx, share.ShareId), sds.ErrShareExpired)
+
+	// the revoked share stays in the records
+	recorded, err := owner.Shares().Get(ctx, share.ShareId)
+	require.NoError(t, err)
+	require.NotNil(t, recorded)
+	assert.Equal(t, share.Password, recorded.Password)
+	assert.False(t, recorded.Revoked.IsZero())
+}
+
+func TestFetcherListShareLinksPages(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+
+	f := newTestFetcher(t, pp, testWalletKey)
+	fileHash, err := f.Upload(ctx, bytes.NewReader([]byte("paged")), 5, sds.UploadMeta{})
+	require.NoError(t, err)
+
+	// more shares than a page of the pp
+	for i := 0; i < 25; i++ {
+		_, err := f.CreateShareLink(ctx, fileHash, "", sds.ShareOptions{})
+		require.NoError(t, err)
+	}
+	listed, err := f.ListShareLinks(ctx)
+	require.NoError(t, err)
+	assert.Len(t, listed, 25)
+
+	recorded, err := f.Shares().List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, recorded, 25)
+}
+
+func TestFetcherResumeUpload(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	pp.SetChunkSize(1000)
+	f := newTestFetcherConfig(t, &config.Sds{
+		PrivateKey: testWalletKey,
+		RpcURLs:    []string{pp.URL()},
+		Retry:      noUploadRetry,
+	})
+	source, err := cid.Decode(testCid)
+	require.NoError(t, err)
+
+	fileData := make([]byte, 4500)
+	_, err = rand.Read(fileData)
+	require.NoError(t, err)
+	fileHash := sds.CreateFileHash(fileData)
+
+	pp.FailUploadAfter(2)
+	meta := sds.UploadMeta{Name: "photo.jpg", ContentType: "image/jpeg", Cid: source}
+	_, err = f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), meta)
+	require.Error(t, err)
+
+	sessions, err := f.Uploads(ctx)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, fileHash, sessions[0].FileHash)
+	assert.Equal(t, testCid, sessions[0].Cid)
+	assert.Equal(t, "photo.jpg", sessions[0].Name)
+	assert.Equal(t, sds.UploadFailed, sessions[0].Status)
+	assert.Equal(t, uint64(1000), sessions[0].OffsetStart)
+	assert.Equal(t, uint64(2000), sessions[0].OffsetEnd)
+
+	// the upload continues from the chunks already received by the pp
+	sent := pp.Calls("user_uploadData")
+	uploaded, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{Cid: source})
+	require.NoError(t, err)
+	assert.Equal(t, fileHash, uploaded)
+	assert.Equal(t, 3, pp.Calls("user_uploadData")-sent)
+
+	stored, ok := pp.File(fileHash)
+	require.True(t, ok)
+	assert.Equal(t, fileData, stored)
+
+	// the resumed upload is requested with the metadata of the session
+	storedMeta, ok := pp.FileMeta(fileHash)
+	require.True(t, ok)
+	assert.Equal(t, sdsmock.FileMeta{Name: "photo.jpg", ContentType: "image/jpeg", Cid: testCid}, storedMeta)
+
+	sessions, err = f.Uploads(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestFetcherFailover(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	peer := pp.NewPeer()
+	defer peer.Close()
+	f := newTestFetcherConfig(t, &config.Sds{
+		PrivateKey:          testWalletKey,
+		RpcURLs:             []string{pp.URL(), peer.URL()},
+		HealthCheckInterval: config.NewOptionalDuration(0),
+	})
+
+	fileData := []byte("hello sds")
+	pp.SetDown(true)
+	fileHash, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, peer.Calls("user_requestUpload"))
+
+	states := f.PPs()
+	require.Len(t, states, 2)
+	assert.False(t, states[0].Healthy)
+	assert.Error(t, states[0].Error)
+	assert.True(t, states[1].Healthy)
+
+	file, err := f.Download(ctx, fileHash)
+	require.NoError(t, err)
+	downloaded, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, fileData, downloaded)
+	assert.Equal(t, 0, pp.Calls("user_requestDownload"))
+}
+
+func TestFetcherRoundRobin(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	peer := pp.NewPeer()
+	defer peer.Close()
+	f := newTestFetcherConfig(t, &config.Sds{
+		PrivateKey:   testWalletKey,
+		RpcURLs:      []string{pp.URL(), peer.URL()},
+		RpcSelection: config.NewOptionalString(config.SdsSelectionRoundRobin),
+	})
+
+	fileData := []byte("hello sds")
+	fileHash, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, f.Cache().Remove(fileHash))
+		file, err := f.Download(ctx, fileHash)
+		require.NoError(t, err)
+		_, err = io.ReadAll(file)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+	assert.Equal(t, 2, pp.Calls("user_requestDownload"))
+	assert.Equal(t, 2, peer.Calls("user_requestDownload"))
+}
+
+func TestFetcherResumeUploadOnSamePP(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	peer := pp.NewPeer()
+	defer peer.Close()
+	pp.SetChunkSize(1000)
+	f := newTestFetcherConfig(t, &config.Sds{
+		PrivateKey:          testWalletKey,
+		RpcURLs:             []string{pp.URL(), peer.URL()},
+		HealthCheckInterval: config.NewOptionalDuration(10 * time.Millisecond),
+		Retry:               noUploadRetry,
+	})
+
+	fileData := make([]byte, 4500)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+
+	// the upload starts on the second pp while the first one is down
+	pp.SetDown(true)
+	peer.FailUploadAfter(2)
+	_, err = f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.Error(t, err)
+	sessions, err := f.Uploads(ctx)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, peer.URL(), sessions[0].PP)
+
+	pp.SetDown(false)
+	require.Eventually(t, func() bool {
+		return f.PPs()[0].Healthy
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the first pp is back, but only the second one has the received chunks
+	sent := peer.Calls("user_uploadData")
+	_, err = f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, pp.Calls("user_uploadData"))
+	assert.Equal(t, 3, peer.Calls("user_uploadData")-sent)
+}
+
+func TestFetcherResumeUploadOnDeadPP(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	peer := pp.NewPeer()
+	defer peer.Close()
+	pp.SetChunkSize(1000)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	newFetcher := func() *sds.Fetcher {
+		return newTestFetcherStore(t, &config.Sds{
+			PrivateKey: testWalletKey,
+			RpcURLs:    []string{pp.URL(), peer.URL()},
+			Retry:      noUploadRetry,
+		}, ds)
+	}
+
+	fileData := make([]byte, 4500)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+
+	// the upload starts on the second pp while the first one is down
+	pp.SetDown(true)
+	peer.FailUploadAfter(2)
+	_, err = newFetcher().Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.Error(t, err)
+
+	// the second pp is gone when the next run of the node resumes the
+	// upload, it is presumed healthy until then
+	pp.SetDown(false)
+	peer.SetDown(true)
+	f := newFetcher()
+	fileHash, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, pp.Calls("user_uploadData"))
+	stored, ok := pp.File(fileHash)
+	require.True(t, ok)
+	assert.Equal(t, fileData, stored)
+	sessions, err := f.Uploads(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestFetcherDownloadCancelled(t *testing.T) {
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	pp.SetChunkSize(100)
+	f := newTestFetcher(t, pp, testWalletKey)
+
+	fileData := make([]byte, 10000)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+	owner, err := f.WalletAddress(context.Background())
+	require.NoError(t, err)
+	fileHash := pp.AddFile(owner, fileData)
+
+	pp.SetLatency(5 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	file, err := f.Download(ctx, fileHash)
+	require.NoError(t, err)
+	_, err = file.Read(make([]byte, 100))
+	require.NoError(t, err)
+
+	// the download stops once its only reader is gone
+	cancel()
+	_, err = file.Read(make([]byte, 100))
+	assert.ErrorIs(t, err, context.Canceled)
+	require.Eventually(t, func() bool {
+		calls := pp.Calls("user_downloadData")
+		time.Sleep(50 * time.Millisecond)
+		return calls == pp.Calls("user_downloadData")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Less(t, pp.Calls("user_downloadData"), 100)
+	assert.Empty(t, f.Cache().List())
+
+	// the file could still be downloaded again
+	file, err = f.Download(context.Background(), fileHash)
+	require.NoError(t, err)
+	downloaded, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, fileData, downloaded)
+}
+
+func TestFetcherConcurrentDownloads(t *testing.T) {
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	pp.SetChunkSize(1000)
+	f := newTestFetcher(t, pp, testWalletKey)
+
+	fileData := make([]byte, 10000)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+	owner, err := f.WalletAddress(context.Background())
+	require.NoError(t, err)
+	fileHash := pp.AddFile(owner, fileData)
+
+	// the requests all arrive before the pp answered the first one
+	pp.SetLatency(20 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file, err := f.Download(context.Background(), fileHash)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer file.Close()
+			downloaded, err := io.ReadAll(file)
+			assert.NoError(t, err)
+			assert.Equal(t, fileData, downloaded)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, pp.Calls("user_requestDownload"))
+}
+
+func TestFetcherRetry(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	pp.SetChunkSize(1000)
+	f := newTestFetcherConfig(t, &config.Sds{
+		PrivateKey: testWalletKey,
+		RpcURLs:    []string{pp.URL()},
+		Retry: config.SdsRetry{
+			SdsRetryPolicy: config.SdsRetryPolicy{
+				MaxAttempts: config.NewOptionalInteger(3),
+				BaseDelay:   config.NewOptionalDuration(time.Millisecond),
+			},
+			Operations: map[string]config.SdsRetryPolicy{
+				"ozone": {MaxAttempts: config.NewOptionalInteger(1)},
+			},
+		},
+	})
+
+	_, err := f.GetOzone(ctx)
+	require.NoError(t, err)
+	pp.FailNext("user_requestGetOzone", 1)
+	_, err = f.GetOzone(ctx)
+	assert.ErrorIs(t, err, sds.ErrPPUnavailable, "ozone requests are not retried by the config")
+
+	fileData := make([]byte, 4500)
+	_, err = rand.Read(fileData)
+	require.NoError(t, err)
+
+	// the upload is resumed after a chunk failed
+	pp.FailNext("user_uploadData", 2)
+	fileHash, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+	stored, ok := pp.File(fileHash)
+	require.True(t, ok)
+	assert.Equal(t, fileData, stored)
+	assert.Equal(t, 5, pp.Calls("user_uploadData"))
+
+	// chunk requests are retried
+	pp.FailNext("user_downloadData", 2)
+	file, err := f.Download(ctx, fileHash)
+	require.NoError(t, err)
+	downloaded, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, fileData, downloaded)
+
+	// sharing is not retried
+	pp.FailNext("user_requestShare", 1)
+	_, err = f.CreateShareLink(ctx, fileHash, testCid, sds.ShareOptions{})
+	assert.ErrorIs(t, err, sds.ErrPPUnavailable)
+	_, err = f.CreateShareLink(ctx, fileHash, testCid, sds.ShareOptions{})
+	assert.NoError(t, err)
+}
+
+func TestFetcherRetryOnce(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	f := newTestFetcherConfig(t, &config.Sds{
+		PrivateKey: testWalletKey,
+		RpcURLs:    []string{pp.URL()},
+		Retry: config.SdsRetry{
+			SdsRetryPolicy: config.SdsRetryPolicy{
+				MaxAttempts: config.NewOptionalInteger(3),
+				BaseDelay:   config.NewOptionalDuration(time.Millisecond),
+			},
+		},
+	})
+
+	// the ozone request of an upload is only retried with the upload, so
+	// the upload gives up after its own attempts
+	pp.FailNext("user_requestGetOzone", 3)
+	fileData := []byte("hello retries")
+	_, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	assert.ErrorIs(t, err, sds.ErrPPUnavailable)
+	assert.Zero(t, pp.Calls("user_requestGetOzone"))
+
+	pp.FailNext("user_requestGetOzone", 2)
+	_, err = f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	assert.NoError(t, err)
+}
+
+// spanAttributes returns the attributes of the spans named name
+func spanAttributes(spans tracetest.SpanStubs, name string) []map[attribute.Key]attribute.Value {
+	var found []map[attribute.Key]attribute.Value
+	for _, span := range spans {
+		if span.Name != name {
+			continue
+		}
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		found = append(found, attrs)
+	}
+	return found
+}
+
+func TestFetcherTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	pp.SetChunkSize(1000)
+	f := newTestFetcher(t, pp, testWalletKey)
+
+	fileData := make([]byte, 2500)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+	fileHash, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+
+	file, err := f.Download(ctx, fileHash)
+	require.NoError(t, err)
+	_, err = io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.NoError(t, tp.ForceFlush(ctx))
+
+	spans := exporter.GetSpans()
+	uploads := spanAttributes(spans, "Sds.Fetcher.Upload")
+	require.Len(t, uploads, 1)
+	assert.Equal(t, fileHash, uploads[0]["filehash"].AsString())
+	assert.Equal(t, int64(len(fileData)), uploads[0]["size"].AsInt64())
+
+	chunks := spanAttributes(spans, "Sds.Rpc.user_uploadData")
+	require.Len(t, chunks, 3)
+	var sent int64
+	for _, attrs := range chunks {
+		assert.Equal(t, "user_uploadData", attrs["method"].AsString())
+		assert.Equal(t, fileHash, attrs["filehash"].AsString())
+		assert.Equal(t, pp.URL(), attrs["pp"].AsString())
+		sent += attrs["bytes"].AsInt64()
+	}
+	assert.Equal(t, int64(len(fileData)), sent)
+	assert.Equal(t, "0", chunks[2]["return"].AsString())
+
+	downloads := spanAttributes(spans, "Sds.Fetcher.Download")
+	require.Len(t, downloads, 1)
+	assert.False(t, downloads[0]["cached"].AsBool())
+
+	// the background download is a child of the download request
+	require.Eventually(t, func() bool {
+		return len(spanAttributes(exporter.GetSpans(), "Sds.Fetcher.Fetch")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	var request, fetch tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		switch span.Name {
+		case "Sds.Fetcher.Download":
+			request = span
+		case "Sds.Fetcher.Fetch":
+			fetch = span
+		}
+	}
+	assert.Equal(t, request.SpanContext.SpanID(), fetch.Parent.SpanID())
+	assert.Equal(t, int64(len(fileData)), spanAttributes(exporter.GetSpans(), "Sds.Fetcher.Fetch")[0]["bytes"].AsInt64())
+
+	// the pp failures are recorded on the rpc spans
+	exporter.Reset()
+	pp.FailNext("user_requestShare", 1)
+	_, err = f.CreateShareLink(ctx, fileHash, testCid, sds.ShareOptions{})
+	require.Error(t, err)
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "Sds.Rpc.user_requestShare" {
+			assert.Equal(t, codes.Error, span.Status.Code)
+		}
+	}
+
+	// the password of a private share is not exported
+	share, err := f.CreateShareLink(ctx, fileHash, "", sds.ShareOptions{Private: true})
+	require.NoError(t, err)
+	exporter.Reset()
+	file, err = f.DownloadFromShare(ctx, sds.FormatShareLink(strings.TrimPrefix(share.ShareLink, "sds://"), share.Password))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	for _, name := range []string{"Sds.Fetcher.DownloadFromShare", "Sds.Rpc.user_requestGetShared"} {
+		attrs := spanAttributes(exporter.GetSpans(), name)
+		require.Len(t, attrs, 1)
+		assert.NotContains(t, attrs[0]["sharelink"].AsString(), share.Password)
+	}
+}
+
+func TestFetcherBudget(t *testing.T) {
+	ctx := context.Background()
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	pp.SetChunkSize(1000)
+	f := newTestFetcherConfig(t, &config.Sds{
+		PrivateKey: testWalletKey,
+		RpcURLs:    []string{pp.URL()},
+		Budget: config.SdsBudget{
+			Daily:      config.NewOptionalInteger(4000),
+			PerClient:  config.NewOptionalInteger(1500),
+			MinBalance: config.NewOptionalInteger(1000),
+		},
+	})
+	wallet, err := sds.NewSdsWallet(testWalletKey)
+	require.NoError(t, err)
+
+	// refused before the upload is requested
+	fileData := make([]byte, 4500)
+	_, err = rand.Read(fileData)
+	require.NoError(t, err)
+	_, err = f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	assert.ErrorIs(t, err, sds.ErrBudgetExceeded)
+	assert.Equal(t, 0, pp.Calls("user_requestUpload"))
+
+	_, err = f.Upload(ctx, bytes.NewReader(fileData[:2500]), 2500, sds.UploadMeta{})
+	require.NoError(t, err)
+
+	// a gateway client is stopped once it spent its own budget
+	fileHash := pp.AddFile(walletAddress(t, wallet), fileData[2500:])
+	file, err := f.Download(sds.WithClient(ctx, "10.0.0.1"), fileHash)
+	require.NoError(t, err)
+	_, err = io.ReadAll(file)
+	assert.ErrorIs(t, err, sds.ErrBudgetExceeded)
+	require.NoError(t, file.Close())
+
+	_, err = f.Download(sds.WithClient(ctx, "10.0.0.1"), fileHash)
+	assert.ErrorIs(t, err, sds.ErrBudgetExceeded)
+
+	spend, err := f.Budget().Spend(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2500), spend.Operations["upload"])
+	assert.Equal(t, int64(2000), spend.Operations["download"])
+	assert.Equal(t, int64(2000), spend.Clients["10.0.0.1"])
+
+	// the daily budget is used up
+	_, err = f.Download(ctx, fileHash)
+	assert.ErrorIs(t, err, sds.ErrBudgetExceeded)
+
+	pp.SetOzone("1500")
+	requested := pp.Calls("user_requestUpload")
+	f = newTestFetcherConfig(t, &config.Sds{
+		PrivateKey: testWalletKey,
+		RpcURLs:    []string{pp.URL()},
+		Budget: config.SdsBudget{
+			MinBalance: config.NewOptionalInteger(1000),
+		},
+	})
+	_, err = f.Upload(ctx, bytes.NewReader(fileData[:600]), 600, sds.UploadMeta{})
+	assert.ErrorIs(t, err, sds.ErrInsufficientOzone)
+	assert.Equal(t, requested, pp.Calls("user_requestUpload"))
+
+	// the pp refuses an upload the balance cannot pay for
+	pp.SetOzone("100")
+	f = newTestFetcherConfig(t, &config.Sds{
+		PrivateKey: testWalletKey,
+		RpcURLs:    []string{pp.URL()},
+	})
+	_, err = f.Upload(ctx, bytes.NewReader(fileData[:600]), 600, sds.UploadMeta{})
+	assert.ErrorIs(t, err, sds.ErrInsufficientOzone)
+	assert.Equal(t, requested+1, pp.Calls("user_requestUpload"))
+}
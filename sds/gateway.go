@@ -2,11 +2,15 @@ package sds
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"io"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/ipfs/boxo/blockservice"
 	"github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/gateway"
 	"github.com/ipfs/boxo/path"
@@ -14,11 +18,22 @@ import (
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/tracing"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
 	fwtypes "github.com/stratosnet/sds/framework/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var _ gateway.IPFSBackend = (*SdsBlocksBackend)(nil)
 
+// maxLinkSize caps the size of the files checked for being mapping files
+const maxLinkSize = 1024 * 1024
+
+// ErrNotDAG is returned for the blocks and CARs of a plain file shared
+// through sds, there is no DAG to serve them from
+var ErrNotDAG = errors.New("sds: shared file is not a DAG")
+
 type SdsBlocksBackend struct {
 	b       gateway.IPFSBackend
 	cfg     *config.Sds
@@ -26,178 +41,691 @@ type SdsBlocksBackend struct {
 	dag     format.DAGService
 	bs      blockstore.GCBlockstore
 	pin     pin.Pinner
-}
+	// guard limits the downloads started by the gateway requests
+	guard *fallbackGuard
+	// lookupTimeout bounds the ipfs lookups of the missing root blocks
+	lookupTimeout time.Duration
 
-func NewSdsBlockBackend(b gateway.IPFSBackend, cfg *config.Sds, dag format.DAGService, bs blockstore.GCBlockstore, pin pin.Pinner) (*SdsBlocksBackend, error) {
-	fetcher, err := NewFetcher(cfg)
-	if err != nil {
-		return nil, err
-	}
+	// ctx is cancelled by Close, the downloads and imports outlive the
+	// requests which start them until then
+	ctx    context.Context
+	cancel context.CancelFunc
 
-	return &SdsBlocksBackend{
+	mu sync.Mutex
+	// imports are the CAR imports in progress, by the root cid or the sds
+	// identifier they were downloaded for and by the root cid of their CAR
+	imports map[string]*carImport
+}
+
+// NewSdsBlockBackend returns the backend serving b, with the content missing
+// there downloaded from sds by the fetcher of the node for the clients
+// allowed by fallback, one of the Gateway.SdsFallback values. The fetcher is
+// nil when sds is not enabled.
+func NewSdsBlockBackend(b gateway.IPFSBackend, cfg *config.Sds, fallback string, fetcher *Fetcher, dag format.DAGService, bs blockstore.GCBlockstore, pin pin.Pinner) (*SdsBlocksBackend, error) {
+	sb := &SdsBlocksBackend{
 		b:       b,
 		cfg:     cfg,
 		fetcher: fetcher,
 		dag:     dag,
 		bs:      bs,
 		pin:     pin,
-	}, nil
+
+		lookupTimeout: cfg.Fallback.LookupTimeout.WithDefault(config.DefaultSdsFallbackLookupTimeout),
+
+		imports: make(map[string]*carImport),
+	}
+	sb.ctx, sb.cancel = context.WithCancel(context.Background())
+
+	if cfg.Enabled {
+		if fetcher == nil {
+			return nil, errors.New("sds is enabled without a fetcher")
+		}
+		// the limits are shared by all the gateways of the node
+		guard, err := fetcher.gatewayGuard(fallback)
+		if err != nil {
+			return nil, err
+		}
+		sb.guard = guard
+	}
+
+	return sb, nil
 }
 
-func readAndResetGatewayResponse(n *gateway.GetResponse) ([]byte, error) {
-	fileReader, ok := getDynamicField(n, "bytes").(io.ReadCloser)
+// CARBackend returns the backend serving the DAG of the CAR file returned by
+// Open for a private share. The blocks are read from the file, they are not
+// in the blockstore of the node, nor provided.
+func CARBackend(file files.File) (gateway.IPFSBackend, error) {
+	ra, ok := file.(io.ReaderAt)
 	if !ok {
-		return []byte{}, fmt.Errorf("not a file reader")
+		return nil, errors.New("sds: the car file does not support ReadAt")
 	}
-	fileSize, ok := getDynamicField(n, "bytesSize").(int64)
-	if !ok {
-		return []byte{}, fmt.Errorf("no file size")
+	bs, err := carblockstore.NewReadOnly(ra, nil)
+	if err != nil {
+		return nil, err
 	}
+	return gateway.NewBlocksBackend(blockservice.New(bs, offline.Exchange(bs)))
+}
+
+// Close stops the downloads and imports in progress, the fetcher is closed
+// with the node
+func (sb *SdsBlocksBackend) Close() {
+	sb.cancel()
+}
 
-	fileData := make([]byte, fileSize)
+// sdsContent is where the content of a requested path is served from
+type sdsContent struct {
+	// path is the path of the content in the ipfs backend
+	path path.ImmutablePath
+	// file is a plain file downloaded from sds, when set the content is not
+	// in the ipfs backend
+	file files.File
+	// imported is the import of the CAR downloaded from sds for the content,
+	// nil when the content was already in the ipfs backend
+	imported *carImport
+}
 
-	if _, err := fileReader.Read(fileData); err != nil {
-		return []byte{}, err
+// call calls fn with the path of the content in the ipfs backend. Blocks are
+// delivered as the import goes, so the response could start before the whole
+// CAR is downloaded, unless the backend is offline and fails on the first
+// missing block, then fn is called again once the import is done.
+//
+// The response of fn could still read blocks after call returns, like the
+// file of Get, so when fn succeeds its context is released with the one of
+// the request rather than on return.
+func (c *sdsContent) call(ctx context.Context, fn func(ctx context.Context, p path.ImmutablePath) error) error {
+	if c.imported == nil {
+		return fn(ctx, c.path)
 	}
+	fctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(c.imported.ctx, func() {
+		cancel(context.Cause(c.imported.ctx))
+	})
+	if err := fn(fctx, c.path); err == nil {
+		context.AfterFunc(fctx, func() { stop() })
+		return nil
+	}
+	stop()
+	cancel(nil)
+	if err := c.imported.wait(); err != nil {
+		return err
+	}
+	return fn(ctx, c.path)
+}
 
-	// NOTE: Required as we read so cursor have been moved, so content length will be missmatch
-	fs, ok := fileReader.(io.ReadSeeker)
-	if ok {
-		if _, err := fs.Seek(0, io.SeekStart); err != nil {
-			return []byte{}, err
-		}
+// wait waits for the whole DAG of the content to be in the ipfs backend
+func (c *sdsContent) wait() error {
+	if c.imported == nil {
+		return nil
 	}
+	return c.imported.wait()
+}
 
-	return fileData, nil
+// metadata is the path metadata of a plain file downloaded from sds
+func (c *sdsContent) metadata() gateway.ContentPathMetadata {
+	return gateway.ContentPathMetadata{
+		PathSegmentRoots: []cid.Cid{c.path.RootCid()},
+		LastSegment:      c.path,
+	}
 }
 
-// TODO: Fix panic on folder
-// Logic
+// resolve finds where to serve the content of p from
 //
-// 1. Get from ipfs by cid
-// 2. If not found, get from sds
-// 3. If found, try import DAG from file
-// 4. If ok, load node
-func (sb *SdsBlocksBackend) Get(ctx context.Context, path_ path.ImmutablePath, ranges ...gateway.ByteRange) (gateway.ContentPathMetadata, *gateway.GetResponse, error) {
-	var (
-		doPinRoots = false
-		fileData   []byte
-		errS       error
-	)
+// 1. If the root block of p is not on the node, look it up in ipfs for at
+// most Sds.Fallback.LookupTimeout
+// 2. If it is not found, get the root cid from sds through its share link
+// 3. A downloaded CAR is imported, its DAG is served from ipfs as the import goes
+//
+// The content itself is not looked up, so the callers get it from ipfs once.
+// They follow the mapping files of their responses with follow.
+//
+// The span of the request has child spans for the ipfs lookup, the sds
+// download and the DAG import, its "sds.source" attribute tells where the
+// content came from.
+func (sb *SdsBlocksBackend) resolve(ctx context.Context, p path.ImmutablePath) (*sdsContent, error) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("sds.source", "ipfs"))
+	if sb.guard.mode == config.SdsFallbackOff {
+		return &sdsContent{path: p}, nil
+	}
 
-	// NOTE: Check first if file exists in ipfs
-	md, n, err := sb.b.Get(ctx, path_, ranges...)
-	fmt.Printf("SdsBlocksBackend Get path_ %+v\n", path_)
-	fmt.Printf("SdsBlocksBackend Get md %+v\n", md)
-	fmt.Printf("SdsBlocksBackend Get n %+v\n", n)
-	fmt.Printf("SdsBlocksBackend Get err %+v\n", err)
-	// Not exist, trying to get from sds
-	if err != nil {
-		if !sb.cfg.Enabled {
-			return md, n, err
+	// the root could be imported for another request, its DAG is then
+	// served as the import goes
+	if !sb.importing(p.RootCid().String()) {
+		missing, err := sb.rootMissing(ctx, p.RootCid())
+		switch {
+		case missing:
+			return sb.fetch(ctx, p, err)
+		case err != nil:
+			return nil, err
 		}
+		return &sdsContent{path: p}, nil
+	}
+	return sb.fetch(ctx, p, nil)
+}
+
+// follow reports if the mapping file of link, found at p, is followed to the
+// DAG it links to. An untrusted mapping file is served as it is.
+func (sb *SdsBlocksBackend) follow(ctx context.Context, p path.ImmutablePath, link *Link) bool {
+	if err := sb.fetcher.LinkTrust().Check(ctx, link); err != nil {
+		logger.Debugf("not following the mapping file %s: %s", p, err)
+		return false
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("sds.source", "mapping"), attribute.String("sds.wallet", link.Wallet))
+	return true
+}
+
+// headLink returns the link of the mapping file when head is the one of a
+// mapping file, with the version and the wallet of the file
+func headLink(head *gateway.HeadResponse) (*Link, bool) {
+	isFile, _ := getDynamicField(head, "isFile").(bool)
+	if !isFile {
+		return nil, false
+	}
+	size, _ := getDynamicField(head, "bytesSize").(int64)
+	startingBytes, _ := getDynamicField(head, "startingBytes").(io.Reader)
+	return peekLink(startingBytes, size)
+}
+
+// getLink returns the link of the mapping file when res is the one of a
+// mapping file
+func getLink(res *gateway.GetResponse) (*Link, bool) {
+	size, _ := getDynamicField(res, "bytesSize").(int64)
+	bytes, _ := getDynamicField(res, "bytes").(io.Reader)
+	return peekLink(bytes, size)
+}
+
+// peekLink decodes the mapping file read from r, of size bytes. Only the
+// small files are read, and r is seeked back where it was so the response
+// could still be served.
+func peekLink(r io.Reader, size int64) (*Link, bool) {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok || size <= 0 || size > maxLinkSize {
+		return nil, false
+	}
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, false
+	}
+	fileData, readErr := io.ReadAll(io.LimitReader(rs, maxLinkSize))
+	if _, err := rs.Seek(pos, io.SeekStart); err != nil || readErr != nil {
+		return nil, false
+	}
+	link, err := DecodeLink(fileData)
+	if err != nil {
+		return nil, false
+	}
+	return link, true
+}
+
+// fetch gets the content of p, missing in ipfs, from sds. lookupErr is
+// returned when sds does not have it either. Without lookupErr, p is only
+// served from the import of its root for another request.
+func (sb *SdsBlocksBackend) fetch(ctx context.Context, p path.ImmutablePath, lookupErr error) (*sdsContent, error) {
+	root := p.RootCid().String()
 
-		// TODO: Maybe to get from ipfs also first?
-		shareLink := fwtypes.SetShareLink(path_.Segments()[1], "")
-
-		// no care of error
-		fileData, _ = sb.fetcher.DownloadFromShare(shareLink.String())
-		// in this case we should pin to store into local block tree
-		doPinRoots = true
-	} else if sb.cfg.Enabled {
-		// in case file found on ipfs, check if it is a mapping file and get original car file
-		// getting file data from gateway
-		fileData, errS = readAndResetGatewayResponse(n)
-		fmt.Println("fileData errS", errS)
-		if errS == nil {
-			originalCid, errS := ParseLink(fileData)
-			fmt.Printf("originalCid %+v\n", originalCid)
-			if errS == nil {
-				oPath, errS := path.NewPath("/ipfs/" + originalCid.String())
-				if err != nil {
-					return gateway.ContentPathMetadata{}, nil, errS
-				}
-				path_, errS = path.NewImmutablePath(oPath)
-				if errS != nil {
-					return gateway.ContentPathMetadata{}, nil, errS
-				}
-				fmt.Printf("path_ %+v\n", path_)
-				md, n, err = sb.b.Get(ctx, path_, ranges...)
-				if err != nil {
-					return md, n, err
-				}
-				// update gateway content
-				fileData, errS = readAndResetGatewayResponse(n)
-				if errS != nil {
-					return md, n, nil
-				}
+	for {
+		// the same root could be imported for another request
+		imported, leader := sb.join(root, lookupErr != nil)
+		switch {
+		case imported == nil:
+			// the import is over, its DAG is in ipfs
+			return &sdsContent{path: p}, nil
+		case !leader:
+			started, err := imported.started(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if started {
+				return importedContent(imported, p)
 			}
+			// the file is not a CAR or the request which downloaded it was
+			// refused, it is downloaded again for this one
+			continue
 		}
+		return sb.download(ctx, imported, p, lookupErr)
+	}
+}
+
+// importing reports if the DAG of root is being imported
+func (sb *SdsBlocksBackend) importing(root string) bool {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	_, ok := sb.imports[root]
+	return ok
+}
+
+// rootMissing looks the root block up in ipfs when it is not on the node.
+// An online node does not report a missing block, it waits for a provider,
+// so the lookup is bounded by Sds.Fallback.LookupTimeout. It reports if the
+// root is missing, not found or not found in time, along with the error of
+// the lookup. The other failures are not fetched from sds, like a request
+// cancelled or timed out.
+func (sb *SdsBlocksBackend) rootMissing(ctx context.Context, root cid.Cid) (bool, error) {
+	if has, err := sb.bs.Has(ctx, root); err != nil || has {
+		return false, err
+	}
+
+	lookupCtx, span := tracing.Span(ctx, "Sds.Gateway", "IPFSLookup", trace.WithAttributes(attribute.String("cid", root.String())))
+	defer span.End()
+	lookupCtx, cancel := context.WithTimeout(lookupCtx, sb.lookupTimeout)
+	defer cancel()
+
+	_, block, err := sb.b.GetBlock(lookupCtx, path.FromCid(root))
+	if err == nil {
+		block.Close()
+		return false, nil
 	}
+	timedOut := errors.Is(lookupCtx.Err(), context.DeadlineExceeded)
+	return ctx.Err() == nil && (timedOut || format.IsNotFound(err)), err
+}
+
+// download downloads the root of p from sds for the import registered by
+// fetch, the DAG of a CAR file is imported. The download is not stopped with
+// ctx, the other requests for the root could wait for it.
+func (sb *SdsBlocksBackend) download(ctx context.Context, imported *carImport, p path.ImmutablePath, lookupErr error) (*sdsContent, error) {
+	root := p.RootCid().String()
 
-	fmt.Println("fileData", fileData)
+	// the content is missing from sds too unless the client was refused the
+	// download
+	shareLink := fwtypes.SetShareLink(root, "").String()
+	dctx, cancel := sb.detach(ctx)
+	file, err := sb.guard.download(dctx, shareLink, func(ctx context.Context) (files.File, error) {
+		return sb.fetcher.DownloadFromShare(ctx, shareLink)
+	})
+	if err != nil {
+		cancel()
+		sb.abort(imported, err)
+		return nil, fallbackError(err, lookupErr)
+	}
+	file = &guardedFile{File: file, release: cancel}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("sds.source", "sds"))
 
-	isCar, _ := IsCAR(files.NewBytesFile(fileData))
-	fmt.Printf("isCar %+v\n", isCar)
-	if isCar {
-		sdsP, errS := NewDagParser(ctx, sb.dag, sb.bs, sb.pin).Import(files.NewBytesFile(fileData), doPinRoots)
-		if errS != nil {
-			return gateway.ContentPathMetadata{}, nil, errS
+	isCar, _ := IsCAR(file)
+	if !isCar {
+		sb.abort(imported, ErrNotDAG)
+		// plain file shared through sds, it has no path below its root
+		if len(p.Segments()) > 2 {
+			file.Close()
+			return nil, lookupErr
 		}
+		return &sdsContent{path: p, file: file}, nil
+	}
+
+	// in this case we should pin to store into local block tree
+	if err := sb.start(imported, file, true); err != nil {
+		return nil, err
+	}
+	return importedContent(imported, p)
+}
+
+// fallbackError returns the error answered when the download from sds
+// failed with err: the refusals of the guard and of the budget, lookupErr
+// otherwise
+func fallbackError(err, lookupErr error) error {
+	switch {
+	case errors.Is(err, ErrFallbackDenied), errors.Is(err, ErrRateLimited), errors.Is(err, ErrFallbackBusy), errors.Is(err, ErrBudgetExceeded):
+		return gateway.NewErrorStatusCode(err, ErrorStatus(err))
+	}
+	return lookupErr
+}
+
+// importedContent returns the content of p in the DAG of the import
+func importedContent(imported *carImport, p path.ImmutablePath) (*sdsContent, error) {
+	sdsP, err := ModifySdsCARPath(imported.root, p)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := path.NewImmutablePath(sdsP)
+	if err != nil {
+		return nil, err
+	}
+	return &sdsContent{path: ip, imported: imported}, nil
+}
+
+// carImport is the download of a CAR from sds and the import of its DAG,
+// shared by the concurrent requests for it
+type carImport struct {
+	// ready is closed once the import is started or failed to
+	ready chan struct{}
+	// root is the path of the root of the imported DAG, nil when the import
+	// did not start
+	root path.Path
+	// ctx is cancelled when the import fails
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	err    error
+	// keys the import is found by in SdsBlocksBackend.imports
+	keys []string
+}
 
-		fmt.Printf("sdsP (before) %+v\n", sdsP)
-		sdsP, errS = ModifySdsCARPath(sdsP, path_)
-		fmt.Printf("sdsP (after) %+v\n", sdsP)
-		if errS != nil {
-			return gateway.ContentPathMetadata{}, nil, errS
+// started waits for the import to start, it reports false when it did not
+func (ci *carImport) started(ctx context.Context) (bool, error) {
+	select {
+	case <-ci.ready:
+		return ci.root != nil, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (ci *carImport) wait() error {
+	<-ci.done
+	return ci.err
+}
+
+// join returns the import found by id. When there is none and register is
+// set, a new one is registered for the caller to download and start, leader
+// is true then, so the concurrent requests for id wait for it instead of
+// downloading the file again.
+func (sb *SdsBlocksBackend) join(id string, register bool) (imported *carImport, leader bool) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if ci, ok := sb.imports[id]; ok {
+		return ci, false
+	}
+	if !register {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	ci := &carImport{
+		ready:  make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		keys:   []string{id},
+	}
+	sb.imports[id] = ci
+	return ci, true
+}
+
+// abort ends the import registered by join before it started, with err
+func (sb *SdsBlocksBackend) abort(ci *carImport, err error) {
+	sb.finish(ci, nil, err)
+}
+
+// finish ends the import registered by join without running it, the DAG of
+// root is already in ipfs unless root is nil
+func (sb *SdsBlocksBackend) finish(ci *carImport, root path.Path, err error) {
+	ci.root = root
+	ci.err = err
+	if err != nil {
+		ci.cancel(err)
+	}
+	sb.unregister(ci)
+	close(ci.ready)
+	close(ci.done)
+}
+
+func (sb *SdsBlocksBackend) unregister(ci *carImport) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for _, k := range ci.keys {
+		if sb.imports[k] == ci {
+			delete(sb.imports, k)
 		}
+	}
+}
+
+// start imports the CAR of file for the import registered by join and
+// closes it when done. The import runs until the node is closed whatever
+// happens to the request which started it, it is found by the root cid of
+// the CAR too while it runs.
+func (sb *SdsBlocksBackend) start(ci *carImport, file files.File, doPinRoots bool) error {
+	p, done, err := NewDagParser(sb.ctx, sb.dag, sb.bs, sb.pin).ImportAsync(file, doPinRoots)
+	if err != nil {
+		file.Close()
+		sb.abort(ci, err)
+		return err
+	}
 
-		path_, errS = path.NewImmutablePath(sdsP)
-		if errS != nil {
-			return gateway.ContentPathMetadata{}, nil, errS
+	sb.mu.Lock()
+	ci.root = p
+	if k := p.Segments()[1]; k != ci.keys[0] {
+		if _, ok := sb.imports[k]; !ok {
+			sb.imports[k] = ci
+			ci.keys = append(ci.keys, k)
 		}
+	}
+	sb.mu.Unlock()
+	close(ci.ready)
 
-		md, n, err = sb.b.Get(ctx, path_, ranges...)
-		fmt.Printf("SdsBlocksBackend Get (CAR) path_ %+v\n", path_)
-		fmt.Printf("SdsBlocksBackend Get (CAR) md %+v\n", md)
-		fmt.Printf("SdsBlocksBackend Get (CAR) n %+v\n", n)
-		fmt.Printf("SdsBlocksBackend Get (CAR) err %+v\n", err)
-		if err != nil {
-			return md, n, err
+	go func() {
+		defer close(ci.done)
+		defer file.Close()
+		ci.err = <-done
+		if ci.err != nil {
+			ci.cancel(ci.err)
 		}
+		sb.unregister(ci)
+	}()
+	return nil
+}
+
+// detach returns a context with the values of ctx, like its client and
+// span, cancelled when the backend is closed rather than with ctx. The
+// downloads shared by several requests run with it.
+func (sb *SdsBlocksBackend) detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	dctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(sb.ctx, cancel)
+	return dctx, func() {
+		stop()
+		cancel()
 	}
+}
 
-	fmt.Println("md total", md)
-	fmt.Println("n total", n)
-	fmt.Println("err total", err)
+// Get serves the content from ipfs, falling back to sds when it is missing
+// there. A mapping file is served as the DAG it links to.
+func (sb *SdsBlocksBackend) Get(ctx context.Context, p path.ImmutablePath, ranges ...gateway.ByteRange) (gateway.ContentPathMetadata, *gateway.GetResponse, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Gateway", "Get", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
 
-	return md, n, err
+	if !sb.cfg.Enabled {
+		return sb.b.Get(ctx, p, ranges...)
+	}
+	return sb.get(ctx, p, true, ranges...)
 }
 
-func (sb *SdsBlocksBackend) GetAll(ctx context.Context, path path.ImmutablePath) (gateway.ContentPathMetadata, files.Node, error) {
-	return sb.b.GetAll(ctx, path)
+func (sb *SdsBlocksBackend) get(ctx context.Context, p path.ImmutablePath, follow bool, ranges ...gateway.ByteRange) (gateway.ContentPathMetadata, *gateway.GetResponse, error) {
+	c, err := sb.resolve(ctx, p)
+	if err != nil {
+		return gateway.ContentPathMetadata{}, nil, err
+	}
+	if c.file != nil {
+		return serveDownload(c)
+	}
+
+	var (
+		md gateway.ContentPathMetadata
+		n  *gateway.GetResponse
+	)
+	err = c.call(ctx, func(ctx context.Context, p path.ImmutablePath) (err error) {
+		md, n, err = sb.b.Get(ctx, p, ranges...)
+		return err
+	})
+	if err != nil || !follow {
+		return md, n, err
+	}
+	if link, ok := getLink(n); ok && sb.follow(ctx, p, link) {
+		n.Close()
+		return sb.get(ctx, path.FromCid(link.Cid), false, ranges...)
+	}
+	return md, n, nil
 }
 
-func (sb *SdsBlocksBackend) GetBlock(ctx context.Context, path path.ImmutablePath) (gateway.ContentPathMetadata, files.File, error) {
-	return sb.b.GetBlock(ctx, path)
+// serveDownload responds with a non CAR file downloaded from sds. The size is
+// required by the gateway for the headers, so it waits for the download end.
+func serveDownload(c *sdsContent) (gateway.ContentPathMetadata, *gateway.GetResponse, error) {
+	size, err := c.file.Size()
+	if err != nil {
+		c.file.Close()
+		return gateway.ContentPathMetadata{}, nil, err
+	}
+	return c.metadata(), gateway.NewGetResponseFromReader(c.file, size), nil
 }
 
-func (sb *SdsBlocksBackend) Head(ctx context.Context, path path.ImmutablePath) (gateway.ContentPathMetadata, *gateway.HeadResponse, error) {
-	return sb.b.Head(ctx, path)
+// GetAll serves the whole DAG of the content, once it is all in ipfs. A
+// mapping file is served as the DAG it links to.
+func (sb *SdsBlocksBackend) GetAll(ctx context.Context, p path.ImmutablePath) (gateway.ContentPathMetadata, files.Node, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Gateway", "GetAll", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	if !sb.cfg.Enabled {
+		return sb.b.GetAll(ctx, p)
+	}
+	return sb.getAll(ctx, p, true)
 }
 
-func (sb *SdsBlocksBackend) ResolvePath(ctx context.Context, path path.ImmutablePath) (gateway.ContentPathMetadata, error) {
-	return sb.b.ResolvePath(ctx, path)
+func (sb *SdsBlocksBackend) getAll(ctx context.Context, p path.ImmutablePath, follow bool) (gateway.ContentPathMetadata, files.Node, error) {
+	c, err := sb.resolve(ctx, p)
+	if err != nil {
+		return gateway.ContentPathMetadata{}, nil, err
+	}
+	if c.file != nil {
+		return c.metadata(), c.file, nil
+	}
+	if err := c.wait(); err != nil {
+		return gateway.ContentPathMetadata{}, nil, err
+	}
+
+	md, n, err := sb.b.GetAll(ctx, c.path)
+	if err != nil || !follow {
+		return md, n, err
+	}
+	if f, ok := n.(files.File); ok {
+		size, err := f.Size()
+		if link, ok := peekLink(f, size); err == nil && ok && sb.follow(ctx, p, link) {
+			f.Close()
+			return sb.getAll(ctx, path.FromCid(link.Cid), false)
+		}
+	}
+	return md, n, nil
+}
+
+// GetBlock serves the block of the content. A mapping file is served as is,
+// the block must match the requested cid.
+func (sb *SdsBlocksBackend) GetBlock(ctx context.Context, p path.ImmutablePath) (gateway.ContentPathMetadata, files.File, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Gateway", "GetBlock", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	if !sb.cfg.Enabled {
+		return sb.b.GetBlock(ctx, p)
+	}
+	c, err := sb.resolve(ctx, p)
+	if err != nil {
+		return gateway.ContentPathMetadata{}, nil, err
+	}
+	if c.file != nil {
+		c.file.Close()
+		return gateway.ContentPathMetadata{}, nil, gateway.NewErrorStatusCode(ErrNotDAG, http.StatusNotAcceptable)
+	}
+
+	var (
+		md gateway.ContentPathMetadata
+		f  files.File
+	)
+	err = c.call(ctx, func(ctx context.Context, p path.ImmutablePath) (err error) {
+		md, f, err = sb.b.GetBlock(ctx, p)
+		return err
+	})
+	return md, f, err
 }
 
+// Head describes the content like Get serves it
+func (sb *SdsBlocksBackend) Head(ctx context.Context, p path.ImmutablePath) (gateway.ContentPathMetadata, *gateway.HeadResponse, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Gateway", "Head", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	if !sb.cfg.Enabled {
+		return sb.b.Head(ctx, p)
+	}
+	return sb.head(ctx, p, true)
+}
+
+func (sb *SdsBlocksBackend) head(ctx context.Context, p path.ImmutablePath, follow bool) (gateway.ContentPathMetadata, *gateway.HeadResponse, error) {
+	c, err := sb.resolve(ctx, p)
+	if err != nil {
+		return gateway.ContentPathMetadata{}, nil, err
+	}
+	if c.file != nil {
+		size, err := c.file.Size()
+		if err != nil {
+			c.file.Close()
+			return gateway.ContentPathMetadata{}, nil, err
+		}
+		return c.metadata(), gateway.NewHeadResponseForFile(c.file, size), nil
+	}
+
+	var (
+		md gateway.ContentPathMetadata
+		h  *gateway.HeadResponse
+	)
+	err = c.call(ctx, func(ctx context.Context, p path.ImmutablePath) (err error) {
+		md, h, err = sb.b.Head(ctx, p)
+		return err
+	})
+	if err != nil || !follow {
+		return md, h, err
+	}
+	if link, ok := headLink(h); ok && sb.follow(ctx, p, link) {
+		h.Close()
+		return sb.head(ctx, path.FromCid(link.Cid), false)
+	}
+	return md, h, nil
+}
+
+// ResolvePath resolves the path like Get serves it. The content is looked up
+// like Head does, to tell the mapping files.
+func (sb *SdsBlocksBackend) ResolvePath(ctx context.Context, p path.ImmutablePath) (gateway.ContentPathMetadata, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Gateway", "ResolvePath", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	if !sb.cfg.Enabled {
+		return sb.b.ResolvePath(ctx, p)
+	}
+	md, h, err := sb.head(ctx, p, true)
+	if err != nil {
+		return gateway.ContentPathMetadata{}, err
+	}
+	h.Close()
+	return md, nil
+}
+
+// GetCAR serves the DAG of the content as a CAR, once it is all in ipfs. A
+// mapping file is served as is, the CAR must match the requested cid.
 func (sb *SdsBlocksBackend) GetCAR(ctx context.Context, p path.ImmutablePath, params gateway.CarParams) (gateway.ContentPathMetadata, io.ReadCloser, error) {
-	return sb.b.GetCAR(ctx, p, params)
+	ctx, span := tracing.Span(ctx, "Sds.Gateway", "GetCAR", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	if !sb.cfg.Enabled {
+		return sb.b.GetCAR(ctx, p, params)
+	}
+	c, err := sb.resolve(ctx, p)
+	if err != nil {
+		return gateway.ContentPathMetadata{}, nil, err
+	}
+	if c.file != nil {
+		c.file.Close()
+		return gateway.ContentPathMetadata{}, nil, gateway.NewErrorStatusCode(ErrNotDAG, http.StatusNotAcceptable)
+	}
+	// the CAR is streamed after this returns, a missing block could not be
+	// reported anymore
+	if err := c.wait(); err != nil {
+		return gateway.ContentPathMetadata{}, nil, err
+	}
+	return sb.b.GetCAR(ctx, c.path, params)
 }
 
+// IsCached only reports the content in ipfs, sds is a remote store
 func (sb *SdsBlocksBackend) IsCached(ctx context.Context, path path.Path) bool {
 	return sb.b.IsCached(ctx, path)
 }
 
+// The names are resolved by ipfs only, sds stores content
+
 func (sb *SdsBlocksBackend) GetIPNSRecord(ctx context.Context, cid cid.Cid) ([]byte, error) {
 	return sb.b.GetIPNSRecord(ctx, cid)
 }
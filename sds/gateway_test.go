@@ -0,0 +1,198 @@
+package sds_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/gateway"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/path"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/pinning/pinner/dspinner"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/sds"
+	sdsmock "github.com/ipfs/kubo/sds/mock"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBackend is the sds backend of a node which does not have the content
+// of the tests, over an offline blockstore
+type testBackend struct {
+	*sds.SdsBlocksBackend
+	bs   blockstore.Blockstore
+	pins pin.Pinner
+}
+
+func newTestBackend(t *testing.T, cfg *config.Sds, f *sds.Fetcher, wrap func(gateway.IPFSBackend) gateway.IPFSBackend) *testBackend {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+	bserv := blockservice.New(bs, offline.Exchange(bs))
+	dag := merkledag.NewDAGService(bserv)
+	pins, err := dspinner.New(ctx, ds, dag)
+	require.NoError(t, err)
+
+	b, err := gateway.NewBlocksBackend(bserv)
+	require.NoError(t, err)
+	var ipfs gateway.IPFSBackend = b
+	if wrap != nil {
+		ipfs = wrap(b)
+	}
+	sb, err := sds.NewSdsBlockBackend(ipfs, cfg, config.SdsFallbackPublic, f, dag, bs, pins)
+	require.NoError(t, err)
+	t.Cleanup(sb.Close)
+	return &testBackend{SdsBlocksBackend: sb, bs: bs, pins: pins}
+}
+
+// unresponsiveBackend waits for the blocks missing on the node, like an
+// online node which finds no provider for them
+type unresponsiveBackend struct {
+	gateway.IPFSBackend
+}
+
+func (b unresponsiveBackend) GetBlock(ctx context.Context, p path.ImmutablePath) (gateway.ContentPathMetadata, files.File, error) {
+	md, f, err := b.IPFSBackend.GetBlock(ctx, p)
+	if err != nil {
+		<-ctx.Done()
+		return md, nil, ctx.Err()
+	}
+	return md, f, nil
+}
+
+// shareCAR uploads the CAR of a UnixFS file of data and shares it under its
+// cid. The data of the block does not match its cid when corrupt is set.
+func shareCAR(t *testing.T, owner *sds.Fetcher, data []byte, corrupt bool) cid.Cid {
+	ctx := context.Background()
+	nd := fileNode(data)
+	blockData := nd.RawData()
+	if corrupt {
+		blockData = append([]byte("corrupted "), blockData...)
+	}
+	var car bytes.Buffer
+	require.NoError(t, gocar.WriteHeader(&gocar.CarHeader{Roots: []cid.Cid{nd.Cid()}, Version: 1}, &car))
+	require.NoError(t, carutil.LdWrite(&car, nd.Cid().Bytes(), blockData))
+
+	fileHash, err := owner.Upload(ctx, bytes.NewReader(car.Bytes()), int64(car.Len()), sds.UploadMeta{})
+	require.NoError(t, err)
+	_, err = owner.CreateShareLink(ctx, fileHash, nd.Cid().String(), sds.ShareOptions{})
+	require.NoError(t, err)
+	return nd.Cid()
+}
+
+// fileNode is the single block UnixFS file of data
+func fileNode(data []byte) *merkledag.ProtoNode {
+	return merkledag.NodeWithData(unixfs.FilePBData(data, uint64(len(data))))
+}
+
+func TestSdsBlocksBackend(t *testing.T) {
+	ctx := sds.WithClient(context.Background(), "10.0.0.1")
+
+	newBackend := func(t *testing.T, fallback config.SdsFallback, wrap func(gateway.IPFSBackend) gateway.IPFSBackend) (*sdsmock.PP, *sds.Fetcher, *testBackend) {
+		pp := sdsmock.NewPP()
+		t.Cleanup(pp.Close)
+		cfg := &config.Sds{
+			PrivateKey: testWalletKey,
+			RpcURLs:    []string{pp.URL()},
+			Fallback:   fallback,
+		}
+		f := newTestFetcherConfig(t, cfg)
+		return pp, f, newTestBackend(t, cfg, f, wrap)
+	}
+
+	t.Run("imports the CAR missing in ipfs once", func(t *testing.T) {
+		pp, f, b := newBackend(t, config.SdsFallback{}, nil)
+		root := shareCAR(t, f, []byte("hello sds"), false)
+
+		_, n, err := b.GetAll(ctx, path.FromCid(root))
+		require.NoError(t, err)
+		data, err := io.ReadAll(n.(files.File))
+		require.NoError(t, err)
+		n.Close()
+		assert.Equal(t, []byte("hello sds"), data)
+
+		has, err := b.bs.Has(ctx, root)
+		require.NoError(t, err)
+		assert.True(t, has)
+		_, pinned, err := b.pins.IsPinned(ctx, root)
+		require.NoError(t, err)
+		assert.True(t, pinned)
+
+		_, n, err = b.GetAll(ctx, path.FromCid(root))
+		require.NoError(t, err)
+		n.Close()
+		assert.Equal(t, 1, pp.Calls("user_requestGetShared"))
+	})
+
+	t.Run("does not keep a failed import", func(t *testing.T) {
+		pp, f, b := newBackend(t, config.SdsFallback{}, nil)
+		root := shareCAR(t, f, []byte("hello sds"), true)
+
+		for i := 1; i <= 2; i++ {
+			_, _, err := b.Get(ctx, path.FromCid(root))
+			assert.ErrorContains(t, err, "import failed")
+			// the import is not found by the next request, which downloads
+			// the CAR again
+			assert.Equal(t, i, pp.Calls("user_requestGetShared"))
+		}
+		has, err := b.bs.Has(ctx, root)
+		require.NoError(t, err)
+		assert.False(t, has)
+	})
+
+	t.Run("does not keep an aborted download", func(t *testing.T) {
+		// the refusal of sds is not cached for the next request
+		_, f, b := newBackend(t, config.SdsFallback{NegativeCacheTTL: config.NewOptionalDuration(time.Nanosecond)}, nil)
+		nd := fileNode([]byte("hello sds"))
+
+		// the root is not shared yet
+		_, _, err := b.Get(ctx, path.FromCid(nd.Cid()))
+		require.Error(t, err)
+
+		root := shareCAR(t, f, []byte("hello sds"), false)
+		require.Equal(t, nd.Cid(), root)
+		_, n, err := b.GetAll(ctx, path.FromCid(root))
+		require.NoError(t, err)
+		n.Close()
+	})
+
+	t.Run("looks the root up in ipfs for the lookup timeout", func(t *testing.T) {
+		const lookupTimeout = 200 * time.Millisecond
+		_, f, b := newBackend(t, config.SdsFallback{LookupTimeout: config.NewOptionalDuration(lookupTimeout)}, func(b gateway.IPFSBackend) gateway.IPFSBackend {
+			return unresponsiveBackend{b}
+		})
+		root := shareCAR(t, f, []byte("hello sds"), false)
+
+		start := time.Now()
+		_, n, err := b.GetAll(ctx, path.FromCid(root))
+		require.NoError(t, err)
+		n.Close()
+		assert.GreaterOrEqual(t, time.Since(start), lookupTimeout)
+	})
+
+	t.Run("does not download the root of a request cancelled during the lookup", func(t *testing.T) {
+		pp, f, b := newBackend(t, config.SdsFallback{LookupTimeout: config.NewOptionalDuration(time.Minute)}, func(b gateway.IPFSBackend) gateway.IPFSBackend {
+			return unresponsiveBackend{b}
+		})
+		root := shareCAR(t, f, []byte("hello sds"), false)
+
+		rctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, _, err := b.Get(rctx, path.FromCid(root))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Zero(t, pp.Calls("user_requestGetShared"))
+	})
+}
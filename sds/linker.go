@@ -1,36 +1,285 @@
 package sds
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/ipfs/boxo/files"
 	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
 	sdsprotos "github.com/ipfs/kubo/sds/protos"
+	mc "github.com/multiformats/go-multicodec"
+	"github.com/stratosnet/sds/framework/crypto"
+	ethcrypto "github.com/stratosnet/sds/framework/crypto/ethereum"
+	fwtypes "github.com/stratosnet/sds/framework/types"
 )
 
-func NewSdsFile(cid cid.Cid, fileHash string) (files.Node, error) {
-	link := &sdsprotos.SdsLinker{
+// LinkVersion is the version of the mapping files written by the node
+const LinkVersion = 2
+
+// linkSignatureLength is the length of the [R || S || V] signatures of the
+// wallets
+const linkSignatureLength = 65
+
+// linkMagic starts the mapping files from v2 on. A v1 mapping file is a bare
+// protobuf, which never starts with a zero byte.
+var linkMagic = []byte("\x00sdslink")
+
+var (
+	// ErrNotLink is returned when a file is not a mapping file
+	ErrNotLink = errors.New("sds: not a mapping file")
+	// ErrLinkSignature is returned when the signature of a mapping file
+	// does not match its content or its wallet
+	ErrLinkSignature = errors.New("sds: invalid mapping file signature")
+	// ErrLinkUntrusted is returned for a mapping file which is not followed,
+	// signed by a wallet which is not trusted or unsigned
+	ErrLinkUntrusted = errors.New("sds: untrusted mapping file")
+)
+
+// Link is the content of a mapping file, which maps the root of a DAG to the
+// sds file holding its CAR
+type Link struct {
+	// Version of the mapping file, 1 for the unsigned files of older nodes
+	Version int
+	// Cid is the root of the original DAG
+	Cid      cid.Cid
+	FileHash string
+	// CarSize is the size of the CAR of the DAG, zero for a v1 file
+	CarSize int64
+	// ShareLink is the sds:// link the CAR is shared under
+	ShareLink string
+	// Wallet which uploaded the CAR and signed the file, along with its
+	// bech32 public key
+	Wallet string
+	PubKey string
+}
+
+// linkPayload is the signed content of a v2 mapping file
+type linkPayload struct {
+	Cid       string `json:"cid"`
+	Codec     string `json:"codec"`
+	FileHash  string `json:"filehash"`
+	CarSize   int64  `json:"carsize"`
+	ShareLink string `json:"sharelink,omitempty"`
+	Wallet    string `json:"wallet"`
+	PubKey    string `json:"pubkey"`
+}
+
+// NewSdsFile returns a mapping file of the link, signed by the wallet. A v2
+// mapping file is the magic prefix, the version and the length of the
+// payload as uvarints, the JSON payload, and the signature of all of these.
+func NewSdsFile(ctx context.Context, wallet *SdsWallet, link Link) (files.File, error) {
+	address, err := wallet.GetAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := wallet.GetBech32PubKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(linkPayload{
+		Cid:       link.Cid.String(),
+		Codec:     mc.Code(link.Cid.Prefix().Codec).String(),
+		FileHash:  link.FileHash,
+		CarSize:   link.CarSize,
+		ShareLink: link.ShareLink,
+		Wallet:    address,
+		PubKey:    pubKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b := append([]byte{}, linkMagic...)
+	b = binary.AppendUvarint(b, LinkVersion)
+	b = binary.AppendUvarint(b, uint64(len(payload)))
+	b = append(b, payload...)
+	sign, err := wallet.SignLink(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	return files.NewBytesFile(append(b, sign...)), nil
+}
+
+// NewSdsFileV1 returns an unsigned v1 mapping file, the format of the older
+// nodes, which is still read
+func NewSdsFileV1(cid cid.Cid, fileHash string) (files.File, error) {
+	b, err := proto.Marshal(&sdsprotos.SdsLinker{
 		OriginalCid: cid.String(),
 		SdsFileHash: fileHash,
-	}
-	b, err := proto.Marshal(link)
+	})
 	if err != nil {
 		return nil, err
 	}
-	rfc := files.NewBytesFile(b)
-	return rfc, nil
+	return files.NewBytesFile(b), nil
 }
 
-func ParseLink(data []byte) (cid.Cid, error) {
+// DecodeLink decodes the content of a mapping file. The signature of a v2
+// file is verified, a v1 file has none and is only accepted when it holds
+// a cid and a file hash and nothing else.
+func DecodeLink(data []byte) (*Link, error) {
 	if len(data) == 0 {
-		return cid.Cid{}, fmt.Errorf("empty file data")
+		return nil, fmt.Errorf("empty file data")
 	}
-	link := &sdsprotos.SdsLinker{}
-	err := proto.Unmarshal(data, link)
+	if bytes.HasPrefix(data, linkMagic) {
+		return decodeLink(data)
+	}
+	return decodeLinkV1(data)
+}
+
+// LinkTrust tells the mapping files followed to the DAGs they link to: the
+// ones signed by a trusted wallet, and the unsigned v1 ones when opted in.
+// A valid signature only tells who wrote the file, anyone could sign one.
+type LinkTrust struct {
+	wallets  map[string]struct{}
+	unsigned bool
+	// node is the wallet of the node, whose address may only be known once
+	// its signer is asked
+	node *SdsWallet
+}
+
+// NewLinkTrust trusts the wallet of the node, which could be empty, along
+// with Sds.TrustedWallets
+func NewLinkTrust(cfg *config.Sds, wallet string) *LinkTrust {
+	t := &LinkTrust{
+		wallets:  make(map[string]struct{}, len(cfg.TrustedWallets)+1),
+		unsigned: cfg.FollowUnsignedLinks.WithDefault(false),
+	}
+	for _, w := range append([]string{wallet}, cfg.TrustedWallets...) {
+		if w != "" {
+			t.wallets[w] = struct{}{}
+		}
+	}
+	return t
+}
+
+// newNodeLinkTrust trusts the wallet of the node along with
+// Sds.TrustedWallets, the signer of the wallet is only asked for the mapping
+// files of the other wallets
+func newNodeLinkTrust(cfg *config.Sds, wallet *SdsWallet) *LinkTrust {
+	t := NewLinkTrust(cfg, wallet.address)
+	t.node = wallet
+	return t
+}
+
+// Check returns ErrLinkUntrusted when the mapping file of link is not
+// followed
+func (t *LinkTrust) Check(ctx context.Context, link *Link) error {
+	if link.Version == 1 {
+		if t.unsigned {
+			return nil
+		}
+		return fmt.Errorf("%w: unsigned v1 mapping file", ErrLinkUntrusted)
+	}
+	if _, ok := t.wallets[link.Wallet]; ok {
+		return nil
+	}
+	if t.node != nil && t.node.address == "" {
+		address, err := t.node.GetAddress(ctx)
+		if err != nil {
+			return fmt.Errorf("%w: signed by %s, the node wallet is unknown: %w", ErrLinkUntrusted, link.Wallet, err)
+		}
+		if address == link.Wallet {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signed by %s", ErrLinkUntrusted, link.Wallet)
+}
+
+// decodeLink decodes a mapping file starting with the magic prefix
+func decodeLink(data []byte) (*Link, error) {
+	rest := data[len(linkMagic):]
+	version, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: invalid version", ErrNotLink)
+	}
+	if version != LinkVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrNotLink, version)
+	}
+	rest = rest[n:]
+	size, m := binary.Uvarint(rest)
+	if m <= 0 || size > uint64(len(rest)-m) {
+		return nil, fmt.Errorf("%w: truncated payload", ErrNotLink)
+	}
+	payloadData := rest[m : m+int(size)]
+	// the signature covers everything before it
+	signed, sign := data[:len(data)-len(rest)+m+int(size)], rest[m+int(size):]
+
+	var payload linkPayload
+	if err := json.Unmarshal(payloadData, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotLink, err)
+	}
+	link, err := payload.link()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := fwtypes.WalletPubKeyFromBech32(payload.PubKey)
+	if err != nil || !fwtypes.VerifyWalletAddr(payload.PubKey, payload.Wallet) {
+		return nil, fmt.Errorf("%w: the public key is not the one of %s", ErrLinkSignature, payload.Wallet)
+	}
+	if !pubKey.VerifySignature(signed, sign) {
+		return nil, ErrLinkSignature
+	}
+	// VerifySignature ignores the recovery id, which is checked as well so
+	// that a mapping file can not be altered into another valid one
+	if len(sign) != linkSignatureLength {
+		return nil, fmt.Errorf("%w: malformed signature", ErrLinkSignature)
+	}
+	recovered, err := ethcrypto.SigToPub(ethcrypto.Keccak256(signed), sign)
+	if err != nil || !bytes.Equal(ethcrypto.CompressPubkey(recovered), pubKey.Bytes()) {
+		return nil, fmt.Errorf("%w: invalid recovery id", ErrLinkSignature)
+	}
+	return link, nil
+}
+
+// link checks the payload of a mapping file and returns its link
+func (p *linkPayload) link() (*Link, error) {
+	c, err := cid.Parse(p.Cid)
 	if err != nil {
-		return cid.Cid{}, err
+		return nil, fmt.Errorf("%w: %w", ErrNotLink, err)
+	}
+	if codec := mc.Code(c.Prefix().Codec).String(); codec != p.Codec {
+		return nil, fmt.Errorf("%w: codec %s of %s is not %s", ErrNotLink, p.Codec, c, codec)
 	}
+	if !crypto.ValidateHash(p.FileHash) {
+		return nil, fmt.Errorf("%w: %q is not a valid sds file hash", ErrNotLink, p.FileHash)
+	}
+	if p.CarSize <= 0 {
+		return nil, fmt.Errorf("%w: invalid car size %d", ErrNotLink, p.CarSize)
+	}
+	return &Link{
+		Version:   LinkVersion,
+		Cid:       c,
+		FileHash:  p.FileHash,
+		CarSize:   p.CarSize,
+		ShareLink: p.ShareLink,
+		Wallet:    p.Wallet,
+		PubKey:    p.PubKey,
+	}, nil
+}
 
-	return cid.Parse(link.OriginalCid)
+// decodeLinkV1 decodes an unsigned v1 mapping file. Most short files parse as
+// a protobuf, so the file should hold nothing but the two fields.
+func decodeLinkV1(data []byte) (*Link, error) {
+	v1 := &sdsprotos.SdsLinker{}
+	if err := proto.Unmarshal(data, v1); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotLink, err)
+	}
+	if len(proto.MessageReflect(v1).GetUnknown()) > 0 {
+		return nil, fmt.Errorf("%w: unknown fields", ErrNotLink)
+	}
+	c, err := cid.Parse(v1.OriginalCid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotLink, err)
+	}
+	if !crypto.ValidateHash(v1.SdsFileHash) {
+		return nil, fmt.Errorf("%w: %q is not a valid sds file hash", ErrNotLink, v1.SdsFileHash)
+	}
+	return &Link{Version: 1, Cid: c, FileHash: v1.SdsFileHash}, nil
 }
@@ -0,0 +1,159 @@
+package sds_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/sds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLinkFileHash = "v05j1m517ljekhi1c4ce82pb62c5p1vdjvrbph2g"
+
+func readLinkFile(t *testing.T, f files.File, err error) []byte {
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return data
+}
+
+func TestLink(t *testing.T) {
+	ctx := context.Background()
+	c, err := cid.Decode("QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	require.NoError(t, err)
+	wallet, err := sds.NewSdsWallet(testWalletKey)
+	require.NoError(t, err)
+	pubKey, err := wallet.GetBech32PubKey(ctx)
+	require.NoError(t, err)
+
+	link := sds.Link{
+		Cid:       c,
+		FileHash:  testLinkFileHash,
+		CarSize:   1234,
+		ShareLink: "sds://" + c.String(),
+	}
+	f, err := sds.NewSdsFile(ctx, wallet, link)
+	data := readLinkFile(t, f, err)
+
+	t.Run("v2 files are signed", func(t *testing.T) {
+		decoded, err := sds.DecodeLink(data)
+		require.NoError(t, err)
+		assert.Equal(t, &sds.Link{
+			Version:   sds.LinkVersion,
+			Cid:       c,
+			FileHash:  testLinkFileHash,
+			CarSize:   1234,
+			ShareLink: "sds://" + c.String(),
+			Wallet:    walletAddress(t, wallet),
+			PubKey:    pubKey,
+		}, decoded)
+	})
+
+	t.Run("tampered files are rejected", func(t *testing.T) {
+		// flip a byte of the payload, then of the signature
+		for _, i := range []int{len(data) - 80, len(data) - 1} {
+			tampered := append([]byte{}, data...)
+			tampered[i] ^= 1
+			_, err := sds.DecodeLink(tampered)
+			assert.Error(t, err, i)
+		}
+
+		// the signatures have a single encoding
+		for _, tampered := range [][]byte{
+			data[:len(data)-1],
+			append(append([]byte{}, data...), 0),
+		} {
+			_, err := sds.DecodeLink(tampered)
+			assert.ErrorIs(t, err, sds.ErrLinkSignature)
+		}
+	})
+
+	t.Run("files signed by another wallet are rejected", func(t *testing.T) {
+		other, err := sds.GenerateSdsWallet()
+		require.NoError(t, err)
+		otherPubKey, err := other.GetBech32PubKey(ctx)
+		require.NoError(t, err)
+		f, err := sds.NewSdsFile(ctx, other, link)
+		otherData := readLinkFile(t, f, err)
+		_, err = sds.DecodeLink(otherData)
+		require.NoError(t, err)
+
+		// claim the key of the wallet, then the wallet as well
+		forged := bytes.ReplaceAll(otherData, []byte(otherPubKey), []byte(pubKey))
+		_, err = sds.DecodeLink(forged)
+		assert.ErrorIs(t, err, sds.ErrLinkSignature)
+		forged = bytes.ReplaceAll(forged, []byte(walletAddress(t, other)), []byte(walletAddress(t, wallet)))
+		_, err = sds.DecodeLink(forged)
+		assert.ErrorIs(t, err, sds.ErrLinkSignature)
+	})
+
+	t.Run("invalid links are rejected", func(t *testing.T) {
+		for _, invalid := range []sds.Link{
+			{Cid: c, FileHash: testLinkFileHash},
+			{Cid: c, FileHash: "not a hash", CarSize: 1},
+		} {
+			f, err := sds.NewSdsFile(ctx, wallet, invalid)
+			_, err = sds.DecodeLink(readLinkFile(t, f, err))
+			assert.ErrorIs(t, err, sds.ErrNotLink)
+		}
+	})
+
+	t.Run("v1 files are still read", func(t *testing.T) {
+		f, err := sds.NewSdsFileV1(c, testLinkFileHash)
+		decoded, err := sds.DecodeLink(readLinkFile(t, f, err))
+		require.NoError(t, err)
+		assert.Equal(t, &sds.Link{Version: 1, Cid: c, FileHash: testLinkFileHash}, decoded)
+
+		f, err = sds.NewSdsFileV1(c, "not a hash")
+		_, err = sds.DecodeLink(readLinkFile(t, f, err))
+		assert.ErrorIs(t, err, sds.ErrNotLink)
+	})
+
+	t.Run("only trusted files are followed", func(t *testing.T) {
+		other, err := sds.GenerateSdsWallet()
+		require.NoError(t, err)
+		f, err := sds.NewSdsFile(ctx, other, link)
+		otherLink, err := sds.DecodeLink(readLinkFile(t, f, err))
+		require.NoError(t, err)
+		f, err = sds.NewSdsFileV1(c, testLinkFileHash)
+		v1Link, err := sds.DecodeLink(readLinkFile(t, f, err))
+		require.NoError(t, err)
+		ownLink, err := sds.DecodeLink(data)
+		require.NoError(t, err)
+
+		trust := sds.NewLinkTrust(&config.Sds{}, walletAddress(t, wallet))
+		assert.NoError(t, trust.Check(ctx, ownLink))
+		assert.ErrorIs(t, trust.Check(ctx, otherLink), sds.ErrLinkUntrusted)
+		assert.ErrorIs(t, trust.Check(ctx, v1Link), sds.ErrLinkUntrusted)
+
+		trust = sds.NewLinkTrust(&config.Sds{
+			TrustedWallets:      []string{walletAddress(t, other)},
+			FollowUnsignedLinks: config.True,
+		}, "")
+		assert.ErrorIs(t, trust.Check(ctx, ownLink), sds.ErrLinkUntrusted)
+		assert.NoError(t, trust.Check(ctx, otherLink))
+		assert.NoError(t, trust.Check(ctx, v1Link))
+	})
+
+	t.Run("other files are not links", func(t *testing.T) {
+		f, err := sds.NewSdsFileV1(c, testLinkFileHash)
+		v1Data := readLinkFile(t, f, err)
+		for _, data := range [][]byte{
+			[]byte("hello sds"),
+			// a protobuf with a field the mapping files do not have
+			append(v1Data, 0x20, 0x01),
+			// a future version
+			append([]byte("\x00sdslink"), 0x03, 0x00),
+		} {
+			_, err := sds.DecodeLink(data)
+			assert.ErrorIs(t, err, sds.ErrNotLink, string(data))
+		}
+	})
+}
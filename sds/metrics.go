@@ -0,0 +1,178 @@
+package sds
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
+)
+
+var (
+	rpcRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sds_rpc_retries_total",
+			Help: "Retries of the sds operations after a pp failure",
+		},
+		[]string{"operation"},
+	)
+	rpcDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sds_rpc_duration_seconds",
+			Help:    "Latency of the JSON-RPC requests answered by the pp nodes",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		},
+		[]string{"method"},
+	)
+
+	uploadDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sds_upload_duration_seconds",
+			Help:    "Duration of the sds uploads by outcome",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+		},
+		[]string{"outcome"},
+	)
+	uploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sds_upload_bytes_total",
+		Help: "Bytes sent to the pp nodes by the sds uploads",
+	})
+	uploadChunks = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sds_upload_chunks_total",
+		Help: "Chunks acknowledged by the pp nodes during the sds uploads",
+	})
+
+	downloadDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sds_download_duration_seconds",
+			Help:    "Duration of the sds downloads by outcome",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+		},
+		[]string{"outcome"},
+	)
+	downloadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sds_download_bytes_total",
+		Help: "Bytes received from the pp nodes by the sds downloads",
+	})
+	downloadChunks = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sds_download_chunks_total",
+		Help: "Chunks received from the pp nodes by the sds downloads",
+	})
+
+	sharesCreated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sds_shares_created_total",
+			Help: "Share link creations by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	queueUploads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sds_queue_uploads_total",
+			Help: "Attempts of the uploads queued by the async adds by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	cacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sds_cache_hits_total",
+		Help: "Downloads served from the cache or joining a running download",
+	})
+	cacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sds_cache_misses_total",
+		Help: "Downloads requested from the pp nodes",
+	})
+	cacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sds_cache_evictions_total",
+		Help: "Files evicted from the cache to stay within its maximum size",
+	})
+
+	ozoneBalance = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sds_ozone_balance",
+		Help: "Last ozone balance of the wallet reported by a pp node",
+	})
+	ozoneSpent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sds_ozone_spent_total",
+			Help: "Ozone charged to the sds budget by operation",
+		},
+		[]string{"operation"},
+	)
+)
+
+func init() {
+	mustRegister(rpcRetries)
+	mustRegister(rpcDuration)
+	mustRegister(uploadDuration)
+	mustRegister(uploadBytes)
+	mustRegister(uploadChunks)
+	mustRegister(downloadDuration)
+	mustRegister(downloadBytes)
+	mustRegister(downloadChunks)
+	mustRegister(sharesCreated)
+	mustRegister(queueUploads)
+	mustRegister(cacheHits)
+	mustRegister(cacheMisses)
+	mustRegister(cacheEvictions)
+	mustRegister(ozoneBalance)
+	mustRegister(ozoneSpent)
+}
+
+func mustRegister(c prometheus.Collector) {
+	err := prometheus.Register(c)
+	are := prometheus.AlreadyRegisteredError{}
+	if errors.As(err, &are) {
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// outcome returns the label of the result of an operation: the Return code
+// answered by the pp, or the kind of failure when there is no code. The sp
+// messages forwarded by the pp are not used as is, to bound the label values.
+func outcome(err error) string {
+	var rpcErr *RPCError
+	switch {
+	case err == nil:
+		return rpc_api.SUCCESS
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, errDownloadAbandoned):
+		return "cancelled"
+	case errors.As(err, &rpcErr) && returnMessages[rpcErr.Return] != "":
+		return rpcErr.Return
+	case errors.Is(err, ErrAlreadyExists):
+		return "already_exists"
+	case errors.Is(err, ErrInsufficientOzone):
+		return "insufficient_ozone"
+	case errors.Is(err, ErrShareNotFound):
+		return "share_not_found"
+	case errors.Is(err, ErrShareExpired):
+		return "share_expired"
+	case errors.Is(err, ErrPPUnavailable):
+		return "pp_unavailable"
+	case errors.Is(err, ErrBudgetExceeded):
+		return "budget_exceeded"
+	case rpcErr != nil:
+		return "pp_error"
+	}
+	return "error"
+}
+
+// observeDuration records the duration of an operation started at start
+func observeDuration(h *prometheus.HistogramVec, start time.Time, err error) {
+	h.WithLabelValues(outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// setOzoneBalance records the ozone balance reported by a pp
+func setOzoneBalance(ozone string) {
+	balance, err := strconv.ParseFloat(ozone, 64)
+	if err != nil {
+		logger.Debugf("pp reported an invalid ozone balance %q", ozone)
+		return
+	}
+	ozoneBalance.Set(balance)
+}
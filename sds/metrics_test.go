@@ -0,0 +1,32 @@
+package sds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutcome(t *testing.T) {
+	cases := []struct {
+		err     error
+		outcome string
+	}{
+		{nil, rpc_api.SUCCESS},
+		{context.Canceled, "cancelled"},
+		{errDownloadAbandoned, "cancelled"},
+		{checkReturn("user_requestDownload", rpc_api.FILE_REQ_FAILURE), rpc_api.FILE_REQ_FAILURE},
+		{checkReturn("user_requestUpload", "Same file with the name already exists"), "already_exists"},
+		{withBalance(checkReturn("user_requestUpload", "sp refused the upload"), "10", 600), "insufficient_ozone"},
+		{checkReturn("user_requestDownload", "some sp message"), "pp_error"},
+		{fmt.Errorf("%w: %w", ErrPPUnavailable, errors.New("connection refused")), "pp_unavailable"},
+		{fmt.Errorf("%w: download is over Sds.Budget.Daily 10", ErrBudgetExceeded), "budget_exceeded"},
+		{errors.New("disk full"), "error"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.outcome, outcome(c.err), "%v", c.err)
+	}
+}
@@ -0,0 +1,734 @@
+// Package sdsmock provides an in-process fake of an sds pp node, speaking the
+// same JSON-RPC API, so sds could be tested without a real network.
+package sdsmock
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/kubo/sds"
+	fwtypes "github.com/stratosnet/sds/framework/types"
+	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
+	ppns "github.com/stratosnet/sds/pp/namespace"
+	msgutils "github.com/stratosnet/sds/sds-msg/utils"
+)
+
+// DefaultOzone is the balance reported for every wallet
+const DefaultOzone = "1000000000000"
+
+// maxReqTimeDrift is how far the request time could be from the pp clock
+const maxReqTimeDrift = 60 * time.Second
+
+// DuplicateFileReturn is the return of an upload request for a file already
+// stored, it is the message the pp sends in that case
+const DuplicateFileReturn = "Same file with the name already exists"
+
+// InsufficientOzoneReturn is the return of an upload request when the
+// ozone balance of the wallet is lower than the file size. Like a real pp,
+// the refusal of the sp is forwarded as a message with no code.
+const InsufficientOzoneReturn = "sp refused the upload request"
+
+// FileMeta is the metadata of a file sent along with its upload request
+type FileMeta struct {
+	Name        string
+	ContentType string
+	// Cid is the root of the DAG exported in the file
+	Cid string
+}
+
+// file is a file stored in the pp
+type file struct {
+	meta  FileMeta
+	owner string
+	data  []byte
+}
+
+// upload is an upload session in progress
+type upload struct {
+	owner string
+	sn    string
+	meta  FileMeta
+	data  []byte
+	// next is the offset of the next chunk expected from the client
+	next uint64
+}
+
+// download is a download session in progress
+type download struct {
+	fileHash string
+	next     uint64
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonrpcMessage struct {
+	Version string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+// network is the state shared by the pp nodes of the same sds network
+type network struct {
+	mu        sync.Mutex
+	chunkSize uint64
+	ozone     string
+	sequences map[string]uint64
+	files     map[string]*file
+	// shares by link
+	shares map[string]*share
+}
+
+// share is a share link of a file
+type share struct {
+	id       string
+	link     string
+	fileHash string
+	owner    string
+	// password of a private share, empty for a public one
+	password string
+	created  time.Time
+	// expires is zero for a share which never expires
+	expires time.Time
+}
+
+// sharesPerPage is the number of shares listed per page
+const sharesPerPage = 10
+
+// PP is a fake pp node. Files are kept in memory, chunks are exchanged at
+// the offsets given by the pp like a real node does, and every signed
+// request is checked against the wallet of the sender.
+type PP struct {
+	server *httptest.Server
+	*network
+
+	// guarded by network.mu
+	uploads   map[string]*upload
+	downloads map[string]*download
+	calls     map[string]int
+	down      bool
+	latency   time.Duration
+	// failNext is the number of calls of a method answered with a 503
+	// error before the method works again
+	failNext map[string]int
+
+	// failUploadAfter is the number of chunks accepted before an upload
+	// data call fails, when failUpload is set
+	failUpload      bool
+	failUploadAfter int
+}
+
+// NewPP starts a fake pp node listening on a random local port
+func NewPP() *PP {
+	return newPP(&network{
+		chunkSize: ppns.FILE_DATA_SAFE_SIZE,
+		ozone:     DefaultOzone,
+		sequences: make(map[string]uint64),
+		files:     make(map[string]*file),
+		shares:    make(map[string]*share),
+	})
+}
+
+// NewPeer starts another pp node of the same network, it serves the same
+// files and shares but has its own upload and download sessions
+func (pp *PP) NewPeer() *PP {
+	return newPP(pp.network)
+}
+
+func newPP(n *network) *PP {
+	pp := &PP{
+		network:   n,
+		uploads:   make(map[string]*upload),
+		downloads: make(map[string]*download),
+		calls:     make(map[string]int),
+		failNext:  make(map[string]int),
+	}
+	pp.server = httptest.NewServer(http.HandlerFunc(pp.serveHTTP))
+	return pp
+}
+
+// SetDown makes the pp answer every request with a 503 error, as if it was
+// restarting
+func (pp *PP) SetDown(down bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.down = down
+}
+
+// FailNext makes the next n calls of the JSON-RPC method fail with a 503
+// error, like a pp restarting
+func (pp *PP) FailNext(method string, n int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.failNext[method] = n
+}
+
+// SetLatency delays every answer of the pp
+func (pp *PP) SetLatency(latency time.Duration) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.latency = latency
+}
+
+// URL of the JSON-RPC endpoint, to be used in Sds.RpcURLs
+func (pp *PP) URL() string {
+	return pp.server.URL
+}
+
+// Close stops the pp
+func (pp *PP) Close() {
+	pp.server.Close()
+}
+
+// SetChunkSize changes the size of the chunks the pp exchanges
+func (pp *PP) SetChunkSize(size uint64) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.chunkSize = size
+}
+
+// SetOzone changes the balance reported for every wallet
+func (pp *PP) SetOzone(ozone string) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.ozone = ozone
+}
+
+// FailUploadAfter makes the upload data call following the next n accepted
+// chunks fail once, as if the pp lost the storage nodes. The upload session
+// is kept, so the upload could be resumed.
+func (pp *PP) FailUploadAfter(n int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.failUpload = true
+	pp.failUploadAfter = n
+}
+
+// File returns the content of a stored file
+func (pp *PP) File(fileHash string) ([]byte, bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	f, ok := pp.files[fileHash]
+	if !ok {
+		return nil, false
+	}
+	return f.data, true
+}
+
+// FileMeta returns the metadata of a stored file
+func (pp *PP) FileMeta(fileHash string) (FileMeta, bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	f, ok := pp.files[fileHash]
+	if !ok {
+		return FileMeta{}, false
+	}
+	return f.meta, true
+}
+
+// AddFile stores data as uploaded by the owner wallet and returns its file hash
+func (pp *PP) AddFile(owner string, data []byte) string {
+	fileHash := sds.CreateFileHash(data)
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.files[fileHash] = &file{owner: owner, data: data}
+	return fileHash
+}
+
+// Calls returns how many times the JSON-RPC method was called
+func (pp *PP) Calls(method string) int {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.calls[method]
+}
+
+func (pp *PP) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pp.mu.Lock()
+	down, latency := pp.down, pp.latency
+	pp.mu.Unlock()
+	time.Sleep(latency)
+	if down {
+		http.Error(w, "pp is down", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req jsonrpcMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pp.mu.Lock()
+	fail := pp.failNext[req.Method] > 0
+	if fail {
+		pp.failNext[req.Method]--
+	}
+	pp.mu.Unlock()
+	if fail {
+		http.Error(w, "pp is restarting", http.StatusServiceUnavailable)
+		return
+	}
+
+	rsp := jsonrpcMessage{Version: "2.0", ID: req.ID}
+	result, err := pp.call(req.Method, req.Params)
+	if err != nil {
+		rsp.Error = err
+	} else {
+		rsp.Result = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rsp)
+}
+
+func (pp *PP) call(method string, params json.RawMessage) (any, *jsonrpcError) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.calls[method]++
+
+	switch method {
+	case "user_requestGetOzone":
+		var p rpc_api.ParamReqGetOzone
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.getOzone(p), nil
+	case "user_requestUpload":
+		var p paramReqUploadFile
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.requestUpload(p), nil
+	case "user_uploadData":
+		var p rpc_api.ParamUploadData
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.uploadData(p), nil
+	case "user_requestDownload":
+		var p rpc_api.ParamReqDownloadFile
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.requestDownload(p), nil
+	case "user_downloadData":
+		var p rpc_api.ParamDownloadData
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.downloadData(p), nil
+	case "user_downloadedFileInfo":
+		var p rpc_api.ParamDownloadFileInfo
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.downloadedFileInfo(p), nil
+	case "user_requestShare":
+		var p rpc_api.ParamReqShareFile
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.requestShare(p), nil
+	case "user_requestGetShared":
+		var p rpc_api.ParamReqGetShared
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.requestGetShared(p), nil
+	case "user_requestListShare":
+		var p rpc_api.ParamReqListShared
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.requestListShare(p), nil
+	case "user_requestStopShare":
+		var p rpc_api.ParamReqStopShare
+		if err := decodeParam(params, &p); err != nil {
+			return nil, err
+		}
+		return pp.requestStopShare(p), nil
+	default:
+		return nil, &jsonrpcError{Code: -32601, Message: fmt.Sprintf("the method %s does not exist/is not available", method)}
+	}
+}
+
+// decodeParam reads the single parameter of a request
+func decodeParam(params json.RawMessage, p any) *jsonrpcError {
+	var ps []json.RawMessage
+	if err := json.Unmarshal(params, &ps); err != nil || len(ps) != 1 {
+		return &jsonrpcError{Code: -32602, Message: "invalid params"}
+	}
+	if err := json.Unmarshal(ps[0], p); err != nil {
+		return &jsonrpcError{Code: -32602, Message: err.Error()}
+	}
+	return nil
+}
+
+// sequence returns the current sequence number of the wallet
+func (pp *PP) sequence(wallet string) string {
+	return strconv.FormatUint(pp.sequences[wallet], 10)
+}
+
+// consumeSequence moves to the next sequence number once an upload using
+// the current one is accepted
+func (pp *PP) consumeSequence(wallet string) {
+	pp.sequences[wallet]++
+}
+
+// verify checks the request signature and time, it returns an empty string
+// when they are valid and the return code to fail with otherwise
+func verify(sig rpc_api.Signature, reqTime int64, message string) string {
+	drift := time.Since(time.Unix(reqTime, 0))
+	if drift > maxReqTimeDrift || drift < -maxReqTimeDrift {
+		return rpc_api.TIME_OUT
+	}
+	if !fwtypes.VerifyWalletAddr(sig.Pubkey, sig.Address) {
+		return rpc_api.WRONG_WALLET_ADDRESS
+	}
+	if !fwtypes.VerifyWalletSign(sig.Pubkey, sig.Signature, message) {
+		return rpc_api.SIGNATURE_FAILURE
+	}
+	return ""
+}
+
+func (pp *PP) getOzone(p rpc_api.ParamReqGetOzone) *rpc_api.GetOzoneResult {
+	if _, err := fwtypes.WalletAddressFromBech32(p.WalletAddr); err != nil {
+		return &rpc_api.GetOzoneResult{Return: rpc_api.WRONG_WALLET_ADDRESS}
+	}
+	return &rpc_api.GetOzoneResult{
+		Return:         rpc_api.SUCCESS,
+		Ozone:          pp.ozone,
+		SequenceNumber: pp.sequence(p.WalletAddr),
+	}
+}
+
+// paramReqUploadFile is an upload request along with the metadata of the file
+type paramReqUploadFile struct {
+	rpc_api.ParamReqUploadFile
+	ContentType string `json:"contenttype,omitempty"`
+	IpfsCid     string `json:"ipfscid,omitempty"`
+}
+
+func (pp *PP) requestUpload(p paramReqUploadFile) *rpc_api.Result {
+	wallet := p.Signature.Address
+	if p.SequenceNumber != pp.sequence(wallet) {
+		return &rpc_api.Result{Return: rpc_api.WRONG_INPUT}
+	}
+	msg := msgutils.GetFileUploadWalletSignMessage(p.FileHash, wallet, p.SequenceNumber, p.ReqTime)
+	if ret := verify(p.Signature, p.ReqTime, msg); ret != "" {
+		return &rpc_api.Result{Return: ret}
+	}
+	if p.FileSize <= 0 {
+		return &rpc_api.Result{Return: rpc_api.WRONG_FILE_SIZE}
+	}
+	if _, ok := pp.files[p.FileHash]; ok {
+		return &rpc_api.Result{Return: DuplicateFileReturn}
+	}
+	if ozone, err := strconv.ParseUint(pp.ozone, 10, 64); err != nil || ozone < uint64(p.FileSize) {
+		return &rpc_api.Result{Return: InsufficientOzoneReturn}
+	}
+	// the upload of a file already partly received is resumed
+	if u, ok := pp.uploads[p.FileHash]; ok {
+		if u.owner != wallet || len(u.data) != p.FileSize {
+			return &rpc_api.Result{Return: rpc_api.CONFLICT_WITH_ANOTHER_SESSION}
+		}
+		pp.consumeSequence(wallet)
+		u.sn = p.SequenceNumber
+		return pp.nextUploadChunk(u)
+	}
+	pp.consumeSequence(wallet)
+
+	u := &upload{
+		owner: wallet,
+		sn:    p.SequenceNumber,
+		meta: FileMeta{
+			Name:        p.FileName,
+			ContentType: p.ContentType,
+			Cid:         p.IpfsCid,
+		},
+		data: make([]byte, p.FileSize),
+	}
+	pp.uploads[p.FileHash] = u
+	return pp.nextUploadChunk(u)
+}
+
+// nextUploadChunk asks the client for the chunk following the received ones
+func (pp *PP) nextUploadChunk(u *upload) *rpc_api.Result {
+	start := u.next
+	end := min(start+pp.chunkSize, uint64(len(u.data)))
+	return &rpc_api.Result{
+		Return:      rpc_api.UPLOAD_DATA,
+		OffsetStart: &start,
+		OffsetEnd:   &end,
+	}
+}
+
+func (pp *PP) uploadData(p rpc_api.ParamUploadData) *rpc_api.Result {
+	u, ok := pp.uploads[p.FileHash]
+	if !ok {
+		return &rpc_api.Result{Return: rpc_api.FILE_REQ_FAILURE}
+	}
+	wallet := p.Signature.Address
+	if wallet != u.owner || p.SequenceNumber != u.sn {
+		return &rpc_api.Result{Return: rpc_api.WRONG_INPUT}
+	}
+	msg := msgutils.GetFileUploadWalletSignMessage(p.FileHash, wallet, p.SequenceNumber, p.ReqTime)
+	if ret := verify(p.Signature, p.ReqTime, msg); ret != "" {
+		return &rpc_api.Result{Return: ret}
+	}
+	if p.Stop {
+		delete(pp.uploads, p.FileHash)
+		return &rpc_api.Result{Return: rpc_api.SESSION_STOPPED}
+	}
+
+	if pp.failUpload {
+		if pp.failUploadAfter == 0 {
+			pp.failUpload = false
+			return &rpc_api.Result{Return: rpc_api.INTERNAL_COMM_FAILURE}
+		}
+		pp.failUploadAfter--
+	}
+
+	chunk, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil {
+		return &rpc_api.Result{Return: rpc_api.WRONG_INPUT}
+	}
+	want := min(pp.chunkSize, uint64(len(u.data))-u.next)
+	if uint64(len(chunk)) != want {
+		return &rpc_api.Result{Return: rpc_api.WRONG_FILE_SIZE}
+	}
+	copy(u.data[u.next:], chunk)
+	u.next += uint64(len(chunk))
+
+	if u.next < uint64(len(u.data)) {
+		return pp.nextUploadChunk(u)
+	}
+
+	delete(pp.uploads, p.FileHash)
+	if sds.CreateFileHash(u.data) != p.FileHash {
+		return &rpc_api.Result{Return: rpc_api.WRONG_FILE_INFO}
+	}
+	pp.files[p.FileHash] = &file{meta: u.meta, owner: u.owner, data: u.data}
+	return &rpc_api.Result{Return: rpc_api.SUCCESS, FileHash: p.FileHash}
+}
+
+func (pp *PP) requestDownload(p rpc_api.ParamReqDownloadFile) *rpc_api.Result {
+	wallet := p.Signature.Address
+	owner, fileHash, ok := parseFileHandle(p.FileHandle)
+	if !ok {
+		return &rpc_api.Result{Return: rpc_api.WRONG_INPUT}
+	}
+	msg := msgutils.GetFileDownloadWalletSignMessage(fileHash, wallet, pp.sequence(wallet), p.ReqTime)
+	if ret := verify(p.Signature, p.ReqTime, msg); ret != "" {
+		return &rpc_api.Result{Return: ret}
+	}
+	f, ok := pp.files[fileHash]
+	if !ok || f.owner != owner {
+		return &rpc_api.Result{Return: rpc_api.FILE_REQ_FAILURE}
+	}
+	return pp.startDownload(fileHash)
+}
+
+// parseFileHandle splits a sdm://<wallet>/<file hash> handle
+func parseFileHandle(handle string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(handle, "sdm://")
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, "/")
+}
+
+func (pp *PP) startDownload(fileHash string) *rpc_api.Result {
+	reqId := randomHex(16)
+	d := &download{fileHash: fileHash}
+	pp.downloads[reqId] = d
+	return pp.nextDownloadChunk(reqId, d)
+}
+
+// nextDownloadChunk sends the chunk following the sent ones, or asks for
+// the downloaded file info when the whole file is sent
+func (pp *PP) nextDownloadChunk(reqId string, d *download) *rpc_api.Result {
+	f := pp.files[d.fileHash]
+	if d.next >= uint64(len(f.data)) {
+		return &rpc_api.Result{Return: rpc_api.DL_OK_ASK_INFO, ReqId: reqId, FileHash: d.fileHash}
+	}
+
+	start := d.next
+	end := min(start+pp.chunkSize, uint64(len(f.data)))
+	d.next = end
+	return &rpc_api.Result{
+		Return:      rpc_api.DOWNLOAD_OK,
+		ReqId:       reqId,
+		OffsetStart: &start,
+		OffsetEnd:   &end,
+		FileHash:    d.fileHash,
+		FileName:    f.meta.Name,
+		FileData:    base64.StdEncoding.EncodeToString(f.data[start:end]),
+	}
+}
+
+func (pp *PP) downloadData(p rpc_api.ParamDownloadData) *rpc_api.Result {
+	d, ok := pp.downloads[p.ReqId]
+	if !ok || d.fileHash != p.FileHash {
+		return &rpc_api.Result{Return: rpc_api.FILE_REQ_FAILURE}
+	}
+	return pp.nextDownloadChunk(p.ReqId, d)
+}
+
+func (pp *PP) downloadedFileInfo(p rpc_api.ParamDownloadFileInfo) *rpc_api.Result {
+	d, ok := pp.downloads[p.ReqId]
+	if !ok || d.fileHash != p.FileHash {
+		return &rpc_api.Result{Return: rpc_api.FILE_REQ_FAILURE}
+	}
+	delete(pp.downloads, p.ReqId)
+	if p.FileSize != uint64(len(pp.files[d.fileHash].data)) {
+		return &rpc_api.Result{Return: rpc_api.WRONG_FILE_SIZE}
+	}
+	return &rpc_api.Result{Return: rpc_api.SUCCESS, FileHash: d.fileHash}
+}
+
+func (pp *PP) requestShare(p rpc_api.ParamReqShareFile) *rpc_api.FileShareResult {
+	wallet := p.Signature.Address
+	msg := msgutils.GetShareFileWalletSignMessage(p.FileHash, wallet, p.ReqTime)
+	if ret := verify(p.Signature, p.ReqTime, msg); ret != "" {
+		return &rpc_api.FileShareResult{Return: ret}
+	}
+	f, ok := pp.files[p.FileHash]
+	if !ok || f.owner != wallet {
+		return &rpc_api.FileShareResult{Return: rpc_api.FILE_REQ_FAILURE}
+	}
+
+	// files of ipfs DAGs are shared under their cid
+	shareId := randomHex(8)
+	link := fwtypes.SetShareLink(shareId, randomHex(3))
+	if p.IpfsCid != "" {
+		if !fwtypes.CheckIpfsCid(p.IpfsCid) {
+			return &rpc_api.FileShareResult{Return: rpc_api.WRONG_INPUT}
+		}
+		shareId = p.IpfsCid
+		link = fwtypes.SetShareLink(p.IpfsCid, "")
+	}
+	sh := &share{
+		id:       shareId,
+		link:     link.Link,
+		fileHash: p.FileHash,
+		owner:    wallet,
+		created:  time.Now(),
+	}
+	if p.Duration > 0 {
+		sh.expires = sh.created.Add(time.Duration(p.Duration) * time.Second)
+	}
+	shareLink := link.String()
+	if p.PrivateFlag {
+		// the password of a private share is given along with its link
+		sh.password = randomHex(3)
+		shareLink += "/" + sh.password
+	}
+	pp.shares[link.Link] = sh
+
+	return &rpc_api.FileShareResult{
+		Return:    rpc_api.SUCCESS,
+		ShareId:   shareId,
+		ShareLink: shareLink,
+	}
+}
+
+func (pp *PP) requestListShare(p rpc_api.ParamReqListShared) *rpc_api.FileShareResult {
+	wallet := p.Signature.Address
+	msg := msgutils.ShareLinkWalletSignMessage(wallet, p.ReqTime)
+	if ret := verify(p.Signature, p.ReqTime, msg); ret != "" {
+		return &rpc_api.FileShareResult{Return: ret}
+	}
+
+	var shares []*share
+	for _, sh := range pp.shares {
+		if sh.owner == wallet {
+			shares = append(shares, sh)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].created.Before(shares[j].created) || (shares[i].created.Equal(shares[j].created) && shares[i].id < shares[j].id)
+	})
+
+	res := &rpc_api.FileShareResult{
+		Return:      rpc_api.SUCCESS,
+		TotalNumber: uint64(len(shares)),
+		PageId:      p.PageId,
+	}
+	start := min(int(p.PageId)*sharesPerPage, len(shares))
+	end := min(start+sharesPerPage, len(shares))
+	for _, sh := range shares[start:end] {
+		info := rpc_api.FileInfo{
+			FileHash:  sh.fileHash,
+			FileSize:  uint64(len(pp.files[sh.fileHash].data)),
+			FileName:  pp.files[sh.fileHash].meta.Name,
+			LinkTime:  sh.created.Unix(),
+			ShareId:   sh.id,
+			ShareLink: fwtypes.ShareDataMeshId{Link: sh.link}.String(),
+		}
+		if !sh.expires.IsZero() {
+			info.LinkTimeExp = sh.expires.Unix()
+		}
+		res.FileInfo = append(res.FileInfo, info)
+	}
+	return res
+}
+
+func (pp *PP) requestStopShare(p rpc_api.ParamReqStopShare) *rpc_api.FileShareResult {
+	wallet := p.Signature.Address
+	msg := msgutils.DeleteShareWalletSignMessage(p.ShareId, wallet, p.ReqTime)
+	if ret := verify(p.Signature, p.ReqTime, msg); ret != "" {
+		return &rpc_api.FileShareResult{Return: ret}
+	}
+	for link, sh := range pp.shares {
+		if sh.id == p.ShareId && sh.owner == wallet {
+			delete(pp.shares, link)
+			return &rpc_api.FileShareResult{Return: rpc_api.SUCCESS}
+		}
+	}
+	return &rpc_api.FileShareResult{Return: rpc_api.FILE_REQ_FAILURE}
+}
+
+func (pp *PP) requestGetShared(p rpc_api.ParamReqGetShared) *rpc_api.Result {
+	wallet := p.Signature.Address
+	link, err := fwtypes.ParseShareLink(p.ShareLink)
+	if err != nil {
+		return &rpc_api.Result{Return: rpc_api.WRONG_INPUT}
+	}
+	msg := msgutils.GetDownloadShareFileWalletSignMessage(link.Link, wallet, pp.sequence(wallet), p.ReqTime)
+	if ret := verify(p.Signature, p.ReqTime, msg); ret != "" {
+		return &rpc_api.Result{Return: ret}
+	}
+	sh, ok := pp.shares[link.Link]
+	if !ok || sh.password != link.Password || (!sh.expires.IsZero() && time.Now().After(sh.expires)) {
+		return &rpc_api.Result{Return: rpc_api.FILE_REQ_FAILURE}
+	}
+	return pp.startDownload(sh.fileHash)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
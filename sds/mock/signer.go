@@ -0,0 +1,151 @@
+package sdsmock
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/kubo/sds"
+	fwsecp256k1 "github.com/stratosnet/sds/framework/crypto/secp256k1"
+	fwcryptotypes "github.com/stratosnet/sds/framework/crypto/types"
+	fwtypes "github.com/stratosnet/sds/framework/types"
+)
+
+// Signer is a stand-in of a remote signing service holding a wallet key,
+// speaking the protocol of sds.RemoteSigner over http or a unix socket.
+type Signer struct {
+	server *httptest.Server
+	url    string
+	key    fwcryptotypes.PrivKey
+
+	mu sync.Mutex
+	// authorization is the Authorization header required, if any
+	authorization string
+	signed        int
+	pubKeys       int
+}
+
+// NewSigner starts a signer of the wallet of the hex key, listening on a
+// random local port
+func NewSigner(key string) (*Signer, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	s := &Signer{key: fwsecp256k1.Generate(raw)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.url = s.server.URL
+	return s, nil
+}
+
+// NewUnixSigner starts a signer of the wallet of the hex key, listening on a
+// unix socket created in dir
+func NewUnixSigner(dir string, key string) (*Signer, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "signer.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Signer{key: fwsecp256k1.Generate(raw)}
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.server.Listener.Close()
+	s.server.Listener = l
+	s.server.Start()
+	s.url = "unix://" + path
+	return s, nil
+}
+
+// URL of the signer, to be used as Sds.Signer.URL
+func (s *Signer) URL() string {
+	return s.url
+}
+
+// Address of the wallet of the signer
+func (s *Signer) Address() string {
+	return fwtypes.WalletAddress(s.key.PubKey().Address()).String()
+}
+
+// Close stops the signer
+func (s *Signer) Close() {
+	s.server.Close()
+}
+
+// RequireAuthorization makes the signer reject the requests without this
+// Authorization header
+func (s *Signer) RequireAuthorization(authorization string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorization = authorization
+}
+
+// Signed returns the number of messages signed
+func (s *Signer) Signed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signed
+}
+
+// PubKeyRequests returns the number of times the public key was asked
+func (s *Signer) PubKeyRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pubKeys
+}
+
+func (s *Signer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	authorization := s.authorization
+	s.mu.Unlock()
+	if authorization != "" && r.Header.Get("Authorization") != authorization {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/pubkey":
+		pubKey, err := fwtypes.WalletPubKeyToBech32(s.key.PubKey())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.mu.Lock()
+		s.pubKeys++
+		s.mu.Unlock()
+		writeJSON(w, &sds.SignerPubKeyResponse{PubKey: pubKey})
+	case r.Method == http.MethodPost && r.URL.Path == "/sign":
+		var req sds.SignerSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Address != s.Address() {
+			http.Error(w, "unknown wallet "+req.Address, http.StatusNotFound)
+			return
+		}
+		sig, err := s.key.Sign(req.Message)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.mu.Lock()
+		s.signed++
+		s.mu.Unlock()
+		writeJSON(w, &sds.SignerSignResponse{Signature: sig})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,199 @@
+package sds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/kubo/tracing"
+	"github.com/stratosnet/sds/framework/crypto"
+	fwtypes "github.com/stratosnet/sds/framework/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Namespace is the gateway namespace of the files stored in sds
+const Namespace = "sds"
+
+// GatewayPath is a path of the sds gateway namespace, either
+// /sds/<share link>/sub/path for a shared file or
+// /sds/<wallet>/<file hash>/sub/path for a file of its owner
+type GatewayPath struct {
+	// ShareLink is the link of the share, without the sds:// prefix
+	ShareLink string
+	// Password of a private share, given by the request rather than the path
+	Password string
+	// Owner is the wallet which uploaded the file of FileHash
+	Owner    string
+	FileHash string
+	// Rest is the path below the file, with its leading slash. It is only
+	// set for the DAGs of CAR files.
+	Rest string
+}
+
+// ParseGatewayPath parses a path of the sds gateway namespace
+func ParseGatewayPath(p string) (*GatewayPath, error) {
+	rest, ok := strings.CutPrefix(p, "/"+Namespace+"/")
+	if !ok {
+		return nil, fmt.Errorf("%q is not an sds path", p)
+	}
+
+	first, rest := cutSegment(rest)
+	if _, err := fwtypes.WalletAddressFromBech32(first); err == nil {
+		fileHash, rest := cutSegment(strings.TrimPrefix(rest, "/"))
+		if !crypto.ValidateHash(fileHash) {
+			return nil, fmt.Errorf("%q is not a valid sds file hash", fileHash)
+		}
+		return &GatewayPath{Owner: first, FileHash: fileHash, Rest: rest}, nil
+	}
+
+	if len(first) != fwtypes.NormalShareLinkLength && len(first) != fwtypes.IpfsShareLinkLength {
+		return nil, fmt.Errorf("%q is neither an sds share link nor a wallet address", first)
+	}
+	return &GatewayPath{ShareLink: first, Rest: rest}, nil
+}
+
+// cutSegment returns the first segment of p and the rest of p, starting with
+// its slash
+func cutSegment(p string) (string, string) {
+	i := strings.Index(p, "/")
+	if i < 0 {
+		return p, ""
+	}
+	return p[:i], p[i:]
+}
+
+// ID is the sds identifier of the file of the path, a share link or a file
+// handle
+func (gp *GatewayPath) ID() string {
+	if gp.ShareLink != "" {
+		return fwtypes.ShareDataMeshId{Link: gp.ShareLink}.String()
+	}
+	return fwtypes.DataMeshId{Owner: gp.Owner, Hash: gp.FileHash}.String()
+}
+
+// downloadKey is the share link with its password, or the file handle, which
+// identifies the download of the path. The imports and the unknown links are
+// tracked by it, so a wrong password does not hide the file from the right one.
+func (gp *GatewayPath) downloadKey() string {
+	if gp.ShareLink != "" {
+		return FormatShareLink(gp.ShareLink, gp.Password)
+	}
+	return gp.ID()
+}
+
+// ipfsPath returns the path of the content in the DAG with the root
+func (gp *GatewayPath) ipfsPath(root path.Path) (path.ImmutablePath, error) {
+	p, err := path.NewPath(root.String() + gp.Rest)
+	if err != nil {
+		return path.ImmutablePath{}, err
+	}
+	return path.NewImmutablePath(p)
+}
+
+// Open gets the file of the sds path. The DAG of a CAR file is imported into
+// ipfs and pinned, the returned path is the one of the content in ipfs, it
+// could be served while the import goes. Other files are returned as they are
+// downloaded, they have no ipfs path.
+//
+// The DAG of a private share, opened with its password, is not imported: it
+// would be served on /ipfs/ and provided without the password. Both the path
+// in the DAG and the CAR file are returned then, the DAG is served from the
+// file with CARBackend.
+func (sb *SdsBlocksBackend) Open(ctx context.Context, gp *GatewayPath) (path.ImmutablePath, files.File, error) {
+	ctx, span := tracing.Span(ctx, "Sds.Gateway", "Open", trace.WithAttributes(attribute.String("id", gp.ID())))
+	defer span.End()
+
+	if !sb.cfg.Enabled {
+		return path.ImmutablePath{}, nil, errors.New("sds is not enabled")
+	}
+
+	id := gp.downloadKey()
+	if gp.Password != "" {
+		return sb.openPrivate(ctx, gp, id)
+	}
+	for {
+		// the file could be imported for another request
+		imported, leader := sb.join(id, true)
+		if leader {
+			return sb.open(ctx, gp, id, imported)
+		}
+		started, err := imported.started(ctx)
+		if err != nil {
+			return path.ImmutablePath{}, nil, err
+		}
+		if started {
+			p, err := gp.ipfsPath(imported.root)
+			return p, nil, err
+		}
+		// the file is not a CAR or the request which downloaded it was
+		// refused, it is downloaded again for this one
+	}
+}
+
+// open downloads the file of the sds path for the import registered by Open,
+// the download is not stopped with ctx
+func (sb *SdsBlocksBackend) open(ctx context.Context, gp *GatewayPath, id string, imported *carImport) (path.ImmutablePath, files.File, error) {
+	dctx, cancel := sb.detach(ctx)
+	file, err := sb.guard.download(dctx, id, func(ctx context.Context) (files.File, error) {
+		if gp.ShareLink != "" {
+			return sb.fetcher.DownloadFromShare(ctx, id)
+		}
+		return sb.fetcher.DownloadFrom(ctx, gp.Owner, gp.FileHash)
+	})
+	if err != nil {
+		cancel()
+		sb.abort(imported, err)
+		return path.ImmutablePath{}, nil, err
+	}
+	file = &guardedFile{File: file, release: cancel}
+
+	root, err := CARRoot(file)
+	if err != nil {
+		sb.abort(imported, ErrNotDAG)
+		return path.ImmutablePath{}, file, nil
+	}
+
+	// the DAG was imported by an earlier request
+	if _, pinned, err := sb.pin.IsPinnedWithType(ctx, root, pin.Recursive); err == nil && pinned {
+		file.Close()
+		sb.finish(imported, path.FromCid(root), nil)
+		p, err := gp.ipfsPath(path.FromCid(root))
+		return p, nil, err
+	}
+
+	if err := sb.start(imported, file, true); err != nil {
+		return path.ImmutablePath{}, nil, err
+	}
+	p, err := gp.ipfsPath(imported.root)
+	return p, nil, err
+}
+
+// openPrivate downloads the file of the private share of the sds path, the
+// requests do not share the download
+func (sb *SdsBlocksBackend) openPrivate(ctx context.Context, gp *GatewayPath, id string) (path.ImmutablePath, files.File, error) {
+	dctx, cancel := sb.detach(ctx)
+	file, err := sb.guard.download(dctx, id, func(ctx context.Context) (files.File, error) {
+		return sb.fetcher.DownloadFromShare(ctx, id)
+	})
+	if err != nil {
+		cancel()
+		return path.ImmutablePath{}, nil, err
+	}
+	file = &guardedFile{File: file, release: cancel}
+
+	root, err := CARRoot(file)
+	if err != nil {
+		return path.ImmutablePath{}, file, nil
+	}
+	p, err := gp.ipfsPath(path.FromCid(root))
+	if err != nil {
+		file.Close()
+		return path.ImmutablePath{}, nil, err
+	}
+	return p, file, nil
+}
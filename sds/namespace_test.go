@@ -0,0 +1,39 @@
+package sds
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGatewayPath(t *testing.T) {
+	wallet, err := GenerateSdsWallet()
+	require.NoError(t, err)
+	owner, err := wallet.GetAddress(context.Background())
+	require.NoError(t, err)
+	fileHash := CreateFileHash([]byte("hello sds"))
+	cidLink := "QmbLQrW85vfWyySX76dwyxxAzz4tcsPk6tgTuLDQjNYxE7"
+
+	gp, err := ParseGatewayPath("/sds/" + cidLink + "/sub/1.txt")
+	require.NoError(t, err)
+	assert.Equal(t, &GatewayPath{ShareLink: cidLink, Rest: "/sub/1.txt"}, gp)
+	assert.Equal(t, "sds://"+cidLink, gp.ID())
+
+	gp, err = ParseGatewayPath("/sds/0123456789abcdef_a1b2c3/")
+	require.NoError(t, err)
+	assert.Equal(t, &GatewayPath{ShareLink: "0123456789abcdef_a1b2c3", Rest: "/"}, gp)
+
+	gp, err = ParseGatewayPath("/sds/" + owner + "/" + fileHash)
+	require.NoError(t, err)
+	assert.Equal(t, &GatewayPath{Owner: owner, FileHash: fileHash}, gp)
+	assert.Equal(t, "sdm://"+owner+"/"+fileHash, gp.ID())
+
+	_, err = ParseGatewayPath("/sds/" + owner + "/not-a-file-hash")
+	assert.Error(t, err)
+	_, err = ParseGatewayPath("/sds/too-short")
+	assert.Error(t, err)
+	_, err = ParseGatewayPath("/ipfs/" + cidLink)
+	assert.Error(t, err)
+}
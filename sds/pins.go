@@ -0,0 +1,264 @@
+package sds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// pinsPrefix is the datastore namespace of the pin requests
+var pinsPrefix = datastore.NewKey("/sds/pins")
+
+// ErrPinNotFound is returned when a pin request does not exist
+var ErrPinNotFound = errors.New("sds: pin not found")
+
+// PinStatus is the status of a pin in the Pinning Services API model
+type PinStatus string
+
+const (
+	PinQueued  PinStatus = "queued"
+	PinPinning PinStatus = "pinning"
+	PinPinned  PinStatus = "pinned"
+	PinFailed  PinStatus = "failed"
+)
+
+// PinStatus returns the status of the pins of a DAG in this queue status
+func (s QueueStatus) PinStatus() PinStatus {
+	switch s {
+	case QueueUploading:
+		return PinPinning
+	case QueueDone:
+		return PinPinned
+	case QueueFailed:
+		return PinFailed
+	default:
+		return PinQueued
+	}
+}
+
+// Pin is a pin request of the Pinning Services API
+type Pin struct {
+	RequestID string
+	// Cid is the root of the pinned DAG
+	Cid     string
+	Name    string            `json:",omitempty"`
+	Origins []string          `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Created time.Time
+}
+
+// PinState is a pin request and the state of the upload of its DAG
+type PinState struct {
+	*Pin
+	Status PinStatus
+	// Upload is the queued upload of the DAG, nil when it was removed from
+	// the queue
+	Upload *QueuedUpload
+}
+
+// Pins persists the pin requests of the Pinning Services API in the repo
+// datastore. The DAG of a pin is uploaded through the upload queue, a DAG
+// pinned by several requests is uploaded once.
+type Pins struct {
+	ds    datastore.Datastore
+	queue *UploadQueue
+
+	mu sync.Mutex
+}
+
+func NewPins(ds datastore.Datastore, queue *UploadQueue) *Pins {
+	return &Pins{ds: ds, queue: queue}
+}
+
+func pinKey(requestID string) datastore.Key {
+	return pinsPrefix.ChildString(requestID)
+}
+
+func (p *Pins) get(ctx context.Context, requestID string) (*Pin, error) {
+	data, err := p.ds.Get(ctx, pinKey(requestID))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, ErrPinNotFound
+		}
+		return nil, err
+	}
+
+	var pin Pin
+	if err := json.Unmarshal(data, &pin); err != nil {
+		return nil, err
+	}
+	return &pin, nil
+}
+
+func (p *Pins) put(ctx context.Context, pin *Pin) error {
+	data, err := json.Marshal(pin)
+	if err != nil {
+		return err
+	}
+	return p.ds.Put(ctx, pinKey(pin.RequestID), data)
+}
+
+// list returns the pin requests, most recent first
+func (p *Pins) list(ctx context.Context) ([]*Pin, error) {
+	results, err := p.ds.Query(ctx, query.Query{Prefix: pinsPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var pins []*Pin
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var pin Pin
+		if err := json.Unmarshal(r.Value, &pin); err != nil {
+			return nil, err
+		}
+		pins = append(pins, &pin)
+	}
+	sort.SliceStable(pins, func(i, j int) bool {
+		return pins[i].Created.After(pins[j].Created)
+	})
+	return pins, nil
+}
+
+// state joins the pins with the queued uploads of their DAG. A pin whose
+// upload was removed from the queue is failed.
+func (p *Pins) state(ctx context.Context, pins ...*Pin) ([]*PinState, error) {
+	uploads, err := p.queue.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byCid := make(map[string]*QueuedUpload, len(uploads))
+	for _, u := range uploads {
+		byCid[u.Cid] = u
+	}
+
+	states := make([]*PinState, 0, len(pins))
+	for _, pin := range pins {
+		s := &PinState{Pin: pin, Status: PinFailed, Upload: byCid[pin.Cid]}
+		if s.Upload != nil {
+			s.Status = s.Upload.Status.PinStatus()
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// Add saves a new pin request and queues the upload of its DAG, the request
+// id and creation time of pin are set
+func (p *Pins) Add(ctx context.Context, pin *Pin) (*PinState, error) {
+	c, err := cid.Decode(pin.Cid)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin.RequestID = uuid.NewString()
+	pin.Created = time.Now().UTC()
+	if _, err := p.queue.Enqueue(ctx, c, pin.Name); err != nil {
+		return nil, err
+	}
+	if err := p.put(ctx, pin); err != nil {
+		return nil, err
+	}
+
+	states, err := p.state(ctx, pin)
+	if err != nil {
+		return nil, err
+	}
+	return states[0], nil
+}
+
+// Get returns the pin request with the id
+func (p *Pins) Get(ctx context.Context, requestID string) (*PinState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin, err := p.get(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	states, err := p.state(ctx, pin)
+	if err != nil {
+		return nil, err
+	}
+	return states[0], nil
+}
+
+// List returns the pin requests, most recent first
+func (p *Pins) List(ctx context.Context) ([]*PinState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pins, err := p.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.state(ctx, pins...)
+}
+
+// Replace removes the pin request with the id and adds pin in its place
+func (p *Pins) Replace(ctx context.Context, requestID string, pin *Pin) (*PinState, error) {
+	p.mu.Lock()
+	old, err := p.get(ctx, requestID)
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := p.Add(ctx, pin)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Remove(ctx, old.RequestID); err != nil && !errors.Is(err, ErrPinNotFound) {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Remove deletes the pin request with the id. The upload of its DAG is
+// removed from the queue unless it is done or another request pins the DAG.
+func (p *Pins) Remove(ctx context.Context, requestID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin, err := p.get(ctx, requestID)
+	if err != nil {
+		return err
+	}
+	if err := p.ds.Delete(ctx, pinKey(requestID)); err != nil {
+		return err
+	}
+
+	pins, err := p.list(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range pins {
+		if other.Cid == pin.Cid {
+			return nil
+		}
+	}
+
+	c, err := cid.Decode(pin.Cid)
+	if err != nil {
+		return err
+	}
+	u, err := p.queue.get(ctx, pin.Cid)
+	if err != nil || u == nil || u.Status == QueueDone {
+		return err
+	}
+	return p.queue.Cancel(ctx, c)
+}
@@ -0,0 +1,95 @@
+package sds_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/sds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPins(t *testing.T) {
+	ctx := context.Background()
+	c := cid.MustParse(testCid)
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	q := newTestQueue(t, ds, 3)
+	pins := sds.NewPins(ds, q)
+
+	first, err := pins.Add(ctx, &sds.Pin{Cid: c.String(), Name: "first"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.RequestID)
+	assert.Equal(t, sds.PinQueued, first.Status)
+
+	// a DAG pinned twice is uploaded once
+	second, err := pins.Add(ctx, &sds.Pin{Cid: c.String(), Name: "second"})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.RequestID, second.RequestID)
+	uploads, err := q.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, uploads, 1)
+
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		return "filehash", cid.MustParse(testLinkCid), nil
+	})
+	queued(t, q, c, sds.QueueDone)
+
+	s, err := pins.Get(ctx, first.RequestID)
+	require.NoError(t, err)
+	assert.Equal(t, sds.PinPinned, s.Status)
+	assert.Equal(t, "filehash", s.Upload.FileHash)
+
+	all, err := pins.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, second.RequestID, all[0].RequestID)
+
+	// the uploaded DAG is kept in the queue when its pins are removed
+	require.NoError(t, pins.Remove(ctx, first.RequestID))
+	require.NoError(t, pins.Remove(ctx, second.RequestID))
+	_, err = pins.Get(ctx, first.RequestID)
+	assert.ErrorIs(t, err, sds.ErrPinNotFound)
+	queued(t, q, c, sds.QueueDone)
+}
+
+func TestPinsReplace(t *testing.T) {
+	ctx := context.Background()
+	c := cid.MustParse(testCid)
+	replacement := cid.MustParse(testLinkCid)
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	q := newTestQueue(t, ds, 3)
+	pins := sds.NewPins(ds, q)
+
+	old, err := pins.Add(ctx, &sds.Pin{Cid: c.String(), Name: "mfs"})
+	require.NoError(t, err)
+
+	s, err := pins.Replace(ctx, old.RequestID, &sds.Pin{Cid: replacement.String(), Name: "mfs"})
+	require.NoError(t, err)
+	assert.NotEqual(t, old.RequestID, s.RequestID)
+	assert.Equal(t, sds.PinQueued, s.Status)
+
+	_, err = pins.Get(ctx, old.RequestID)
+	assert.ErrorIs(t, err, sds.ErrPinNotFound)
+
+	// the upload of the replaced DAG is not done, it is removed from the
+	// queue
+	uploads, err := q.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	assert.Equal(t, replacement.String(), uploads[0].Cid)
+
+	_, err = pins.Replace(ctx, old.RequestID, &sds.Pin{Cid: c.String()})
+	assert.ErrorIs(t, err, sds.ErrPinNotFound)
+
+	// a pin whose upload is cancelled is failed
+	require.NoError(t, q.Cancel(ctx, replacement))
+	s, err = pins.Get(ctx, s.RequestID)
+	require.NoError(t, err)
+	assert.Equal(t, sds.PinFailed, s.Status)
+	assert.Nil(t, s.Upload)
+}
@@ -0,0 +1,584 @@
+package sds
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/kubo/config"
+)
+
+var (
+	// queuePrefix is the datastore namespace of the upload queue
+	queuePrefix = datastore.NewKey("/sds/queue")
+	// queueDonePrefix is the datastore namespace of the done uploads, they
+	// are kept apart so the workers do not go through them
+	queueDonePrefix = datastore.NewKey("/sds/queue-done")
+)
+
+type QueueStatus string
+
+const (
+	// QueueQueued is an upload waiting for a worker, either never attempted
+	// or waiting for its next attempt
+	QueueQueued QueueStatus = "queued"
+	// QueueUploading is an upload run by a worker
+	QueueUploading QueueStatus = "uploading"
+	// QueueFailed is an upload which failed its last attempt, it is only
+	// attempted again when retried
+	QueueFailed QueueStatus = "failed"
+	// QueueDone is an uploaded DAG, its mapping file is added
+	QueueDone QueueStatus = "done"
+)
+
+// queueRetryPolicy is the default policy of Sds.Queue.Retry, the queued
+// uploads outlive the pp outages the requests are retried through
+var queueRetryPolicy = retryPolicy{
+	maxAttempts: config.DefaultSdsQueueMaxAttempts,
+	baseDelay:   config.DefaultSdsQueueBaseDelay,
+	maxDelay:    config.DefaultSdsQueueMaxDelay,
+	jitter:      config.DefaultSdsRetryJitter,
+}
+
+// QueuedUpload is a DAG added in async mode, uploaded by the daemon workers
+type QueuedUpload struct {
+	// Cid is the root of the DAG
+	Cid string
+	// Name of the upload, the pin name when it is queued as a remote pin
+	Name   string `json:",omitempty"`
+	Status QueueStatus
+	// Attempts of the upload so far
+	Attempts int64
+	// NextAttempt is when a queued upload is due, zero when it is due now
+	NextAttempt time.Time
+	// FileHash is the sds file hash of the DAG CAR once uploaded
+	FileHash string `json:",omitempty"`
+	// Link is the cid of the mapping file added once the DAG is uploaded
+	Link string `json:",omitempty"`
+	// Error of the last failed attempt
+	Error   string `json:",omitempty"`
+	Added   time.Time
+	Updated time.Time
+}
+
+// QueueUploader uploads a queued DAG under the name of the upload and adds its
+// mapping file, it returns the sds file hash of the DAG CAR and the cid of
+// the mapping file
+type QueueUploader func(ctx context.Context, c cid.Cid, name string) (string, cid.Cid, error)
+
+// UploadQueue persists the uploads queued by the async adds in the repo
+// datastore. They are uploaded by the workers once started, a queued upload
+// survives the daemon restarts until it is done, failed or cancelled.
+type UploadQueue struct {
+	ds      datastore.Datastore
+	workers int
+	retry   retryPolicy
+
+	mu sync.Mutex
+	// running uploads by cid, cancelled when the upload is cancelled
+	running map[string]context.CancelFunc
+	// wake is closed when an upload is queued, to wake up the idle workers
+	wake chan struct{}
+	// pending indexes the queued uploads by their next attempt, it is
+	// loaded from the datastore by the first worker
+	pending   pendingUploads
+	scheduled map[string]*pendingUpload
+	indexed   bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewUploadQueue(cfg *config.SdsQueue, ds datastore.Datastore) *UploadQueue {
+	return &UploadQueue{
+		ds:        ds,
+		workers:   int(max(cfg.Workers.WithDefault(config.DefaultSdsQueueWorkers), 1)),
+		retry:     newRetryPolicy(cfg.Retry, queueRetryPolicy),
+		running:   make(map[string]context.CancelFunc),
+		wake:      make(chan struct{}),
+		scheduled: make(map[string]*pendingUpload),
+	}
+}
+
+func queueKey(c string) datastore.Key {
+	return queuePrefix.ChildString(c)
+}
+
+func queueDoneKey(c string) datastore.Key {
+	return queueDonePrefix.ChildString(c)
+}
+
+// get returns the queued upload of the cid, or nil when there is none
+func (q *UploadQueue) get(ctx context.Context, c string) (*QueuedUpload, error) {
+	data, err := q.ds.Get(ctx, queueKey(c))
+	if errors.Is(err, datastore.ErrNotFound) {
+		data, err = q.ds.Get(ctx, queueDoneKey(c))
+	}
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var u QueuedUpload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// put saves the upload, a done upload is moved to the done namespace
+func (q *UploadQueue) put(ctx context.Context, u *QueuedUpload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if u.Status != QueueDone {
+		return q.ds.Put(ctx, queueKey(u.Cid), data)
+	}
+	if err := q.ds.Put(ctx, queueDoneKey(u.Cid), data); err != nil {
+		return err
+	}
+	return q.ds.Delete(ctx, queueKey(u.Cid))
+}
+
+// query returns the uploads saved under the prefix
+func (q *UploadQueue) query(ctx context.Context, prefix datastore.Key) ([]*QueuedUpload, error) {
+	results, err := q.ds.Query(ctx, query.Query{Prefix: prefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var uploads []*QueuedUpload
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var u QueuedUpload
+		if err := json.Unmarshal(r.Value, &u); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &u)
+	}
+	return uploads, nil
+}
+
+// list returns the queued and done uploads, oldest first
+func (q *UploadQueue) list(ctx context.Context) ([]*QueuedUpload, error) {
+	uploads, err := q.query(ctx, queuePrefix)
+	if err != nil {
+		return nil, err
+	}
+	done, err := q.query(ctx, queueDonePrefix)
+	if err != nil {
+		return nil, err
+	}
+	uploads = append(uploads, done...)
+	sort.SliceStable(uploads, func(i, j int) bool {
+		return uploads[i].Added.Before(uploads[j].Added)
+	})
+	return uploads, nil
+}
+
+// notify wakes up the idle workers, q.mu must be held
+func (q *UploadQueue) notify() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// Enqueue queues the upload of the DAG under the name, an empty name keeps
+// the name of an upload already queued. A DAG already queued or uploaded is
+// left as is but for its name, a failed one is queued again.
+func (q *UploadQueue) Enqueue(ctx context.Context, c cid.Cid, name string) (*QueuedUpload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u, err := q.get(ctx, c.String())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if u != nil && u.Status != QueueFailed {
+		if name == "" || name == u.Name {
+			return u, nil
+		}
+		u.Name = name
+		u.Updated = now
+		if err := q.put(ctx, u); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+
+	if u == nil {
+		u = &QueuedUpload{Cid: c.String(), Added: now}
+	}
+	if name != "" {
+		u.Name = name
+	}
+	u.Status = QueueQueued
+	u.Attempts = 0
+	u.NextAttempt = time.Time{}
+	u.Error = ""
+	u.Updated = now
+	if err := q.put(ctx, u); err != nil {
+		return nil, err
+	}
+	q.schedule(u)
+	q.notify()
+	return u, nil
+}
+
+// List returns the queued uploads, oldest first. The uploads left in
+// progress by a previous process are reported as queued, they are attempted
+// again by the workers.
+func (q *UploadQueue) List(ctx context.Context) ([]*QueuedUpload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	uploads, err := q.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range uploads {
+		if _, ok := q.running[u.Cid]; !ok && u.Status == QueueUploading {
+			u.Status = QueueQueued
+		}
+	}
+	return uploads, nil
+}
+
+// Retry queues a failed upload again with all its attempts, or makes an
+// upload waiting for its next attempt due right away
+func (q *UploadQueue) Retry(ctx context.Context, c cid.Cid) (*QueuedUpload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u, err := q.get(ctx, c.String())
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("upload of %s is not queued", c)
+	}
+	if _, ok := q.running[u.Cid]; ok {
+		return nil, fmt.Errorf("upload of %s is already in progress", c)
+	}
+	if u.Status == QueueDone {
+		return nil, fmt.Errorf("%s is already uploaded", c)
+	}
+
+	if u.Status == QueueFailed {
+		u.Attempts = 0
+	}
+	u.Status = QueueQueued
+	u.NextAttempt = time.Time{}
+	u.Updated = time.Now()
+	if err := q.put(ctx, u); err != nil {
+		return nil, err
+	}
+	q.schedule(u)
+	q.notify()
+	return u, nil
+}
+
+// Cancel removes the upload from the queue, an upload in progress is
+// stopped. The chunks the pp already acknowledged are kept in the upload
+// session, see Fetcher.Uploads.
+func (q *UploadQueue) Cancel(ctx context.Context, c cid.Cid) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u, err := q.get(ctx, c.String())
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return fmt.Errorf("upload of %s is not queued", c)
+	}
+	if cancel, ok := q.running[u.Cid]; ok {
+		cancel()
+		delete(q.running, u.Cid)
+	}
+	q.unschedule(u.Cid)
+	if u.Status == QueueDone {
+		return q.ds.Delete(ctx, queueDoneKey(u.Cid))
+	}
+	return q.ds.Delete(ctx, queueKey(u.Cid))
+}
+
+// Running reports if the workers are started, the queued DAGs are only
+// uploaded while they run
+func (q *UploadQueue) Running() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.cancel != nil
+}
+
+// Start runs the workers uploading the queued DAGs until Close
+func (q *UploadQueue) Start(upload QueueUploader) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.cancel != nil {
+		return
+	}
+
+	var ctx context.Context
+	ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.work(ctx, upload)
+		}()
+	}
+}
+
+// Close stops the workers, the uploads in progress are attempted again on
+// the next start
+func (q *UploadQueue) Close() {
+	q.mu.Lock()
+	cancel := q.cancel
+	q.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	q.wg.Wait()
+}
+
+func (q *UploadQueue) work(ctx context.Context, upload QueueUploader) {
+	for ctx.Err() == nil {
+		u, uctx, wake, wait, err := q.next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("reading the sds upload queue: %s", err)
+			wait = q.retry.baseDelay
+		}
+		if u != nil {
+			q.process(ctx, uctx, u, upload)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// idleWait is how long an idle worker waits when no upload is due, queuing
+// an upload wakes it up before
+const idleWait = time.Minute
+
+// pendingUpload is a queued upload in the pending index of the queue
+type pendingUpload struct {
+	cid   string
+	due   time.Time
+	added time.Time
+	index int
+}
+
+// pendingUploads is a heap of the queued uploads, the next one due first
+type pendingUploads []*pendingUpload
+
+func (p pendingUploads) Len() int { return len(p) }
+
+func (p pendingUploads) Less(i, j int) bool {
+	if !p[i].due.Equal(p[j].due) {
+		return p[i].due.Before(p[j].due)
+	}
+	return p[i].added.Before(p[j].added)
+}
+
+func (p pendingUploads) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+	p[i].index = i
+	p[j].index = j
+}
+
+func (p *pendingUploads) Push(x any) {
+	u := x.(*pendingUpload)
+	u.index = len(*p)
+	*p = append(*p, u)
+}
+
+func (p *pendingUploads) Pop() any {
+	old := *p
+	u := old[len(old)-1]
+	old[len(old)-1] = nil
+	*p = old[:len(old)-1]
+	return u
+}
+
+// schedule indexes the queued upload by its next attempt, q.mu must be held.
+// The uploads are only indexed once the index is loaded.
+func (q *UploadQueue) schedule(u *QueuedUpload) {
+	if !q.indexed {
+		return
+	}
+	if p, ok := q.scheduled[u.Cid]; ok {
+		p.due = u.NextAttempt
+		heap.Fix(&q.pending, p.index)
+		return
+	}
+	p := &pendingUpload{cid: u.Cid, due: u.NextAttempt, added: u.Added}
+	heap.Push(&q.pending, p)
+	q.scheduled[u.Cid] = p
+}
+
+// unschedule removes the upload from the index, q.mu must be held
+func (q *UploadQueue) unschedule(c string) {
+	if p, ok := q.scheduled[c]; ok {
+		heap.Remove(&q.pending, p.index)
+		delete(q.scheduled, c)
+	}
+}
+
+// index loads the queued uploads in the pending index, q.mu must be held.
+// The done uploads saved in the queue namespace by the previous versions are
+// moved to the done namespace.
+func (q *UploadQueue) index(ctx context.Context) error {
+	if q.indexed {
+		return nil
+	}
+	uploads, err := q.query(ctx, queuePrefix)
+	if err != nil {
+		return err
+	}
+	q.indexed = true
+	for _, u := range uploads {
+		switch u.Status {
+		case QueueQueued, QueueUploading:
+			if _, ok := q.running[u.Cid]; !ok {
+				q.schedule(u)
+			}
+		case QueueDone:
+			if err := q.put(ctx, u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// next claims the oldest upload which is due. When there is none, it returns
+// the channel closed by the next upload queued and the wait until the next
+// upload is due.
+func (q *UploadQueue) next(ctx context.Context) (*QueuedUpload, context.Context, <-chan struct{}, time.Duration, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// the workers could be stopped while waiting for the lock
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if err := q.index(ctx); err != nil {
+		return nil, nil, nil, 0, err
+	}
+
+	now := time.Now()
+	for q.pending.Len() > 0 {
+		p := q.pending[0]
+		if p.due.After(now) {
+			return nil, nil, q.wake, min(idleWait, p.due.Sub(now)), nil
+		}
+
+		u, err := q.get(ctx, p.cid)
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
+		q.unschedule(p.cid)
+		if u == nil || (u.Status != QueueQueued && u.Status != QueueUploading) {
+			continue
+		}
+
+		u.Status = QueueUploading
+		u.Attempts++
+		u.Updated = now
+		if err := q.put(ctx, u); err != nil {
+			q.schedule(u)
+			return nil, nil, nil, 0, err
+		}
+		uctx, cancel := context.WithCancel(ctx)
+		q.running[u.Cid] = cancel
+		return u, uctx, nil, 0, nil
+	}
+	return nil, nil, q.wake, idleWait, nil
+}
+
+// process runs the upload and saves its outcome, a failed upload is queued
+// again until it runs out of attempts
+func (q *UploadQueue) process(ctx, uctx context.Context, u *QueuedUpload, upload QueueUploader) {
+	start := time.Now()
+	c, err := cid.Decode(u.Cid)
+	var (
+		fileHash string
+		link     cid.Cid
+	)
+	if err == nil {
+		fileHash, link, err = upload(uctx, c, u.Name)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cancel, ok := q.running[u.Cid]
+	if !ok {
+		// cancelled, the upload is already removed from the queue
+		queueUploads.WithLabelValues("cancelled").Inc()
+		return
+	}
+	cancel()
+	delete(q.running, u.Cid)
+
+	now := time.Now()
+	u.Updated = now
+	switch {
+	case ctx.Err() != nil:
+		// the workers are stopping, the attempt is not counted
+		u.Status = QueueQueued
+		u.Attempts--
+	case err != nil:
+		logger.Warnf("sds upload of %s failed, attempt %d/%d: %s", u.Cid, u.Attempts, q.retry.maxAttempts, err)
+		u.Error = err.Error()
+		if u.Attempts >= q.retry.maxAttempts {
+			u.Status = QueueFailed
+		} else {
+			u.Status = QueueQueued
+			u.NextAttempt = now.Add(q.retry.delay(u.Attempts))
+		}
+	default:
+		logger.Infof("sds upload of %s done in %s, mapping file %s", u.Cid, now.Sub(start), link)
+		u.Status = QueueDone
+		u.FileHash = fileHash
+		u.Link = link.String()
+		u.Error = ""
+		u.NextAttempt = time.Time{}
+	}
+	if ctx.Err() == nil {
+		queueUploads.WithLabelValues(outcome(err)).Inc()
+	}
+
+	// the worker context could be done, the outcome is saved anyway
+	if errS := q.put(context.WithoutCancel(ctx), u); errS != nil {
+		logger.Errorf("failed to save queued upload of %s: %s", u.Cid, errS)
+	}
+	if u.Status == QueueQueued {
+		q.schedule(u)
+	}
+}
@@ -0,0 +1,284 @@
+package sds_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/sds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLinkCid = "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"
+
+func newTestQueue(t *testing.T, ds datastore.Datastore, maxAttempts int64) *sds.UploadQueue {
+	q := sds.NewUploadQueue(&config.SdsQueue{
+		Retry: config.SdsRetryPolicy{
+			MaxAttempts: config.NewOptionalInteger(maxAttempts),
+			BaseDelay:   config.NewOptionalDuration(time.Millisecond),
+			MaxDelay:    config.NewOptionalDuration(time.Millisecond),
+		},
+	}, ds)
+	t.Cleanup(q.Close)
+	return q
+}
+
+// queued waits for the upload of c to reach the status
+func queued(t *testing.T, q *sds.UploadQueue, c cid.Cid, status sds.QueueStatus) *sds.QueuedUpload {
+	var found *sds.QueuedUpload
+	require.Eventually(t, func() bool {
+		uploads, err := q.List(context.Background())
+		require.NoError(t, err)
+		for _, u := range uploads {
+			if u.Cid == c.String() && u.Status == status {
+				found = u
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	return found
+}
+
+func TestUploadQueue(t *testing.T) {
+	ctx := context.Background()
+	c := cid.MustParse(testCid)
+	link := cid.MustParse(testLinkCid)
+
+	q := newTestQueue(t, dssync.MutexWrap(datastore.NewMapDatastore()), 3)
+	u, err := q.Enqueue(ctx, c, "")
+	require.NoError(t, err)
+	assert.Equal(t, sds.QueueQueued, u.Status)
+
+	var attempts atomic.Int32
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		assert.Equal(t, c, uc)
+		if attempts.Add(1) < 2 {
+			return "", cid.Undef, sds.ErrPPUnavailable
+		}
+		return "filehash", link, nil
+	})
+
+	u = queued(t, q, c, sds.QueueDone)
+	assert.Equal(t, int64(2), u.Attempts)
+	assert.Equal(t, "filehash", u.FileHash)
+	assert.Equal(t, link.String(), u.Link)
+	assert.Empty(t, u.Error)
+
+	// an uploaded DAG is not queued again
+	u, err = q.Enqueue(ctx, c, "")
+	require.NoError(t, err)
+	assert.Equal(t, sds.QueueDone, u.Status)
+	_, err = q.Retry(ctx, c)
+	assert.Error(t, err)
+}
+
+func TestUploadQueueFailedAndRetried(t *testing.T) {
+	ctx := context.Background()
+	c := cid.MustParse(testCid)
+
+	q := newTestQueue(t, dssync.MutexWrap(datastore.NewMapDatastore()), 2)
+	var fail atomic.Bool
+	fail.Store(true)
+	var attempts atomic.Int32
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		attempts.Add(1)
+		if fail.Load() {
+			return "", cid.Undef, errors.New("pp is down")
+		}
+		return "filehash", cid.MustParse(testLinkCid), nil
+	})
+
+	_, err := q.Enqueue(ctx, c, "")
+	require.NoError(t, err)
+	u := queued(t, q, c, sds.QueueFailed)
+	assert.Equal(t, int64(2), u.Attempts)
+	assert.Equal(t, "pp is down", u.Error)
+	assert.Equal(t, int32(2), attempts.Load())
+
+	fail.Store(false)
+	u, err = q.Retry(ctx, c)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), u.Attempts)
+	queued(t, q, c, sds.QueueDone)
+}
+
+func TestUploadQueueCancel(t *testing.T) {
+	ctx := context.Background()
+	c := cid.MustParse(testCid)
+
+	q := newTestQueue(t, dssync.MutexWrap(datastore.NewMapDatastore()), 3)
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return "", cid.Undef, ctx.Err()
+	})
+
+	_, err := q.Enqueue(ctx, c, "")
+	require.NoError(t, err)
+	<-started
+	queued(t, q, c, sds.QueueUploading)
+
+	require.NoError(t, q.Cancel(ctx, c))
+	assert.ErrorIs(t, <-stopped, context.Canceled)
+	uploads, err := q.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, uploads)
+
+	assert.Error(t, q.Cancel(ctx, c))
+}
+
+func TestUploadQueueSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	c := cid.MustParse(testCid)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	// the first process stops during the upload
+	q := newTestQueue(t, ds, 3)
+	started := make(chan struct{})
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		close(started)
+		<-ctx.Done()
+		return "", cid.Undef, ctx.Err()
+	})
+	_, err := q.Enqueue(ctx, c, "")
+	require.NoError(t, err)
+	<-started
+	q.Close()
+
+	q = newTestQueue(t, ds, 3)
+	u := queued(t, q, c, sds.QueueQueued)
+	assert.Equal(t, int64(0), u.Attempts)
+
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		return "filehash", cid.MustParse(testLinkCid), nil
+	})
+	u = queued(t, q, c, sds.QueueDone)
+	assert.Equal(t, int64(1), u.Attempts)
+}
+
+func TestUploadQueueName(t *testing.T) {
+	ctx := context.Background()
+	c := cid.MustParse(testCid)
+
+	q := newTestQueue(t, dssync.MutexWrap(datastore.NewMapDatastore()), 3)
+	assert.False(t, q.Running())
+
+	u, err := q.Enqueue(ctx, c, "mypin")
+	require.NoError(t, err)
+	assert.Equal(t, "mypin", u.Name)
+
+	// queuing the DAG again without a name keeps its name
+	u, err = q.Enqueue(ctx, c, "")
+	require.NoError(t, err)
+	assert.Equal(t, "mypin", u.Name)
+
+	u, err = q.Enqueue(ctx, c, "renamed")
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", u.Name)
+	assert.Equal(t, sds.QueueQueued, u.Status)
+
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		return "filehash", cid.MustParse(testLinkCid), nil
+	})
+	assert.True(t, q.Running())
+	u = queued(t, q, c, sds.QueueDone)
+	assert.Equal(t, "renamed", u.Name)
+}
+
+// keys returns the keys saved under the prefix
+func keys(t *testing.T, ds datastore.Datastore, prefix string) []string {
+	results, err := ds.Query(context.Background(), query.Query{Prefix: prefix, KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := results.Rest()
+	require.NoError(t, err)
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestUploadQueueDoneApart(t *testing.T) {
+	ctx := context.Background()
+	c := cid.MustParse(testCid)
+	legacy := cid.MustParse(testLinkCid)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	// the done uploads of the previous versions are in the queue namespace
+	data, err := json.Marshal(&sds.QueuedUpload{Cid: legacy.String(), Status: sds.QueueDone, Attempts: 1})
+	require.NoError(t, err)
+	require.NoError(t, ds.Put(ctx, datastore.NewKey("/sds/queue").ChildString(legacy.String()), data))
+
+	q := newTestQueue(t, ds, 3)
+	_, err = q.Enqueue(ctx, c, "")
+	require.NoError(t, err)
+	var uploaded atomic.Int32
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		uploaded.Add(1)
+		return "filehash", cid.MustParse(testLinkCid), nil
+	})
+	queued(t, q, c, sds.QueueDone)
+
+	assert.Empty(t, keys(t, ds, "/sds/queue"))
+	assert.ElementsMatch(t, []string{"/sds/queue-done/" + c.String(), "/sds/queue-done/" + legacy.String()}, keys(t, ds, "/sds/queue-done"))
+	assert.Equal(t, int32(1), uploaded.Load())
+
+	u, err := q.Enqueue(ctx, legacy, "")
+	require.NoError(t, err)
+	assert.Equal(t, sds.QueueDone, u.Status)
+	require.NoError(t, q.Cancel(ctx, legacy))
+	assert.Equal(t, []string{"/sds/queue-done/" + c.String()}, keys(t, ds, "/sds/queue-done"))
+}
+
+func TestUploadQueueNextAttempt(t *testing.T) {
+	ctx := context.Background()
+	first := cid.MustParse(testCid)
+	second := cid.MustParse(testLinkCid)
+
+	q := sds.NewUploadQueue(&config.SdsQueue{
+		Workers: config.NewOptionalInteger(1),
+		Retry: config.SdsRetryPolicy{
+			MaxAttempts: config.NewOptionalInteger(2),
+			BaseDelay:   config.NewOptionalDuration(time.Hour),
+			MaxDelay:    config.NewOptionalDuration(time.Hour),
+		},
+	}, dssync.MutexWrap(datastore.NewMapDatastore()))
+	t.Cleanup(q.Close)
+
+	_, err := q.Enqueue(ctx, first, "")
+	require.NoError(t, err)
+	_, err = q.Enqueue(ctx, second, "")
+	require.NoError(t, err)
+	q.Start(func(ctx context.Context, uc cid.Cid, name string) (string, cid.Cid, error) {
+		if uc == first {
+			return "", cid.Undef, sds.ErrPPUnavailable
+		}
+		return "filehash", cid.MustParse(testLinkCid), nil
+	})
+
+	// the failed upload waits for its next attempt, the other one is not
+	// held up behind it
+	queued(t, q, second, sds.QueueDone)
+	u := queued(t, q, first, sds.QueueQueued)
+	assert.Equal(t, int64(1), u.Attempts)
+	assert.True(t, u.NextAttempt.After(time.Now()))
+
+	// retrying makes it due right away
+	_, err = q.Retry(ctx, first)
+	require.NoError(t, err)
+	u = queued(t, q, first, sds.QueueFailed)
+	assert.Equal(t, int64(2), u.Attempts)
+}
@@ -0,0 +1,98 @@
+package sds
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/ipfs/kubo/config"
+)
+
+// The operations retried when the pp nodes are unavailable, see
+// config.SdsRetry
+const (
+	retryOzone    = "ozone"
+	retryDownload = "download"
+	retryUpload   = "upload"
+)
+
+// retryPolicy is a config.SdsRetryPolicy with the defaults applied
+type retryPolicy struct {
+	maxAttempts int64
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64
+}
+
+// defaultRetryPolicy is the policy of Sds.Retry
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts: config.DefaultSdsRetryMaxAttempts,
+	baseDelay:   config.DefaultSdsRetryBaseDelay,
+	maxDelay:    config.DefaultSdsRetryMaxDelay,
+	jitter:      config.DefaultSdsRetryJitter,
+}
+
+// newRetryPolicy applies the defaults to the fields cfg does not set, the
+// jitter of the defaults is a percentage
+func newRetryPolicy(cfg config.SdsRetryPolicy, defaults retryPolicy) retryPolicy {
+	p := retryPolicy{
+		maxAttempts: cfg.MaxAttempts.WithDefault(defaults.maxAttempts),
+		baseDelay:   cfg.BaseDelay.WithDefault(defaults.baseDelay),
+		maxDelay:    cfg.MaxDelay.WithDefault(defaults.maxDelay),
+		jitter:      float64(cfg.Jitter.WithDefault(int64(defaults.jitter))) / 100,
+	}
+	p.maxAttempts = max(p.maxAttempts, 1)
+	p.jitter = min(max(p.jitter, 0), 1)
+	return p
+}
+
+func (p retryPolicy) backOff(ctx context.Context) backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = p.baseDelay
+	bo.MaxInterval = p.maxDelay
+	bo.Multiplier = 2
+	bo.RandomizationFactor = p.jitter
+	bo.MaxElapsedTime = 0 // bounded by the attempts
+	bo.Reset()
+	return backoff.WithContext(backoff.WithMaxRetries(bo, uint64(p.maxAttempts-1)), ctx)
+}
+
+// delay returns the wait after the attempt, the way backOff grows it, for
+// the retries which outlive the process and could not keep a backOff
+func (p retryPolicy) delay(attempt int64) time.Duration {
+	d := p.baseDelay
+	for i := int64(1); i < attempt && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, p.maxDelay)
+	return time.Duration(float64(d) * (1 + p.jitter*(2*rand.Float64()-1)))
+}
+
+// isTransient reports if the operation could succeed when retried
+func isTransient(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && errors.Is(err, ErrPPUnavailable)
+}
+
+// retry runs op until it succeeds, fails with an error which is not
+// transient or runs out of attempts
+func (rpc *Rpc) retry(ctx context.Context, operation string, op func() error) error {
+	policy, ok := rpc.pool.retry[operation]
+	if !ok {
+		return op()
+	}
+
+	attempt := 0
+	return backoff.RetryNotify(func() error {
+		attempt++
+		err := op()
+		if err != nil && !isTransient(ctx, err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, policy.backOff(ctx), func(err error, delay time.Duration) {
+		rpcRetries.WithLabelValues(operation).Inc()
+		logger.Warnf("retrying %s in %s after attempt %d/%d failed: %s", operation, delay, attempt, policy.maxAttempts, err)
+	})
+}
@@ -2,15 +2,27 @@ package sds
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/tracing"
 	fwtypes "github.com/stratosnet/sds/framework/types"
 	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type jsonrpcMessage struct {
@@ -19,6 +31,12 @@ type jsonrpcMessage struct {
 	Method  string          `json:"method,omitempty"`
 	Params  json.RawMessage `json:"params,omitempty"`
 	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
 }
 
 func wrapJsonRpc(method string, param []byte) []byte {
@@ -37,19 +55,321 @@ func wrapJsonRpc(method string, param []byte) []byte {
 	return r
 }
 
-var timeout = 10 * time.Second
+// latencyWeight is the weight of the last request in the latency average
+const latencyWeight = 0.3
+
+// endpoint is a pp node and its observed state
+type endpoint struct {
+	url string
+
+	mu      sync.Mutex
+	healthy bool
+	latency time.Duration
+	lastErr error
+}
+
+func (ep *endpoint) succeeded(latency time.Duration) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.healthy = true
+	ep.lastErr = nil
+	if ep.latency == 0 {
+		ep.latency = latency
+	} else {
+		ep.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(ep.latency))
+	}
+}
+
+func (ep *endpoint) failed(err error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.healthy = false
+	ep.lastErr = err
+}
 
+// PPState is the observed state of a pp node
+type PPState struct {
+	URL     string
+	Healthy bool
+	Latency time.Duration
+	Error   error
+}
+
+func (ep *endpoint) state() PPState {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return PPState{URL: ep.url, Healthy: ep.healthy, Latency: ep.latency, Error: ep.lastErr}
+}
+
+// ppPool is the set of pp nodes shared by an rpc client and its sessions
+type ppPool struct {
+	client    *http.Client
+	endpoints []*endpoint
+	selection string
+	next      atomic.Uint64
+	// retry are the policies of the operations which could be retried
+	retry map[string]retryPolicy
+
+	// ctx is cancelled when the rpc client is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Rpc is a JSON-RPC client of the pp nodes. Requests fail over to the next
+// pp node when one is unreachable. A session, returned by Session, sticks to
+// the pp node which answered its first request, as the upload and download
+// sessions only exist on the pp node which started them.
 type Rpc struct {
-	httpRpcUrl string
+	pool *ppPool
+	// download selects the pp node with the download selection strategy
+	download bool
+	// session requests stick to the first pp node answering
+	session bool
+
+	mu     sync.Mutex
+	pinned *endpoint
 }
 
-func NewRpc(httpRpcUrl string) (*Rpc, error) {
-	return &Rpc{
-		httpRpcUrl: httpRpcUrl,
-	}, nil
+// NewRpc creates a client of the pp nodes of the config, and starts their
+// health checks until Close is called
+func NewRpc(cfg *config.Sds) (*Rpc, error) {
+	urls := cfg.PPs()
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no pp node set in Sds.RpcURLs")
+	}
+	selection := cfg.RpcSelection.WithDefault(config.DefaultSdsRpcSelection)
+	switch selection {
+	case config.SdsSelectionFailover, config.SdsSelectionRoundRobin, config.SdsSelectionLeastLatency:
+	default:
+		return nil, fmt.Errorf("unknown Sds.RpcSelection %q", selection)
+	}
+
+	pool := &ppPool{
+		client: &http.Client{
+			Timeout: cfg.RpcTimeout.WithDefault(config.DefaultSdsRpcTimeout),
+		},
+		selection: selection,
+		retry:     make(map[string]retryPolicy),
+	}
+	for _, op := range []string{retryOzone, retryDownload, retryUpload} {
+		pool.retry[op] = newRetryPolicy(cfg.Retry.Policy(op), defaultRetryPolicy)
+	}
+	pool.ctx, pool.cancel = context.WithCancel(context.Background())
+	for _, u := range urls {
+		// pp nodes are presumed healthy until a request fails
+		pool.endpoints = append(pool.endpoints, &endpoint{url: u, healthy: true})
+	}
+
+	if interval := cfg.HealthCheckInterval.WithDefault(config.DefaultSdsHealthCheckInterval); interval > 0 {
+		go pool.healthChecks(interval)
+	}
+
+	return &Rpc{pool: pool}, nil
+}
+
+// Close stops the health checks
+func (rpc *Rpc) Close() {
+	rpc.pool.cancel()
+}
+
+// Session returns a client sticking to the pp node which answers its first
+// request. Download sessions pick the pp node with the configured selection
+// strategy, the other ones use the failover order. A session could be pinned
+// beforehand to a given pp node with Pin.
+func (rpc *Rpc) Session(download bool) *Rpc {
+	return &Rpc{pool: rpc.pool, download: download, session: true}
+}
+
+// Pin makes the session use the pp node at url, if it is still one of the
+// pp nodes and is healthy, it is ignored otherwise
+func (rpc *Rpc) Pin(url string) {
+	for _, ep := range rpc.pool.endpoints {
+		if ep.url == url && ep.state().Healthy {
+			rpc.mu.Lock()
+			rpc.pinned = ep
+			rpc.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Unpin makes the session use the pp nodes in the order of the candidates
+// again, it sticks to the next one answering
+func (rpc *Rpc) Unpin() {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	rpc.pinned = nil
+}
+
+// PP returns the url of the pp node the session sticks to, empty until the
+// first request is answered
+func (rpc *Rpc) PP() string {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	if rpc.pinned == nil {
+		return ""
+	}
+	return rpc.pinned.url
+}
+
+// PPs returns the state of all the pp nodes
+func (rpc *Rpc) PPs() []PPState {
+	states := make([]PPState, 0, len(rpc.pool.endpoints))
+	for _, ep := range rpc.pool.endpoints {
+		states = append(states, ep.state())
+	}
+	return states
+}
+
+// candidates returns the pp nodes to try in order, the healthy ones first
+func (rpc *Rpc) candidates() []*endpoint {
+	rpc.mu.Lock()
+	pinned := rpc.pinned
+	rpc.mu.Unlock()
+	if pinned != nil {
+		return []*endpoint{pinned}
+	}
+
+	pool := rpc.pool
+	var healthy, down []*endpoint
+	for _, ep := range pool.endpoints {
+		if ep.state().Healthy {
+			healthy = append(healthy, ep)
+		} else {
+			down = append(down, ep)
+		}
+	}
+
+	if rpc.download && len(healthy) > 1 {
+		switch pool.selection {
+		case config.SdsSelectionRoundRobin:
+			n := int(pool.next.Add(1) % uint64(len(healthy)))
+			healthy = append(healthy[n:], healthy[:n]...)
+		case config.SdsSelectionLeastLatency:
+			sort.SliceStable(healthy, func(i, j int) bool {
+				return healthy[i].state().Latency < healthy[j].state().Latency
+			})
+		}
+	}
+
+	// pp nodes marked down are still tried as a last resort, they could be
+	// back before the next health check
+	return append(healthy, down...)
+}
+
+// healthChecks probes the pp nodes until the pool is closed
+func (pool *ppPool) healthChecks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pool.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, ep := range pool.endpoints {
+			pool.check(ep)
+		}
+	}
+}
+
+// check probes the pp node with an ozone request, any JSON-RPC answer means
+// the pp node is up
+func (pool *ppPool) check(ep *endpoint) {
+	var res rpc_api.GetOzoneResult
+	start := time.Now()
+	var rpcErr *RPCError
+	if err := pool.post(pool.ctx, ep.url, "user_requestGetOzone", &rpc_api.ParamReqGetOzone{}, &res); err != nil && !errors.As(err, &rpcErr) {
+		if ep.state().Healthy {
+			logger.Warnf("pp %s is down: %s", ep.url, err)
+		}
+		ep.failed(err)
+		return
+	}
+	if !ep.state().Healthy {
+		logger.Infof("pp %s is back up", ep.url)
+	}
+	ep.succeeded(time.Since(start))
+}
+
+// decodedLen returns the length of the data encoded in base64 in s
+func decodedLen(s string) int {
+	return base64.StdEncoding.DecodedLen(len(s)) - strings.Count(s[max(0, len(s)-2):], "=")
+}
+
+// sendRequest sends the request to the first pp node answering it, in a span
+// with attrs describing the request. The pp errors are returned right away,
+// the next pp node is only tried when the pp node is unreachable.
+func (rpc *Rpc) sendRequest(ctx context.Context, method string, param any, res any, attrs ...attribute.KeyValue) error {
+	ctx, span := tracing.Span(ctx, "Sds.Rpc", method, trace.WithAttributes(append(attrs, attribute.String("method", method))...))
+	defer span.End()
+
+	err := rpc.send(ctx, method, param, res)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	var ret string
+	switch r := res.(type) {
+	case *rpc_api.Result:
+		ret = r.Return
+		if r.FileHash != "" {
+			span.SetAttributes(attribute.String("filehash", r.FileHash))
+		}
+		if r.FileData != "" && r.OffsetStart != nil && r.OffsetEnd != nil {
+			span.SetAttributes(attribute.Int64("bytes", int64(*r.OffsetEnd-*r.OffsetStart)))
+		}
+	case *rpc_api.GetOzoneResult:
+		ret = r.Return
+	case *rpc_api.FileShareResult:
+		ret = r.Return
+	}
+	span.SetAttributes(attribute.String("return", ret))
+	if !isReturnOK(ret) {
+		span.SetStatus(codes.Error, "pp returned "+ret)
+	}
+	return nil
+}
+
+// send posts the request to the pp nodes in the order of the candidates
+func (rpc *Rpc) send(ctx context.Context, method string, param any, res any) error {
+	var err error
+	for _, ep := range rpc.candidates() {
+		start := time.Now()
+		err = rpc.pool.post(ctx, ep.url, method, param, res)
+		var rpcErr *RPCError
+		if err == nil || errors.As(err, &rpcErr) {
+			// the pp node answered, even if with an error
+			latency := time.Since(start)
+			ep.succeeded(latency)
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("pp", ep.url))
+			rpcDuration.WithLabelValues(method).Observe(latency.Seconds())
+			if rpc.session {
+				rpc.mu.Lock()
+				if rpc.pinned == nil {
+					rpc.pinned = ep
+				}
+				rpc.mu.Unlock()
+			}
+			return err
+		}
+
+		// the request was given up, it tells nothing about the pp node
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		logger.Warnf("pp %s failed on %s: %s", ep.url, method, err)
+		ep.failed(err)
+	}
+	return fmt.Errorf("%w: %w", ErrPPUnavailable, err)
 }
 
-func (rpc *Rpc) sendRequest(method string, param any, res any) error {
+// post sends the request to the pp node at url. The errors answered by the
+// pp node are returned as *RPCError, the other ones mean the pp node could
+// not be reached.
+func (pool *ppPool) post(ctx context.Context, url, method string, param any, res any) error {
 	var params []any
 	params = append(params, param)
 	pm, err := json.Marshal(params)
@@ -68,17 +388,13 @@ func (rpc *Rpc) sendRequest(method string, param any, res any) error {
 	}
 
 	// http post
-	req, err := http.NewRequest("POST", rpc.httpRpcUrl, bytes.NewBuffer(request))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(request))
 	if err != nil {
 		return err
 	}
-	// req.Header.Set("X-Custom-Header", "myvalue")
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{
-		Timeout: timeout,
-	}
-	resp, err := client.Do(req)
+	resp, err := pool.client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -92,6 +408,13 @@ func (rpc *Rpc) sendRequest(method string, param any, res any) error {
 
 	resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("pp answered with http status %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &RPCError{Method: method, HTTPStatus: resp.StatusCode, Message: string(bytes.TrimSpace(body))}
+	}
+
 	if len(body) == 0 {
 		logger.Error("emptry body after read buffer")
 		return fmt.Errorf("empty response body")
@@ -103,6 +426,9 @@ func (rpc *Rpc) sendRequest(method string, param any, res any) error {
 	if err != nil {
 		return err
 	}
+	if rsp.Error != nil {
+		return &RPCError{Method: method, Code: rsp.Error.Code, Message: rsp.Error.Message}
+	}
 
 	err = json.Unmarshal(rsp.Result, &res)
 	if err != nil {
@@ -112,132 +438,185 @@ func (rpc *Rpc) sendRequest(method string, param any, res any) error {
 	return nil
 }
 
-func (rpc *Rpc) GetOzone(wallet *SdsWallet) (*rpc_api.GetOzoneResult, error) {
+func (rpc *Rpc) GetOzone(ctx context.Context, wallet *SdsWallet) (*rpc_api.GetOzoneResult, error) {
+	var res *rpc_api.GetOzoneResult
+	err := rpc.retry(ctx, retryOzone, func() (err error) {
+		res, err = rpc.getOzone(ctx, wallet)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// getOzone is GetOzone without the retries, for the operations retried as a
+// whole
+func (rpc *Rpc) getOzone(ctx context.Context, wallet *SdsWallet) (*rpc_api.GetOzoneResult, error) {
+	address, err := wallet.GetAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
 	req := &rpc_api.ParamReqGetOzone{
-		WalletAddr: wallet.GetAddress(),
+		WalletAddr: address,
 	}
 
 	var res rpc_api.GetOzoneResult
-
-	err := rpc.sendRequest("user_requestGetOzone", req, &res)
-	if err != nil {
+	if err := rpc.sendRequest(ctx, "user_requestGetOzone", req, &res); err != nil {
+		return nil, err
+	}
+	if err := checkReturn("user_requestGetOzone", res.Return); err != nil {
 		return nil, err
 	}
+	setOzoneBalance(res.Ozone)
 	return &res, nil
 }
 
-func (rpc *Rpc) RequestUpload(wallet *SdsWallet, sn, fileName, fileHash string, fileSize int) (*rpc_api.Result, error) {
+// walletSignature returns the signature of a request along with the wallet
+// which signed it
+func walletSignature(ctx context.Context, wallet *SdsWallet, sign []byte) (rpc_api.Signature, error) {
+	address, err := wallet.GetAddress(ctx)
+	if err != nil {
+		return rpc_api.Signature{}, err
+	}
+	wpk, err := wallet.GetBech32PubKey(ctx)
+	if err != nil {
+		return rpc_api.Signature{}, err
+	}
+	return rpc_api.Signature{
+		Address:   address,
+		Pubkey:    wpk,
+		Signature: hex.EncodeToString(sign),
+	}, nil
+}
+
+// paramReqUploadFile is the upload request along with the metadata of the
+// file, the pps which do not know the metadata ignore it
+type paramReqUploadFile struct {
+	rpc_api.ParamReqUploadFile
+	ContentType string `json:"contenttype,omitempty"`
+	IpfsCid     string `json:"ipfscid,omitempty"`
+}
+
+// RequestUpload requests the upload of the file, stored in sds under the
+// name of its metadata
+func (rpc *Rpc) RequestUpload(ctx context.Context, wallet *SdsWallet, sn, fileHash string, fileSize int, meta UploadMeta) (*rpc_api.Result, error) {
 	nowSec := time.Now().Unix()
 
-	sign, err := wallet.SignFileUpload(sn, fileHash)
+	fileName, err := meta.fileName()
+	if err != nil {
+		return nil, err
+	}
+
+	sign, err := wallet.SignFileUpload(ctx, sn, fileHash, nowSec)
 	if err != nil {
 		return nil, err
 	}
-	wpk, err := wallet.GetBech32PubKey()
+	signature, err := walletSignature(ctx, wallet, sign)
 	if err != nil {
 		return nil, err
 	}
 
-	req := &rpc_api.ParamReqUploadFile{
-		FileName: fileName,
-		FileHash: fileHash,
-		FileSize: fileSize,
-		Signature: rpc_api.Signature{
-			Address:   wallet.GetAddress(),
-			Pubkey:    wpk,
-			Signature: hex.EncodeToString(sign),
+	req := &paramReqUploadFile{
+		ParamReqUploadFile: rpc_api.ParamReqUploadFile{
+			FileName:        fileName,
+			FileHash:        fileHash,
+			FileSize:        fileSize,
+			Signature:       signature,
+			DesiredTier:     1,
+			AllowHigherTier: true,
+			ReqTime:         nowSec,
+			SequenceNumber:  sn,
 		},
-		DesiredTier:     1,
-		AllowHigherTier: true,
-		ReqTime:         nowSec,
-		SequenceNumber:  sn,
+		ContentType: meta.ContentType,
+	}
+	if meta.Cid.Defined() {
+		req.IpfsCid = meta.Cid.String()
 	}
 
 	var res rpc_api.Result
-	err = rpc.sendRequest("user_requestUpload", req, &res)
+	err = rpc.sendRequest(ctx, "user_requestUpload", req, &res, attribute.String("filehash", fileHash), attribute.String("filename", fileName), attribute.Int("size", fileSize))
 	if err != nil {
 		return nil, err
 	}
-	return &res, nil
+	return &res, checkReturn("user_requestUpload", res.Return)
 }
 
-func (rpc *Rpc) UploadData(wallet *SdsWallet, sn, fileHash string, fileChunk string) (*rpc_api.Result, error) {
+func (rpc *Rpc) UploadData(ctx context.Context, wallet *SdsWallet, sn, fileHash string, fileChunk string) (*rpc_api.Result, error) {
 	nowSec := time.Now().Unix()
 	// signature
-	sign, err := wallet.SignFileUpload(sn, fileHash)
+	sign, err := wallet.SignFileUpload(ctx, sn, fileHash, nowSec)
 	if err != nil {
 		return nil, err
 	}
-	wpk, err := wallet.GetBech32PubKey()
+	signature, err := walletSignature(ctx, wallet, sign)
 	if err != nil {
 		return nil, err
 	}
 
 	req := rpc_api.ParamUploadData{
-		FileHash: fileHash,
-		Data:     fileChunk,
-		Signature: rpc_api.Signature{
-			Address:   wallet.GetAddress(),
-			Pubkey:    wpk,
-			Signature: hex.EncodeToString(sign),
-		},
+		FileHash:       fileHash,
+		Data:           fileChunk,
+		Signature:      signature,
 		ReqTime:        nowSec,
 		SequenceNumber: sn,
 	}
 
 	var res rpc_api.Result
-	err = rpc.sendRequest("user_uploadData", req, &res)
+	err = rpc.sendRequest(ctx, "user_uploadData", req, &res, attribute.String("filehash", fileHash), attribute.Int("bytes", decodedLen(fileChunk)))
 	if err != nil {
 		return nil, err
 	}
-	return &res, nil
+	return &res, checkReturn("user_uploadData", res.Return)
 }
 
-func (rpc *Rpc) RequestDownload(wallet *SdsWallet, sn, fileHash string) (*rpc_api.Result, error) {
+// RequestDownload starts the download of the file uploaded by the owner wallet
+func (rpc *Rpc) RequestDownload(ctx context.Context, wallet *SdsWallet, sn, owner, fileHash string) (*rpc_api.Result, error) {
 	nowSec := time.Now().Unix()
 	// signature
-	sign, err := wallet.SignDownloadData(sn, fileHash)
+	sign, err := wallet.SignDownloadData(ctx, sn, fileHash, nowSec)
 	if err != nil {
 		return nil, err
 	}
-	wpk, err := wallet.GetBech32PubKey()
+	signature, err := walletSignature(ctx, wallet, sign)
 	if err != nil {
 		return nil, err
 	}
 
 	req := rpc_api.ParamReqDownloadFile{
-		FileHandle: "sdm://" + wallet.GetAddress() + "/" + fileHash,
-		Signature: rpc_api.Signature{
-			Address:   wallet.GetAddress(),
-			Pubkey:    wpk,
-			Signature: hex.EncodeToString(sign),
-		},
-		ReqTime: nowSec,
+		FileHandle: fwtypes.DATA_MESH_PROTOCOL + owner + "/" + fileHash,
+		Signature:  signature,
+		ReqTime:    nowSec,
 	}
 
 	var res rpc_api.Result
-	err = rpc.sendRequest("user_requestDownload", req, &res)
+	err = rpc.sendRequest(ctx, "user_requestDownload", req, &res, attribute.String("filehash", fileHash))
 	if err != nil {
 		return nil, err
 	}
-	return &res, nil
+	return &res, checkReturn("user_requestDownload", res.Return)
 }
 
-func (rpc *Rpc) DownloadData(wallet *SdsWallet, reqid, fileHash string) (*rpc_api.Result, error) {
+func (rpc *Rpc) DownloadData(ctx context.Context, wallet *SdsWallet, reqid, fileHash string) (*rpc_api.Result, error) {
 	req := rpc_api.ParamDownloadData{
 		ReqId:    reqid,
 		FileHash: fileHash,
 	}
 
 	var res rpc_api.Result
-	err := rpc.sendRequest("user_downloadData", req, &res)
+	err := rpc.retry(ctx, retryDownload, func() error {
+		if err := rpc.sendRequest(ctx, "user_downloadData", req, &res, attribute.String("filehash", fileHash)); err != nil {
+			return err
+		}
+		return checkReturn("user_downloadData", res.Return)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
-func (rpc *Rpc) DownloadedFileInfo(wallet *SdsWallet, reqid, fileHash string, fileSize uint64) (*rpc_api.Result, error) {
+func (rpc *Rpc) DownloadedFileInfo(ctx context.Context, wallet *SdsWallet, reqid, fileHash string, fileSize uint64) (*rpc_api.Result, error) {
 	req := rpc_api.ParamDownloadFileInfo{
 		FileHash: fileHash,
 		FileSize: fileSize,
@@ -245,33 +624,38 @@ func (rpc *Rpc) DownloadedFileInfo(wallet *SdsWallet, reqid, fileHash string, fi
 	}
 
 	var res rpc_api.Result
-	err := rpc.sendRequest("user_downloadedFileInfo", req, &res)
+	err := rpc.retry(ctx, retryDownload, func() error {
+		if err := rpc.sendRequest(ctx, "user_downloadedFileInfo", req, &res, attribute.String("filehash", fileHash)); err != nil {
+			return err
+		}
+		return checkReturn("user_downloadedFileInfo", res.Return)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
-func (rpc *Rpc) RequestShare(wallet *SdsWallet, fileHash string, cid *string) (*rpc_api.FileShareResult, error) {
+// RequestShare shares the file for duration seconds, zero for the default
+// duration of the sp. The password of a private share is generated by the sp.
+func (rpc *Rpc) RequestShare(ctx context.Context, wallet *SdsWallet, fileHash string, cid *string, duration int64, private bool) (*rpc_api.FileShareResult, error) {
 	nowSec := time.Now().Unix()
 	// signature
-	sign, err := wallet.SignCreateShareLink(fileHash)
+	sign, err := wallet.SignCreateShareLink(ctx, fileHash, nowSec)
 	if err != nil {
 		return nil, err
 	}
-	wpk, err := wallet.GetBech32PubKey()
+	signature, err := walletSignature(ctx, wallet, sign)
 	if err != nil {
 		return nil, err
 	}
 
 	req := rpc_api.ParamReqShareFile{
-		FileHash: fileHash,
-		Signature: rpc_api.Signature{
-			Address:   wallet.GetAddress(),
-			Pubkey:    wpk,
-			Signature: hex.EncodeToString(sign),
-		},
-		ReqTime: nowSec,
+		FileHash:    fileHash,
+		Signature:   signature,
+		Duration:    duration,
+		PrivateFlag: private,
+		ReqTime:     nowSec,
 	}
 
 	if cid != nil {
@@ -279,49 +663,95 @@ func (rpc *Rpc) RequestShare(wallet *SdsWallet, fileHash string, cid *string) (*
 	}
 
 	var res rpc_api.FileShareResult
-	err = rpc.sendRequest("user_requestShare", req, &res)
+	err = rpc.sendRequest(ctx, "user_requestShare", req, &res, attribute.String("filehash", fileHash))
 	if err != nil {
 		return nil, err
 	}
-	return &res, nil
+	return &res, checkReturn("user_requestShare", res.Return)
+}
+
+// ListShares returns a page of the share links of the wallet
+func (rpc *Rpc) ListShares(ctx context.Context, wallet *SdsWallet, page uint64) (*rpc_api.FileShareResult, error) {
+	nowSec := time.Now().Unix()
+	// signature
+	sign, err := wallet.SignListShareLinks(ctx, nowSec)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := walletSignature(ctx, wallet, sign)
+	if err != nil {
+		return nil, err
+	}
+
+	req := rpc_api.ParamReqListShared{
+		Signature: signature,
+		PageId:    page,
+		ReqTime:   nowSec,
+	}
+
+	var res rpc_api.FileShareResult
+	err = rpc.sendRequest(ctx, "user_requestListShare", req, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, checkReturn("user_requestListShare", res.Return)
 }
 
-func (rpc *Rpc) GetShared(wallet *SdsWallet, sn, shareLink string) (*rpc_api.Result, error) {
+// StopShare revokes the share link of the share id
+func (rpc *Rpc) StopShare(ctx context.Context, wallet *SdsWallet, shareId string) (*rpc_api.FileShareResult, error) {
 	nowSec := time.Now().Unix()
+	// signature
+	sign, err := wallet.SignStopShareLink(ctx, shareId, nowSec)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := walletSignature(ctx, wallet, sign)
+	if err != nil {
+		return nil, err
+	}
+
+	req := rpc_api.ParamReqStopShare{
+		Signature: signature,
+		ShareId:   shareId,
+		ReqTime:   nowSec,
+	}
 
-	fmt.Println("ParseShareLink shareLink", shareLink)
+	var res rpc_api.FileShareResult
+	err = rpc.sendRequest(ctx, "user_requestStopShare", req, &res, attribute.String("shareid", shareId))
+	if err != nil {
+		return nil, err
+	}
+	return &res, checkReturn("user_requestStopShare", res.Return)
+}
+
+func (rpc *Rpc) GetShared(ctx context.Context, wallet *SdsWallet, sn, shareLink string) (*rpc_api.Result, error) {
+	nowSec := time.Now().Unix()
 
 	parsedLink, err := fwtypes.ParseShareLink(shareLink)
-	fmt.Println("ParseShareLink parsedLink", parsedLink)
-	fmt.Println("ParseShareLink parsedLink err", err)
 	if err != nil {
 		return nil, err
 	}
 
 	// signature
-	sign, err := wallet.SignGetShareLink(sn, parsedLink.Link)
+	sign, err := wallet.SignGetShareLink(ctx, sn, parsedLink.Link, nowSec)
 	if err != nil {
 		return nil, err
 	}
-	wpk, err := wallet.GetBech32PubKey()
+	signature, err := walletSignature(ctx, wallet, sign)
 	if err != nil {
 		return nil, err
 	}
 
 	req := rpc_api.ParamReqGetShared{
-		Signature: rpc_api.Signature{
-			Address:   wallet.GetAddress(),
-			Pubkey:    wpk,
-			Signature: hex.EncodeToString(sign),
-		},
+		Signature: signature,
 		ReqTime:   nowSec,
 		ShareLink: shareLink,
 	}
 
 	var res rpc_api.Result
-	err = rpc.sendRequest("user_requestGetShared", req, &res)
+	err = rpc.sendRequest(ctx, "user_requestGetShared", req, &res, attribute.String("sharelink", redactShareLink(shareLink)))
 	if err != nil {
 		return nil, err
 	}
-	return &res, nil
+	return &res, checkReturn("user_requestGetShared", res.Return)
 }
@@ -1,124 +1,162 @@
-package sds
+package sds_test
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"fmt"
+	"errors"
 	"testing"
 
-	"github.com/stratosnet/sds/framework/utils"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/sds"
+	sdsmock "github.com/ipfs/kubo/sds/mock"
 	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
 	ppns "github.com/stratosnet/sds/pp/namespace"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+const testWalletKey = "0xf4a2b939592564feb35ab10a8e04f6f2fe0943579fb3c9c33505298978b74893"
+
+// walletAddress returns the address of a wallet whose key is known
+func walletAddress(t *testing.T, w *sds.SdsWallet) string {
+	address, err := w.GetAddress(context.Background())
+	require.NoError(t, err)
+	return address
+}
+
+func newTestRpc(t *testing.T) (*sdsmock.PP, *sds.Rpc, *sds.SdsWallet) {
+	pp := sdsmock.NewPP()
+	t.Cleanup(pp.Close)
+
+	wallet, err := sds.NewSdsWallet(testWalletKey)
+	require.NoError(t, err)
+	rpc, err := sds.NewRpc(&config.Sds{RpcURLs: []string{pp.URL()}})
+	require.NoError(t, err)
+	t.Cleanup(rpc.Close)
+	return pp, rpc, wallet
+}
+
 func TestRPC_Upload(t *testing.T) {
-	utils.NewDefaultLogger("/", true, false)
-	wallet, err := NewSdsWallet("0xf4a2b939592564feb35ab10a8e04f6f2fe0943579fb3c9c33505298978b74893")
-	assert.Equal(t, err, nil)
-	rpc, err := NewRpc("http://0.0.0.0:18281")
-	assert.Equal(t, err, nil)
-	addr := wallet.GetAddress()
-	fmt.Println("addr", addr)
-	oz, err := rpc.GetOzone(wallet)
-	assert.Equal(t, err, nil)
-
-	fmt.Println("ozone", oz.Ozone)
-	fmt.Println("seq", oz.SequenceNumber)
-
-	fileName, err := randomFileName(16, "txt")
-	assert.Equal(t, err, nil)
+	pp, rpc, wallet := newTestRpc(t)
+	ctx := context.Background()
 
-	fileData := make([]byte, ppns.FILE_DATA_SAFE_SIZE+1)
-	fileHash := CreateFileHash(fileData)
-	fmt.Println("file hash", fileHash)
+	oz, err := rpc.GetOzone(ctx, wallet)
+	require.NoError(t, err)
+	assert.Equal(t, sdsmock.DefaultOzone, oz.Ozone)
 
+	fileData := make([]byte, ppns.FILE_DATA_SAFE_SIZE+1)
 	_, err = rand.Read(fileData)
-	assert.Equal(t, err, nil)
-
-	res, err := rpc.RequestUpload(wallet, oz.SequenceNumber, fileName, fileHash, len(fileData))
-	fmt.Println("-> request upload", res)
-	fmt.Println("res", res)
-	fmt.Println("res err", err)
-	assert.Equal(t, err, nil)
-	assert.Equal(t, res.Return, "1")
-	fmt.Println("offset start", *res.OffsetStart)
-	fmt.Println("offset end", *res.OffsetEnd)
-	fmt.Println("total file length", len(fileData))
+	require.NoError(t, err)
+	fileHash := sds.CreateFileHash(fileData)
+
+	res, err := rpc.RequestUpload(ctx, wallet, oz.SequenceNumber, fileHash, len(fileData), sds.UploadMeta{Name: "test.txt"})
+	require.NoError(t, err)
+	require.Equal(t, rpc_api.UPLOAD_DATA, res.Return)
 
+	chunks := 0
 	for res.Return == rpc_api.UPLOAD_DATA {
-		chunkData := make([]byte, *res.OffsetEnd-*res.OffsetStart)
-		copy(chunkData, fileData[*res.OffsetStart:*res.OffsetEnd])
-		fmt.Println("chunk file length", len(chunkData))
-		assert.Equal(t, err, nil)
+		chunkData := fileData[*res.OffsetStart:*res.OffsetEnd]
 		fileChunk := base64.StdEncoding.EncodeToString(chunkData)
-		assert.Equal(t, err, nil)
 
-		res, err = rpc.UploadData(wallet, oz.SequenceNumber, fileHash, fileChunk)
-		fmt.Println("-> upload data", res)
-		fmt.Println("res", res)
-		fmt.Println("res err", err)
-		assert.Equal(t, err, nil)
+		res, err = rpc.UploadData(ctx, wallet, oz.SequenceNumber, fileHash, fileChunk)
+		require.NoError(t, err)
+		chunks++
 	}
-	assert.Equal(t, res.Return, rpc_api.SUCCESS)
+	assert.Equal(t, rpc_api.SUCCESS, res.Return)
+	assert.Equal(t, 2, chunks)
+
+	stored, ok := pp.File(fileHash)
+	require.True(t, ok)
+	assert.Equal(t, fileData, stored)
+
+	// the sequence number is consumed by the upload
+	_, err = rpc.RequestUpload(ctx, wallet, oz.SequenceNumber, fileHash, len(fileData), sds.UploadMeta{Name: "test.txt"})
+	assertReturn(t, rpc_api.WRONG_INPUT, err)
+}
+
+// assertReturn checks err is the failure reported in the Return of a result
+func assertReturn(t *testing.T, ret string, err error) {
+	t.Helper()
+	var rpcErr *sds.RPCError
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, ret, rpcErr.Return)
 }
 
 func TestRPC_Download(t *testing.T) {
-	utils.NewDefaultLogger("/", true, false)
-	wallet, err := NewSdsWallet("0xf4a2b939592564feb35ab10a8e04f6f2fe0943579fb3c9c33505298978b74893")
-	assert.Equal(t, err, nil)
-	rpc, err := NewRpc("http://0.0.0.0:18281")
-	assert.Equal(t, err, nil)
-	addr := wallet.GetAddress()
-	fmt.Println("addr", addr)
-	oz, err := rpc.GetOzone(wallet)
-	assert.Equal(t, err, nil)
-
-	fmt.Println("ozone", oz.Ozone)
-	fmt.Println("seq", oz.SequenceNumber)
-
-	fileHash := "v05j1m517ljekhi1c4ce82pb62c5p1vdjvrbph2g"
-	// fileHash := "v05j1m556nt0igqi8f76625t9sn4e13vpgr0mi0o"
-
-	res, err := rpc.RequestDownload(wallet, oz.SequenceNumber, fileHash)
-	fmt.Println("-> request download", res)
-	fmt.Printf("res: %+v\n", res)
-	fmt.Println("res err", err)
-	assert.Equal(t, err, nil)
-
-	var (
-		fileSize uint64 = 0
-		fileData        = make([]byte, 0)
-	)
-
-	// Handle result:1 sending the content
+	pp, rpc, wallet := newTestRpc(t)
+	ctx := context.Background()
+	pp.SetChunkSize(100)
+
+	fileData := make([]byte, 250)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+	fileHash := pp.AddFile(walletAddress(t, wallet), fileData)
+
+	oz, err := rpc.GetOzone(ctx, wallet)
+	require.NoError(t, err)
+
+	res, err := rpc.RequestDownload(ctx, wallet, oz.SequenceNumber, walletAddress(t, wallet), fileHash)
+	require.NoError(t, err)
+
+	var downloaded []byte
 	for res.Return == rpc_api.DOWNLOAD_OK || res.Return == rpc_api.DL_OK_ASK_INFO {
 		if res.Return == rpc_api.DL_OK_ASK_INFO {
-			fmt.Println("- received response (return: DL_OK_ASK_INFO)")
-			res, err = rpc.DownloadedFileInfo(wallet, res.ReqId, fileHash, fileSize)
-			fmt.Println("- request file information verification (method: user_downloadedFileInfo)")
-			fmt.Println("-> download file info", res)
-			fmt.Println("res", res)
-			fmt.Println("res err", err)
-			assert.Equal(t, err, nil)
+			res, err = rpc.DownloadedFileInfo(ctx, wallet, res.ReqId, fileHash, uint64(len(downloaded)))
 		} else {
-			fmt.Println("- received response (return: DOWNLOAD_OK)")
-			start := *res.OffsetStart
-			end := *res.OffsetEnd
-			fileSize = fileSize + (end - start)
-			decoded, _ := base64.StdEncoding.DecodeString(res.FileData)
-			fileData = append(fileData, decoded...)
-			fmt.Println("- fileData", fileData)
-			fmt.Println("- fileSize", fileSize)
-			fmt.Println("- fileHash", fileHash)
-			fmt.Println("- req id", res.ReqId)
-			res, err = rpc.DownloadData(wallet, res.ReqId, fileHash)
-			fmt.Println("- request downloading file data (method: user_downloadData)")
-			fmt.Println("-> download data", res)
-			fmt.Println("res", res)
-			fmt.Println("res err", err)
-			assert.Equal(t, err, nil)
+			assert.Equal(t, uint64(len(downloaded)), *res.OffsetStart)
+			decoded, decErr := base64.StdEncoding.DecodeString(res.FileData)
+			require.NoError(t, decErr)
+			downloaded = append(downloaded, decoded...)
+			res, err = rpc.DownloadData(ctx, wallet, res.ReqId, fileHash)
 		}
+		require.NoError(t, err)
 	}
-	assert.Equal(t, res.Return, rpc_api.SUCCESS)
+	assert.Equal(t, rpc_api.SUCCESS, res.Return)
+	assert.Equal(t, fileData, downloaded)
+}
+
+func TestRPC_SignatureChecked(t *testing.T) {
+	pp, rpc, wallet := newTestRpc(t)
+	ctx := context.Background()
+	fileHash := pp.AddFile(walletAddress(t, wallet), []byte("hello sds"))
+
+	// a wrong sequence number breaks the signed message
+	_, err := rpc.RequestDownload(ctx, wallet, "42", walletAddress(t, wallet), fileHash)
+	assertReturn(t, rpc_api.SIGNATURE_FAILURE, err)
+
+	// files could only be shared by their owner
+	other, err := sds.GenerateSdsWallet()
+	require.NoError(t, err)
+	_, err = rpc.RequestShare(ctx, other, fileHash, nil, 0, false)
+	assertReturn(t, rpc_api.FILE_REQ_FAILURE, err)
+}
+
+func TestRPC_Errors(t *testing.T) {
+	pp, rpc, wallet := newTestRpc(t)
+	ctx := context.Background()
+	fileData := []byte("hello sds")
+	fileHash := pp.AddFile(walletAddress(t, wallet), fileData)
+
+	oz, err := rpc.GetOzone(ctx, wallet)
+	require.NoError(t, err)
+	_, err = rpc.RequestUpload(ctx, wallet, oz.SequenceNumber, fileHash, len(fileData), sds.UploadMeta{Name: "test.txt"})
+	assert.ErrorIs(t, err, sds.ErrAlreadyExists)
+
+	_, err = rpc.GetShared(ctx, wallet, oz.SequenceNumber, "sds://"+testCid)
+	assert.ErrorIs(t, err, sds.ErrShareNotFound)
+
+	pp.SetOzone("0")
+	otherData := []byte("another file")
+	_, err = rpc.RequestUpload(ctx, wallet, oz.SequenceNumber, sds.CreateFileHash(otherData), len(otherData), sds.UploadMeta{Name: "test.txt"})
+	// the pp gives no reason, the fetcher tells it from the balance
+	var refused *sds.RPCError
+	require.ErrorAs(t, err, &refused)
+	assert.Equal(t, sdsmock.InsufficientOzoneReturn, refused.Return)
+
+	pp.SetDown(true)
+	_, err = rpc.GetOzone(ctx, wallet)
+	assert.ErrorIs(t, err, sds.ErrPPUnavailable)
+	var rpcErr *sds.RPCError
+	assert.False(t, errors.As(err, &rpcErr))
 }
@@ -0,0 +1,229 @@
+package sds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	fwtypes "github.com/stratosnet/sds/framework/types"
+	rpc_api "github.com/stratosnet/sds/pp/api/rpc"
+)
+
+// sharesPrefix is the datastore namespace of the share links created by the
+// node
+var sharesPrefix = datastore.NewKey("/sds/shares")
+
+// Share is a share link created by the node. The shares are kept in the repo
+// once revoked or expired, to audit what was exposed.
+type Share struct {
+	ShareId string
+	// ShareLink is the sds:// link of the share, without its password
+	ShareLink string
+	// Password of a private share, empty when the pp did not return it
+	Password string `json:",omitempty"`
+	Private  bool
+	FileHash string
+	// Cid is the root of the shared DAG, empty when the file is not a DAG
+	Cid     string `json:",omitempty"`
+	Created time.Time
+	// Expires is zero for a share which does not expire
+	Expires time.Time
+	// Revoked is when the share was stopped, zero while it is active
+	Revoked time.Time
+}
+
+// SharedFile is a share link of the wallet, as listed by the pp
+type SharedFile struct {
+	ShareId   string
+	ShareLink string
+	FileHash  string
+	FileName  string
+	FileSize  uint64
+	Created   time.Time
+	// Expires is zero for a share which does not expire
+	Expires time.Time
+}
+
+// ShareOptions are the options of a share link
+type ShareOptions struct {
+	// Duration of the share, zero for the default duration of the sp
+	Duration time.Duration
+	// Private shares are protected by a password generated by the sp
+	Private bool
+}
+
+// FormatShareLink returns the sds:// link of a share, followed by its
+// password when it has one, the form accepted by the pp
+func FormatShareLink(link, password string) string {
+	s := fwtypes.ShareDataMeshId{Link: link}.String()
+	if password != "" {
+		s += "/" + password
+	}
+	return s
+}
+
+// redactShareLink returns the share link without its password, to be traced
+// and logged
+func redactShareLink(shareLink string) string {
+	if !strings.HasPrefix(shareLink, fwtypes.SHARED_DATA_MESH_PROTOCOL) {
+		return shareLink
+	}
+	parsed, err := fwtypes.ParseShareLink(shareLink)
+	if err != nil {
+		return shareLink
+	}
+	return parsed.String()
+}
+
+// Shares persists the share links created by the node in the repo datastore
+type Shares struct {
+	ds datastore.Datastore
+
+	mu sync.Mutex
+}
+
+func NewShares(ds datastore.Datastore) *Shares {
+	return &Shares{ds: ds}
+}
+
+func shareKey(shareId string) datastore.Key {
+	return sharesPrefix.ChildString(shareId)
+}
+
+// Get returns the share with the id, nil when the node did not create it
+func (s *Shares) Get(ctx context.Context, shareId string) (*Share, error) {
+	data, err := s.ds.Get(ctx, shareKey(shareId))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var share Share
+	if err := json.Unmarshal(data, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// Find returns the share of the share link, nil when the node did not
+// create it
+func (s *Shares) Find(ctx context.Context, shareLink string) (*Share, error) {
+	parsed, err := fwtypes.ParseShareLink(shareLink)
+	if err != nil {
+		return nil, nil
+	}
+	link := fwtypes.ShareDataMeshId{Link: parsed.Link}.String()
+
+	shares, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		if share.ShareLink == link {
+			return share, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Shares) put(ctx context.Context, share *Share) error {
+	data, err := json.Marshal(share)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(ctx, shareKey(share.ShareId), data)
+}
+
+// List returns the shares created by the node, most recent first
+func (s *Shares) List(ctx context.Context) ([]*Share, error) {
+	results, err := s.ds.Query(ctx, query.Query{Prefix: sharesPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var shares []*Share
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var share Share
+		if err := json.Unmarshal(r.Value, &share); err != nil {
+			return nil, err
+		}
+		shares = append(shares, &share)
+	}
+	sort.SliceStable(shares, func(i, j int) bool {
+		return shares[i].Created.After(shares[j].Created)
+	})
+	return shares, nil
+}
+
+// add records a share created by the node. The password of a private share
+// is only known when the pp returned it within the link.
+func (s *Shares) add(ctx context.Context, shareId, shareLink, fileHash, cid string, opts ShareOptions) (*Share, error) {
+	share := &Share{
+		ShareId:   shareId,
+		ShareLink: shareLink,
+		Private:   opts.Private,
+		FileHash:  fileHash,
+		Cid:       cid,
+		Created:   time.Now().UTC(),
+	}
+	if strings.HasPrefix(shareLink, fwtypes.SHARED_DATA_MESH_PROTOCOL) {
+		if parsed, err := fwtypes.ParseShareLink(shareLink); err == nil {
+			share.ShareLink = fwtypes.ShareDataMeshId{Link: parsed.Link}.String()
+			share.Password = parsed.Password
+		}
+	}
+	if opts.Duration > 0 {
+		share.Expires = share.Created.Add(opts.Duration)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return share, s.put(ctx, share)
+}
+
+// revoke records that the share was stopped, the shares created by another
+// node are not recorded
+func (s *Shares) revoke(ctx context.Context, shareId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	share, err := s.Get(ctx, shareId)
+	if err != nil || share == nil {
+		return err
+	}
+	share.Revoked = time.Now().UTC()
+	return s.put(ctx, share)
+}
+
+// sharedFile converts a share listed by the pp
+func sharedFile(info rpc_api.FileInfo) *SharedFile {
+	f := &SharedFile{
+		ShareId:   info.ShareId,
+		ShareLink: info.ShareLink,
+		FileHash:  info.FileHash,
+		FileName:  info.FileName,
+		FileSize:  info.FileSize,
+	}
+	if !strings.HasPrefix(f.ShareLink, fwtypes.SHARED_DATA_MESH_PROTOCOL) {
+		f.ShareLink = fwtypes.ShareDataMeshId{Link: f.ShareLink}.String()
+	}
+	if info.LinkTime > 0 {
+		f.Created = time.Unix(info.LinkTime, 0).UTC()
+	}
+	if info.LinkTimeExp > 0 {
+		f.Expires = time.Unix(info.LinkTimeExp, 0).UTC()
+	}
+	return f
+}
@@ -0,0 +1,230 @@
+package sds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/boxo/keystore"
+	"github.com/ipfs/kubo/config"
+	fwcryptotypes "github.com/stratosnet/sds/framework/crypto/types"
+	fwtypes "github.com/stratosnet/sds/framework/types"
+)
+
+// Signer signs the sds requests of a wallet. The key may be kept outside of
+// the daemon, which then only knows the public key of the wallet.
+type Signer interface {
+	// PubKey returns the public key of the wallet, it is asked on first use
+	// so a signer which is not reachable does not stop the node
+	PubKey(ctx context.Context) (fwcryptotypes.PubKey, error)
+	// Sign returns the signature of msg by the wallet key
+	Sign(ctx context.Context, msg []byte) ([]byte, error)
+}
+
+// SignerConstructor creates a signer from the Sds.Signer config
+type SignerConstructor func(cfg *config.SdsSigner) (Signer, error)
+
+var (
+	signersMu sync.Mutex
+	signers   = map[string]SignerConstructor{}
+)
+
+// RegisterSigner adds a type of signer, selected with Sds.Signer.Type. It is
+// called by the sds signer plugins.
+func RegisterSigner(name string, ctor SignerConstructor) error {
+	if name == config.SdsSignerKeystore || name == config.SdsSignerRemote {
+		return fmt.Errorf("sds signer type %q is builtin", name)
+	}
+
+	signersMu.Lock()
+	defer signersMu.Unlock()
+	if _, ok := signers[name]; ok {
+		return fmt.Errorf("already have an sds signer named %q", name)
+	}
+	signers[name] = ctor
+	return nil
+}
+
+// newSigner returns the signer selected by Sds.Signer.Type
+func newSigner(cfg *config.Sds, ks keystore.Keystore) (Signer, error) {
+	switch typ := cfg.Signer.Type.WithDefault(config.DefaultSdsSignerType); typ {
+	case config.SdsSignerKeystore:
+		return loadKeySigner(cfg, ks)
+	case config.SdsSignerRemote:
+		return NewRemoteSigner(&cfg.Signer)
+	default:
+		signersMu.Lock()
+		ctor, ok := signers[typ]
+		signersMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown sds signer type %q, is its plugin loaded?", typ)
+		}
+		return ctor(&cfg.Signer)
+	}
+}
+
+// keySigner signs with a wallet key held by the daemon
+type keySigner struct {
+	key fwcryptotypes.PrivKey
+}
+
+func (s *keySigner) PubKey(context.Context) (fwcryptotypes.PubKey, error) {
+	return s.key.PubKey(), nil
+}
+
+func (s *keySigner) Sign(_ context.Context, msg []byte) ([]byte, error) {
+	return s.key.Sign(msg)
+}
+
+// SignerPubKeyResponse is the answer of a remote signer to GET <url>/pubkey
+type SignerPubKeyResponse struct {
+	// PubKey is the bech32 public key of the wallet
+	PubKey string
+}
+
+// SignerSignRequest is the request POSTed to <url>/sign
+type SignerSignRequest struct {
+	// Address of the wallet expected to sign the message
+	Address string
+	Message []byte
+}
+
+// SignerSignResponse is the answer of a remote signer to a SignerSignRequest
+type SignerSignResponse struct {
+	Signature []byte
+}
+
+// RemoteSigner asks a signing service for the signatures, over http or a
+// unix socket. The service answers GET <url>/pubkey with a
+// SignerPubKeyResponse and POST <url>/sign with a SignerSignResponse, other
+// statuses are errors described by the body of the response.
+//
+// The public key is requested on first use and kept once answered, then
+// every signature is checked against it.
+type RemoteSigner struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	mu     sync.Mutex
+	pubKey fwcryptotypes.PubKey
+}
+
+// NewRemoteSigner returns the remote signer of the config, which is only
+// contacted on first use
+func NewRemoteSigner(cfg *config.SdsSigner) (*RemoteSigner, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("Sds.Signer.URL must be set for the remote signer")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sds.Signer.URL: %w", err)
+	}
+
+	s := &RemoteSigner{
+		url:     strings.TrimSuffix(cfg.URL, "/"),
+		headers: cfg.Headers,
+		client: &http.Client{
+			Timeout: cfg.Timeout.WithDefault(config.DefaultSdsSignerTimeout),
+		},
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid Sds.Signer.URL %q: no socket path", cfg.URL)
+		}
+		var d net.Dialer
+		s.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", u.Path)
+			},
+		}
+		s.url = "http://unix"
+	default:
+		return nil, fmt.Errorf("invalid Sds.Signer.URL %q: unsupported scheme %q", cfg.URL, u.Scheme)
+	}
+	return s, nil
+}
+
+// PubKey asks the public key of the wallet to the signer until it answers
+func (s *RemoteSigner) PubKey(ctx context.Context) (fwcryptotypes.PubKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pubKey != nil {
+		return s.pubKey, nil
+	}
+
+	var res SignerPubKeyResponse
+	if err := s.call(ctx, http.MethodGet, "/pubkey", nil, &res); err != nil {
+		return nil, err
+	}
+	pubKey, err := fwtypes.WalletPubKeyFromBech32(res.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer answered an invalid public key: %w", err)
+	}
+	s.pubKey = pubKey
+	return pubKey, nil
+}
+
+func (s *RemoteSigner) Sign(ctx context.Context, msg []byte) ([]byte, error) {
+	pubKey, err := s.PubKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := &SignerSignRequest{
+		Address: fwtypes.WalletAddress(pubKey.Address()).String(),
+		Message: msg,
+	}
+	var res SignerSignResponse
+	if err := s.call(ctx, http.MethodPost, "/sign", req, &res); err != nil {
+		return nil, err
+	}
+	if !pubKey.VerifySignature(msg, res.Signature) {
+		return nil, errors.New("remote signer answered an invalid signature")
+	}
+	return res.Signature, nil
+}
+
+func (s *RemoteSigner) call(ctx context.Context, method, path string, req, res any) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, s.url+path, body)
+	if err != nil {
+		return err
+	}
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range s.headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	httpRes, err := s.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("remote signer: %w", err)
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpRes.Body, 1024))
+		return fmt.Errorf("remote signer: %s: %s", httpRes.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(httpRes.Body).Decode(res); err != nil {
+		return fmt.Errorf("remote signer: invalid response: %w", err)
+	}
+	return nil
+}
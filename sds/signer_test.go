@@ -0,0 +1,192 @@
+package sds_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/keystore"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/sds"
+	sdsmock "github.com/ipfs/kubo/sds/mock"
+	fwsecp256k1 "github.com/stratosnet/sds/framework/crypto/secp256k1"
+	fwcryptotypes "github.com/stratosnet/sds/framework/crypto/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadDownload checks the fetcher signs the requests accepted by the pp
+func uploadDownload(t *testing.T, f *sds.Fetcher) {
+	ctx := context.Background()
+	fileData := []byte("hello signer")
+
+	fileHash, err := f.Upload(ctx, bytes.NewReader(fileData), int64(len(fileData)), sds.UploadMeta{})
+	require.NoError(t, err)
+	file, err := f.Download(ctx, fileHash)
+	require.NoError(t, err)
+	downloaded, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, fileData, downloaded)
+}
+
+func TestRemoteSigner(t *testing.T) {
+	wallet, err := sds.NewSdsWallet(testWalletKey)
+	require.NoError(t, err)
+
+	httpSigner, err := sdsmock.NewSigner(testWalletKey)
+	require.NoError(t, err)
+	t.Cleanup(httpSigner.Close)
+	unixSigner, err := sdsmock.NewUnixSigner(t.TempDir(), testWalletKey)
+	require.NoError(t, err)
+	t.Cleanup(unixSigner.Close)
+
+	for name, signer := range map[string]*sdsmock.Signer{"http": httpSigner, "unix": unixSigner} {
+		t.Run(name, func(t *testing.T) {
+			pp := sdsmock.NewPP()
+			defer pp.Close()
+			f := newTestFetcherConfig(t, &config.Sds{
+				RpcURLs: []string{pp.URL()},
+				Signer: config.SdsSigner{
+					Type:    config.NewOptionalString(config.SdsSignerRemote),
+					URL:     signer.URL(),
+					Address: walletAddress(t, wallet),
+				},
+			})
+			address, err := f.WalletAddress(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, walletAddress(t, wallet), address)
+
+			before := signer.Signed()
+			uploadDownload(t, f)
+			assert.Greater(t, signer.Signed(), before)
+		})
+	}
+
+	t.Run("rejects another wallet", func(t *testing.T) {
+		other, err := sds.NewSdsWallet(otherWalletKey)
+		require.NoError(t, err)
+		w, err := sds.LoadWallet(&config.Sds{
+			Signer: config.SdsSigner{
+				Type:    config.NewOptionalString(config.SdsSignerRemote),
+				URL:     httpSigner.URL(),
+				Address: walletAddress(t, other),
+			},
+		}, nil)
+		require.NoError(t, err)
+		_, err = w.SignLink(context.Background(), []byte("hello signer"))
+		assert.ErrorContains(t, err, "instead of Sds.Signer.Address")
+	})
+
+	t.Run("sends the headers", func(t *testing.T) {
+		signer, err := sdsmock.NewSigner(testWalletKey)
+		require.NoError(t, err)
+		defer signer.Close()
+		signer.RequireAuthorization("Bearer secret")
+
+		cfg := &config.Sds{
+			Signer: config.SdsSigner{
+				Type: config.NewOptionalString(config.SdsSignerRemote),
+				URL:  signer.URL(),
+			},
+		}
+		w, err := sds.LoadWallet(cfg, nil)
+		require.NoError(t, err)
+		_, err = w.GetAddress(context.Background())
+		assert.ErrorContains(t, err, "401")
+
+		cfg.Signer.Headers = map[string]string{"Authorization": "Bearer secret"}
+		w, err = sds.LoadWallet(cfg, nil)
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress(t, wallet), walletAddress(t, w))
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		// the signer is only asked for the public key on first use
+		pp := sdsmock.NewPP()
+		defer pp.Close()
+		f := newTestFetcherConfig(t, &config.Sds{
+			RpcURLs: []string{pp.URL()},
+			Signer: config.SdsSigner{
+				Type: config.NewOptionalString(config.SdsSignerRemote),
+				URL:  "unix://" + t.TempDir() + "/missing.sock",
+			},
+		})
+		_, err := f.WalletAddress(context.Background())
+		assert.ErrorContains(t, err, "remote signer")
+	})
+
+	t.Run("asks the public key once", func(t *testing.T) {
+		signer, err := sdsmock.NewSigner(testWalletKey)
+		require.NoError(t, err)
+		defer signer.Close()
+		w, err := sds.LoadWallet(&config.Sds{
+			Signer: config.SdsSigner{
+				Type: config.NewOptionalString(config.SdsSignerRemote),
+				URL:  signer.URL(),
+			},
+		}, nil)
+		require.NoError(t, err)
+		assert.Zero(t, signer.PubKeyRequests())
+
+		assert.Equal(t, walletAddress(t, wallet), walletAddress(t, w))
+		_, err = w.SignLink(context.Background(), []byte("hello signer"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, signer.PubKeyRequests())
+	})
+}
+
+// pluginSigner is a signer provided by a plugin
+type pluginSigner struct {
+	key fwcryptotypes.PrivKey
+}
+
+func (s *pluginSigner) PubKey(context.Context) (fwcryptotypes.PubKey, error) {
+	return s.key.PubKey(), nil
+}
+
+func (s *pluginSigner) Sign(_ context.Context, msg []byte) ([]byte, error) {
+	return s.key.Sign(msg)
+}
+
+func TestRegisterSigner(t *testing.T) {
+	var got *config.SdsSigner
+	err := sds.RegisterSigner("test-plugin", func(cfg *config.SdsSigner) (sds.Signer, error) {
+		got = cfg
+		raw, err := hex.DecodeString(strings.TrimPrefix(testWalletKey, "0x"))
+		if err != nil {
+			return nil, err
+		}
+		return &pluginSigner{key: fwsecp256k1.Generate(raw)}, nil
+	})
+	require.NoError(t, err)
+
+	assert.Error(t, sds.RegisterSigner("test-plugin", nil))
+	assert.Error(t, sds.RegisterSigner(config.SdsSignerRemote, nil))
+
+	pp := sdsmock.NewPP()
+	defer pp.Close()
+	cfg := &config.Sds{
+		Enabled:     true,
+		CacheFolder: t.TempDir(),
+		RpcURLs:     []string{pp.URL()},
+		Signer: config.SdsSigner{
+			Type:   config.NewOptionalString("test-plugin"),
+			Config: map[string]interface{}{"slot": 1.0},
+		},
+	}
+	f, err := sds.NewFetcher(cfg, "", dssync.MutexWrap(datastore.NewMapDatastore()), keystore.NewMemKeystore())
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+	assert.Equal(t, 1.0, got.Config["slot"])
+	uploadDownload(t, f)
+
+	cfg.Signer.Type = config.NewOptionalString("missing-plugin")
+	_, err = sds.LoadWallet(cfg, nil)
+	assert.ErrorContains(t, err, "unknown sds signer type")
+}
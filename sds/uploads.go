@@ -0,0 +1,165 @@
+package sds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// uploadsPrefix is the datastore namespace of the upload sessions
+var uploadsPrefix = datastore.NewKey("/sds/uploads")
+
+type UploadStatus string
+
+const (
+	// UploadInProgress is a session being uploaded
+	UploadInProgress UploadStatus = "uploading"
+	// UploadInterrupted is a session left in progress by a process which is
+	// gone, the daemon was stopped or died during the upload
+	UploadInterrupted UploadStatus = "interrupted"
+	// UploadFailed is a session stopped on an error
+	UploadFailed UploadStatus = "failed"
+)
+
+// UploadSession is the progress of an upload, saved after every chunk
+// acknowledged by the pp so an upload could be resumed where it stopped.
+type UploadSession struct {
+	FileHash string
+	// Cid is the root of the DAG exported in the uploaded CAR, undefined
+	// when the file is not a DAG
+	Cid string `json:",omitempty"`
+	// Name and ContentType of the uploaded file, kept so a resumed upload
+	// is requested with them again
+	Name        string `json:",omitempty"`
+	ContentType string `json:",omitempty"`
+	Size        int64
+	// SequenceNumber of the last upload request
+	SequenceNumber string
+	// PP is the url of the pp node which accepted the upload, the upload is
+	// resumed there
+	PP string `json:",omitempty"`
+	// OffsetStart and OffsetEnd are the last range acknowledged by the pp
+	OffsetStart uint64
+	OffsetEnd   uint64
+	Status      UploadStatus
+	Error       string `json:",omitempty"`
+	Started     time.Time
+	Updated     time.Time
+}
+
+// maxFileNameLength is the length the sds file names are cut to
+const maxFileNameLength = 255
+
+// UploadMeta describes an uploaded file, it is sent to the pp along with the
+// upload request so the files are meaningful in the sds listings
+type UploadMeta struct {
+	// Name of the file, the name of the added file or directory
+	Name string
+	// ContentType of the file, or of the content of the DAG
+	ContentType string
+	// Cid is the root of the DAG exported in the file, undefined when the
+	// file is not a DAG
+	Cid cid.Cid
+}
+
+// fileName returns the name the file is stored under in sds: the base of the
+// name without its control characters, or the root of the DAG when the file
+// has no name
+func (m UploadMeta) fileName() (string, error) {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' || r == '\\' {
+			return -1
+		}
+		return r
+	}, path.Base(strings.ReplaceAll(m.Name, "\\", "/")))
+	name = strings.TrimSpace(name)
+	if name == "." || name == "/" {
+		name = ""
+	}
+	for len(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	switch {
+	case name != "":
+		return name, nil
+	case m.Cid.Defined():
+		return m.Cid.String() + ".car", nil
+	default:
+		return randomFileName(16, "bin")
+	}
+}
+
+// UploadStore persists the upload sessions in the repo datastore
+type UploadStore struct {
+	ds datastore.Datastore
+}
+
+func NewUploadStore(ds datastore.Datastore) *UploadStore {
+	return &UploadStore{ds: ds}
+}
+
+func uploadKey(fileHash string) datastore.Key {
+	return uploadsPrefix.ChildString(fileHash)
+}
+
+// Get returns the session of the file, or nil when there is none
+func (s *UploadStore) Get(ctx context.Context, fileHash string) (*UploadSession, error) {
+	data, err := s.ds.Get(ctx, uploadKey(fileHash))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *UploadStore) Put(ctx context.Context, session *UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(ctx, uploadKey(session.FileHash), data)
+}
+
+func (s *UploadStore) Delete(ctx context.Context, fileHash string) error {
+	return s.ds.Delete(ctx, uploadKey(fileHash))
+}
+
+// List returns all the saved sessions
+func (s *UploadStore) List(ctx context.Context) ([]*UploadSession, error) {
+	results, err := s.ds.Query(ctx, query.Query{Prefix: uploadsPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var sessions []*UploadSession
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var session UploadSession
+		if err := json.Unmarshal(r.Value, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,43 @@
+package sds
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadMetaFileName(t *testing.T) {
+	c, err := cid.Decode("QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name, expected string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"dir/sub/photo.jpg", "photo.jpg"},
+		{`C:\photos\photo.jpg`, "photo.jpg"},
+		{" new\nline.txt ", "newline.txt"},
+		{"site/", "site"},
+		{"", c.String() + ".car"},
+		{"/", c.String() + ".car"},
+		{".", c.String() + ".car"},
+	} {
+		name, err := UploadMeta{Name: tc.name, Cid: c}.fileName()
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, name, tc.name)
+	}
+
+	// the names are cut on a rune boundary
+	name, err := UploadMeta{Name: strings.Repeat("é", maxFileNameLength)}.fileName()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(name), maxFileNameLength)
+	assert.Equal(t, strings.Repeat("é", maxFileNameLength/2), name)
+
+	// a file which is neither named nor a DAG still gets a name
+	name, err = UploadMeta{}.fileName()
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(name, ".bin"), name)
+}
@@ -30,12 +30,71 @@ func randomFileName(size int, ext string) (string, error) {
 }
 
 func CreateFileHash(fileData []byte) string {
-	sliceKeccak256, _ := mh.SumStream(bytes.NewReader(fileData), mh.KECCAK_256, 20)
+	fileHash, _ := CreateFileHashFromReader(bytes.NewReader(fileData))
+	return fileHash
+}
+
+// CreateFileHashFromReader computes the sds file hash of everything read from r
+// in a single streaming pass, so the content never has to be held in memory.
+func CreateFileHashFromReader(r io.Reader) (string, error) {
+	sliceKeccak256, err := mh.SumStream(r, mh.KECCAK_256, 20)
+	if err != nil {
+		return "", err
+	}
 	data := append([]byte(""), sliceKeccak256...)
-	kHash, _ := mh.Sum(data, mh.KECCAK_256, 20)
+	kHash, err := mh.Sum(data, mh.KECCAK_256, 20)
+	if err != nil {
+		return "", err
+	}
 	fileCid := cid.NewCidV1(uint64(crypto.SDS_CODEC), kHash)
-	encoder, _ := mbase.NewEncoder(mbase.Base32hex)
-	return fileCid.Encode(encoder)
+	encoder, err := mbase.NewEncoder(mbase.Base32hex)
+	if err != nil {
+		return "", err
+	}
+	return fileCid.Encode(encoder), nil
+}
+
+// TempFile is a files.File backed by a temporary file on disk. The file is
+// removed as soon as it is closed.
+type TempFile struct {
+	*os.File
+	size int64
+}
+
+var _ files.File = (*TempFile)(nil)
+var _ io.ReaderAt = (*TempFile)(nil)
+
+// SpoolFile copies r into a temporary file of dir, so the data could be read
+// at arbitrary offsets later without keeping it in memory.
+func SpoolFile(dir string, r io.Reader) (*TempFile, error) {
+	f, err := os.CreateTemp(dir, "sds-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	tf := &TempFile{File: f}
+
+	tf.size, err = io.Copy(f, r)
+	if err != nil {
+		tf.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		tf.Close()
+		return nil, err
+	}
+	return tf, nil
+}
+
+func (tf *TempFile) Size() (int64, error) {
+	return tf.size, nil
+}
+
+func (tf *TempFile) Close() error {
+	err := tf.File.Close()
+	if rmErr := os.Remove(tf.Name()); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return err
 }
 
 func getDynamicField(i any, key string) any {
@@ -68,55 +127,6 @@ func setDynamicField(i any, key string, value any) error {
 	return nil
 }
 
-// readFile reads the contents of the file from the provided file path.
-// If the file exists and has content, it returns the data; otherwise, it returns an error.
-func readFile(filePath string) ([]byte, error) {
-	// Open the file for reading
-	ff, err := os.OpenFile(filePath, os.O_RDONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return nil, err
-	}
-	defer ff.Close()
-
-	// Get file info
-	fInfo, err := ff.Stat()
-	if err != nil {
-		return nil, err
-	}
-
-	// If the file is empty, return an empty slice or nil
-	if fInfo.Size() == 0 {
-		return nil, nil
-	}
-
-	// Read the file contents
-	fileData, err := io.ReadAll(ff)
-	if err != nil {
-		return nil, err
-	}
-
-	return fileData, nil
-}
-
-// writeOnly writes the provided byte data to the specified file path.
-// It creates or overwrites the file with the data.
-func writeOnly(filePath string, data []byte) error {
-	// Open the file with write-only and create flags (truncate if exists)
-	file, err := os.OpenFile(filePath, os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Write the data to the file
-	_, err = file.Write(data)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func IsCAR(f files.Node) (bool, error) {
 	file, ok := f.(files.File)
 	if !ok {
@@ -124,19 +134,34 @@ func IsCAR(f files.Node) (bool, error) {
 	}
 
 	// TODO: Optimize and use header reading to detect cbor so we do not need to read a whole file
-	_, err := gocarv2.NewBlockReader(file)
-	if err != nil {
-		return false, err
-	}
+	_, readErr := gocarv2.NewBlockReader(file)
 
-	fs := file.(io.ReadSeeker)
 	// we need to seek at initial position as reader not copied during cbor read
-	if _, err := fs.Seek(0, io.SeekStart); err != nil {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
 		return false, err
 	}
+	if readErr != nil {
+		return false, readErr
+	}
 	return true, nil
 }
 
+// CARRoot returns the first root of a CAR file, the file is seeked back to
+// its start
+func CARRoot(file files.File) (cid.Cid, error) {
+	car, readErr := gocarv2.NewBlockReader(file)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return cid.Undef, err
+	}
+	if readErr != nil {
+		return cid.Undef, readErr
+	}
+	if len(car.Roots) == 0 {
+		return cid.Undef, fmt.Errorf("car file has no roots")
+	}
+	return car.Roots[0], nil
+}
+
 // ModifySdsCARPath modifies path of root cid from dag in order to get it later from ipfs
 // Example:
 //
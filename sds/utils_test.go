@@ -0,0 +1,50 @@
+package sds
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateFileHashFromReader(t *testing.T) {
+	fileData := make([]byte, 3*1024*1024+7)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+
+	fileHash, err := CreateFileHashFromReader(bytes.NewReader(fileData))
+	require.NoError(t, err)
+	assert.Equal(t, CreateFileHash(fileData), fileHash)
+}
+
+func TestSpoolFile(t *testing.T) {
+	fileData := make([]byte, 1024)
+	_, err := rand.Read(fileData)
+	require.NoError(t, err)
+
+	tf, err := SpoolFile(t.TempDir(), bytes.NewReader(fileData))
+	require.NoError(t, err)
+
+	size, err := tf.Size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(fileData)), size)
+
+	chunk, err := readChunk(tf, size, 100, 300)
+	require.NoError(t, err)
+	assert.Equal(t, fileData[100:300], chunk)
+
+	_, err = readChunk(tf, size, 1000, 1025)
+	assert.Error(t, err)
+
+	spooled, err := io.ReadAll(tf)
+	require.NoError(t, err)
+	assert.Equal(t, fileData, spooled)
+
+	require.NoError(t, tf.Close())
+	_, err = os.Stat(tf.Name())
+	assert.True(t, os.IsNotExist(err))
+}
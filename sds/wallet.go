@@ -1,18 +1,28 @@
 package sds
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"time"
 
+	"github.com/ipfs/boxo/keystore"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	fwsecp256k1 "github.com/stratosnet/sds/framework/crypto/secp256k1"
 	fwcryptotypes "github.com/stratosnet/sds/framework/crypto/types"
 	fwtypes "github.com/stratosnet/sds/framework/types"
 	msgutils "github.com/stratosnet/sds/sds-msg/utils"
 )
 
+// SdsWallet signs the sds requests with its Signer
 type SdsWallet struct {
-	privateKey fwcryptotypes.PrivKey
+	signer Signer
+	// address is the wallet address known without asking the signer, the
+	// one of a local key or Sds.Signer.Address
+	address string
 }
 
 func GenerateSdsWallet() (*SdsWallet, error) {
@@ -20,69 +30,299 @@ func GenerateSdsWallet() (*SdsWallet, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewSdsWallet(pk.String())
+	return NewSdsWallet(hex.EncodeToString(pk.Bytes()))
 }
 
 func NewSdsWallet(privatKey string) (*SdsWallet, error) {
+	key, err := parseWalletKey(privatKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewSdsWalletFromSigner(&keySigner{key: key}), nil
+}
+
+// NewSdsWalletFromKey returns the wallet of a secp256k1 key of the keystore
+func NewSdsWalletFromKey(sk crypto.PrivKey) (*SdsWallet, error) {
+	key, err := walletKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	return NewSdsWalletFromSigner(&keySigner{key: key}), nil
+}
+
+// NewSdsWalletFromSigner returns the wallet signing with s
+func NewSdsWalletFromSigner(s Signer) *SdsWallet {
+	w := &SdsWallet{signer: s}
+	if ks, ok := s.(*keySigner); ok {
+		w.address = fwtypes.WalletAddress(ks.key.PubKey().Address()).String()
+	}
+	return w
+}
+
+// LoadWallet returns the wallet of the signer selected by Sds.Signer. The
+// wallet is rejected when its address is not Sds.Signer.Address, on load for
+// a local key and once the signer is asked for its public key otherwise.
+func LoadWallet(cfg *config.Sds, ks keystore.Keystore) (*SdsWallet, error) {
+	signer, err := newSigner(cfg, ks)
+	if err != nil {
+		return nil, err
+	}
+	w := NewSdsWalletFromSigner(signer)
+	if cfg.Signer.Address != "" {
+		if w.address != "" && w.address != cfg.Signer.Address {
+			return nil, fmt.Errorf("sds signer holds the wallet %s instead of Sds.Signer.Address %s", w.address, cfg.Signer.Address)
+		}
+		w.address = cfg.Signer.Address
+	}
+	return w, nil
+}
+
+// loadKeySigner returns the signer of the keystore key named by Sds.Wallet.
+// The deprecated Sds.PrivateKey is only used when Sds.Wallet is unset.
+func loadKeySigner(cfg *config.Sds, ks keystore.Keystore) (Signer, error) {
+	name := cfg.WalletName()
+	if name == "" {
+		logger.Warn("Sds.PrivateKey is deprecated and keeps the wallet key in clear in the config, " +
+			"move it to the keystore with 'ipfs key import --format=sds-hex' and set Sds.Wallet")
+		key, err := parseWalletKey(cfg.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		return &keySigner{key: key}, nil
+	}
+
+	sk, err := loadWalletKey(ks, name, cfg.Wallet == "")
+	if err != nil {
+		return nil, err
+	}
+	key, err := walletKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	return &keySigner{key: key}, nil
+}
+
+// loadWalletKey returns the wallet key named name from the keystore. The
+// default wallet is generated on first use, so that the nodes which do not use
+// sds have no wallet key.
+func loadWalletKey(ks keystore.Keystore, name string, generate bool) (crypto.PrivKey, error) {
+	if ks == nil {
+		return nil, errors.New("no keystore to load the sds wallet from")
+	}
+	sk, err := ks.Get(name)
+	if generate && errors.Is(err, keystore.ErrNoSuchKey) {
+		sk, _, err = crypto.GenerateSecp256k1Key(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		err = ks.Put(name, sk)
+		if errors.Is(err, keystore.ErrKeyExists) {
+			// generated by another process in the meantime
+			sk, err = ks.Get(name)
+		} else if err == nil {
+			logger.Infof("generated the sds wallet %q", name)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading sds wallet %q from the keystore: %w", name, err)
+	}
+	return sk, nil
+}
+
+// parseWalletKey decodes an hex wallet key, with or without 0x
+func parseWalletKey(privatKey string) (fwcryptotypes.PrivKey, error) {
+	pkBytes, err := decodeWalletKey(privatKey)
+	if err != nil {
+		return nil, err
+	}
+	return fwsecp256k1.Generate(pkBytes), nil
+}
+
+func decodeWalletKey(privatKey string) ([]byte, error) {
 	if len(privatKey) < 2 {
 		return nil, fmt.Errorf("wrong pk length")
 	}
 	if privatKey[:2] == "0x" {
 		privatKey = privatKey[2:]
 	}
-	pkBytes, err := hex.DecodeString(privatKey)
+	return hex.DecodeString(privatKey)
+}
+
+// MigratePrivateKey moves the deprecated Sds.PrivateKey of the repo config
+// into the keystore, as an sds-secp256k1 key. The key signing the requests
+// becomes Sds.Wallet, a key unused because Sds.Wallet is set is kept in the
+// keystore too. The key is named after DefaultSdsWallet, with a suffix when
+// the name holds another key.
+func MigratePrivateKey(r repo.Repo) error {
+	cfg, err := r.Config()
+	if err != nil || cfg.Sds.PrivateKey == "" {
+		return err
+	}
+
+	raw, err := decodeWalletKey(cfg.Sds.PrivateKey)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("invalid Sds.PrivateKey: %w", err)
 	}
-	return &SdsWallet{
-		privateKey: fwsecp256k1.Generate(pkBytes),
-	}, nil
+	sk, err := crypto.UnmarshalSecp256k1PrivateKey(raw)
+	if err != nil {
+		return fmt.Errorf("invalid Sds.PrivateKey: %w", err)
+	}
+	name, err := importWalletKey(r.Keystore(), sk)
+	if err != nil {
+		return fmt.Errorf("moving Sds.PrivateKey into the keystore: %w", err)
+	}
+
+	// the config returned by the repo is shared
+	cfg, err = cfg.Clone()
+	if err != nil {
+		return err
+	}
+	cfg.Sds.PrivateKey = ""
+	if cfg.Sds.Wallet == "" {
+		cfg.Sds.Wallet = name
+	}
+	if err := r.SetConfig(cfg); err != nil {
+		return err
+	}
+	// the config set is merged into the one on disk, where the key is
+	// cleared on its own
+	if err := r.SetConfigKey(config.SdsPrivateKeySelector, ""); err != nil {
+		return err
+	}
+
+	if cfg.Sds.Wallet == name {
+		logger.Infof("moved Sds.PrivateKey into the keystore, it is the sds wallet %q", name)
+	} else {
+		logger.Infof("moved the unused Sds.PrivateKey into the keystore as the key %q", name)
+	}
+	return nil
 }
 
-func (w *SdsWallet) GetAddress() string {
-	return fwtypes.WalletAddress(w.privateKey.PubKey().Address()).String()
+// importWalletKey puts sk in the keystore under the first name after
+// DefaultSdsWallet which is free or already holds sk, and returns it
+func importWalletKey(ks keystore.Keystore, sk crypto.PrivKey) (string, error) {
+	if ks == nil {
+		return "", errors.New("no keystore to move the sds wallet into")
+	}
+	for i := 0; ; i++ {
+		name := config.DefaultSdsWallet
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d", config.DefaultSdsWallet, i)
+		}
+		existing, err := ks.Get(name)
+		switch {
+		case errors.Is(err, keystore.ErrNoSuchKey):
+			return name, ks.Put(name, sk)
+		case err != nil:
+			return "", err
+		case existing.Equals(sk):
+			return name, nil
+		}
+	}
 }
 
-func (w *SdsWallet) GetBech32PubKey() (string, error) {
-	wpk, err := fwtypes.WalletPubKeyToBech32(w.privateKey.PubKey())
+// walletKey converts a secp256k1 key of the keystore
+func walletKey(sk crypto.PrivKey) (fwcryptotypes.PrivKey, error) {
+	if _, ok := sk.(*crypto.Secp256k1PrivateKey); !ok {
+		return nil, fmt.Errorf("%s keys could not be used as sds wallets, generate one with 'ipfs key gen --type=sds-secp256k1'", sk.Type())
+	}
+	raw, err := sk.Raw()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return wpk, nil
+	return fwsecp256k1.Generate(raw), nil
 }
 
-func (w *SdsWallet) SignFileUpload(sn, fileHash string) ([]byte, error) {
-	nowSec := time.Now().Unix()
-	sign, err := w.privateKey.Sign([]byte(msgutils.GetFileUploadWalletSignMessage(fileHash, w.GetAddress(), sn, nowSec)))
+// pubKey returns the public key of the signer, checked against the address
+// known for the wallet
+func (w *SdsWallet) pubKey(ctx context.Context) (fwcryptotypes.PubKey, error) {
+	pubKey, err := w.signer.PubKey(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return sign, nil
+	if address := fwtypes.WalletAddress(pubKey.Address()).String(); w.address != "" && address != w.address {
+		return nil, fmt.Errorf("sds signer holds the wallet %s instead of Sds.Signer.Address %s", address, w.address)
+	}
+	return pubKey, nil
 }
 
-func (w *SdsWallet) SignDownloadData(sn, fileHash string) ([]byte, error) {
-	nowSec := time.Now().Unix()
-	sign, err := w.privateKey.Sign([]byte(msgutils.GetFileDownloadWalletSignMessage(fileHash, w.GetAddress(), sn, nowSec)))
+// GetAddress returns the wallet address, the signer is only asked when the
+// address is not known in advance
+func (w *SdsWallet) GetAddress(ctx context.Context) (string, error) {
+	if w.address != "" {
+		return w.address, nil
+	}
+	pubKey, err := w.pubKey(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return sign, nil
+	return fwtypes.WalletAddress(pubKey.Address()).String(), nil
 }
 
-func (w *SdsWallet) SignCreateShareLink(fileHash string) ([]byte, error) {
-	nowSec := time.Now().Unix()
-	sign, err := w.privateKey.Sign([]byte(msgutils.GetShareFileWalletSignMessage(fileHash, w.GetAddress(), nowSec)))
+func (w *SdsWallet) GetBech32PubKey(ctx context.Context) (string, error) {
+	pubKey, err := w.pubKey(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	wpk, err := fwtypes.WalletPubKeyToBech32(pubKey)
+	if err != nil {
+		return "", err
 	}
-	return sign, nil
+	return wpk, nil
 }
 
-func (w *SdsWallet) SignGetShareLink(sn, shareId string) ([]byte, error) {
-	nowSec := time.Now().Unix()
-	sign, err := w.privateKey.Sign([]byte(msgutils.GetDownloadShareFileWalletSignMessage(shareId, w.GetAddress(), sn, nowSec)))
+// sign signs the message built for the wallet address, once the signer is
+// known to hold the wallet
+func (w *SdsWallet) sign(ctx context.Context, msg func(address string) string) ([]byte, error) {
+	pubKey, err := w.pubKey(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return sign, nil
+	return w.signer.Sign(ctx, []byte(msg(fwtypes.WalletAddress(pubKey.Address()).String())))
+}
+
+// SignFileUpload signs an upload request, reqTime must be the request time
+// sent along with the signature as the pp checks the signature against it.
+func (w *SdsWallet) SignFileUpload(ctx context.Context, sn, fileHash string, reqTime int64) ([]byte, error) {
+	return w.sign(ctx, func(address string) string {
+		return msgutils.GetFileUploadWalletSignMessage(fileHash, address, sn, reqTime)
+	})
+}
+
+func (w *SdsWallet) SignDownloadData(ctx context.Context, sn, fileHash string, reqTime int64) ([]byte, error) {
+	return w.sign(ctx, func(address string) string {
+		return msgutils.GetFileDownloadWalletSignMessage(fileHash, address, sn, reqTime)
+	})
+}
+
+func (w *SdsWallet) SignCreateShareLink(ctx context.Context, fileHash string, reqTime int64) ([]byte, error) {
+	return w.sign(ctx, func(address string) string {
+		return msgutils.GetShareFileWalletSignMessage(fileHash, address, reqTime)
+	})
+}
+
+func (w *SdsWallet) SignListShareLinks(ctx context.Context, reqTime int64) ([]byte, error) {
+	return w.sign(ctx, func(address string) string {
+		return msgutils.ShareLinkWalletSignMessage(address, reqTime)
+	})
+}
+
+func (w *SdsWallet) SignStopShareLink(ctx context.Context, shareId string, reqTime int64) ([]byte, error) {
+	return w.sign(ctx, func(address string) string {
+		return msgutils.DeleteShareWalletSignMessage(shareId, address, reqTime)
+	})
+}
+
+// SignLink signs the content of a mapping file
+func (w *SdsWallet) SignLink(ctx context.Context, data []byte) ([]byte, error) {
+	if _, err := w.pubKey(ctx); err != nil {
+		return nil, err
+	}
+	return w.signer.Sign(ctx, data)
+}
+
+func (w *SdsWallet) SignGetShareLink(ctx context.Context, sn, shareId string, reqTime int64) ([]byte, error) {
+	return w.sign(ctx, func(address string) string {
+		return msgutils.GetDownloadShareFileWalletSignMessage(shareId, address, sn, reqTime)
+	})
 }
@@ -0,0 +1,148 @@
+package sds_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/keystore"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/sds"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey returns the keystore key of a hex wallet key
+func testKey(t *testing.T, key string) crypto.PrivKey {
+	raw, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+	require.NoError(t, err)
+	sk, err := crypto.UnmarshalSecp256k1PrivateKey(raw)
+	require.NoError(t, err)
+	return sk
+}
+
+func TestLoadWallet(t *testing.T) {
+	legacy, err := sds.NewSdsWallet(testWalletKey)
+	require.NoError(t, err)
+
+	ks := keystore.NewMemKeystore()
+	require.NoError(t, ks.Put("wallet", testKey(t, testWalletKey)))
+	edKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, ks.Put("ed", edKey))
+
+	t.Run("from the keystore", func(t *testing.T) {
+		w, err := sds.LoadWallet(&config.Sds{Wallet: "wallet"}, ks)
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress(t, legacy), walletAddress(t, w))
+	})
+
+	t.Run("prefers the keystore over the deprecated key", func(t *testing.T) {
+		w, err := sds.LoadWallet(&config.Sds{Wallet: "wallet", PrivateKey: otherWalletKey}, ks)
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress(t, legacy), walletAddress(t, w))
+	})
+
+	t.Run("from the deprecated key", func(t *testing.T) {
+		w, err := sds.LoadWallet(&config.Sds{PrivateKey: testWalletKey}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress(t, legacy), walletAddress(t, w))
+	})
+
+	t.Run("rejects other key types", func(t *testing.T) {
+		_, err := sds.LoadWallet(&config.Sds{Wallet: "ed"}, ks)
+		assert.ErrorContains(t, err, "could not be used as sds wallets")
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, err := sds.LoadWallet(&config.Sds{Wallet: "missing"}, ks)
+		assert.ErrorIs(t, err, keystore.ErrNoSuchKey)
+	})
+
+	t.Run("generates the default wallet on first use", func(t *testing.T) {
+		ks := keystore.NewMemKeystore()
+		w, err := sds.LoadWallet(&config.Sds{}, ks)
+		require.NoError(t, err)
+		has, err := ks.Has(config.DefaultSdsWallet)
+		require.NoError(t, err)
+		assert.True(t, has)
+
+		again, err := sds.LoadWallet(&config.Sds{}, ks)
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress(t, w), walletAddress(t, again))
+	})
+
+	t.Run("named wallets are not generated", func(t *testing.T) {
+		ks := keystore.NewMemKeystore()
+		_, err := sds.LoadWallet(&config.Sds{Wallet: config.DefaultSdsWallet}, ks)
+		assert.ErrorIs(t, err, keystore.ErrNoSuchKey)
+		_, err = sds.LoadWallet(&config.Sds{}, nil)
+		assert.ErrorContains(t, err, "no keystore")
+	})
+}
+
+// configRepo is a mock repo clearing the deprecated wallet key like fsrepo
+type configRepo struct {
+	repo.Mock
+}
+
+func newConfigRepo(cfg config.Config, ks keystore.Keystore) *configRepo {
+	return &configRepo{repo.Mock{C: cfg, K: ks}}
+}
+
+func (r *configRepo) SetConfigKey(key string, value interface{}) error {
+	if key != config.SdsPrivateKeySelector {
+		return r.Mock.SetConfigKey(key, value)
+	}
+	r.C.Sds.PrivateKey = value.(string)
+	return nil
+}
+
+func TestMigratePrivateKey(t *testing.T) {
+	legacy, err := sds.NewSdsWallet(testWalletKey)
+	require.NoError(t, err)
+
+	t.Run("moves the key signing the requests", func(t *testing.T) {
+		r := newConfigRepo(config.Config{Sds: config.Sds{PrivateKey: testWalletKey}}, keystore.NewMemKeystore())
+		require.NoError(t, sds.MigratePrivateKey(r))
+		assert.Empty(t, r.C.Sds.PrivateKey)
+		assert.Equal(t, config.DefaultSdsWallet, r.C.Sds.Wallet)
+
+		w, err := sds.LoadWallet(&r.C.Sds, r.K)
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress(t, legacy), walletAddress(t, w))
+
+		// nothing left to move
+		require.NoError(t, sds.MigratePrivateKey(r))
+		assert.Equal(t, config.DefaultSdsWallet, r.C.Sds.Wallet)
+	})
+
+	t.Run("keeps the other keys", func(t *testing.T) {
+		ks := keystore.NewMemKeystore()
+		require.NoError(t, ks.Put(config.DefaultSdsWallet, testKey(t, otherWalletKey)))
+		r := newConfigRepo(config.Config{Sds: config.Sds{PrivateKey: testWalletKey}}, ks)
+		require.NoError(t, sds.MigratePrivateKey(r))
+		assert.Equal(t, config.DefaultSdsWallet+"-1", r.C.Sds.Wallet)
+		w, err := sds.LoadWallet(&r.C.Sds, ks)
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress(t, legacy), walletAddress(t, w))
+
+		// an unused key is moved without changing the wallet
+		r = newConfigRepo(config.Config{Sds: config.Sds{Wallet: "wallet", PrivateKey: otherWalletKey}}, ks)
+		require.NoError(t, sds.MigratePrivateKey(r))
+		assert.Empty(t, r.C.Sds.PrivateKey)
+		assert.Equal(t, "wallet", r.C.Sds.Wallet)
+		sk, err := ks.Get(config.DefaultSdsWallet)
+		require.NoError(t, err)
+		assert.True(t, sk.Equals(testKey(t, otherWalletKey)))
+	})
+
+	t.Run("rejects an invalid key", func(t *testing.T) {
+		r := newConfigRepo(config.Config{Sds: config.Sds{PrivateKey: "0xnothex"}}, keystore.NewMemKeystore())
+		assert.Error(t, sds.MigratePrivateKey(r))
+		assert.Equal(t, "0xnothex", r.C.Sds.PrivateKey)
+	})
+}
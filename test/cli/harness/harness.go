@@ -10,6 +10,7 @@ import (
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
+	sdsmock "github.com/ipfs/kubo/sds/mock"
 	. "github.com/ipfs/kubo/test/cli/testutils"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
@@ -22,6 +23,8 @@ type Harness struct {
 	Runner    *Runner
 	NodesRoot string
 	Nodes     Nodes
+
+	sdsPPs []*sdsmock.PP
 }
 
 // TODO: use zaptest.NewLogger(t) instead
@@ -183,6 +186,9 @@ func (h *Harness) Sh(expr string) *RunResult {
 func (h *Harness) Cleanup() {
 	log.Debugf("cleaning up cluster")
 	h.Nodes.StopDaemons()
+	for _, pp := range h.sdsPPs {
+		pp.Close()
+	}
 	// TODO: don't do this if test fails, not sure how?
 	log.Debugf("removing harness dir")
 	err := os.RemoveAll(h.Dir)
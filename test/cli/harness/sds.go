@@ -0,0 +1,27 @@
+package harness
+
+import (
+	"github.com/ipfs/kubo/config"
+	sdsmock "github.com/ipfs/kubo/sds/mock"
+)
+
+// StartSdsPP starts a fake sds pp node, which is stopped with the harness.
+func (h *Harness) StartSdsPP() *sdsmock.PP {
+	pp := sdsmock.NewPP()
+	h.sdsPPs = append(h.sdsPPs, pp)
+	return pp
+}
+
+// EnableSds switches sds on, using the given pps.
+func (n *Node) EnableSds(pps ...*sdsmock.PP) *Node {
+	n.UpdateConfig(func(cfg *config.Config) {
+		cfg.Sds.Enabled = true
+		// the tests download from sds through the gateway without a token
+		cfg.Gateway.SdsFallback = config.NewOptionalString(config.SdsFallbackPublic)
+		cfg.Sds.RpcURLs = nil
+		for _, pp := range pps {
+			cfg.Sds.RpcURLs = append(cfg.Sds.RpcURLs, pp.URL())
+		}
+	})
+	return n
+}
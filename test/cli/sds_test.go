@@ -0,0 +1,1005 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/sds"
+	sdsmock "github.com/ipfs/kubo/sds/mock"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSdsCommands(t *testing.T) {
+	t.Parallel()
+
+	t.Run("status reports disabled sds", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+
+		res := node.IPFS("sds", "status")
+		assert.Contains(t, res.Stdout.String(), "Enabled: false")
+	})
+
+	t.Run("upload fails when sds is disabled", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		cidStr := node.IPFSAddStr("hello sds")
+
+		res := node.RunIPFS("sds", "upload", cidStr)
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "sds is not enabled")
+	})
+
+	t.Run("resolve prints the mapping file content", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		cidStr := node.IPFSAddStr("hello sds")
+		c, err := cid.Decode(cidStr)
+		require.NoError(t, err)
+
+		mapFile, err := sds.NewSdsFileV1(c, "v05j1m517ljekhi1c4ce82pb62c5p1vdjvrbph2g")
+		require.NoError(t, err)
+		defer mapFile.Close()
+		mapData, err := io.ReadAll(mapFile)
+		require.NoError(t, err)
+		mapCid := node.IPFSAdd(bytes.NewReader(mapData))
+
+		res := node.IPFS("sds", "resolve", mapCid)
+		assert.Contains(t, res.Stdout.String(), cidStr)
+		assert.Contains(t, res.Stdout.String(), "v05j1m517ljekhi1c4ce82pb62c5p1vdjvrbph2g")
+
+		res = node.RunIPFS("sds", "resolve", cidStr)
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "is not an sds mapping file")
+	})
+}
+
+func TestSdsWithPP(t *testing.T) {
+	t.Parallel()
+
+	// setupSdsNodes returns a node which added the content with sds enabled
+	// along with the root cid of the content, and a node with an empty repo
+	// using the same pp
+	setupSdsNodes := func(t *testing.T, content string) (*harness.Node, *harness.Node, string) {
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		nodes := h.NewNodes(2).Init()
+		nodes.ForEachPar(func(n *harness.Node) {
+			n.EnableSds(pp)
+		})
+
+		mapCid := nodes[0].IPFSAddStr(content)
+		res := nodes[0].IPFS("sds", "resolve", "--enc=json", mapCid)
+		var link struct{ Cid string }
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &link))
+		return nodes[0], nodes[1], link.Cid
+	}
+
+	t.Run("status reports the pp", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		node := h.NewNode().Init().EnableSds(pp)
+
+		res := node.IPFS("sds", "status")
+		assert.Regexp(t, `Enabled:\s+true`, res.Stdout.String())
+		assert.Regexp(t, `Reachable:\s+true`, res.Stdout.String())
+		assert.Regexp(t, `Ozone:\s+`+sdsmock.DefaultOzone, res.Stdout.String())
+	})
+
+	t.Run("mapping files are signed by the wallet", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		node := h.NewNode().Init().EnableSds(pp)
+
+		mapCid := node.IPFSAddStr("hello signed sds")
+		res := node.IPFS("sds", "resolve", "--enc=json", mapCid)
+		var link struct {
+			Version   int
+			CarSize   int64
+			ShareLink string
+			Wallet    string
+		}
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &link))
+		assert.Equal(t, sds.LinkVersion, link.Version)
+		assert.Positive(t, link.CarSize)
+		assert.True(t, strings.HasPrefix(link.ShareLink, "sds://"), link.ShareLink)
+		assert.Equal(t, strings.TrimSpace(node.IPFS("sds", "wallet", "address").Stdout.String()), link.Wallet)
+
+		// a tampered mapping file is not followed. cat would follow the
+		// mapping file, so its bytes are read through mfs.
+		node.IPFS("files", "cp", "/ipfs/"+mapCid, "/map")
+		mapData := node.IPFS("files", "read", "/map").Stdout.Bytes()
+		i := bytes.Index(mapData, []byte(`"carsize":`)) + len(`"carsize":`)
+		require.Greater(t, i, len(`"carsize":`))
+		tampered := append([]byte{}, mapData...)
+		tampered[i] = '1' + (tampered[i]-'0')%8
+		tamperedCid := strings.TrimSpace(node.PipeToIPFS(bytes.NewReader(tampered), "block", "put").Stdout.String())
+		res = node.RunIPFS("sds", "resolve", tamperedCid)
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "invalid mapping file signature")
+		assert.Equal(t, tampered, node.IPFS("cat", tamperedCid).Stdout.Bytes())
+	})
+
+	t.Run("gateway follows the mapping files of the trusted wallets only", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		nodes := h.NewNodes(2).Init()
+		nodes.ForEachPar(func(n *harness.Node) {
+			n.EnableSds(pp)
+		})
+		nodeA, nodeB := nodes[0], nodes[1]
+
+		mapCid := nodeA.IPFSAddStr("hello trusted sds")
+		nodeA.IPFS("files", "cp", "/ipfs/"+mapCid, "/map")
+		mapData := nodeA.IPFS("files", "read", "/map").Stdout.Bytes()
+		mapBlock := nodeA.IPFS("block", "get", mapCid).Stdout.Bytes()
+		nodeB.PipeToIPFS(bytes.NewReader(mapBlock), "block", "put", "--cid-codec=dag-pb")
+
+		// signed by the wallet of another node
+		nodeB.StartDaemon("--offline")
+		resp := nodeB.GatewayClient().Get("/ipfs/" + mapCid)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, string(mapData), resp.Body)
+		nodeB.StopDaemon()
+
+		wallet := nodeA.IPFS("sds", "wallet", "address").Stdout.Trimmed()
+		nodeB.UpdateConfig(func(cfg *config.Config) {
+			cfg.Sds.TrustedWallets = []string{wallet}
+		})
+		nodeB.StartDaemon("--offline")
+		resp = nodeB.GatewayClient().Get("/ipfs/" + mapCid)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello trusted sds", resp.Body)
+	})
+
+	t.Run("empty node cats content added with sds", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello sds")
+
+		res := nodeB.IPFS("cat", rootCid)
+		assert.Equal(t, "hello sds", res.Stdout.String())
+
+		// the DAG is imported and pinned from the CAR
+		res = nodeB.IPFS("pin", "ls", "--type=recursive")
+		assert.Contains(t, res.Stdout.String(), rootCid)
+	})
+
+	t.Run("empty node cats several sds files from an offset", func(t *testing.T) {
+		t.Parallel()
+		nodeA, nodeB, firstCid := setupSdsNodes(t, "hello ")
+		mapCid := nodeA.IPFSAddStr("sds world")
+		res := nodeA.IPFS("sds", "resolve", "--enc=json", mapCid)
+		var link struct{ Cid string }
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &link))
+
+		// the first file is skipped by its size
+		res = nodeB.IPFS("cat", "--offset=7", "--length=2", firstCid, link.Cid)
+		assert.Equal(t, "ds", res.Stdout.String())
+		res = nodeB.IPFS("cat", "--offset=2", "--length=6", firstCid, link.Cid)
+		assert.Equal(t, "llo sd", res.Stdout.String())
+		res = nodeB.IPFS("cat", "--offset=3", firstCid, link.Cid)
+		assert.Equal(t, "lo sds world", res.Stdout.String())
+	})
+
+	t.Run("empty node fetches content added with sds through the gateway", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello sds gateway")
+		nodeB.StartDaemon("--offline")
+
+		resp := nodeB.GatewayClient().Get("/ipfs/" + rootCid)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello sds gateway", resp.Body)
+	})
+
+	t.Run("online node fetches from sds the content not found in ipfs in time", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello online sds gateway")
+		// online, the lookup of a missing block waits for its providers
+		nodeB.UpdateConfig(func(cfg *config.Config) {
+			cfg.Sds.Fallback.LookupTimeout = config.NewOptionalDuration(time.Second)
+		})
+		nodeB.StartDaemon()
+
+		resp := nodeB.GatewayClient().Get("/ipfs/" + rootCid)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello online sds gateway", resp.Body)
+
+		// the local content is served right away, through its mapping file
+		mapCid := nodeB.IPFSAddStr("hello local content")
+		resp = nodeB.GatewayClient().Get("/ipfs/" + mapCid)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello local content", resp.Body)
+	})
+
+	t.Run("gateway fetches from sds only the content whose root is not on the node", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		node := h.NewNode().Init()
+		dir := filepath.Join(node.Dir, "site")
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello local dir"), 0o644))
+		dirCid := node.IPFS("add", "-r", "-Q", dir).Stdout.Trimmed()
+		node.EnableSds(pp)
+		node.StartDaemon("--offline")
+
+		resp := node.GatewayClient().Get("/ipfs/" + dirCid + "/missing.txt")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Zero(t, pp.Calls("user_requestGetShared"))
+	})
+
+	t.Run("concurrent gateway requests share the sds download", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		nodes := h.NewNodes(2).Init()
+		nodes.ForEachPar(func(n *harness.Node) {
+			n.EnableSds(pp)
+		})
+		mapCid := nodes[0].IPFSAddStr("hello shared download")
+		res := nodes[0].IPFS("sds", "resolve", "--enc=json", mapCid)
+		var link struct{ Cid string }
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &link))
+		nodes[1].StartDaemon("--offline")
+		pp.SetLatency(200 * time.Millisecond)
+
+		const requests = 4
+		bodies := make(chan string, requests)
+		for i := 0; i < requests; i++ {
+			go func() {
+				resp := nodes[1].GatewayClient().Get("/ipfs/" + link.Cid)
+				bodies <- resp.Body
+			}()
+		}
+		for i := 0; i < requests; i++ {
+			assert.Equal(t, "hello shared download", <-bodies)
+		}
+		assert.Equal(t, 1, pp.Calls("user_requestGetShared"))
+	})
+
+	t.Run("gateway serves every response format of sds only content", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		nodeA := h.NewNode().Init()
+
+		// the directory is only uploaded to sds, no other node has its blocks
+		dir := filepath.Join(nodeA.Dir, "site")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello sds dir"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("hello sds subdir"), 0o644))
+		dirCid := nodeA.IPFS("add", "-r", "-Q", dir).Stdout.Trimmed()
+		nodeA.EnableSds(pp)
+		fileHash := nodeA.IPFS("sds", "upload", dirCid).Stdout.Trimmed()
+		nodeA.IPFS("sds", "share", "create", dirCid, "--file-hash", fileHash)
+
+		checks := []struct {
+			name  string
+			check func(t *testing.T, client *harness.HTTPClient)
+		}{
+			{"directory listing", func(t *testing.T, client *harness.HTTPClient) {
+				resp := client.Get("/ipfs/" + dirCid + "/")
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Contains(t, resp.Body, "a.txt")
+				assert.Contains(t, resp.Body, "sub")
+			}},
+			{"file in a subdirectory", func(t *testing.T, client *harness.HTTPClient) {
+				resp := client.Get("/ipfs/" + dirCid + "/sub/b.txt")
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, "hello sds subdir", resp.Body)
+			}},
+			{"head", func(t *testing.T, client *harness.HTTPClient) {
+				resp := client.Head("/ipfs/" + dirCid + "/a.txt")
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, "13", resp.Headers.Get("Content-Length"))
+			}},
+			{"raw block", func(t *testing.T, client *harness.HTTPClient) {
+				resp := client.Get("/ipfs/" + dirCid + "?format=raw")
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, "application/vnd.ipld.raw", resp.Headers.Get("Content-Type"))
+				assert.NotEmpty(t, resp.Body)
+			}},
+			{"car", func(t *testing.T, client *harness.HTTPClient) {
+				resp := client.Get("/ipfs/" + dirCid + "?format=car")
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.True(t, strings.HasPrefix(resp.Headers.Get("Content-Type"), "application/vnd.ipld.car"))
+
+				// the CAR holds the whole DAG
+				node := h.NewNode().Init()
+				require.NoError(t, node.IPFSDagImport(strings.NewReader(resp.Body), dirCid))
+				res := node.IPFS("cat", "--offline", dirCid+"/sub/b.txt")
+				assert.Equal(t, "hello sds subdir", res.Stdout.String())
+			}},
+		}
+		for _, c := range checks {
+			t.Run(c.name, func(t *testing.T) {
+				// every check starts from an empty node
+				node := h.NewNode().Init().EnableSds(pp)
+				node.StartDaemon("--offline")
+				defer node.StopDaemon()
+				c.check(t, node.GatewayClient())
+			})
+		}
+	})
+
+	t.Run("gateway serves the sds namespace", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		nodeA := h.NewNode().Init()
+
+		dir := filepath.Join(nodeA.Dir, "site")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("hello sds namespace"), 0o644))
+		dirCid := nodeA.IPFS("add", "-r", "-Q", dir).Stdout.Trimmed()
+		nodeA.EnableSds(pp)
+		fileHash := nodeA.IPFS("sds", "upload", dirCid).Stdout.Trimmed()
+		shareLink := nodeA.IPFS("sds", "share", "create", dirCid, "--file-hash", fileHash).Stdout.Trimmed()
+		share := strings.TrimPrefix(shareLink, "sds://")
+
+		// a plain file uploaded by the wallet of nodeA
+		wallet := nodeA.IPFS("sds", "wallet", "address").Stdout.Trimmed()
+		htmlHash := pp.AddFile(wallet, []byte("<!DOCTYPE html><html><body>hello sds</body></html>"))
+
+		// the files are downloaded by a node with an empty repo
+		nodeB := h.NewNode().Init().EnableSds(pp)
+		nodeB.StartDaemon("--offline")
+		client := nodeB.GatewayClient()
+
+		resp := client.Get("/sds/" + share + "/")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Body, "/sds/"+share+"/sub")
+
+		resp = client.Get("/sds/" + share + "/sub/b.txt")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello sds namespace", resp.Body)
+
+		resp = client.Get("/sds/" + wallet + "/" + htmlHash)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Headers.Get("Content-Type"))
+		assert.Contains(t, resp.Body, "hello sds")
+
+		resp = client.Get("/sds/" + wallet + "/" + htmlHash + "/sub")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = client.Get("/sds/not-a-share-link")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		// the subdomain of the share is the CIDv1 of the shared cid
+		c, err := cid.Decode(share)
+		require.NoError(t, err)
+		label := cid.NewCidV1(c.Type(), c.Hash()).String()
+		resp = client.DisableRedirects().Get("/sds/"+share+"/sub/b.txt", func(r *http.Request) {
+			r.Host = "localhost"
+		})
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+		assert.Equal(t, "http://"+label+".sds.localhost/sub/b.txt", resp.Headers.Get("Location"))
+
+		resp = client.Get("/sub/b.txt", func(r *http.Request) {
+			r.Host = label + ".sds.localhost"
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello sds namespace", resp.Body)
+	})
+
+	t.Run("gateway clients are held to their ozone budget", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello sds budget")
+		nodeB.UpdateConfig(func(cfg *config.Config) {
+			cfg.Sds.Budget.Daily = config.NewOptionalInteger(1000)
+			cfg.Sds.Budget.PerClient = config.NewOptionalInteger(1)
+		})
+		nodeB.StartDaemon("--offline")
+
+		// the first download is stopped once it went over the budget
+		resp := nodeB.GatewayClient().Get("/ipfs/" + rootCid)
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+		resp = nodeB.GatewayClient().Get("/ipfs/" + rootCid)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+		res := nodeB.IPFS("sds", "status")
+		assert.Regexp(t, `Spent today:\s+\d+ of 1000`, res.Stdout.String())
+		assert.Regexp(t, `Spent on download:\s+\d+`, res.Stdout.String())
+	})
+
+	t.Run("gateway restricts the sds fallback to authenticated clients", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello sds fallback")
+		nodeB.UpdateConfig(func(cfg *config.Config) {
+			cfg.Gateway.SdsFallback = config.NewOptionalString(config.SdsFallbackAuthenticated)
+			cfg.API.Authorizations = map[string]*config.RPCAuthScope{
+				"gateway": {AuthSecret: "bearer:secret", AllowedPaths: []string{"/api/v0", "/ipfs"}},
+			}
+		})
+		nodeB.StartDaemonWithAuthorization("Bearer secret", "--offline")
+		client := nodeB.GatewayClient()
+
+		resp := client.Get("/ipfs/" + rootCid)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp = client.Get("/sds/"+rootCid, client.WithHeader("Authorization", "Bearer secret"))
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = client.Get("/ipfs/"+rootCid, client.WithHeader("Authorization", "Bearer secret"))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello sds fallback", resp.Body)
+	})
+
+	t.Run("gateway with the sds fallback off serves the local content only", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello sds fallback")
+		nodeB.UpdateConfig(func(cfg *config.Config) {
+			cfg.Gateway.SdsFallback = config.NewOptionalString(config.SdsFallbackOff)
+		})
+		nodeB.StartDaemon("--offline")
+
+		resp := nodeB.GatewayClient().Get("/ipfs/" + rootCid)
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+		resp = nodeB.GatewayClient().Get("/sds/" + rootCid)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("gateway rate limits the sds downloads", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, _ := setupSdsNodes(t, "hello sds fallback")
+		nodeB.UpdateConfig(func(cfg *config.Config) {
+			cfg.Sds.Fallback.RateLimit = config.NewOptionalInteger(1)
+		})
+		nodeB.StartDaemon("--offline")
+
+		unknown := "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+		resp := nodeB.GatewayClient().Get("/sds/" + unknown)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		// the unknown link is not asked again, the other links are over
+		// the rate limit
+		resp = nodeB.GatewayClient().Get("/sds/" + unknown)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		resp = nodeB.GatewayClient().Get("/sds/QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	})
+
+	t.Run("daemon exports sds metrics", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello sds metrics")
+		nodeB.StartDaemon("--offline")
+
+		res := nodeB.IPFS("cat", rootCid)
+		assert.Equal(t, "hello sds metrics", res.Stdout.String())
+
+		resp := nodeB.APIClient().Get("/debug/metrics/prometheus")
+		assert.Contains(t, resp.Body, `sds_download_duration_seconds_count{outcome="0"} 1`)
+		assert.Contains(t, resp.Body, "sds_download_chunks_total 1")
+		assert.Contains(t, resp.Body, "sds_cache_misses_total 1")
+		assert.Contains(t, resp.Body, `sds_rpc_duration_seconds_count{method="user_requestGetShared"} 1`)
+		assert.Contains(t, resp.Body, "sds_ozone_balance 1e+12", "the default ozone of the mock")
+	})
+
+	t.Run("gateway traces the sds fallback", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello sds tracing")
+		tracesFile := filepath.Join(nodeB.Dir, "traces.json")
+		nodeB.Runner.Env["OTEL_TRACES_EXPORTER"] = "file"
+		nodeB.Runner.Env["OTEL_EXPORTER_FILE_PATH"] = tracesFile
+		nodeB.StartDaemon("--offline")
+
+		resp := nodeB.GatewayClient().Get("/ipfs/" + rootCid)
+		assert.Equal(t, "hello sds tracing", resp.Body)
+
+		// the spans are exported in batches while the daemon runs
+		names := []string{
+			"Sds.Gateway.Get",
+			"Sds.Gateway.IPFSLookup",
+			"Sds.Fetcher.DownloadFromShare",
+			"Sds.Rpc.user_requestGetShared",
+			"Sds.DagParser.Import",
+		}
+		assert.Eventually(t, func() bool {
+			traces, err := os.ReadFile(tracesFile)
+			require.NoError(t, err)
+			for _, name := range names {
+				if !strings.Contains(string(traces), `"Name":"`+name+`"`) {
+					return false
+				}
+			}
+			return true
+		}, 30*time.Second, 100*time.Millisecond)
+	})
+
+	t.Run("upload and share commands", func(t *testing.T) {
+		t.Parallel()
+		nodeA, _, rootCid := setupSdsNodes(t, "hello sds share")
+
+		fileHash := strings.TrimSpace(nodeA.IPFS("sds", "upload", rootCid).Stdout.String())
+		assert.NotEmpty(t, fileHash)
+
+		res := nodeA.IPFS("sds", "share", "create", rootCid, "--file-hash", fileHash)
+		assert.Equal(t, "sds://"+rootCid, strings.TrimSpace(res.Stdout.String()))
+	})
+
+	t.Run("share links expire, are protected by a password and revoked", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		nodeA := h.NewNode().Init()
+
+		dir := filepath.Join(nodeA.Dir, "site")
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello private share"), 0o644))
+		dirCid := nodeA.IPFS("add", "-r", "-Q", dir).Stdout.Trimmed()
+		nodeA.EnableSds(pp)
+		fileHash := nodeA.IPFS("sds", "upload", dirCid).Stdout.Trimmed()
+
+		res := nodeA.RunIPFS("sds", "share", "create", dirCid, "--file-hash", fileHash, "--expire", "soon")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "invalid --expire")
+
+		shareLink := nodeA.IPFS("sds", "share", "create", dirCid, "--file-hash", fileHash, "--expire=7d", "--password").Stdout.Trimmed()
+		share, password, ok := strings.Cut(strings.TrimPrefix(shareLink, "sds://"), "/")
+		require.True(t, ok, shareLink)
+		assert.Equal(t, dirCid, share)
+
+		var out struct {
+			Shares []struct {
+				ShareId   string
+				ShareLink string
+				Password  string
+				Expires   time.Time
+				Revoked   time.Time
+				Recorded  bool
+			}
+		}
+		res = nodeA.IPFS("sds", "share", "ls", "--enc=json")
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &out))
+		require.Len(t, out.Shares, 1)
+		assert.Equal(t, "sds://"+share, out.Shares[0].ShareLink)
+		assert.Equal(t, password, out.Shares[0].Password)
+		assert.True(t, out.Shares[0].Recorded)
+		assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), out.Shares[0].Expires, time.Minute)
+
+		// the gateway needs the password of the share
+		nodeB := h.NewNode().Init().EnableSds(pp)
+		nodeB.StartDaemon("--offline")
+		client := nodeB.GatewayClient()
+
+		resp := client.Get("/sds/" + share + "/a.txt")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		resp = client.Get("/sds/" + share + "/a.txt?password=" + password)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello private share", resp.Body)
+		resp = client.Get("/sds/"+share+"/a.txt", client.WithHeader("Sds-Share-Password", password))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// the private DAG is not imported, it is not served without the
+		// password
+		assert.NotContains(t, nodeB.IPFS("pin", "ls", "--type=recursive").Stdout.String(), dirCid)
+		assert.Equal(t, 1, nodeB.RunIPFS("block", "stat", "--offline", dirCid).ExitCode())
+		resp = client.Get("/ipfs/" + dirCid + "/a.txt")
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+		nodeA.IPFS("sds", "share", "rm", out.Shares[0].ShareId)
+		assert.Empty(t, nodeA.IPFS("sds", "share", "ls").Stdout.Trimmed())
+
+		// the revoked share stays in the records of the repo
+		res = nodeA.IPFS("sds", "share", "ls", "--recorded")
+		assert.Contains(t, res.Stdout.String(), shareLink)
+		assert.Contains(t, res.Stdout.String(), "revoked")
+
+		res = nodeA.RunIPFS("sds", "share", "rm", out.Shares[0].ShareId)
+		assert.Equal(t, 1, res.ExitCode())
+	})
+
+	t.Run("uploads keep the names of the added files", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		node := h.NewNode().Init()
+
+		// added before sds is enabled, to be uploaded by 'sds upload'
+		reportCid := node.IPFSAddStr("a report")
+		node.EnableSds(pp)
+
+		resolve := func(mapCid string) (string, string) {
+			var link struct{ Cid, FileHash string }
+			res := node.IPFS("sds", "resolve", "--enc=json", mapCid)
+			require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &link))
+			return link.Cid, link.FileHash
+		}
+		fileMeta := func(fileHash string) sdsmock.FileMeta {
+			meta, ok := pp.FileMeta(fileHash)
+			require.True(t, ok, fileHash)
+			return meta
+		}
+
+		require.NoError(t, os.WriteFile(filepath.Join(node.Dir, "hello.txt"), []byte("hello names"), 0o644))
+		rootCid, fileHash := resolve(node.IPFS("add", "-Q", filepath.Join(node.Dir, "hello.txt")).Stdout.Trimmed())
+		assert.Equal(t, sdsmock.FileMeta{Name: "hello.txt", ContentType: "text/plain; charset=utf-8", Cid: rootCid}, fileMeta(fileHash))
+
+		rootCid, fileHash = resolve(node.IPFSAddStr("hello index", "--sds-name=index.html"))
+		assert.Equal(t, sdsmock.FileMeta{Name: "index.html", ContentType: "text/html; charset=utf-8", Cid: rootCid}, fileMeta(fileHash))
+
+		// a DAG with no name is stored under its cid
+		rootCid, fileHash = resolve(node.IPFSAddStr("hello nameless"))
+		assert.Equal(t, rootCid+".car", fileMeta(fileHash).Name)
+
+		fileHash = node.IPFS("sds", "upload", reportCid, "--name=report", "--content-type=application/pdf").Stdout.Trimmed()
+		assert.Equal(t, sdsmock.FileMeta{Name: "report", ContentType: "application/pdf", Cid: reportCid}, fileMeta(fileHash))
+	})
+
+	t.Run("failed upload is listed and resumed", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		pp.SetChunkSize(100)
+		node := h.NewNode().Init()
+		cidStr := node.IPFSAddStr(strings.Repeat("resumable sds upload ", 50))
+		node.EnableSds(pp)
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Sds.Retry.Operations = map[string]config.SdsRetryPolicy{
+				"upload": {MaxAttempts: config.NewOptionalInteger(1)},
+			}
+		})
+
+		pp.FailUploadAfter(3)
+		res := node.RunIPFS("sds", "upload", cidStr)
+		assert.Equal(t, 1, res.ExitCode())
+
+		res = node.IPFS("sds", "uploads")
+		fields := strings.Fields(res.Stdout.String())
+		require.GreaterOrEqual(t, len(fields), 4)
+		fileHash := fields[0]
+		assert.Equal(t, cidStr, fields[1])
+		assert.Equal(t, "failed", fields[2])
+		assert.True(t, strings.HasPrefix(fields[3], "300/"))
+
+		res = node.IPFS("sds", "uploads", "resume", fileHash)
+		assert.Equal(t, fileHash, strings.TrimSpace(res.Stdout.String()))
+
+		res = node.IPFS("sds", "uploads")
+		assert.Empty(t, res.Stdout.String())
+	})
+
+	t.Run("async add queues the upload for the daemon", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		nodes := h.NewNodes(2).Init()
+		nodes.ForEachPar(func(n *harness.Node) {
+			n.EnableSds(pp)
+		})
+		nodeA, nodeB := nodes[0], nodes[1]
+
+		// the add completes while the pp is down
+		pp.SetDown(true)
+		rootCid := nodeA.IPFSAddStr("hello sds queue", "--sds-async")
+		res := nodeA.IPFS("sds", "queue", "ls")
+		assert.Equal(t, []string{rootCid, "queued", "0"}, strings.Fields(res.Stdout.String()))
+
+		pp.SetDown(false)
+		nodeA.StartDaemon("--offline")
+		var link string
+		require.Eventually(t, func() bool {
+			fields := strings.Fields(nodeA.IPFS("sds", "queue", "ls").Stdout.String())
+			if len(fields) < 4 || fields[1] != "done" {
+				return false
+			}
+			link = fields[3]
+			return true
+		}, 30*time.Second, 100*time.Millisecond)
+
+		res = nodeA.IPFS("sds", "resolve", "--enc=json", link)
+		assert.Contains(t, res.Stdout.String(), rootCid)
+		res = nodeB.IPFS("cat", rootCid)
+		assert.Equal(t, "hello sds queue", res.Stdout.String())
+
+		nodeA.IPFS("sds", "queue", "cancel", rootCid)
+		res = nodeA.IPFS("sds", "queue", "ls")
+		assert.Empty(t, res.Stdout.String())
+	})
+
+	t.Run("pin remote add pins to the sds service", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		node := h.NewNode().Init()
+		node.EnableSds(pp)
+		rootCid := node.IPFSAddStr("hello sds remote pin", "--sds-async")
+
+		res := node.RunIPFS("pin", "remote", "add", "--service=sds", rootCid)
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "uploaded by the daemon")
+
+		res = node.IPFS("pin", "remote", "add", "--service=sds", "--name=mypin", "--background", rootCid)
+		assert.Contains(t, res.Stdout.String(), "mypin")
+		res = node.IPFS("pin", "remote", "ls", "--service=sds", "--status=queued,pinning,pinned,failed")
+		assert.Equal(t, []string{rootCid, "queued", "mypin"}, strings.Fields(res.Stdout.String()))
+
+		res = node.IPFS("pin", "remote", "service", "ls")
+		assert.Contains(t, res.Stdout.String(), "sds")
+		res = node.RunIPFS("pin", "remote", "service", "add", "sds", "https://example.com", "key")
+		assert.Equal(t, 1, res.ExitCode())
+
+		node.StartDaemon("--offline")
+		res = node.IPFS("pin", "remote", "add", "--service=sds", "--name=mypin", rootCid)
+		assert.Contains(t, res.Stdout.String(), "pinned")
+		res = node.IPFS("pin", "remote", "ls", "--service=sds", "--name=mypin")
+		assert.Equal(t, []string{rootCid, "pinned", "mypin"}, strings.Fields(res.Stdout.String()))
+
+		node.IPFS("pin", "remote", "rm", "--service=sds", "--cid="+rootCid)
+		res = node.IPFS("pin", "remote", "ls", "--service=sds")
+		assert.Empty(t, res.Stdout.String())
+	})
+
+	t.Run("daemon serves the pinning services api", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		nodes := h.NewNodes(2).Init()
+		nodeA, nodeB := nodes[0], nodes[1]
+		nodeA.EnableSds(pp)
+		nodeA.UpdateConfig(func(cfg *config.Config) {
+			cfg.Sds.PinningService.Enabled = config.True
+			cfg.API.Authorizations = map[string]*config.RPCAuthScope{
+				"test-node-starter": {
+					AuthSecret:   "bearer:test-node-starter",
+					AllowedPaths: []string{"/api/v0"},
+				},
+				"pinner": {
+					AuthSecret:   "bearer:pinning-token",
+					AllowedPaths: []string{"/pinning/v1"},
+				},
+			}
+		})
+		rootCid := nodeA.IPFSAddStr("hello sds pinning service")
+		nodeB.IPFSAddStr("hello sds pinning service")
+		nodeA.StartDaemonWithAuthorization("Bearer test-node-starter", "--offline")
+		endpoint := nodeA.APIURL() + "/pinning/v1"
+
+		resp, err := http.Get(endpoint + "/pins")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		nodeB.IPFS("pin", "remote", "service", "add", "nodea", endpoint, "pinning-token")
+		res := nodeB.IPFS("pin", "remote", "add", "--service=nodea", "--name=mypin", "--background", rootCid)
+		assert.Contains(t, res.Stdout.String(), "mypin")
+		require.Eventually(t, func() bool {
+			res := nodeB.IPFS("pin", "remote", "ls", "--service=nodea", "--name=mypin")
+			return strings.Contains(res.Stdout.String(), "pinned")
+		}, 30*time.Second, 100*time.Millisecond)
+
+		res = nodeB.IPFS("pin", "remote", "service", "ls", "--stat")
+		assert.Contains(t, res.Stdout.String(), "0/0/1/0")
+
+		nodeB.IPFS("pin", "remote", "rm", "--service=nodea", "--name=mypin")
+		res = nodeB.IPFS("pin", "remote", "ls", "--service=nodea", "--status=queued,pinning,pinned,failed")
+		assert.Empty(t, res.Stdout.String())
+	})
+
+	t.Run("downloads are kept in the cache", func(t *testing.T) {
+		t.Parallel()
+		_, nodeB, rootCid := setupSdsNodes(t, "hello sds cache")
+		nodeB.IPFS("cat", rootCid)
+
+		res := nodeB.IPFS("sds", "cache", "stat")
+		assert.Regexp(t, `Folder:\s+`+regexp.QuoteMeta(filepath.Join(nodeB.Dir, "sds-cache")), res.Stdout.String())
+		assert.Regexp(t, `Files:\s+1`, res.Stdout.String())
+
+		fields := strings.Fields(nodeB.IPFS("sds", "cache", "ls").Stdout.String())
+		require.NotEmpty(t, fields)
+		fileHash := fields[0]
+
+		res = nodeB.IPFS("sds", "cache", "verify")
+		assert.Empty(t, res.Stdout.String())
+
+		nodeB.WriteBytes(filepath.Join("sds-cache", "sds-files", fileHash), []byte("corrupted"))
+		res = nodeB.IPFS("sds", "cache", "verify")
+		assert.Contains(t, res.Stdout.String(), fileHash)
+
+		res = nodeB.IPFS("sds", "cache", "ls")
+		assert.Empty(t, res.Stdout.String())
+
+		nodeB.IPFS("sds", "download", rootCid)
+		nodeB.IPFS("sds", "cache", "clear")
+		res = nodeB.IPFS("sds", "cache", "ls")
+		assert.Empty(t, res.Stdout.String())
+	})
+
+	t.Run("api answers sds errors with their own status", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		node := h.NewNode().Init()
+		cidStr := node.IPFSAddStr("hello sds errors")
+		node.EnableSds(pp)
+		node.StartDaemon("--offline")
+
+		pp.SetOzone("0")
+		resp := node.APIClient().Post("/api/v0/sds/upload?arg="+cidStr, nil)
+		assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+
+		// the api clients take a 404 for an unknown command
+		resp = node.APIClient().Post("/api/v0/sds/download?arg=sds://"+cidStr, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		pp.SetDown(true)
+		resp = node.APIClient().Post("/api/v0/sds/download?arg=sds://"+cidStr, nil)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+}
+
+func TestSdsWallet(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the wallet is generated in the keystore on first use", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+
+		// nodes which do not use sds have no wallet
+		assert.NotContains(t, node.IPFS("key", "list").Stdout.Lines(), config.DefaultSdsWallet)
+		assert.Empty(t, node.ReadConfig().Sds.Wallet)
+		assert.NotContains(t, node.IPFS("config", "show").Stdout.String(), "PrivateKey")
+
+		res := node.IPFS("sds", "wallet", "address")
+		addr := res.Stdout.Trimmed()
+		assert.True(t, strings.HasPrefix(addr, "st1"), addr)
+		assert.Contains(t, node.IPFS("key", "list").Stdout.Lines(), config.DefaultSdsWallet)
+		assert.Equal(t, addr, node.IPFS("sds", "wallet", "address").Stdout.Trimmed())
+	})
+
+	t.Run("the deprecated private key is hidden and moved to the keystore", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		key := "0x" + strings.Repeat("1f", 32)
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Sds.PrivateKey = key
+		})
+
+		configShow := node.IPFS("config", "show").Stdout.String()
+		assert.NotContains(t, configShow, "PrivateKey")
+		assert.NotContains(t, node.IPFS("config", "Sds").Stdout.String(), "PrivateKey")
+		res := node.RunIPFS("config", "Sds.PrivateKey")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.NotContains(t, res.Stdout.String(), key[2:])
+
+		// the config shown could be replaced without losing the key
+		node.WriteBytes("config-show", []byte(configShow))
+		node.IPFS("config", "replace", "config-show")
+		assert.Equal(t, key, node.ReadConfig().Sds.PrivateKey)
+
+		// the first command using the node moves the key
+		addr := node.IPFS("sds", "wallet", "address").Stdout.Trimmed()
+		cfg := node.ReadConfig()
+		assert.Empty(t, cfg.Sds.PrivateKey)
+		assert.Equal(t, config.DefaultSdsWallet, cfg.Sds.Wallet)
+		assert.NotContains(t, node.ReadFile(node.ConfigFile()), key[2:])
+
+		node.WriteBytes("key.hex", []byte(key))
+		node.IPFS("key", "import", "imported", "--format=sds-hex", filepath.Join(node.Dir, "key.hex"))
+		assert.Equal(t, addr, node.IPFS("sds", "wallet", "address", "imported").Stdout.Trimmed())
+	})
+
+	t.Run("wallets are generated, exported and imported with the key commands", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+
+		node.IPFS("key", "gen", "--type=sds-secp256k1", "other")
+		addr := node.IPFS("sds", "wallet", "address", "other").Stdout.Trimmed()
+		assert.NotEqual(t, node.IPFS("sds", "wallet", "address").Stdout.Trimmed(), addr)
+
+		node.IPFS("config", "Sds.Wallet", "other")
+		assert.Equal(t, addr, node.IPFS("sds", "wallet", "address").Stdout.Trimmed())
+
+		hexFile := filepath.Join(node.Dir, "other.hex")
+		node.IPFS("key", "export", "other", "--format=sds-hex", "-o", hexFile)
+		data, err := os.ReadFile(hexFile)
+		require.NoError(t, err)
+		assert.Regexp(t, `^0x[0-9a-f]{64}\n$`, string(data))
+
+		node.IPFS("key", "import", "imported", "--format=sds-hex", hexFile)
+		assert.Equal(t, addr, node.IPFS("sds", "wallet", "address", "imported").Stdout.Trimmed())
+
+		node.IPFS("key", "gen", "--type=ed25519", "ed")
+		res := node.RunIPFS("key", "export", "ed", "--format=sds-hex", "-o", filepath.Join(node.Dir, "ed.hex"))
+		assert.Equal(t, 1, res.ExitCode())
+		res = node.RunIPFS("sds", "wallet", "address", "ed")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "could not be used as sds wallets")
+	})
+
+	t.Run("rotate keeps the previous wallet", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		before := node.IPFS("sds", "wallet", "address").Stdout.Trimmed()
+
+		after := node.IPFS("sds", "wallet", "rotate", "--oldkey=old-wallet").Stdout.Trimmed()
+		assert.NotEqual(t, before, after)
+		assert.Equal(t, after, node.IPFS("sds", "wallet", "address").Stdout.Trimmed())
+		assert.Equal(t, before, node.IPFS("sds", "wallet", "address", "old-wallet").Stdout.Trimmed())
+
+		res := node.RunIPFS("sds", "wallet", "rotate", "--oldkey=old-wallet")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "already exists")
+	})
+
+	t.Run("mapping files signed before a rotate are still followed", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		node := h.NewNode().Init().EnableSds(pp)
+		mapCid := node.IPFSAddStr("hello rotated sds")
+		before := node.IPFS("sds", "wallet", "address").Stdout.Trimmed()
+
+		node.IPFS("sds", "wallet", "rotate", "--oldkey=old-wallet")
+		var trusted []string
+		require.NoError(t, json.Unmarshal(node.IPFS("config", "Sds.TrustedWallets").Stdout.Bytes(), &trusted))
+		assert.Equal(t, []string{before}, trusted)
+
+		node.StartDaemon("--offline")
+		resp := node.GatewayClient().Get("/ipfs/" + mapCid)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello rotated sds", resp.Body)
+	})
+
+	t.Run("remote signer keeps the key off the node", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		signer, err := sdsmock.NewUnixSigner(t.TempDir(), "0xf4a2b939592564feb35ab10a8e04f6f2fe0943579fb3c9c33505298978b74893")
+		require.NoError(t, err)
+		t.Cleanup(signer.Close)
+
+		node := h.NewNode().Init().EnableSds(pp)
+		node.IPFS("key", "rm", config.DefaultSdsWallet)
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Sds.Wallet = ""
+			cfg.Sds.Signer = config.SdsSigner{
+				Type:    config.NewOptionalString(config.SdsSignerRemote),
+				URL:     signer.URL(),
+				Address: signer.Address(),
+			}
+		})
+
+		assert.Equal(t, signer.Address(), node.IPFS("sds", "wallet", "address").Stdout.Trimmed())
+		node.IPFSAddStr("hello remote signer")
+		assert.Greater(t, signer.Signed(), 0)
+
+		res := node.RunIPFS("sds", "wallet", "rotate", "--oldkey=old-wallet")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), `held by the "remote" signer`)
+	})
+
+	t.Run("balance asks the pp", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		pp := h.StartSdsPP()
+		node := h.NewNode().Init()
+
+		res := node.RunIPFS("sds", "wallet", "balance")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "sds is not enabled")
+
+		node.EnableSds(pp)
+		res = node.IPFS("sds", "wallet", "balance")
+		assert.Equal(t, sdsmock.DefaultOzone, res.Stdout.Trimmed())
+
+		res = node.IPFS("sds", "wallet", "balance", "--enc=json")
+		var out struct{ Name, Address, Ozone string }
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &out))
+		assert.Equal(t, config.DefaultSdsWallet, out.Name)
+		assert.Equal(t, node.IPFS("sds", "wallet", "address").Stdout.Trimmed(), out.Address)
+	})
+}